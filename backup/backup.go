@@ -7,14 +7,13 @@ package backup
 import (
 	"context"
 	"cs-agent/backup/borg"
+	"cs-agent/config"
 	"cs-agent/store"
 	"cs-agent/types"
 	"errors"
 	"time"
 
 	"github.com/getsentry/sentry-go"
-
-	"github.com/spf13/viper"
 )
 
 // Perform runs a volume.backup task: create a borg archive of the volume and, on
@@ -45,7 +44,7 @@ func Perform(ctx context.Context, st *store.Store, task store.Task, projectEvent
 
 	backupLogger().Info("Backing up volume", "volume", task.Volume)
 
-	repo, findRepoMsg := borg.FindRepository(st, &vol, &vol)
+	repo, findRepoMsg := borg.FindRepository(ctx, st, &vol, &vol)
 
 	defer func() {
 		// Stop borg container
@@ -56,26 +55,29 @@ func Perform(ctx context.Context, st *store.Store, task store.Task, projectEvent
 
 	if findRepoMsg != nil {
 		if findRepoMsg.MsgID == "Repository.DoesNotExist" {
-			repo = &borg.Repository{Name: vol.Name, Store: st}
+			repo = &borg.Repository{Name: vol.Name, Store: st, Ctx: ctx}
 			// Build backup container
 			repoErr := repo.Setup(&vol, &vol)
 			if repoErr != nil {
 				projectEvent.EventLog.Status = "failed"
+				projectEvent.noteBorg(repoErr)
 				projectEvent.PostEventUpdate("agent-d4c34f1d89c20aa6", repoErr.ToYaml())
 				return errors.New(repoErr.Message)
 			}
-		} else if findRepoMsg.MsgID == "InvalidRepository" && viper.GetBool("backups.borg.ssh.enabled") {
+		} else if findRepoMsg.MsgID == "InvalidRepository" && config.GetBool("backups.borg.ssh.enabled") {
 			// Empty SSH repos return 'InvalidRepository' rather than 'DoesNotExist'.
-			repo = &borg.Repository{Name: vol.Name, Store: st}
+			repo = &borg.Repository{Name: vol.Name, Store: st, Ctx: ctx}
 			// Build backup container
 			repoErr := repo.Setup(&vol, &vol)
 			if repoErr != nil {
 				projectEvent.EventLog.Status = "failed"
+				projectEvent.noteBorg(repoErr)
 				projectEvent.PostEventUpdate("agent-7fad20a06cbd26a2", repoErr.ToYaml())
 				return errors.New(repoErr.Message)
 			}
 		} else {
 			projectEvent.EventLog.Status = "failed"
+			projectEvent.noteBorg(findRepoMsg)
 			projectEvent.PostEventUpdate("agent-c4087f229d50d4dc", findRepoMsg.ToYaml())
 			return errors.New("(" + findRepoMsg.MsgID + ") " + findRepoMsg.Message)
 		}
@@ -93,6 +95,7 @@ func Perform(ctx context.Context, st *store.Store, task store.Task, projectEvent
 	if preBackupSuccess {
 		archiveMsg, archiveErr := archive.Create()
 		if archiveErr != nil {
+			projectEvent.noteBorg(archiveErr)
 			projectEvent.PostEventUpdate("agent-d894f86c71d0db7b", archiveErr.ToYaml())
 			if projectEvent.EventLog.Status == "running" {
 				projectEvent.EventLog.Status = "failed"
@@ -107,6 +110,11 @@ func Perform(ctx context.Context, st *store.Store, task store.Task, projectEvent
 			// logs a concise "Completed backup" line (archive id + duration). The
 			// full response is only worth logging on failure (see archiveErr above).
 			projectEvent.Record(archiveMsg.ToYaml())
+			// The name borg created ({utcnow} expanded), for the controller and
+			// for any chain step that runs against this archive.
+			if archiveMsg.Archive.Name != "" {
+				projectEvent.Set("archive", archiveMsg.Archive.Name)
+			}
 			postBackup(&vol, projectEvent, repo)
 			backupSucceeded = true
 		}
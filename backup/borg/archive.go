@@ -1,6 +1,8 @@
 package borg
 
 import (
+	"context"
+	"cs-agent/config"
 	"encoding/json"
 	"math/rand"
 	"reflect"
@@ -10,7 +12,6 @@ import (
 
 	"github.com/getsentry/sentry-go"
 	"github.com/hashicorp/go-hclog"
-	"github.com/spf13/viper"
 )
 
 // lockWait returns the borg --lock-wait value for an operation, falling back to
@@ -18,10 +19,10 @@ import (
 // scheduled `create` uses a longer wait so it rides out an in-agent compact/prune
 // (both hold borg's exclusive lock) instead of failing fast and missing a backup.
 func lockWait(op string) string {
-	if v := viper.GetString("backups.borg.lock_wait_" + op); v != "" {
+	if v := config.GetString("backups.borg.lock_wait_" + op); v != "" {
 		return v
 	}
-	return viper.GetString("backups.borg.lock_wait")
+	return config.GetString("backups.borg.lock_wait")
 }
 
 func (a *Archive) Create() (ArchiveMessage, *LogMessage) {
@@ -44,7 +45,10 @@ func (a *Archive) Create() (ArchiveMessage, *LogMessage) {
 	backupCmd := []string{"cd /mnt/data && borg --log-json"}
 	backupCmd = append(backupCmd, "--lock-wait "+lockWait("create"))
 	backupCmd = append(backupCmd, "create --error --one-file-system --json --numeric-ids --exclude-caches")
-	backupCmd = append(backupCmd, "--compression "+viper.GetString("backups.borg.compression"))
+	if a.Repository.streamsProgress() {
+		backupCmd = append(backupCmd, "--progress")
+	}
+	backupCmd = append(backupCmd, "--compression "+config.GetString("backups.borg.compression"))
 	backupCmd = append(backupCmd, a.archivePath())
 	backupCmd = append(backupCmd, ".")
 
@@ -79,7 +83,9 @@ func (a *Archive) Restore(filePaths []string) *LogMessage {
 	preRestore := []string{"mkdir", "-p /root/.snapshot"}
 	preRestore = append(preRestore, "&&", "mv", "/mnt/data/* /root/.snapshot/")
 
-	_, _, preRestoreLog := a.Repository.ExecWithLog(preRestore)
+	// The snapshot move and its rollback are not interruptible: a cancel landing
+	// mid-move must not strand the volume half in /mnt/data, half in the snapshot.
+	_, _, preRestoreLog := a.Repository.execWithLog(context.Background(), preRestore)
 
 	if preRestoreLog != (LogMessage{}) {
 		return &preRestoreLog
@@ -87,8 +93,11 @@ func (a *Archive) Restore(filePaths []string) *LogMessage {
 
 	// Perform Restore
 	cmd := []string{"cd /mnt/data && borg --log-json"}
-	cmd = append(cmd, "--lock-wait "+viper.GetString("backups.borg.lock_wait"))
+	cmd = append(cmd, "--lock-wait "+config.GetString("backups.borg.lock_wait"))
 	cmd = append(cmd, "extract --error --numeric-ids")
+	if a.Repository.streamsProgress() {
+		cmd = append(cmd, "--progress")
+	}
 	cmd = append(cmd, a.archivePath())
 	for _, p := range filePaths {
 		cmd = append(cmd, p)
@@ -102,7 +111,7 @@ func (a *Archive) Restore(filePaths []string) *LogMessage {
 		// Failed, so we roll back
 		rollbackCmd := []string{"rm", "-rf /mnt/data/*"}
 		rollbackCmd = append(rollbackCmd, "&&", "mv /root/.snapshot/* /mnt/data/")
-		if _, rollbackResponse, rollbackLog := a.Repository.ExecWithLog(rollbackCmd); rollbackLog != (LogMessage{}) {
+		if _, rollbackResponse, rollbackLog := a.Repository.execWithLog(context.Background(), rollbackCmd); rollbackLog != (LogMessage{}) {
 			borgLogger().Warn("Fatal error performing rollback on restore", "response", rollbackResponse, "error", rollbackLog.Message)
 		}
 		return &log
@@ -117,7 +126,7 @@ func (a *Archive) Info() (*ArchiveResponse, *LogMessage) {
 	var archiveResponse ArchiveResponse
 
 	cmd := []string{"borg --log-json"}
-	cmd = append(cmd, "--lock-wait "+viper.GetString("backups.borg.lock_wait"))
+	cmd = append(cmd, "--lock-wait "+config.GetString("backups.borg.lock_wait"))
 	cmd = append(cmd, "info --error --json")
 	cmd = append(cmd, a.archivePath())
 
@@ -147,7 +156,7 @@ func (a *Archive) Delete() ([]LogMessage, *LogMessage) {
 	}
 
 	cmd := []string{"borg --log-json --error"}
-	cmd = append(cmd, "--lock-wait "+viper.GetString("backups.borg.lock_wait"))
+	cmd = append(cmd, "--lock-wait "+config.GetString("backups.borg.lock_wait"))
 	cmd = append(cmd, "delete --stats --force")
 	cmd = append(cmd, a.archivePath())
 
@@ -0,0 +1,192 @@
+package borg
+
+import (
+	"bytes"
+	"context"
+	"cs-agent/config"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ArchiveEntry is one item of an archive listing. Path is relative to the
+// volume root; Type is "file", "dir", "symlink", "hardlink", "fifo", "char",
+// "block" or "socket"; Mtime is unix seconds.
+type ArchiveEntry struct {
+	Path       string `json:"path"`
+	Type       string `json:"type"`
+	Size       int64  `json:"size"`
+	Mtime      int64  `json:"mtime"`
+	Mode       string `json:"mode"`
+	LinkTarget string `json:"link_target,omitempty"`
+}
+
+// ArchiveListing is one page of an archive listing: Total counts every entry
+// under the listed path, Entries the page of them from the requested offset.
+type ArchiveListing struct {
+	Total   int
+	Entries []ArchiveEntry
+}
+
+// listItem is a `borg list --json-lines` line.
+type listItem struct {
+	Type       string `json:"type"`
+	Mode       string `json:"mode"`
+	Path       string `json:"path"`
+	LinkTarget string `json:"linktarget"`
+	Mtime      string `json:"mtime"`
+	Size       int64  `json:"size"`
+}
+
+var entryTypes = map[string]string{
+	"-": "file",
+	"d": "dir",
+	"l": "symlink",
+	"h": "hardlink",
+	"p": "fifo",
+	"c": "char",
+	"b": "block",
+	"s": "socket",
+}
+
+// List lists the archive's entries under path ("" = everything) in borg's
+// order, returning only those from offset to offset+limit. The listing is
+// streamed, so an archive of millions of files is never held in memory whole.
+func (a *Archive) List(ctx context.Context, path string, offset, limit int) (ArchiveListing, *LogMessage) {
+	w := &listingWriter{offset: offset, limit: limit}
+	if lg := a.list(ctx, path, false, w); lg != nil {
+		return ArchiveListing{}, lg
+	}
+	return w.listing, nil
+}
+
+// ListForExport is List run the way an export reads the archive: with
+// --bypass-lock (see ExportTar), so checking what an export is about to stream
+// is never blocked by a backup holding the repository lock.
+func (a *Archive) ListForExport(ctx context.Context, path string, offset, limit int) (ArchiveListing, *LogMessage) {
+	w := &listingWriter{offset: offset, limit: limit}
+	if lg := a.list(ctx, path, true, w); lg != nil {
+		return ArchiveListing{}, lg
+	}
+	return w.listing, nil
+}
+
+// FileUsage counts the archive's regular files (hardlinks included, as find
+// counts them) and their total size in bytes, from the same streamed listing —
+// what an extract of it must write (see Repository.DataUsage).
+func (a *Archive) FileUsage(ctx context.Context) (files, bytes int64, log *LogMessage) {
+	w := &listingWriter{}
+	if lg := a.list(ctx, "", false, w); lg != nil {
+		return 0, 0, lg
+	}
+	return w.files, w.bytes, nil
+}
+
+// list streams `borg list --json-lines` of the archive's entries under path
+// into w, with --bypass-lock when bypassLock is set.
+func (a *Archive) list(ctx context.Context, path string, bypassLock bool, w *listingWriter) *LogMessage {
+	if a.Repository == nil {
+		return &LogMessage{Message: "Missing Repository"}
+	}
+	if reflect.ValueOf(a.Repository.Container).IsNil() {
+		return &LogMessage{Message: "Missing backup container"}
+	}
+
+	exitCode, stderr, err := a.Repository.Container.ExecStream(ctx, []string{"sh", "-c", a.listCommand(path, bypassLock)}, w)
+	if err != nil {
+		if ctx.Err() != nil {
+			return &LogMessage{MsgID: MsgIDCancelled, Message: "task cancelled; borg interrupted"}
+		}
+		return &LogMessage{Message: err.Error()}
+	}
+	if exitCode != 0 {
+		if log := readArchiveRestoreResponse(stderr); log != nil {
+			return log
+		}
+		return &LogMessage{Message: "borg list exited with code " + strconv.Itoa(exitCode)}
+	}
+	w.flush()
+	return nil
+}
+
+// listCommand is the `borg list --json-lines` command line for list.
+func (a *Archive) listCommand(path string, bypassLock bool) string {
+	cmd := []string{"borg --log-json"}
+	if bypassLock {
+		cmd = append(cmd, "--bypass-lock")
+	} else {
+		cmd = append(cmd, "--lock-wait "+config.GetString("backups.borg.lock_wait"))
+	}
+	cmd = append(cmd, "list --json-lines")
+	cmd = append(cmd, a.archivePath())
+	if path != "" {
+		cmd = append(cmd, shellQuote(path))
+	}
+	return strings.Join(cmd, " ")
+}
+
+// listingWriter parses `borg list --json-lines` output as it arrives, counting
+// every entry and keeping the page [offset, offset+limit). files and bytes tally
+// the regular files (see Archive.FileUsage).
+type listingWriter struct {
+	offset, limit int
+	line          []byte
+	listing       ArchiveListing
+	files, bytes  int64
+}
+
+func (w *listingWriter) Write(p []byte) (int, error) {
+	w.line = append(w.line, p...)
+	for {
+		i := bytes.IndexByte(w.line, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.add(w.line[:i])
+		w.line = w.line[i+1:]
+	}
+}
+
+func (w *listingWriter) flush() {
+	if len(w.line) > 0 {
+		w.add(w.line)
+		w.line = nil
+	}
+}
+
+func (w *listingWriter) add(line []byte) {
+	var it listItem
+	if json.Unmarshal(bytes.TrimSpace(line), &it) != nil || it.Path == "" {
+		return
+	}
+	if it.Type == "-" || it.Type == "h" {
+		w.files++
+		w.bytes += it.Size
+	}
+	n := w.listing.Total
+	w.listing.Total++
+	if n < w.offset || n >= w.offset+w.limit {
+		return
+	}
+	typ, ok := entryTypes[it.Type]
+	if !ok {
+		typ = it.Type
+	}
+	var mtime int64
+	for _, layout := range []string{"2006-01-02T15:04:05.999999", time.RFC3339Nano} {
+		if t, err := time.Parse(layout, it.Mtime); err == nil {
+			mtime = t.Unix()
+			break
+		}
+	}
+	w.listing.Entries = append(w.listing.Entries, ArchiveEntry{
+		Path:       it.Path,
+		Type:       typ,
+		Size:       it.Size,
+		Mtime:      mtime,
+		Mode:       it.Mode,
+		LinkTarget: it.LinkTarget,
+	})
+}
@@ -0,0 +1,74 @@
+package borg
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestListingWriter(t *testing.T) {
+	out := `{"type": "d", "mode": "drwxr-xr-x", "path": "etc", "linktarget": "", "mtime": "2026-10-01T10:00:00.000000", "size": 0}
+{"type": "-", "mode": "-rw-r--r--", "path": "etc/app.conf", "linktarget": "", "mtime": "2026-10-01T10:00:01.500000", "size": 42}
+{"type": "l", "mode": "lrwxrwxrwx", "path": "etc/current", "linktarget": "app.conf", "mtime": "2026-10-01T10:00:02.000000", "size": 0}
+{"type": "-", "mode": "-rw-------", "path": "etc/secret", "linktarget": "", "mtime": "2026-10-01T10:00:03.000000", "size": 7}`
+	w := &listingWriter{offset: 1, limit: 2}
+	// Split mid-line, as a stream would.
+	_, _ = w.Write([]byte(out[:50]))
+	_, _ = w.Write([]byte(out[50:]))
+	w.flush()
+
+	if w.listing.Total != 4 || len(w.listing.Entries) != 2 {
+		t.Fatalf("listing = %+v", w.listing)
+	}
+	got := w.listing.Entries
+	if got[0].Path != "etc/app.conf" || got[0].Type != "file" || got[0].Size != 42 || got[0].Mtime != 1790848801 {
+		t.Errorf("entry 0 = %+v", got[0])
+	}
+	if got[1].Type != "symlink" || got[1].LinkTarget != "app.conf" {
+		t.Errorf("entry 1 = %+v", got[1])
+	}
+	// FileUsage's tally: the regular files only, across the whole listing.
+	if w.files != 2 || w.bytes != 49 {
+		t.Errorf("usage = %d files, %d bytes; want 2, 49", w.files, w.bytes)
+	}
+}
+
+// TestListCommand proves the export's listing bypasses the repository lock
+// (as the export itself does) while a browse waits for it.
+func TestListCommand(t *testing.T) {
+	viper.Set("backups.borg.lock_wait", "60")
+	t.Cleanup(func() { viper.Set("backups.borg.lock_wait", nil) })
+	a := &Archive{Name: "auto-1"}
+	if got, want := a.listCommand("etc/app.conf", true), "borg --log-json --bypass-lock list --json-lines ::auto-1 'etc/app.conf'"; got != want {
+		t.Errorf("export listing:\n got: %s\nwant: %s", got, want)
+	}
+	if got, want := a.listCommand("", false), "borg --log-json --lock-wait 60 list --json-lines ::auto-1"; got != want {
+		t.Errorf("browse listing:\n got: %s\nwant: %s", got, want)
+	}
+}
+
+func TestCleanArchivePath(t *testing.T) {
+	for in, want := range map[string]string{
+		"":                "",
+		"/":               "",
+		".":               "",
+		"/etc/app.conf":   "etc/app.conf",
+		"./etc//app.conf": "etc/app.conf",
+		"etc/nginx/":      "etc/nginx",
+	} {
+		if got, ok := CleanArchivePath(in); !ok || got != want {
+			t.Errorf("CleanArchivePath(%q) = %q, %v; want %q", in, got, ok, want)
+		}
+	}
+	for _, in := range []string{"..", "../etc", "etc/../../root"} {
+		if _, ok := CleanArchivePath(in); ok {
+			t.Errorf("CleanArchivePath(%q) accepted", in)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	if got := shellQuote(`it's $(here)`); got != `'it'\''s $(here)'` {
+		t.Fatalf("shellQuote = %s", got)
+	}
+}
@@ -0,0 +1,55 @@
+package borg
+
+import (
+	"context"
+	"cs-agent/config"
+	"time"
+
+	"github.com/docker/docker/api/types/filters"
+	volumeTypes "github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+)
+
+// cloneTaskLabel carries the id of the volume.clone task that created a clone
+// volume, so the boot reconcile can remove the partial clone of a task a crash
+// interrupted.
+const cloneTaskLabel = "com.computestacks.task"
+
+// CreateCloneVolume creates the empty Docker volume name that a volume.clone
+// task extracts one of forVol's archives into. It is labelled with the volume
+// and archive it was cloned from and with the task. name must be unique:
+// Docker hands back the existing volume for a name already taken.
+func CreateCloneVolume(name, forVol, archive, taskID string) error {
+	return createVolume(name, map[string]string{
+		"com.computestacks.role":    "clone",
+		"com.computestacks.for":     forVol,
+		"com.computestacks.archive": archive,
+		cloneTaskLabel:              taskID,
+	})
+}
+
+// RemoveCloneVolumes removes the clone volumes task taskID created.
+func RemoveCloneVolumes(taskID string) (int, error) {
+	cli, err := client.NewClientWithOpts(client.WithVersion(config.GetString("docker.version")))
+	if err != nil {
+		return 0, err
+	}
+	defer cli.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	found, err := cli.VolumeList(ctx, volumeTypes.ListOptions{Filters: filters.NewArgs(
+		filters.Arg("label", "com.computestacks.role=clone"),
+		filters.Arg("label", cloneTaskLabel+"="+taskID),
+	)})
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, v := range found.Volumes {
+		if err := removeVolume(ctx, cli, v.Name); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
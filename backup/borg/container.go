@@ -2,6 +2,7 @@ package borg
 
 import (
 	"context"
+	"cs-agent/config"
 	"cs-agent/containermgr"
 	"cs-agent/sshremote"
 	"cs-agent/types"
@@ -15,11 +16,11 @@ import (
 
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/mount"
 	volumeTypes "github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
 	"github.com/getsentry/sentry-go"
-	"github.com/spf13/viper"
 )
 
 func (r *Repository) InitBackupContainer(vol *types.Volume, source *types.Volume) (bool, error) {
@@ -27,7 +28,7 @@ func (r *Repository) InitBackupContainer(vol *types.Volume, source *types.Volume
 		// If a container already exists, stop.
 		return true, nil
 	}
-	cli, clientErr := client.NewClientWithOpts(client.WithVersion(viper.GetString("docker.version")))
+	cli, clientErr := client.NewClientWithOpts(client.WithVersion(config.GetString("docker.version")))
 	if clientErr != nil {
 		borgLogger().Error("Unable to connect to Docker", "error", clientErr.Error())
 		return false, clientErr
@@ -41,9 +42,9 @@ func (r *Repository) InitBackupContainer(vol *types.Volume, source *types.Volume
 	ctx := context.Background()
 
 	// Ensure image exists
-	_, _, missingImage := cli.ImageInspectWithRaw(ctx, viper.GetString("backups.borg.image"))
+	_, _, missingImage := cli.ImageInspectWithRaw(ctx, config.GetString("backups.borg.image"))
 	if missingImage != nil {
-		_, err := cli.ImagePull(ctx, viper.GetString("backups.borg.image"), image.PullOptions{})
+		_, err := cli.ImagePull(ctx, config.GetString("backups.borg.image"), image.PullOptions{})
 		if err != nil {
 			borgLogger().Error("Fatal error pulling image", "error", clientErr.Error())
 			return false, err
@@ -55,9 +56,9 @@ func (r *Repository) InitBackupContainer(vol *types.Volume, source *types.Volume
 	labels["com.computestacks.role"] = "backup"
 	labels["com.computestacks.for"] = vol.Name
 
-	if viper.GetBool("backups.borg.ssh.enabled") {
+	if config.GetBool("backups.borg.ssh.enabled") {
 		labels["com.computestacks.backup-kind"] = "ssh"
-	} else if viper.GetBool("backups.borg.nfs") {
+	} else if config.GetBool("backups.borg.nfs") {
 		labels["com.computestacks.backup-kind"] = "nfs"
 	} else {
 		labels["com.computestacks.backup-kind"] = "local"
@@ -70,7 +71,7 @@ func (r *Repository) InitBackupContainer(vol *types.Volume, source *types.Volume
 	containerName := "backup-" + strconv.Itoa(randNumber) + string(t.Format("150405"))
 
 	borgEnv := []string{
-		"BORG_PASSPHRASE=" + viper.GetString("backups.key"),
+		"BORG_PASSPHRASE=" + config.GetString("backups.key"),
 		"BORG_RELOCATED_REPO_ACCESS_IS_OK=yes",
 		"BORG_DELETE_I_KNOW_WHAT_I_AM_DOING=YES",
 		"BORG_CHECK_I_KNOW_WHAT_I_AM_DOING=YES",
@@ -83,7 +84,7 @@ func (r *Repository) InitBackupContainer(vol *types.Volume, source *types.Volume
 		Binds:       []string{},
 		Mounts:      []mount.Mount{},
 		AutoRemove:  true,
-		Privileged:  viper.GetBool("docker.privileged"),
+		Privileged:  config.GetBool("docker.privileged"),
 	}
 
 	hostConfig.Mounts = append(hostConfig.Mounts, mount.Mount{
@@ -95,9 +96,9 @@ func (r *Repository) InitBackupContainer(vol *types.Volume, source *types.Volume
 
 	hostConfig.NetworkMode = "host"
 
-	if viper.GetBool("backups.borg.ssh.enabled") {
-		borgEnv = append(borgEnv, "BORG_REMOTE_PATH="+viper.GetString("backups.borg.ssh_borg_remote_path"))
-		borgEnv = append(borgEnv, "BORG_RSH=ssh -i "+viper.GetString("backups.borg.ssh.keyfile"))
+	if config.GetBool("backups.borg.ssh.enabled") {
+		borgEnv = append(borgEnv, "BORG_REMOTE_PATH="+config.GetString("backups.borg.ssh_borg_remote_path"))
+		borgEnv = append(borgEnv, "BORG_RSH=ssh -i "+config.GetString("backups.borg.ssh.keyfile"))
 	}
 
 	hostConfig.Mounts = append(hostConfig.Mounts, mount.Mount{
@@ -107,15 +108,19 @@ func (r *Repository) InitBackupContainer(vol *types.Volume, source *types.Volume
 	})
 
 	if !vol.Trash {
+		dataVolume := vol.Name
+		if r.DataVolume != "" {
+			dataVolume = r.DataVolume
+		}
 		hostConfig.Mounts = append(hostConfig.Mounts, mount.Mount{
 			Type:   mount.TypeVolume,
-			Source: vol.Name,
+			Source: dataVolume,
 			Target: "/mnt/data",
 		})
 	}
 
 	resp, err := cli.ContainerCreate(ctx, &container.Config{
-		Image:  viper.GetString("backups.borg.image"),
+		Image:  config.GetString("backups.borg.image"),
 		Labels: labels,
 		Env:    borgEnv,
 	}, &hostConfig, nil, nil, containerName)
@@ -155,13 +160,29 @@ func (r *Repository) InitBackupContainer(vol *types.Volume, source *types.Volume
 	return true, nil
 }
 
+// ExecWithLog runs cmd in the backup container under the repository's task
+// context (see Repository.Ctx).
 func (r *Repository) ExecWithLog(cmd []string) (exitCode int, response string, log LogMessage) {
+	ctx := r.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return r.execWithLog(ctx, cmd)
+}
+
+// execWithLog is ExecWithLog under an explicit context. Cleanup steps that must
+// still run after a cancel (e.g. a restore rollback) pass context.Background().
+func (r *Repository) execWithLog(ctx context.Context, cmd []string) (exitCode int, response string, log LogMessage) {
 	if reflect.ValueOf(r.Container).IsNil() {
 		return 99, "", LogMessage{Message: "Missing backup container"}
 	}
 	execCmd := []string{"sh", "-c", strings.Join(cmd, " ")}
-	exitCode, response, err := r.Container.Exec(execCmd)
+	exitCode, response, err := r.execTo(ctx, execCmd)
 
+	if err != nil && ctx.Err() != nil {
+		borgLogger().Warn("borg exec interrupted", "repo", r.Name, "error", err.Error())
+		return exitCode, response, LogMessage{MsgID: MsgIDCancelled, Message: "task cancelled; borg interrupted"}
+	}
 	if err != nil {
 		borgLogger().Debug("ExecWithLog Error", "error", err.Error())
 		if response == "" {
@@ -200,6 +221,39 @@ func (r *Repository) StopContainer() bool {
 	return r.Container.Stop()
 }
 
+// RemoveBackupContainers force-removes every backup container (running or not)
+// labelled for the volume — the leftovers of a process that died mid-task. Removing
+// a running one kills its borg, which is what makes a following BreakLock safe.
+// Only call it when no task of this agent can be using the volume's container.
+func RemoveBackupContainers(volName string) (int, error) {
+	cli, err := client.NewClientWithOpts(client.WithVersion(config.GetString("docker.version")))
+	if err != nil {
+		return 0, err
+	}
+	defer cli.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	found, err := cli.ContainerList(ctx, container.ListOptions{
+		All: true,
+		Filters: filters.NewArgs(
+			filters.Arg("label", "com.computestacks.role=backup"),
+			filters.Arg("label", "com.computestacks.for="+volName),
+		),
+	})
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, c := range found {
+		if rmErr := cli.ContainerRemove(ctx, c.ID, container.RemoveOptions{Force: true}); rmErr != nil && !client.IsErrNotFound(rmErr) {
+			return removed, rmErr
+		}
+		borgLogger().Info("Removed stale backup container", "volume", volName, "container", c.ID, "state", c.State)
+		removed++
+	}
+	return removed, nil
+}
+
 /*
 Volumes
 */
@@ -217,16 +271,16 @@ func (r *Repository) ensureBackupVolumeExists(cli *client.Client, vol *types.Vol
 		// Driver Opts
 		driverOpts := make(map[string]string)
 
-		if viper.GetBool("backups.borg.nfs") {
-			if viper.GetBool("backups.borg.nfs_create_path") {
+		if config.GetBool("backups.borg.nfs") {
+			if config.GetBool("backups.borg.nfs_create_path") {
 				borgLogger().Info("Creating remote volume directory", "volume", "b-"+vol.Name, "type", "nfs")
-				sshCmd := "mkdir -p " + viper.GetString("backups.borg.nfs_host_path") + "/b-" + vol.Name
-				sshCmd = sshCmd + " && chown -R " + viper.GetString("backups.borg.nfs_ssh.fs_user") + ":" + viper.GetString("backups.borg.nfs_ssh.fs_group") + " " + viper.GetString("backups.borg.nfs_host_path") + "/b-" + vol.Name
+				sshCmd := "mkdir -p " + config.GetString("backups.borg.nfs_host_path") + "/b-" + vol.Name
+				sshCmd = sshCmd + " && chown -R " + config.GetString("backups.borg.nfs_ssh.fs_user") + ":" + config.GetString("backups.borg.nfs_ssh.fs_group") + " " + config.GetString("backups.borg.nfs_host_path") + "/b-" + vol.Name
 				connInfo := sshremote.ServerConnInfo{
-					Server: viper.GetString("backups.borg.nfs_host"),
-					Port:   viper.GetString("backups.borg.nfs_ssh.port"),
-					User:   viper.GetString("backups.borg.nfs_ssh.user"),
-					Key:    viper.GetString("backups.borg.nfs_ssh.keyfile"),
+					Server: config.GetString("backups.borg.nfs_host"),
+					Port:   config.GetString("backups.borg.nfs_ssh.port"),
+					User:   config.GetString("backups.borg.nfs_ssh.user"),
+					Key:    config.GetString("backups.borg.nfs_ssh.keyfile"),
 				}
 
 				createDirSuccess, createDirErr := sshremote.SSHCommandBool(sshCmd, connInfo)
@@ -242,18 +296,18 @@ func (r *Repository) ensureBackupVolumeExists(cli *client.Client, vol *types.Vol
 				}
 			}
 			driverOpts["type"] = "nfs"
-			driverOpts["o"] = "addr=" + viper.GetString("backups.borg.nfs_host") + ",rw,nfsvers=4" + viper.GetString("backups.borg.nfs_opts")
-			driverOpts["device"] = ":" + viper.GetString("backups.borg.nfs_host_path") + "/b-" + vol.Name
+			driverOpts["o"] = "addr=" + config.GetString("backups.borg.nfs_host") + ",rw,nfsvers=4" + config.GetString("backups.borg.nfs_opts")
+			driverOpts["device"] = ":" + config.GetString("backups.borg.nfs_host_path") + "/b-" + vol.Name
 
-		} else if viper.GetBool("backups.borg.ssh.enabled") {
+		} else if config.GetBool("backups.borg.ssh.enabled") {
 
 			borgLogger().Info("Creating remote volume directory", "repository", "b-"+r.Name, "type", "ssh")
-			sshCmd := "mkdir -p " + viper.GetString("backups.borg.ssh.host_path") + "/b-" + r.Name + "/backup"
+			sshCmd := "mkdir -p " + config.GetString("backups.borg.ssh.host_path") + "/b-" + r.Name + "/backup"
 			connInfo := sshremote.ServerConnInfo{
-				Server: viper.GetString("backups.borg.ssh.host"),
-				Port:   viper.GetString("backups.borg.ssh.port"),
-				User:   viper.GetString("backups.borg.ssh.user"),
-				Key:    viper.GetString("backups.borg.ssh.keyfile"),
+				Server: config.GetString("backups.borg.ssh.host"),
+				Port:   config.GetString("backups.borg.ssh.port"),
+				User:   config.GetString("backups.borg.ssh.user"),
+				Key:    config.GetString("backups.borg.ssh.keyfile"),
 			}
 
 			createDirSuccess, createDirErr := sshremote.SSHCommandBool(sshCmd, connInfo)
@@ -291,7 +345,7 @@ func (r *Repository) ensureBackupVolumeExists(cli *client.Client, vol *types.Vol
 
 func (r *Repository) TrashBackupVolumeExists(vol *types.Volume) (bool, error) {
 	ctx := context.Background()
-	cli, clientErr := client.NewClientWithOpts(client.WithVersion(viper.GetString("docker.version")))
+	cli, clientErr := client.NewClientWithOpts(client.WithVersion(config.GetString("docker.version")))
 	if clientErr != nil {
 		borgLogger().Error("Unable to connect to Docker", "error", clientErr.Error())
 		return false, clientErr
@@ -307,15 +361,15 @@ func (r *Repository) TrashBackupVolumeExists(vol *types.Volume) (bool, error) {
 		return false, removeErr
 	}
 
-	if viper.GetBool("backups.borg.nfs") {
+	if config.GetBool("backups.borg.nfs") {
 		borgLogger().Info("Cleaning remote volume path", "volume", "b-"+vol.Name)
 
-		sshCmd := "rm -rf " + viper.GetString("backups.borg.nfs_host_path") + "/b-" + vol.Name
+		sshCmd := "rm -rf " + config.GetString("backups.borg.nfs_host_path") + "/b-" + vol.Name
 		connInfo := sshremote.ServerConnInfo{
-			Server: viper.GetString("backups.borg.nfs_host"),
-			Port:   viper.GetString("backups.borg.nfs_ssh.port"),
-			User:   viper.GetString("backups.borg.nfs_ssh.user"),
-			Key:    viper.GetString("backups.borg.nfs_ssh.keyfile"),
+			Server: config.GetString("backups.borg.nfs_host"),
+			Port:   config.GetString("backups.borg.nfs_ssh.port"),
+			User:   config.GetString("backups.borg.nfs_ssh.user"),
+			Key:    config.GetString("backups.borg.nfs_ssh.keyfile"),
 		}
 
 		destroyDirSuccess, destroyDirErr := sshremote.SSHCommandBool(sshCmd, connInfo)
@@ -329,16 +383,16 @@ func (r *Repository) TrashBackupVolumeExists(vol *types.Volume) (bool, error) {
 			borgLogger().Warn("Invalid response while destroying remote directory", "volume", "b-"+vol.Name)
 			return false, errors.New("invalid response while destroying directory")
 		}
-	} else if viper.GetBool("backups.borg.ssh.enabled") {
+	} else if config.GetBool("backups.borg.ssh.enabled") {
 
 		borgLogger().Info("Cleaning remote volume path", "repository", r.Name, "method", "ssh")
 
-		sshCmd := "rm -rf " + viper.GetString("backups.borg.ssh.host_path") + "/b-" + vol.Name
+		sshCmd := "rm -rf " + config.GetString("backups.borg.ssh.host_path") + "/b-" + vol.Name
 		connInfo := sshremote.ServerConnInfo{
-			Server: viper.GetString("backups.borg.ssh.host"),
-			Port:   viper.GetString("backups.borg.ssh.port"),
-			User:   viper.GetString("backups.borg.ssh.user"),
-			Key:    viper.GetString("backups.borg.ssh.keyfile"),
+			Server: config.GetString("backups.borg.ssh.host"),
+			Port:   config.GetString("backups.borg.ssh.port"),
+			User:   config.GetString("backups.borg.ssh.user"),
+			Key:    config.GetString("backups.borg.ssh.keyfile"),
 		}
 
 		destroyDirSuccess, destroyDirErr := sshremote.SSHCommandBool(sshCmd, connInfo)
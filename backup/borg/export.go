@@ -2,15 +2,16 @@ package borg
 
 import (
 	"context"
+	"cs-agent/config"
 	"io"
 	"reflect"
 	"strconv"
 	"strings"
-
-	"github.com/spf13/viper"
 )
 
-// ExportTar streams the archive as a tar to w using `borg export-tar`.
+// ExportTar streams the archive as a tar to w using `borg export-tar` — only
+// the given paths in it when there are any (archive paths as CleanArchivePath
+// returns them).
 //
 // It runs with --bypass-lock: the export is read-only and the repo uses
 // authenticated encryption, so a concurrent writer can only make the export
@@ -22,7 +23,7 @@ import (
 // w receives raw tar bytes; borg's --log-json diagnostics arrive on stderr and
 // are parsed into a LogMessage. A non-nil return means the tar written to w is
 // incomplete/untrustworthy and must NOT be published.
-func (a *Archive) ExportTar(ctx context.Context, w io.Writer) *LogMessage {
+func (a *Archive) ExportTar(ctx context.Context, w io.Writer, paths ...string) *LogMessage {
 	if a.Repository == nil {
 		return &LogMessage{Message: "Missing Repository"}
 	}
@@ -32,12 +33,37 @@ func (a *Archive) ExportTar(ctx context.Context, w io.Writer) *LogMessage {
 
 	cmd := []string{"borg --log-json --bypass-lock"}
 	cmd = append(cmd, "export-tar")
-	if f := viper.GetString("backups.export.tar_filter"); f != "" {
+	if f := config.GetString("backups.export.tar_filter"); f != "" {
 		cmd = append(cmd, "--tar-filter='"+f+"'")
 	}
 	cmd = append(cmd, a.archivePath())
 	cmd = append(cmd, "-") // write the tar to stdout
+	for _, p := range paths {
+		cmd = append(cmd, shellQuote(p))
+	}
+	return a.stream(ctx, cmd, w, "export-tar")
+}
+
+// ExportFile streams one regular file of the archive, as its raw bytes, to w
+// using `borg extract --stdout`. It runs with --bypass-lock and its callers
+// hold the per-repo lock, as for ExportTar.
+func (a *Archive) ExportFile(ctx context.Context, w io.Writer, path string) *LogMessage {
+	if a.Repository == nil {
+		return &LogMessage{Message: "Missing Repository"}
+	}
+	if reflect.ValueOf(a.Repository.Container).IsNil() {
+		return &LogMessage{Message: "Missing backup container"}
+	}
+
+	cmd := []string{"borg --log-json --bypass-lock"}
+	cmd = append(cmd, "extract --stdout")
+	cmd = append(cmd, a.archivePath())
+	cmd = append(cmd, shellQuote(path))
+	return a.stream(ctx, cmd, w, "extract --stdout")
+}
 
+// stream runs a borg command whose stdout is the payload, writing it to w.
+func (a *Archive) stream(ctx context.Context, cmd []string, w io.Writer, what string) *LogMessage {
 	exitCode, stderr, err := a.Repository.Container.ExecStream(ctx, []string{"sh", "-c", strings.Join(cmd, " ")}, w)
 	if err != nil {
 		return &LogMessage{Message: err.Error()}
@@ -47,7 +73,7 @@ func (a *Archive) ExportTar(ctx context.Context, w io.Writer) *LogMessage {
 		if log := readArchiveRestoreResponse(stderr); log != nil {
 			return log
 		}
-		return &LogMessage{Message: "borg export-tar exited with code " + strconv.Itoa(exitCode)}
+		return &LogMessage{Message: "borg " + what + " exited with code " + strconv.Itoa(exitCode)}
 	}
 	return nil
 }
@@ -0,0 +1,109 @@
+package borg
+
+import (
+	"bytes"
+	"context"
+	"cs-agent/taskevent"
+	"encoding/json"
+	"io"
+)
+
+// progressRecord is a borg --log-json progress line (archive_progress during a
+// create, progress_percent / progress_message during e.g. an extract).
+type progressRecord struct {
+	Type     string `json:"type"`
+	MsgID    string `json:"msgid"`
+	Finished bool   `json:"finished"`
+	Message  string `json:"message"`
+	// archive_progress
+	OriginalSize int64  `json:"original_size"`
+	NFiles       int64  `json:"nfiles"`
+	Path         string `json:"path"`
+	// progress_percent
+	Current int64 `json:"current"`
+	Total   int64 `json:"total"`
+}
+
+// streamsProgress reports whether the repository's task has a live event
+// stream, i.e. whether a long borg command is worth running with --progress.
+func (r *Repository) streamsProgress() bool {
+	return r.Ctx != nil && taskevent.FromContext(r.Ctx) != nil
+}
+
+// progressWriter sits between a borg exec's output and the buffer the caller
+// parses: borg's JSON progress lines are published to the task's event stream
+// and kept out of the buffer (they would break the --json response and the
+// error parsing); every other byte passes through unchanged.
+type progressWriter struct {
+	out    io.Writer
+	events *taskevent.Stream
+	line   []byte
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.line = append(w.line, p...)
+	for {
+		i := bytes.IndexByte(w.line, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.emit(w.line[:i+1])
+		w.line = w.line[i+1:]
+	}
+}
+
+// Flush passes through a trailing unterminated line.
+func (w *progressWriter) Flush() {
+	if len(w.line) > 0 {
+		w.emit(w.line)
+		w.line = nil
+	}
+}
+
+func (w *progressWriter) emit(line []byte) {
+	if e, ok := progressEvent(line); ok {
+		w.events.Publish(e)
+		return
+	}
+	_, _ = w.out.Write(line)
+}
+
+// progressEvent converts a borg progress line into a task event. ok=false for
+// anything that is not one (which the caller passes through).
+func progressEvent(line []byte) (taskevent.Event, bool) {
+	trimmed := bytes.TrimSpace(line)
+	if len(trimmed) < 2 || trimmed[0] != '{' || trimmed[len(trimmed)-1] != '}' {
+		return taskevent.Event{}, false
+	}
+	var rec progressRecord
+	if json.Unmarshal(trimmed, &rec) != nil {
+		return taskevent.Event{}, false
+	}
+	e := taskevent.Event{Type: taskevent.TypeProgress, Phase: rec.MsgID, Message: rec.Message}
+	switch rec.Type {
+	case "archive_progress":
+		e.Phase = "create"
+		e.Bytes = rec.OriginalSize
+		e.Files = rec.NFiles
+		e.Message = rec.Path
+	case "progress_percent":
+		e.Bytes = rec.Current
+		e.Total = rec.Total
+		if rec.Total > 0 {
+			e.Percent = float64(rec.Current) * 100 / float64(rec.Total)
+		}
+	case "progress_message":
+	default:
+		return taskevent.Event{}, false
+	}
+	return e, true
+}
+
+// execTo runs cmd through a progressWriter and returns the remaining output.
+func (r *Repository) execTo(ctx context.Context, cmd []string) (exitCode int, response string, err error) {
+	var buf bytes.Buffer
+	pw := &progressWriter{out: &buf, events: taskevent.FromContext(ctx)}
+	exitCode, err = r.Container.ExecContextTo(ctx, cmd, pw)
+	pw.Flush()
+	return exitCode, buf.String(), err
+}
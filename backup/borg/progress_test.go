@@ -0,0 +1,49 @@
+package borg
+
+import (
+	"bytes"
+	"cs-agent/taskevent"
+	"testing"
+)
+
+// TestProgressWriter proves borg's JSON progress lines are published and kept
+// out of the output the caller parses, whatever the write boundaries — the
+// --json response and log lines pass through byte for byte.
+func TestProgressWriter(t *testing.T) {
+	hub := taskevent.NewHub()
+	var out bytes.Buffer
+	pw := &progressWriter{out: &out, events: hub.Open("t1")}
+	input := "{\"type\": \"archive_progress\", \"original_size\": 2048, \"nfiles\": 3, \"path\": \"data/a\", \"finished\": false}\r\n" +
+		"{\n    \"archive\": {\"name\": \"auto-x\"}\n}\n" +
+		"{\"type\": \"log_message\", \"msgid\": \"Foo\", \"message\": \"boom\"}\n" +
+		"tail"
+	for i := 0; i < len(input); i += 7 { // arbitrary chunking
+		end := min(i+7, len(input))
+		if _, err := pw.Write([]byte(input[i:end])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pw.Flush()
+
+	want := "{\n    \"archive\": {\"name\": \"auto-x\"}\n}\n" +
+		"{\"type\": \"log_message\", \"msgid\": \"Foo\", \"message\": \"boom\"}\n" +
+		"tail"
+	if out.String() != want {
+		t.Fatalf("passed through:\n%q\nwant\n%q", out.String(), want)
+	}
+	history, _, _, cancel := hub.Subscribe("t1", 0)
+	defer cancel()
+	if len(history) != 1 || history[0].Type != taskevent.TypeProgress || history[0].Bytes != 2048 || history[0].Files != 3 || history[0].Message != "data/a" {
+		t.Fatalf("published = %+v", history)
+	}
+}
+
+func TestProgressEvent_Percent(t *testing.T) {
+	e, ok := progressEvent([]byte(`{"type": "progress_percent", "msgid": "extract", "current": 25, "total": 200, "message": "12.5% Extracting: a"}`))
+	if !ok || e.Percent != 12.5 || e.Bytes != 25 || e.Total != 200 || e.Message != "12.5% Extracting: a" {
+		t.Fatalf("progressEvent = %+v, %v", e, ok)
+	}
+	if _, ok := progressEvent([]byte(`{"type": "log_message"}`)); ok {
+		t.Fatal("a log message was taken for progress")
+	}
+}
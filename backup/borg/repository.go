@@ -15,19 +15,19 @@ package borg
 
 import (
 	"context"
+	"cs-agent/config"
 	"cs-agent/sshremote"
 	"cs-agent/store"
 	"cs-agent/types"
 	"reflect"
 	"strconv"
 
-	"github.com/spf13/viper"
-
+	"github.com/docker/docker/client"
 	"github.com/getsentry/sentry-go"
 )
 
-func FindRepository(st *store.Store, vol *types.Volume, source *types.Volume) (*Repository, *LogMessage) {
-	r := Repository{Name: vol.Name, Retention: vol.Retention, SourceVolumeName: source.Name, Store: st}
+func FindRepository(ctx context.Context, st *store.Store, vol *types.Volume, source *types.Volume) (*Repository, *LogMessage) {
+	r := Repository{Name: vol.Name, Retention: vol.Retention, SourceVolumeName: source.Name, Store: st, Ctx: ctx}
 
 	containerBuilt, containerErr := r.InitBackupContainer(vol, source)
 	if containerErr != nil {
@@ -77,7 +77,7 @@ func (r *Repository) Setup(vol *types.Volume, source *types.Volume) *LogMessage
 	var backupCmd []string
 
 	backupCmd = append(backupCmd, "borg --log-json")
-	backupCmd = append(backupCmd, "--lock-wait "+viper.GetString("backups.borg.lock_wait"))
+	backupCmd = append(backupCmd, "--lock-wait "+config.GetString("backups.borg.lock_wait"))
 	backupCmd = append(backupCmd, "init --error --encryption=repokey-blake2")
 
 	if _, _, log := r.ExecWithLog(backupCmd); log != (LogMessage{}) {
@@ -95,7 +95,7 @@ func (r *Repository) Info() (RepositoryResponse, *LogMessage) {
 	}
 
 	cmd := []string{"borg --log-json"}
-	cmd = append(cmd, "--lock-wait "+viper.GetString("backups.borg.lock_wait"))
+	cmd = append(cmd, "--lock-wait "+config.GetString("backups.borg.lock_wait"))
 	cmd = append(cmd, "info --error --json")
 
 	_, response, logMsg := r.ExecWithLog(cmd)
@@ -119,7 +119,7 @@ func (r *Repository) Contents() (RepositoryContentResponse, *LogMessage) {
 	}
 
 	cmd := []string{"borg --log-json"}
-	cmd = append(cmd, "--lock-wait "+viper.GetString("backups.borg.lock_wait"))
+	cmd = append(cmd, "--lock-wait "+config.GetString("backups.borg.lock_wait"))
 	cmd = append(cmd, "list --error --json")
 
 	_, response, logMsg := r.ExecWithLog(cmd)
@@ -137,6 +137,58 @@ func (r *Repository) Contents() (RepositoryContentResponse, *LogMessage) {
 	return repoResponse, nil
 }
 
+// BreakLock removes a stale borg repository/cache lock left by a borg process
+// that died holding it (e.g. the agent crashed mid-backup). It must only run once
+// no borg can still be using the repository — see RemoveBackupContainers. A
+// volume never backed up has no backup volume, so no lock: that is reported as
+// Repository.DoesNotExist without building a container (which would create the
+// backup volume, and the remote directory, as a side effect).
+func (r *Repository) BreakLock() *LogMessage {
+	vol := types.Volume{Name: r.Name, Trash: true}
+	sourceVol := types.Volume{Name: r.SourceVolumeName, Trash: true}
+	if reflect.ValueOf(r.Container).IsNil() {
+		exists, err := r.backupVolumeExists()
+		if err != nil {
+			return &LogMessage{Message: err.Error()}
+		}
+		if !exists {
+			return &LogMessage{MsgID: "Repository.DoesNotExist", Message: "no backup volume b-" + r.SourceVolumeName}
+		}
+		containerBuilt, containerErr := r.InitBackupContainer(&vol, &sourceVol)
+		if containerErr != nil {
+			return &LogMessage{Message: containerErr.Error()}
+		}
+		if !containerBuilt {
+			return &LogMessage{Message: "Failed to build backup container"}
+		}
+	}
+	defer r.StopContainer()
+
+	cmd := []string{"borg --log-json break-lock"}
+	if _, _, log := r.ExecWithLog(cmd); log != (LogMessage{}) {
+		return &log
+	}
+	borgLogger().Info("Released stale borg lock", "volume_name", r.Name)
+	return nil
+}
+
+// backupVolumeExists reports whether the repository's backup volume exists,
+// creating nothing (InitBackupContainer creates it on first use).
+func (r *Repository) backupVolumeExists() (bool, error) {
+	cli, err := client.NewClientWithOpts(client.WithVersion(config.GetString("docker.version")))
+	if err != nil {
+		return false, err
+	}
+	defer cli.Close()
+	if _, err := cli.VolumeInspect(context.Background(), "b-"+r.SourceVolumeName); err != nil {
+		if client.IsErrNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
 func (r *Repository) Delete() (bool, error) {
 	vol := types.Volume{Name: r.Name, Trash: true}
 	return r.TrashBackupVolumeExists(&vol)
@@ -150,23 +202,24 @@ func (r *Repository) Delete() (bool, error) {
 		*  Will ignore all repositories that don't match the `auto-` prefix.
 	    *  Testing this by creating 2 backups back-to-back, and then running prune with
 		   an hourly retention of 2 will only retain 1 because the content would not have changed between the 2 backups.
+		*  Returns borg's log lines, the --stats block among them.
 */
-func (r *Repository) Prune() *LogMessage {
+func (r *Repository) Prune() ([]LogMessage, *LogMessage) {
 	vol := types.Volume{Name: r.Name, Trash: true}
 	sourceVol := types.Volume{Name: r.SourceVolumeName, Trash: true}
 	if reflect.ValueOf(r.Container).IsNil() {
 		containerBuilt, containerErr := r.InitBackupContainer(&vol, &sourceVol)
 		if containerErr != nil {
 			sentry.CaptureException(containerErr)
-			return &LogMessage{Message: containerErr.Error()}
+			return nil, &LogMessage{Message: containerErr.Error()}
 		}
 		if !containerBuilt {
-			return &LogMessage{Message: "Failed to build backup container"}
+			return nil, &LogMessage{Message: "Failed to build backup container"}
 		}
 	}
 
 	cmd := []string{"borg --log-json"}
-	cmd = append(cmd, "--lock-wait "+viper.GetString("backups.borg.lock_wait"))
+	cmd = append(cmd, "--lock-wait "+config.GetString("backups.borg.lock_wait"))
 	cmd = append(cmd, "prune --error --stats --prefix=\"auto-\"")
 	cmd = append(cmd, "--keep-hourly="+strconv.Itoa(r.Retention.Hourly))
 	cmd = append(cmd, "--keep-daily="+strconv.Itoa(r.Retention.Daily))
@@ -174,13 +227,74 @@ func (r *Repository) Prune() *LogMessage {
 	cmd = append(cmd, "--keep-monthly="+strconv.Itoa(r.Retention.Monthly))
 	cmd = append(cmd, "--keep-yearly="+strconv.Itoa(r.Retention.Annually))
 
-	if _, _, log := r.ExecWithLog(cmd); log != (LogMessage{}) {
-		return &log
+	_, response, log := r.ExecWithLog(cmd)
+	if log != (LogMessage{}) {
+		return nil, &log
 	}
 
 	r.Sync()
 	borgLogger().Info("Completed prune event", "volume_name", r.Name)
-	return nil
+	return logMessages(response), nil
+}
+
+// Check verifies the repository's integrity: `borg check --repository-only`
+// (segment and index consistency), or with verifyData `borg check --verify-data`,
+// which also reads back and verifies every archive chunk — it reads the whole
+// repository, so it is slow. Callers hold the per-repo lock.
+//
+// ok=false with findings means the check ran and found problems. The returned
+// *LogMessage is for a check that could not run (no container, the repo lock
+// held past --lock-wait, the SSH connection dropped, no repository), which says
+// nothing about the repository's health.
+func (r *Repository) Check(verifyData bool) (ok bool, findings []LogMessage, log *LogMessage) {
+	vol := types.Volume{Name: r.Name, Trash: true}
+	sourceVol := types.Volume{Name: r.SourceVolumeName, Trash: true}
+	if reflect.ValueOf(r.Container).IsNil() {
+		containerBuilt, containerErr := r.InitBackupContainer(&vol, &sourceVol)
+		if containerErr != nil {
+			sentry.CaptureException(containerErr)
+			return false, nil, &LogMessage{Message: containerErr.Error()}
+		}
+		if !containerBuilt {
+			return false, nil, &LogMessage{Message: "Failed to build backup container"}
+		}
+	}
+
+	cmd := []string{"borg --log-json"}
+	cmd = append(cmd, "--lock-wait "+config.GetString("backups.borg.lock_wait"))
+	if verifyData {
+		cmd = append(cmd, "check --verify-data")
+	} else {
+		cmd = append(cmd, "check --repository-only")
+	}
+
+	exitCode, response, execLog := r.ExecWithLog(cmd)
+	if execLog != (LogMessage{}) {
+		return false, nil, &execLog
+	}
+	ok, findings, log = checkOutcome(exitCode, logMessages(response))
+	if log == nil {
+		borgLogger().Info("Completed check event", "volume_name", r.Name, "verify_data", verifyData, "ok", ok)
+	}
+	return ok, findings, log
+}
+
+// checkOutcome classifies a finished `borg check`: exit 0 is a clean repository;
+// otherwise a message saying the check could not run (see Check) wins over
+// treating the output as findings.
+func checkOutcome(exitCode int, msgs []LogMessage) (ok bool, findings []LogMessage, log *LogMessage) {
+	if exitCode == 0 {
+		return true, nil, nil
+	}
+	for i := range msgs {
+		if msgs[i].Retryable() || msgs[i].MsgID == "Repository.DoesNotExist" {
+			return false, nil, &msgs[i]
+		}
+	}
+	if len(msgs) == 0 {
+		return false, nil, &LogMessage{Message: "borg check exited " + strconv.Itoa(exitCode) + " without output"}
+	}
+	return false, msgs, nil
 }
 
 // Compact reclaims space freed by prune/delete. Callers MUST hold the per-repo
@@ -191,39 +305,42 @@ func (r *Repository) Prune() *LogMessage {
 // rewriting segments through the NFS mount would push all that I/O over the
 // network. local/SSH backends compact through the borg container (for the SSH
 // backend borg-serve keeps the heavy work server-side).
-func (r *Repository) Compact() *LogMessage {
-	if viper.GetBool("backups.borg.nfs") {
+//
+// It returns borg's --verbose log lines, which report the space freed.
+func (r *Repository) Compact() ([]LogMessage, *LogMessage) {
+	if config.GetBool("backups.borg.nfs") {
 		return r.compactNFS()
 	}
 	return r.compactContainer()
 }
 
-func (r *Repository) compactContainer() *LogMessage {
+func (r *Repository) compactContainer() ([]LogMessage, *LogMessage) {
 	vol := types.Volume{Name: r.Name, Trash: true}
 	sourceVol := types.Volume{Name: r.SourceVolumeName, Trash: true}
 	if reflect.ValueOf(r.Container).IsNil() {
 		containerBuilt, containerErr := r.InitBackupContainer(&vol, &sourceVol)
 		if containerErr != nil {
 			sentry.CaptureException(containerErr)
-			return &LogMessage{Message: containerErr.Error()}
+			return nil, &LogMessage{Message: containerErr.Error()}
 		}
 		if !containerBuilt {
-			return &LogMessage{Message: "Failed to build backup container"}
+			return nil, &LogMessage{Message: "Failed to build backup container"}
 		}
 	}
 
 	cmd := []string{"borg --log-json"}
-	cmd = append(cmd, "--lock-wait "+viper.GetString("backups.borg.lock_wait"))
+	cmd = append(cmd, "--lock-wait "+config.GetString("backups.borg.lock_wait"))
 	cmd = append(cmd, "compact --error --verbose")
 
-	if _, _, log := r.ExecWithLog(cmd); log != (LogMessage{}) {
-		return &log
+	_, response, log := r.ExecWithLog(cmd)
+	if log != (LogMessage{}) {
+		return nil, &log
 	}
 
 	// Refresh Consul on-disk usage stats now that space has been reclaimed.
 	r.Sync()
 	borgLogger().Info("Completed compact event", "volume_name", r.Name)
-	return nil
+	return logMessages(response), nil
 }
 
 // compactNFS runs `borg compact` on the NFS/backup server over SSH, then fixes
@@ -232,28 +349,29 @@ func (r *Repository) compactContainer() *LogMessage {
 // the files unreadable on the next backup). Mirrors the host cron it replaces.
 // Consul usage stats refresh on the next backup/prune (both call SyncConsul); we
 // don't build a container here just to re-read them.
-func (r *Repository) compactNFS() *LogMessage {
+func (r *Repository) compactNFS() ([]LogMessage, *LogMessage) {
 	cmd, ok := nfsCompactCommand(r.Name)
 	if !ok {
-		return &LogMessage{Message: "refusing to compact: unsafe repository name " + r.Name}
+		return nil, &LogMessage{Message: "refusing to compact: unsafe repository name " + r.Name}
 	}
 
 	connInfo := sshremote.ServerConnInfo{
-		Server: viper.GetString("backups.borg.nfs_host"),
-		Port:   viper.GetString("backups.borg.nfs_ssh.port"),
-		User:   viper.GetString("backups.borg.nfs_ssh.user"),
-		Key:    viper.GetString("backups.borg.nfs_ssh.keyfile"),
+		Server: config.GetString("backups.borg.nfs_host"),
+		Port:   config.GetString("backups.borg.nfs_ssh.port"),
+		User:   config.GetString("backups.borg.nfs_ssh.user"),
+		Key:    config.GetString("backups.borg.nfs_ssh.keyfile"),
 	}
 
-	if _, err := sshremote.SSHCommandString(cmd, connInfo); err != nil {
-		// SSHCommandString folds remote stderr into err; stdout is empty on error.
+	// borg logs to stderr; on error it is folded into err instead.
+	_, stderr, err := sshremote.SSHCommandOutput(cmd, connInfo)
+	if err != nil {
 		borgLogger().Error("NFS compact failed", "volume", r.Name, "error", err.Error())
 		sentry.CaptureException(err)
-		return &LogMessage{Message: err.Error()}
+		return nil, &LogMessage{Message: err.Error()}
 	}
 
 	borgLogger().Info("Completed compact event", "volume_name", r.Name, "backend", "nfs")
-	return nil
+	return logMessages(stderr), nil
 }
 
 // nfsCompactCommand builds the remote shell command to compact a repo locally on
@@ -264,10 +382,10 @@ func nfsCompactCommand(name string) (string, bool) {
 	if !safeRepoName(name) {
 		return "", false
 	}
-	basePath := viper.GetString("backups.borg.nfs_host_path") + "/b-" + name
-	cmd := viper.GetString("backups.borg.nfs_borg_path") + " compact --verbose --log-json " + basePath + "/backup" +
-		" && chown -R " + viper.GetString("backups.borg.nfs_ssh.fs_user") + ":" +
-		viper.GetString("backups.borg.nfs_ssh.fs_group") + " " + basePath
+	basePath := config.GetString("backups.borg.nfs_host_path") + "/b-" + name
+	cmd := config.GetString("backups.borg.nfs_borg_path") + " compact --verbose --log-json " + basePath + "/backup" +
+		" && chown -R " + config.GetString("backups.borg.nfs_ssh.fs_user") + ":" +
+		config.GetString("backups.borg.nfs_ssh.fs_group") + " " + basePath
 	return cmd, true
 }
 
@@ -313,11 +431,11 @@ func (r *Repository) Sync() {
 }
 
 func (r *Repository) repoPath() string {
-	if viper.GetBool("backups.borg.ssh.enabled") {
-		sshUser := viper.GetString("backups.borg.ssh.user")
-		sshHost := viper.GetString("backups.borg.ssh.host")
-		sshPort := viper.GetString("backups.borg.ssh.port")
-		hostPath := viper.GetString("backups.borg.ssh.host_path")
+	if config.GetBool("backups.borg.ssh.enabled") {
+		sshUser := config.GetString("backups.borg.ssh.user")
+		sshHost := config.GetString("backups.borg.ssh.host")
+		sshPort := config.GetString("backups.borg.ssh.port")
+		hostPath := config.GetString("backups.borg.ssh.host_path")
 		fullPath := "ssh://" + sshUser + "@" + sshHost + ":" + sshPort + hostPath + "/b-" + r.Name + "/backup"
 		return fullPath
 	} else {
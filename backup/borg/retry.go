@@ -0,0 +1,52 @@
+package borg
+
+import "strings"
+
+// retryableMsgIDs are the borg --log-json msgids that describe a transient
+// condition rather than a broken repository or a bad request: another process
+// held the repo lock past --lock-wait, or the SSH transport to the backup server
+// dropped. Re-running the same command later is expected to succeed.
+var retryableMsgIDs = map[string]bool{
+	"LockTimeout":              true,
+	"LockFailed":               true,
+	"LockError":                true,
+	"LockErrorT":               true,
+	"ConnectionClosed":         true,
+	"ConnectionClosedWithHint": true,
+}
+
+// transientMarkers match (lowercased) transport failures that surface as plain
+// text — ssh's own stderr, or a borg error borg did not tag with a msgid.
+var transientMarkers = []string{
+	"connection reset by peer",
+	"connection closed by remote host",
+	"connection timed out",
+	"broken pipe",
+	"kex_exchange_identification",
+	"ssh_exchange_identification",
+}
+
+// Retryable reports whether the failure is transient (lock contention, a dropped
+// SSH connection) and the operation may be retried as-is. An interrupted exec
+// (MsgIDCancelled) is never retryable: it was cancelled on purpose.
+func (b *LogMessage) Retryable() bool {
+	if b == nil || b.MsgID == MsgIDCancelled {
+		return false
+	}
+	if retryableMsgIDs[b.MsgID] {
+		return true
+	}
+	return TransientText(b.Message)
+}
+
+// TransientText reports whether a free-form error message describes a transient
+// transport failure (see transientMarkers).
+func TransientText(msg string) bool {
+	msg = strings.ToLower(msg)
+	for _, m := range transientMarkers {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
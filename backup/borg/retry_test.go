@@ -0,0 +1,26 @@
+package borg
+
+import "testing"
+
+func TestLogMessageRetryable(t *testing.T) {
+	cases := []struct {
+		name string
+		msg  *LogMessage
+		want bool
+	}{
+		{"nil", nil, false},
+		{"lock timeout", &LogMessage{MsgID: "LockTimeout", Message: "Failed to create/acquire the lock"}, true},
+		{"connection closed", &LogMessage{MsgID: "ConnectionClosed"}, true},
+		{"ssh reset text", &LogMessage{Message: "Remote: read: Connection reset by peer"}, true},
+		{"missing repo", &LogMessage{MsgID: "Repository.DoesNotExist", Message: "Missing Repository"}, false},
+		{"missing archive", &LogMessage{MsgID: "Archive.DoesNotExist"}, false},
+		{"cancelled", &LogMessage{MsgID: MsgIDCancelled, Message: "broken pipe"}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.msg.Retryable(); got != tc.want {
+				t.Errorf("Retryable() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package borg
 
 import (
+	"context"
 	"cs-agent/containermgr"
 	"cs-agent/store"
 
@@ -21,6 +22,7 @@ type Archive struct {
 type ArchiveMessage struct {
 	Archive struct {
 		ID       string      `json:"id"`
+		Name     string      `json:"name"`
 		Duration float64     `json:"duration"`
 		Start    BTimeFormat `json:"start"`
 		End      BTimeFormat `json:"end"`
@@ -49,6 +51,12 @@ type ArchiveResponse struct {
 		Limits      struct {
 			MaxArchiveSize float64 `json:"max_archive_size"`
 		} `json:"limits"`
+		Stats struct {
+			CompressedSize int64 `json:"compressed_size"`
+			DedupedSize    int64 `json:"deduplicated_size"`
+			FileCount      int64 `json:"nfiles"`
+			OriginalSize   int64 `json:"original_size"`
+		} `json:"stats"`
 	} `json:"archives"`
 	Cache      CacheItem      `json:"cache"`
 	Encryption EncryptionItem `json:"encryption"`
@@ -65,6 +73,11 @@ type LogMessage struct {
 	Name      string  `json:"name"`
 }
 
+// MsgIDCancelled is the LogMessage.MsgID ExecWithLog reports when the task
+// context was cancelled (an operator cancel or agent shutdown) rather than borg
+// failing on its own.
+const MsgIDCancelled = "Task.Cancelled"
+
 // Repo format from ComputeStack Volumes
 type Repository struct {
 	Name             string
@@ -75,6 +88,15 @@ type Repository struct {
 	// Set at construction (FindRepository / the &Repository{} literals); Sync is a
 	// no-op when nil.
 	Store *store.Store
+	// Ctx is the owning task's context. Cancelling it interrupts the in-flight
+	// borg exec (SIGTERM, so borg releases its repository lock) and fails every
+	// later exec fast with MsgIDCancelled. nil means context.Background() — the
+	// scheduler's maintenance paths are not cancellable per task.
+	Ctx context.Context
+	// DataVolume is the Docker volume mounted at /mnt/data in the backup
+	// container; "" means the volume itself. A verify extracts into a scratch
+	// volume instead.
+	DataVolume string
 	//ContainerConfig        *BorgContainerConfig
 	Strategy               string   `json:"strategy"`
 	PreBackup              []string `json:"pre_backup"`
@@ -2,6 +2,8 @@ package borg
 
 import (
 	"cs-agent/log"
+	"encoding/json"
+	"path"
 	"regexp"
 	"runtime"
 	"strings"
@@ -21,6 +23,26 @@ func safeRepoName(name string) bool {
 	return repoNameRe.MatchString(name)
 }
 
+// shellQuote single-quotes s for the `sh -c` line a borg command runs as, so a
+// caller-supplied path is one argument whatever it contains.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// CleanArchivePath turns a caller-supplied path into the form borg stores paths
+// in (relative to the volume root: archives are created from /mnt/data as
+// "."): a leading "/" or "./" is dropped and the path cleaned. "" is the
+// archive root. ok=false for a path that climbs out of it.
+func CleanArchivePath(p string) (clean string, ok bool) {
+	for _, seg := range strings.Split(p, "/") {
+		if seg == ".." {
+			return "", false
+		}
+	}
+	clean = path.Clean("/" + strings.TrimSpace(p))
+	return strings.TrimPrefix(clean, "/"), true
+}
+
 type BTimeFormat time.Time
 
 func (bt *BTimeFormat) UnmarshalJSON(b []byte) error {
@@ -47,6 +69,20 @@ func (bt BTimeFormat) Format(s string) string {
 	return t.Format(s)
 }
 
+// logMessages picks borg's --log-json log lines (its --stats and --verbose
+// output among them) out of a command's output, skipping anything else.
+func logMessages(output string) []LogMessage {
+	var msgs []LogMessage
+	for _, line := range strings.Split(output, "\n") {
+		var m LogMessage
+		if json.Unmarshal([]byte(line), &m) != nil || m.Type != "log_message" {
+			continue
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
 func borgLogger() hclog.Logger {
 	return log.New().Named("borg")
 }
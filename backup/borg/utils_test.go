@@ -21,3 +21,44 @@ func TestBackupPath(t *testing.T) {
 	}
 
 }
+
+func TestLogMessages(t *testing.T) {
+	out := `{"type": "log_message", "levelname": "INFO", "name": "borg.output.stats", "message": "Deleted data: -1.20 MB"}
+not json
+{"type": "progress_percent", "message": "Compacting segments"}
+{"type": "log_message", "levelname": "INFO", "name": "borg.repository", "message": "compaction freed about 1.20 MB repository space."}
+`
+	got := logMessages(out)
+	if len(got) != 2 || got[0].Name != "borg.output.stats" || got[1].Message != "compaction freed about 1.20 MB repository space." {
+		t.Fatalf("logMessages = %+v", got)
+	}
+}
+
+func TestCheckOutcome(t *testing.T) {
+	if ok, findings, log := checkOutcome(0, nil); !ok || findings != nil || log != nil {
+		t.Fatalf("clean: %v %v %v", ok, findings, log)
+	}
+	problems := []LogMessage{{LevelName: "ERROR", Message: "Index object count mismatch."}}
+	if ok, findings, log := checkOutcome(1, problems); ok || len(findings) != 1 || log != nil {
+		t.Fatalf("problems: %v %v %v", ok, findings, log)
+	}
+	// A check that could not run says nothing about the repository.
+	lock := append(problems, LogMessage{MsgID: "LockTimeout", Message: "Failed to create/acquire the lock"})
+	if ok, findings, log := checkOutcome(2, lock); ok || findings != nil || log == nil || log.MsgID != "LockTimeout" {
+		t.Fatalf("lock timeout: %v %v %v", ok, findings, log)
+	}
+	if _, findings, log := checkOutcome(2, nil); findings != nil || log == nil {
+		t.Fatalf("no output: %v %v", findings, log)
+	}
+}
+
+func TestParseDataUsage(t *testing.T) {
+	if files, bytes, log := parseDataUsage("1204 987654321012\r\n"); log != nil || files != 1204 || bytes != 987654321012 {
+		t.Fatalf("parseDataUsage = %d %d %v", files, bytes, log)
+	}
+	for _, out := range []string{"", "12", "a b", "find: /mnt/data: No such file or directory"} {
+		if _, _, log := parseDataUsage(out); log == nil {
+			t.Errorf("parseDataUsage(%q) accepted", out)
+		}
+	}
+}
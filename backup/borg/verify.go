@@ -0,0 +1,185 @@
+package borg
+
+import (
+	"context"
+	"cs-agent/config"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/filters"
+	volumeTypes "github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+)
+
+// scratchLabel marks the throwaway Docker volumes a backup.verify extracts into,
+// so a run can find and remove the ones an earlier, crashed run left behind.
+const scratchLabel = "com.computestacks.scratch"
+
+// CreateScratchVolume creates an empty local Docker volume for a verify of
+// forVol's archives and returns its name. It is labelled for forVol; remove it
+// with RemoveScratchVolumes.
+func CreateScratchVolume(forVol string) (string, error) {
+	name := "verify-" + forVol + "-" + strconv.FormatInt(time.Now().Unix(), 10)
+	return name, createVolume(name, map[string]string{
+		"com.computestacks.role": "backup",
+		"com.computestacks.for":  forVol,
+		scratchLabel:             "verify",
+	})
+}
+
+// RemoveScratchVolumes removes every scratch volume labelled for forVol.
+func RemoveScratchVolumes(forVol string) (int, error) {
+	cli, err := client.NewClientWithOpts(client.WithVersion(config.GetString("docker.version")))
+	if err != nil {
+		return 0, err
+	}
+	defer cli.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	found, err := cli.VolumeList(ctx, volumeTypes.ListOptions{Filters: filters.NewArgs(
+		filters.Arg("label", scratchLabel+"=verify"),
+		filters.Arg("label", "com.computestacks.for="+forVol),
+	)})
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, v := range found.Volumes {
+		if err := removeVolume(ctx, cli, v.Name); err != nil {
+			return removed, err
+		}
+		borgLogger().Debug("Removed scratch volume", "volume", forVol, "scratch", v.Name)
+		removed++
+	}
+	return removed, nil
+}
+
+// RemoveVolume removes a Docker volume the agent created (e.g. a clone whose
+// extract failed). A volume that is already gone is not an error.
+func RemoveVolume(name string) error {
+	cli, err := client.NewClientWithOpts(client.WithVersion(config.GetString("docker.version")))
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	return removeVolume(ctx, cli, name)
+}
+
+// createVolume creates an empty local Docker volume.
+func createVolume(name string, labels map[string]string) error {
+	cli, err := client.NewClientWithOpts(client.WithVersion(config.GetString("docker.version")))
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+	_, err = cli.VolumeCreate(context.Background(), volumeTypes.CreateOptions{
+		Name:   name,
+		Driver: "local",
+		Labels: labels,
+	})
+	return err
+}
+
+// removeVolume removes a volume, retrying for a few seconds while a container
+// on its way out (AutoRemove) still holds it.
+func removeVolume(ctx context.Context, cli *client.Client, name string) error {
+	var err error
+	for attempt := 0; attempt < 10; attempt++ {
+		if err = cli.VolumeRemove(ctx, name, true); err == nil || client.IsErrNotFound(err) {
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+	return err
+}
+
+// LatestArchive returns the name of the repository's newest archive whose name
+// starts with prefix; "" when there is none.
+func (r *Repository) LatestArchive(prefix string) (string, *LogMessage) {
+	contents, err := r.Contents()
+	if err != nil {
+		return "", err
+	}
+	var (
+		name   string
+		newest time.Time
+	)
+	for _, a := range contents.Archives {
+		if !strings.HasPrefix(a.Name, prefix) {
+			continue
+		}
+		if t := time.Time(a.Start); name == "" || t.After(newest) {
+			name, newest = a.Name, t
+		}
+	}
+	return name, nil
+}
+
+// Extract extracts the archive — or, given paths, only those paths in it — into
+// /mnt/data as it is, over what is there. Unlike Restore there is no snapshot or
+// rollback: it is meant for an empty volume (a verify's scratch volume, a clone;
+// see Repository.DataVolume) or for putting back a few paths. Paths are archive
+// paths as CleanArchivePath returns them.
+func (a *Archive) Extract(paths ...string) *LogMessage {
+	if reflect.ValueOf(a.Repository.Container).IsNil() {
+		return &LogMessage{Message: "Missing backup container"}
+	}
+
+	cmd := []string{"cd /mnt/data && borg --log-json"}
+	cmd = append(cmd, "--lock-wait "+config.GetString("backups.borg.lock_wait"))
+	cmd = append(cmd, "extract --error --numeric-ids")
+	if a.Repository.streamsProgress() {
+		cmd = append(cmd, "--progress")
+	}
+	cmd = append(cmd, a.archivePath())
+	for _, p := range paths {
+		cmd = append(cmd, shellQuote(p))
+	}
+
+	exitCode, response, log := a.Repository.ExecWithLog(cmd)
+	if log != (LogMessage{}) {
+		return &log
+	}
+	if lg := readArchiveRestoreResponse(response); lg != nil {
+		return lg
+	}
+	if exitCode != 0 {
+		return &LogMessage{Message: "borg extract exited with code " + strconv.Itoa(exitCode)}
+	}
+	return nil
+}
+
+// DataUsage counts the regular files under /mnt/data and their total size in
+// bytes — what Archive.FileUsage counts for an archive.
+func (r *Repository) DataUsage() (files, bytes int64, log *LogMessage) {
+	// The sizes go through a file rather than a pipe so a failed find fails the
+	// command (sh has no portable pipefail) instead of being summed as a short
+	// count. %.0f: awk sums in floating point, and %d truncates at 2^31 in some awks.
+	cmd := []string{`sizes=$(mktemp) && find /mnt/data -type f -exec stat -c %s {} + > "$sizes" && awk '{n++; s+=$1} END {printf "%.0f %.0f\n", n, s}' "$sizes"; rc=$?; rm -f "$sizes"; exit $rc`}
+	exitCode, response, execLog := r.ExecWithLog(cmd)
+	if execLog != (LogMessage{}) {
+		return 0, 0, &execLog
+	}
+	if exitCode != 0 {
+		return 0, 0, &LogMessage{Message: "counting extracted files failed: " + strings.TrimSpace(response)}
+	}
+	return parseDataUsage(response)
+}
+
+// parseDataUsage reads DataUsage's "<files> <bytes>" line.
+func parseDataUsage(out string) (files, bytes int64, log *LogMessage) {
+	fields := strings.Fields(out)
+	if len(fields) != 2 {
+		return 0, 0, &LogMessage{Message: "unexpected file count output: " + strings.TrimSpace(out)}
+	}
+	files, fErr := strconv.ParseInt(fields[0], 10, 64)
+	bytes, bErr := strconv.ParseInt(fields[1], 10, 64)
+	if fErr != nil || bErr != nil {
+		return 0, 0, &LogMessage{Message: "unexpected file count output: " + strings.TrimSpace(out)}
+	}
+	return files, bytes, nil
+}
@@ -0,0 +1,127 @@
+package backup
+
+import (
+	"context"
+	"cs-agent/backup/borg"
+	"cs-agent/config"
+	"cs-agent/store"
+	"cs-agent/types"
+)
+
+// ListArchive lists what the task's archive holds (backup.list): the entries
+// under params.path ("" = all), a page at a time — params.offset, and
+// params.limit up to backups.browse.max_limit (backups.browse.default_limit when
+// unset). The result carries the page as "entries", the count of every entry
+// under the path as "total", and "next_offset" while there are more.
+func ListArchive(ctx context.Context, st *store.Store, task store.Task, projectEvent *progress) error {
+	vol, err := maintenanceVolume(ctx, st, task, projectEvent)
+	if err != nil {
+		return err
+	}
+	if task.Archive == "" {
+		return taskFailed(projectEvent, "agent-list-no-archive", "an archive is required")
+	}
+	params := parseParams(task)
+	path, ok := borg.CleanArchivePath(params.Path)
+	if !ok {
+		return taskFailed(projectEvent, "agent-list-bad-path", "invalid path: "+params.Path)
+	}
+	offset := max(params.Offset, 0)
+	limit := params.Limit
+	if limit <= 0 {
+		limit = config.GetInt("backups.browse.default_limit")
+	}
+	limit = min(limit, config.GetInt("backups.browse.max_limit"))
+
+	// Listing needs the repository only, not the volume's data.
+	repo := borg.Repository{Name: vol.Name, SourceVolumeName: vol.Name, Store: st, Ctx: ctx}
+	defer repo.StopContainer()
+	if built, err := repo.InitBackupContainer(&types.Volume{Name: vol.Name, Trash: true}, &vol); err != nil || !built {
+		msg := "Failed to build backup container"
+		if err != nil {
+			msg = err.Error()
+			projectEvent.noteErr(err)
+		}
+		return taskFailed(projectEvent, "agent-list-container-failed", msg)
+	}
+	archive, lg := repo.FindArchive(task.Archive)
+	if lg != nil {
+		return maintenanceFailed(projectEvent, "agent-list-archive-missing", lg)
+	}
+	listing, lg := archive.List(ctx, path, offset, limit)
+	if lg != nil {
+		return maintenanceFailed(projectEvent, "agent-list-failed", lg)
+	}
+
+	entries := listing.Entries
+	if entries == nil {
+		entries = []borg.ArchiveEntry{}
+	}
+	projectEvent.Set("archive", task.Archive)
+	projectEvent.Set("path", path)
+	projectEvent.Set("offset", offset)
+	projectEvent.Set("limit", limit)
+	projectEvent.Set("total", listing.Total)
+	projectEvent.Set("entries", entries)
+	if next := offset + len(listing.Entries); next < listing.Total {
+		projectEvent.Set("next_offset", next)
+	}
+	return nil
+}
+
+// RestorePaths puts params.file_paths of the task's archive back into the task
+// volume (volume.restore_paths), overwriting what is at those paths and leaving
+// everything else alone: unlike Restore there is no snapshot move, no container
+// is stopped and no hook runs. The archive is params.source_volume's when set
+// (as for a restore), else the volume's own. Database volumes are refused —
+// single files of a live datadir are not a restore.
+func RestorePaths(ctx context.Context, st *store.Store, task store.Task, projectEvent *progress) error {
+	destVol, err := maintenanceVolume(ctx, st, task, projectEvent)
+	if err != nil {
+		return err
+	}
+	if task.Archive == "" {
+		return taskFailed(projectEvent, "agent-restore-paths-no-archive", "an archive is required")
+	}
+	params := parseParams(task)
+	srcVol := destVol
+	if params.SourceVolume != "" && params.SourceVolume != destVol.Name {
+		srcVol, err = maintenanceVolume(ctx, st, store.Task{Name: task.Name, Volume: params.SourceVolume}, projectEvent)
+		if err != nil {
+			return err
+		}
+	}
+	if databaseEngine(srcVol.Strategy) != "" {
+		return taskFailed(projectEvent, "agent-restore-paths-database", "paths cannot be restored from a "+srcVol.Strategy+" volume; restore the whole volume")
+	}
+	if len(params.FilePaths) == 0 {
+		return taskFailed(projectEvent, "agent-restore-paths-none", "file_paths is required")
+	}
+	paths := make([]string, 0, len(params.FilePaths))
+	for _, p := range params.FilePaths {
+		clean, ok := borg.CleanArchivePath(p)
+		if !ok || clean == "" {
+			return taskFailed(projectEvent, "agent-restore-paths-bad-path", "invalid path: "+p)
+		}
+		paths = append(paths, clean)
+	}
+
+	repo, lg := borg.FindRepository(ctx, st, &destVol, &srcVol)
+	if lg != nil {
+		return maintenanceFailed(projectEvent, "agent-restore-paths-repo-missing", lg)
+	}
+	defer repo.StopContainer()
+	archive, lg := repo.FindArchive(task.Archive)
+	if lg != nil {
+		return maintenanceFailed(projectEvent, "agent-restore-paths-archive-missing", lg)
+	}
+	backupLogger().Info("Restoring paths", "volume", destVol.Name, "source_volume", srcVol.Name, "archive", task.Archive, "paths", len(paths))
+	if lg := archive.Extract(paths...); lg != nil {
+		return maintenanceFailed(projectEvent, "agent-restore-paths-failed", lg)
+	}
+
+	projectEvent.Set("archive", task.Archive)
+	projectEvent.Set("paths", paths)
+	backupLogger().Info("Completed path restore", "volume", destVol.Name, "source_volume", srcVol.Name, "archive", task.Archive)
+	return nil
+}
@@ -0,0 +1,199 @@
+package backup
+
+import (
+	"context"
+	"cs-agent/backup/borg"
+	"cs-agent/config"
+	"cs-agent/store"
+	"cs-agent/types"
+	"errors"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/robfig/cron/v3"
+)
+
+// checkCatchUp is how stale a repository's last check may get before the sweep
+// checks it off its weekday slot (the node was down on its day, say).
+const checkCatchUp = 8 * 24 * time.Hour
+
+// CheckRepository runs `borg check` on the task volume's repository under its
+// per-repo lock: --repository-only, or --verify-data when the task's params ask
+// for it. A check that ran is recorded on the repository row (and so reaches
+// the controller through the changelog); one that found problems fails the
+// task with borg's findings in the result.
+func CheckRepository(ctx context.Context, st *store.Store, task store.Task, projectEvent *progress) error {
+	vol, err := maintenanceVolume(ctx, st, task, projectEvent)
+	if err != nil {
+		return err
+	}
+	verifyData := parseParams(task).VerifyData
+
+	defer borg.AcquireRepoLock(vol.Name)()
+	repo := borg.Repository{Name: vol.Name, SourceVolumeName: vol.Name, Store: st, Ctx: ctx}
+	defer repo.StopContainer()
+	ok, findings, checkErr := repo.Check(verifyData)
+	if checkErr != nil {
+		return maintenanceFailed(projectEvent, "agent-check-failed", checkErr)
+	}
+
+	lines := make([]string, 0, len(findings))
+	for _, m := range findings {
+		lines = append(lines, m.Message)
+	}
+	check := store.RepositoryCheck{At: time.Now().Unix(), VerifyData: verifyData, OK: ok, Findings: strings.Join(lines, "\n")}
+	if err := st.RecordRepositoryCheck(ctx, vol.Name, check); err != nil {
+		return err
+	}
+	mode := "repository"
+	if verifyData {
+		mode = "verify_data"
+	}
+	projectEvent.Set("repository", vol.Name)
+	projectEvent.Set("verify_data", verifyData)
+	projectEvent.Set("ok", ok)
+	if ok {
+		repositoryChecks.Inc(mode, "ok")
+		return nil
+	}
+
+	repositoryChecks.Inc(mode, "problems")
+	backupLogger().Error("Repository check found problems", "volume", vol.Name, "verify_data", verifyData, "findings", check.Findings)
+	sentry.CaptureMessage("borg check found problems in repository " + vol.Name)
+	projectEvent.Set("findings", check.Findings)
+	projectEvent.EventLog.Status = "failed"
+	return errors.New("repository check found problems")
+}
+
+// checkSweepJittered is the scheduled check sweep: a per-node delay
+// (backups.check_jitter_sec, as compact's) first, so nodes sharing a backup
+// server don't all start checking at the same cron minute.
+func (s *Scheduler) checkSweepJittered(ctx context.Context) {
+	if jitter := config.GetInt("backups.check_jitter_sec"); jitter > 0 {
+		select {
+		case <-time.After(jitterDelay(s.hostname, jitter)):
+		case <-ctx.Done():
+			return
+		}
+	}
+	s.checkSweep(ctx)
+}
+
+// checkSweep enqueues a repository.check task for each backup-enabled volume
+// whose check is due (checkDue), with --verify-data when that slower cadence
+// is due too (verifyDue). The task ids carry the day, so a second sweep on the
+// same day (a run-now, a cron firing twice) adds nothing.
+func (s *Scheduler) checkSweep(ctx context.Context) {
+	defer sentry.Recover()
+	vols, err := s.st.ListVolumes(ctx)
+	if err != nil {
+		backupLogger().Warn("Check sweep error listing volumes", "error", err.Error())
+		sentry.CaptureException(err)
+		return
+	}
+	now := time.Now()
+	span := sweepSpan(config.GetString("backups.check_freq"), now)
+	enqueued := 0
+	for _, sv := range vols {
+		vol, err := types.LoadVolume(sv.Config)
+		if err != nil {
+			backupLogger().Warn("Check sweep: error parsing volume", "volume", sv.Name, "error", err.Error())
+			continue
+		}
+		if !vol.Backup || vol.Trash {
+			continue
+		}
+		r, _, err := s.st.GetRepository(ctx, vol.Name)
+		if err != nil {
+			backupLogger().Warn("Check sweep: load repository", "volume", vol.Name, "error", err.Error())
+			continue
+		}
+		var lastCheck int64
+		if r.LastCheck != nil {
+			lastCheck = r.LastCheck.At
+		}
+		if !checkDue(s.hostname, vol.Name, lastCheck, span, now) {
+			continue
+		}
+		params := `{"verify_data":false}`
+		if verifyDue(r.VerifiedAt, now) {
+			params = `{"verify_data":true}`
+		}
+		created, err := s.st.CreateTask(ctx, store.Task{
+			ID:        "repository.check:" + vol.Name + ":" + now.UTC().Format("2006-01-02"),
+			Name:      "repository.check",
+			Node:      s.hostname,
+			Volume:    vol.Name,
+			ProjectID: strconv.Itoa(vol.ProjectID),
+			Params:    []byte(params),
+		})
+		if err != nil {
+			backupLogger().Warn("Check sweep: enqueue check", "volume", vol.Name, "error", err.Error())
+			continue
+		}
+		if created {
+			enqueued++
+		}
+	}
+	if enqueued > 0 {
+		backupLogger().Info("Enqueued repository checks", "count", enqueued)
+		if s.dispatch != nil {
+			s.dispatch()
+		}
+	}
+}
+
+// checkDue reports whether a repository is checked in a sweep at now: on its
+// weekday slot — hashed from node and name, so one node's repositories, and the
+// nodes sharing a backup server, spread across the week — or off it when its
+// last check is older than checkCatchUp. A repository never checked waits for
+// its slot, so enabling the job does not check everything on the first day;
+// span is how many days the sweep stands for (sweepSpan), so one whose slot
+// the schedule skips (a weekly check_freq, say) is taken by the next sweep.
+func checkDue(node, name string, lastCheck int64, span int, now time.Time) bool {
+	if lastCheck > 0 && now.Sub(time.Unix(lastCheck, 0)) >= checkCatchUp {
+		return true
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(node + "/" + name))
+	slot := time.Weekday(h.Sum32() % 7)
+	return (int(now.Weekday())-int(slot)+7)%7 < max(span, 1)
+}
+
+// sweepSpan is how many days, 1 to 7, a check sweep at now stands for: the days
+// between check_freq's latest firing by now and the one before it. A daily cron
+// is 1; a weekly one, a sparser one or an unparseable one is 7.
+func sweepSpan(expr string, now time.Time) int {
+	sched, err := cron.ParseStandard(expr)
+	if err != nil {
+		return 7
+	}
+	var prev, latest time.Time
+	for t := now.AddDate(0, 0, -8); ; {
+		next := sched.Next(t)
+		if next.IsZero() || next.After(now) {
+			break
+		}
+		prev, latest, t = latest, next, next
+	}
+	if prev.IsZero() {
+		return 7
+	}
+	date := func(t time.Time) time.Time { return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC) }
+	days := int(date(latest).Sub(date(prev)).Hours() / 24)
+	return min(max(days, 1), 7)
+}
+
+// verifyDue reports whether a check at now should be a --verify-data one:
+// backups.check_verify_data_days is set and that long has passed since the
+// last one (or there has been none).
+func verifyDue(verifiedAt int64, now time.Time) bool {
+	days := config.GetInt("backups.check_verify_data_days")
+	if days <= 0 {
+		return false
+	}
+	return verifiedAt == 0 || now.Sub(time.Unix(verifiedAt, 0)) >= time.Duration(days)*24*time.Hour
+}
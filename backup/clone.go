@@ -0,0 +1,95 @@
+package backup
+
+import (
+	"context"
+	"cs-agent/backup/borg"
+	"cs-agent/store"
+	"cs-agent/types"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// cloneSteps are CloneVolume's Docker and borg steps; tests swap them out.
+var cloneSteps = struct {
+	create  func(name, forVol, archive, taskID string) error
+	remove  func(name string) error
+	extract func(ctx context.Context, st *store.Store, vol types.Volume, clone, archive string, projectEvent *progress) error
+}{borg.CreateCloneVolume, borg.RemoveVolume, extractClone}
+
+// CloneVolume restores the task's archive of the task volume into a new Docker
+// volume next to it, for a customer to compare with or copy from the live data.
+// Unlike Restore nothing of the service is touched: no container is stopped,
+// no pre/post restore hook runs, and the archive is extracted as it is (a
+// database volume's clone holds the raw datadir plus its backups/ copy). The
+// new volume's name is in the result under "volume"; a clone whose extract
+// fails is removed (and one a crash interrupted, by the boot reconcile: see
+// CleanupOrphan).
+func CloneVolume(ctx context.Context, st *store.Store, task store.Task, projectEvent *progress) error {
+	vol, err := maintenanceVolume(ctx, st, task, projectEvent)
+	if err != nil {
+		return err
+	}
+	if task.Archive == "" {
+		return taskFailed(projectEvent, "agent-clone-no-archive", "an archive is required")
+	}
+
+	clone := cloneVolumeName(vol.Name, time.Now())
+	if err := cloneSteps.create(clone, vol.Name, task.Archive, task.ID); err != nil {
+		projectEvent.noteErr(err)
+		return taskFailed(projectEvent, "agent-clone-volume-failed", "create volume: "+err.Error())
+	}
+	done := false
+	defer func() {
+		if done {
+			return
+		}
+		if err := cloneSteps.remove(clone); err != nil {
+			backupLogger().Error("Clone: failed to remove unfinished clone volume", "volume", vol.Name, "clone", clone, "error", err.Error())
+			sentry.CaptureException(err)
+		}
+	}()
+
+	backupLogger().Info("Cloning volume", "volume", vol.Name, "archive", task.Archive, "clone", clone)
+	if err := cloneSteps.extract(ctx, st, vol, clone, task.Archive, projectEvent); err != nil {
+		return err
+	}
+	done = true
+
+	projectEvent.Set("volume", clone)
+	projectEvent.Set("source_volume", vol.Name)
+	projectEvent.Set("archive", task.Archive)
+	backupLogger().Info("Completed volume clone", "volume", vol.Name, "archive", task.Archive, "clone", clone)
+	return nil
+}
+
+// cloneVolumeName is a new clone volume's name: <volume>-clone-<UTC time>-<random>.
+// The random part keeps two clones of a volume in the same second apart.
+func cloneVolumeName(forVol string, now time.Time) string {
+	return forVol + "-clone-" + now.UTC().Format("20060102150405") + "-" + randomToken()
+}
+
+// extractClone extracts vol's archive into the clone volume through a backup
+// container. A failure is recorded on projectEvent.
+func extractClone(ctx context.Context, st *store.Store, vol types.Volume, clone, archive string, projectEvent *progress) error {
+	repo := borg.Repository{Name: vol.Name, SourceVolumeName: vol.Name, DataVolume: clone, Store: st, Ctx: ctx}
+	defer repo.StopContainer()
+	if built, err := repo.InitBackupContainer(&vol, &vol); err != nil || !built {
+		msg := "Failed to build backup container"
+		if err != nil {
+			msg = err.Error()
+			projectEvent.noteErr(err)
+		}
+		return taskFailed(projectEvent, "agent-clone-container-failed", msg)
+	}
+	found, lg := repo.FindArchive(archive)
+	if lg != nil {
+		return maintenanceFailed(projectEvent, "agent-clone-archive-missing", lg)
+	}
+	if lg := found.Extract(); lg != nil {
+		return maintenanceFailed(projectEvent, "agent-clone-extract-failed", lg)
+	}
+	// The clone is complete once the backup container lets go of it.
+	repo.StopContainer()
+	return nil
+}
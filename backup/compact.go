@@ -3,6 +3,7 @@ package backup
 import (
 	"context"
 	"cs-agent/backup/borg"
+	"cs-agent/config"
 	"cs-agent/store"
 	"cs-agent/types"
 	"hash/fnv"
@@ -10,20 +11,18 @@ import (
 	"time"
 
 	"github.com/getsentry/sentry-go"
-	"github.com/spf13/viper"
 )
 
 // compact reclaims space freed by prune/delete for every backup-enabled volume in
 // this node's control.db. It runs borg compact under the per-repo lock so it never
 // overlaps an export of the same repo. (hostname is used only for the jitter seed.)
 func compact(ctx context.Context, st *store.Store) {
-	defer sentry.Recover()
 	hostname, _ := os.Hostname()
 
 	// Per-node jitter so many nodes sharing one backup server don't all start
 	// compacting at the same cron minute. Deterministic (hostname-derived) for
 	// even spread and reproducibility.
-	if jitter := viper.GetInt("backups.compact_jitter_sec"); jitter > 0 {
+	if jitter := config.GetInt("backups.compact_jitter_sec"); jitter > 0 {
 		select {
 		case <-time.After(jitterDelay(hostname, jitter)):
 		case <-ctx.Done(): // ctx-aware: don't hold up shutdown during the jitter sleep
@@ -31,6 +30,13 @@ func compact(ctx context.Context, st *store.Store) {
 		}
 	}
 
+	compactAll(ctx, st)
+}
+
+// compactAll is the compact sweep itself, without the jitter: an operator's
+// run-now (Scheduler.RunNow) starts it at once.
+func compactAll(ctx context.Context, st *store.Store) {
+	defer sentry.Recover()
 	vols, err := st.ListVolumes(ctx)
 	if err != nil {
 		backupLogger().Warn("Compact error listing volumes", "error", err.Error())
@@ -54,7 +60,7 @@ func compact(ctx context.Context, st *store.Store) {
 			func() {
 				defer borg.AcquireRepoLock(vol.Name)()
 				repo := borg.Repository{Name: vol.Name, SourceVolumeName: vol.Name, Store: st}
-				if log := repo.Compact(); log != nil {
+				if _, log := repo.Compact(); log != nil {
 					backupLogger().Warn("Compact Volume Error", "volume", vol.Name, "error", log.Message)
 				}
 				repo.StopContainer() // no-op for the NFS backend (no container)
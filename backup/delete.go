@@ -35,10 +35,11 @@ func DeleteBackup(ctx context.Context, st *store.Store, task store.Task, project
 		return err
 	}
 
-	repo, findRepoErr := borg.FindRepository(st, &types.Volume{Name: task.Volume}, &types.Volume{Name: params.SourceVolume})
+	repo, findRepoErr := borg.FindRepository(ctx, st, &types.Volume{Name: task.Volume}, &types.Volume{Name: params.SourceVolume})
 
 	if findRepoErr != nil {
 		projectEvent.EventLog.Status = "failed"
+		projectEvent.noteBorg(findRepoErr)
 		if findRepoErr.Message == "" {
 			projectEvent.PostEventUpdate("agent-18e788c90cc0760d", "Failed to find backup repository.")
 		} else {
@@ -54,6 +55,7 @@ func DeleteBackup(ctx context.Context, st *store.Store, task store.Task, project
 		backupLogger().Warn("Error deleting repository", "volume", vol.Name, "response", findArchiveErr.Message)
 		repo.StopContainer()
 		projectEvent.EventLog.Status = "failed"
+		projectEvent.noteBorg(findArchiveErr)
 		projectEvent.PostEventUpdate("agent-e67edd61abe38301", findArchiveErr.ToYaml())
 		return errors.New("(" + findArchiveErr.MsgID + ") " + findArchiveErr.Message)
 	}
@@ -64,6 +66,7 @@ func DeleteBackup(ctx context.Context, st *store.Store, task store.Task, project
 	if deleteArchiveErr != nil {
 		backupLogger().Warn("Error deleting archive", "volume", vol.Name, "archive", archive.Name, "response", deleteArchiveErr.Message)
 		projectEvent.EventLog.Status = "failed"
+		projectEvent.noteBorg(deleteArchiveErr)
 		projectEvent.PostEventUpdate("agent-a7ae639c559b3088", deleteArchiveErr.ToYaml())
 		return errors.New("(" + deleteArchiveErr.MsgID + ") " + deleteArchiveErr.Message)
 	}
@@ -4,19 +4,20 @@ import (
 	"context"
 	"crypto/rand"
 	"cs-agent/backup/borg"
-	"cs-agent/s3upload"
+	"cs-agent/config"
+	"cs-agent/exportdest"
 	"cs-agent/store"
 	"cs-agent/types"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"path"
 	"regexp"
 	"strings"
 	"time"
 
 	"github.com/getsentry/sentry-go"
-	"github.com/spf13/viper"
 )
 
 // exportDetailFailed labels the failure-detail line in the task result output.
@@ -29,7 +30,7 @@ const exportDetailFailed = "agent-3f8b2c1e7a9d4056"
 // The filter may carry flags ("gzip -9"), so we key off the command word only;
 // an unrecognized filter falls back to ".tar".
 func exportArchiveSuffix() string {
-	filter := strings.TrimSpace(viper.GetString("backups.export.tar_filter"))
+	filter := strings.TrimSpace(config.GetString("backups.export.tar_filter"))
 	if filter == "" {
 		return ".tar"
 	}
@@ -51,16 +52,24 @@ func exportArchiveSuffix() string {
 
 var objectKeyUnsafe = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
 
-// ExportBackup streams a chosen archive to S3 and, on success, records the
-// presigned GET URL + size/expiry in the task result (result_json) for the
-// controller to read — there is no separate KV download record anymore. The task
-// status (completed/failed) is the readiness gate.
+// ExportBackup streams a chosen archive to the export destination
+// (backups.export.destination: an S3 bucket by default, or SFTP or a local
+// directory) and, on success, records its retrievable reference under "url" —
+// a presigned GET URL, an sftp:// path or a one-time download URL — plus the
+// size/expiry in the task result (result_json) for the controller to read;
+// there is no separate KV download record anymore. The task status
+// (completed/failed) is the readiness gate. params.paths limits the tar
+// to those archive paths; with params.raw the one path, which must be a regular
+// file, is uploaded as its own bytes instead of a tar. With params.recipient the
+// stream is encrypted to that age or OpenPGP key on its way out (object name
+// suffixed .age/.gpg), so the destination never holds the plaintext.
 //
 // Runs under the per-repo lock so a compact never rewrites segments mid-stream.
 // The borg export uses --bypass-lock, so scheduled backups (create) are never
-// blocked. A presigned URL is published ONLY when both the borg export exited 0
-// and the upload succeeded. A crashed export is left "running" by the worker and
-// failed by the boot crash-reconcile — never blindly re-run.
+// blocked. A reference is published ONLY when both the borg export exited 0
+// and the upload succeeded. A crashed export is left "running" by the worker; the
+// boot crash-reconcile replays it (a fresh object key; the stale container and
+// borg lock are cleaned up first) up to tasks.replay.backup.export.max_replays.
 func ExportBackup(ctx context.Context, st *store.Store, task store.Task, projectEvent *progress) error {
 	// No handler-level sentry.Recover(): let a panic reach the worker terminal
 	// guard so a crashed export is FAILED (never a false "completed").
@@ -81,47 +90,61 @@ func ExportBackup(ctx context.Context, st *store.Store, task store.Task, project
 	}
 
 	params := parseParams(task)
-
-	// S3 destination (credentials are node-local config, never from the task).
-	s3cfg := s3upload.ConfigFromViper()
-	if !s3cfg.Enabled() {
-		return failExport(projectEvent, "backup export is not configured (no S3 bucket)")
+	paths, pathErr := exportPaths(params)
+	if pathErr != nil {
+		return failExport(projectEvent, pathErr.Error())
 	}
-	if cfgErr := s3cfg.Validate(0); cfgErr != nil {
-		return failExport(projectEvent, cfgErr.Error())
+	recipient, rcptErr := parseRecipient(params.Recipient)
+	if rcptErr != nil {
+		return failExport(projectEvent, rcptErr.Error())
 	}
-	uploader, err := s3upload.New(s3cfg)
+
+	// The destination (credentials are node-local config, never from the task).
+	dest, err := exportdest.FromViper()
 	if err != nil {
-		return failExport(projectEvent, "s3 init: "+err.Error())
+		return failExport(projectEvent, err.Error())
 	}
 
-	// Bound the whole export so a hung borg or a stalled S3 endpoint can't hold
-	// the per-repo lock or the export worker forever.
-	if t := viper.GetInt("backups.export.timeout_sec"); t > 0 {
-		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, time.Duration(t)*time.Second)
-		defer cancel()
-	}
+	// The whole export is bounded by the worker's per-kind timeout (by default
+	// backups.export.timeout_sec), so a hung borg or a stalled destination can't
+	// hold the per-repo lock or the export worker forever.
 
 	// Serialize against compact/prune of the same repo for the whole stream.
 	defer borg.AcquireRepoLock(vol.Name)()
 
-	repo, findErr := borg.FindRepository(st, &vol, &vol)
+	repo, findErr := borg.FindRepository(ctx, st, &vol, &vol)
 	if findErr != nil {
+		projectEvent.noteBorg(findErr)
 		return failExport(projectEvent, "find repository: "+findErr.Message)
 	}
 	archive, archErr := repo.FindArchive(task.Archive)
 	if archErr != nil {
 		repo.StopContainer()
+		projectEvent.noteBorg(archErr)
 		return failExport(projectEvent, "find archive: "+archErr.Message)
 	}
+	if params.Raw {
+		// Raw mode streams file bytes, so the path must be one regular file.
+		// Checked with --bypass-lock, as the export itself reads, so a running
+		// backup doesn't stall it.
+		listing, listErr := archive.ListForExport(ctx, paths[0], 0, 1)
+		if listErr != nil {
+			repo.StopContainer()
+			projectEvent.noteBorg(listErr)
+			return failExport(projectEvent, "list path: "+listErr.Message)
+		}
+		if listing.Total != 1 || listing.Entries[0].Path != paths[0] || listing.Entries[0].Type != "file" {
+			repo.StopContainer()
+			return failExport(projectEvent, "raw export needs a single regular file: "+paths[0]+" is not one")
+		}
+	}
 
-	objectKey := task.ID + "/" + randomToken() + "/" +
-		sanitizeKeySegment(vol.Name) + "-" + sanitizeKeySegment(task.Archive) + exportArchiveSuffix()
+	objectKey := task.ID + "/" + randomToken() + "/" + exportObjectName(vol.Name, task.Archive, params.Raw, paths) + recipient.suffix()
 
-	// Stream: borg export-tar (producer) -> io.Pipe -> S3 multipart upload.
+	// Stream: borg export-tar (producer) -> io.Pipe -> destination upload.
 	pr, pw := io.Pipe()
 	exportErrCh := make(chan *borg.LogMessage, 1)
+	var encErr error // set by the producer before it sends on exportErrCh
 	go func() {
 		// n5: recover a panic in the producer so it can't crash the whole agent.
 		// Convert it into a pipe error + a channel message so the parent unblocks
@@ -138,17 +161,20 @@ func ExportBackup(ctx context.Context, st *store.Store, task store.Task, project
 				exportErrCh <- &borg.LogMessage{Message: msg}
 			}
 		}()
-		lg := archive.ExportTar(ctx, pw)
+		lg, err := writeExport(ctx, archive, pw, recipient, params.Raw, paths)
+		encErr = err
 		if lg != nil {
 			// Make the uploader's Read fail so it can't Complete a truncated tar.
 			pw.CloseWithError(errors.New(lg.Message))
+		} else if err != nil {
+			pw.CloseWithError(err)
 		} else {
 			pw.Close()
 		}
 		exportErrCh <- lg
 	}()
 
-	size, upErr := uploader.Upload(ctx, objectKey, pr)
+	size, upErr := dest.Upload(ctx, objectKey, projectEvent.uploadReader(pr))
 
 	// If the upload abandoned the read (error or timeout), unblock the producer's
 	// pw.Write so the export goroutine can't leak.
@@ -161,25 +187,85 @@ func ExportBackup(ctx context.Context, st *store.Store, task store.Task, project
 
 	// Publish a URL ONLY if borg exited 0 AND the upload succeeded.
 	if exportLog != nil {
+		projectEvent.noteBorg(exportLog)
 		return failExport(projectEvent, "export collided with a concurrent repo write or borg failed (retry): "+exportLog.Message)
 	}
+	if encErr != nil && upErr == nil {
+		return failExport(projectEvent, "encrypt: "+encErr.Error())
+	}
 	if upErr != nil {
+		projectEvent.noteErr(upErr)
 		return failExport(projectEvent, "upload failed: "+upErr.Error())
 	}
 
-	url, expiry, psErr := uploader.PresignGet(ctx, objectKey, time.Duration(params.DownloadTTL)*time.Second)
+	url, expiry, psErr := dest.Reference(ctx, objectKey, time.Duration(params.DownloadTTL)*time.Second)
 	if psErr != nil {
-		return failExport(projectEvent, "presign failed: "+psErr.Error())
+		return failExport(projectEvent, "reference failed: "+psErr.Error())
 	}
 
 	projectEvent.Set("url", url)
-	projectEvent.Set("object_key", s3cfg.Prefix+objectKey)
+	projectEvent.Set("destination", exportdest.Kind())
+	projectEvent.Set("object_key", dest.ObjectKey(objectKey))
 	projectEvent.Set("size", size)
 	projectEvent.Set("expiry", expiry.Unix())
+	if len(paths) > 0 {
+		projectEvent.Set("paths", paths)
+	}
+	if recipient != nil {
+		projectEvent.Set("encryption", recipient.kind())
+	}
 	backupLogger().Info("Completed backup export", "volume", vol.Name, "archive", task.Archive, "size", size)
 	return nil
 }
 
+// exportPaths checks and cleans an export's params.paths (none: the whole
+// archive). A raw export takes exactly one path.
+func exportPaths(params taskParams) ([]string, error) {
+	paths := make([]string, 0, len(params.Paths))
+	for _, p := range params.Paths {
+		clean, ok := borg.CleanArchivePath(p)
+		if !ok || clean == "" {
+			return nil, errors.New("invalid path: " + p)
+		}
+		paths = append(paths, clean)
+	}
+	if params.Raw && len(paths) != 1 {
+		return nil, errors.New("a raw export takes exactly one path")
+	}
+	return paths, nil
+}
+
+// writeExport streams the export into w: the one file of a raw export, else the
+// tar of paths — encrypted to recipient when there is one, the encryption
+// finalized only once borg has exited 0. A borg failure is the LogMessage; the
+// error is the encryption's own, kept apart so it isn't reported as borg's.
+func writeExport(ctx context.Context, archive *borg.Archive, w io.Writer, recipient *exportRecipient, raw bool, paths []string) (*borg.LogMessage, error) {
+	if recipient != nil {
+		enc, err := recipient.encrypt(w)
+		if err != nil {
+			return nil, err
+		}
+		if lg, _ := writeExport(ctx, archive, enc, nil, raw, paths); lg != nil {
+			return lg, nil
+		}
+		return nil, enc.Close()
+	}
+	if raw {
+		return archive.ExportFile(ctx, w, paths[0]), nil
+	}
+	return archive.ExportTar(ctx, w, paths...), nil
+}
+
+// exportObjectName is the last object-key segment: <volume>-<archive> plus the
+// tar suffix, or for a raw export <volume>-<archive>-<file name>.
+func exportObjectName(volume, archive string, raw bool, paths []string) string {
+	name := sanitizeKeySegment(volume) + "-" + sanitizeKeySegment(archive)
+	if raw {
+		return name + "-" + sanitizeKeySegment(path.Base(paths[0]))
+	}
+	return name + exportArchiveSuffix()
+}
+
 func failExport(p *progress, msg string) error {
 	backupLogger().Warn("Backup export failed", "error", msg)
 	p.EventLog.Status = "failed"
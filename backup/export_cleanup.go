@@ -0,0 +1,128 @@
+package backup
+
+import (
+	"context"
+	"cs-agent/config"
+	"cs-agent/exportdest"
+	"cs-agent/store"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// exportCleanup is the export_cleanup maintenance job
+// (backups.export.cleanup_freq). It deletes each lapsed export's file from the
+// kind of destination it was made to, whichever is configured now (see
+// exportdest.DeletesOnExpiry: S3 only with backups.export.delete_expired_objects,
+// since the bucket's lifecycle rule otherwise does it), and sweeps the temporary
+// files of uploads a crash cut off at the destination configured now.
+func exportCleanup(ctx context.Context, st *store.Store) {
+	defer sentry.Recover()
+	dests := map[string]exportdest.Destination{}
+	deleteFor := func(kind string) func(ctx context.Context, objectKey string) error {
+		dest, seen := dests[kind]
+		if !seen {
+			var err error
+			if dest, err = exportdest.ForKind(kind); err != nil {
+				backupLogger().Warn("Export cleanup: no destination to delete lapsed exports from", "destination", kind, "error", err.Error())
+			}
+			dests[kind] = dest
+		}
+		if dest == nil {
+			return nil
+		}
+		return dest.DeleteObject
+	}
+	if kind := exportdest.Kind(); exportdest.DeletesOnExpiry(kind) && deleteFor(kind) != nil {
+		sweepPartialExports(ctx, dests[kind])
+	}
+	_, _, _ = cleanupExports(ctx, st, time.Now(), deleteFor)
+}
+
+// sweepPartialExports removes what interrupted uploads left at dest. A partial
+// file is stale once it is older than any export may run (and at least a day).
+func sweepPartialExports(ctx context.Context, dest exportdest.Destination) {
+	sweeper, ok := dest.(exportdest.PartialSweeper)
+	if !ok {
+		return
+	}
+	staleAfter := max(24*time.Hour, time.Duration(config.GetInt("backups.export.timeout_sec"))*time.Second)
+	n, err := sweeper.SweepPartial(ctx, time.Now().Add(-staleAfter))
+	if err != nil {
+		backupLogger().Warn("Export cleanup: sweep partial uploads", "error", err.Error())
+	}
+	if n > 0 {
+		backupLogger().Info("Removed partial export uploads", "count", n)
+	}
+}
+
+// cleanupExports is one export cleanup pass. Every completed export whose
+// reference has lapsed is flipped to expired with the URL dropped from its
+// result (and "expired_at" added), so the controller stops offering a dead
+// download. An export of a kind that deletes on expiry has its object removed
+// first by what deleteFor returns for that kind ("object_deleted" in the result
+// says whether that worked — a failure still expires the task, S3's lifecycle
+// rule being the backstop); deleteFor returns nil when that kind's destination
+// can't be built, and the file is logged as left in place.
+// Failed exports older than backups.export.failed_retention_sec are then
+// reaped. A failure of one step is logged and does not skip the other; the
+// errors are joined.
+func cleanupExports(ctx context.Context, st *store.Store, now time.Time, deleteFor func(kind string) func(ctx context.Context, objectKey string) error) (expired, reaped int64, err error) {
+	lapsed, lErr := st.ListLapsedExports(ctx, now.Unix())
+	if lErr != nil {
+		backupLogger().Warn("Export cleanup: list lapsed exports", "error", lErr.Error())
+		err = lErr
+	}
+	for _, task := range lapsed {
+		result := map[string]json.RawMessage{}
+		if len(task.Result) > 0 {
+			if jErr := json.Unmarshal(task.Result, &result); jErr != nil {
+				backupLogger().Warn("Export cleanup: unreadable result", "task", task.ID, "error", jErr.Error())
+				result = map[string]json.RawMessage{}
+			}
+		}
+		delete(result, "url")
+		result["expired_at"], _ = json.Marshal(now.Unix())
+		var objectKey string
+		_ = json.Unmarshal(result["object_key"], &objectKey)
+		// Exports from before destinations were pluggable went to S3.
+		madeTo := exportdest.KindS3
+		_ = json.Unmarshal(result["destination"], &madeTo)
+		if objectKey != "" && exportdest.DeletesOnExpiry(madeTo) {
+			deleted := false
+			if deleteObject := deleteFor(madeTo); deleteObject == nil {
+				backupLogger().Warn("Export cleanup: lapsed export left in place", "task", task.ID, "destination", madeTo, "object_key", objectKey)
+			} else if dErr := deleteObject(ctx, objectKey); dErr != nil {
+				backupLogger().Warn("Export cleanup: delete object", "task", task.ID, "object_key", objectKey, "error", dErr.Error())
+			} else {
+				deleted = true
+			}
+			result["object_deleted"], _ = json.Marshal(deleted)
+		}
+		b, _ := json.Marshal(result)
+		ok, eErr := st.ExpireExport(ctx, task.ID, b)
+		if eErr != nil {
+			backupLogger().Warn("Export cleanup: expire export", "task", task.ID, "error", eErr.Error())
+			err = errors.Join(err, eErr)
+			continue
+		}
+		if ok {
+			expired++
+		}
+	}
+	if expired > 0 {
+		backupLogger().Info("Expired lapsed exports", "count", expired)
+	}
+
+	if retention := int64(config.GetInt("backups.export.failed_retention_sec")); retention > 0 {
+		if n, rErr := st.DeleteFailedExportsBefore(ctx, now.Unix()-retention); rErr != nil {
+			backupLogger().Warn("Export cleanup: reap failed exports", "error", rErr.Error())
+			err = errors.Join(err, rErr)
+		} else if reaped = n; n > 0 {
+			backupLogger().Info("Reaped failed exports", "count", n)
+		}
+	}
+	return expired, reaped, err
+}
@@ -0,0 +1,82 @@
+package backup
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// exportRecipient is who a client-side encrypted export is for (params.recipient):
+// an age X25519 public key ("age1…") or an ASCII-armored OpenPGP public key
+// block. Exactly one of its fields is set.
+type exportRecipient struct {
+	age *age.X25519Recipient
+	pgp openpgp.EntityList
+}
+
+// parseRecipient parses params.recipient. "" is no recipient (nil, nil): the
+// export is uploaded as borg produces it.
+func parseRecipient(s string) (*exportRecipient, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "":
+		return nil, nil
+	case strings.HasPrefix(s, "age1"):
+		r, err := age.ParseX25519Recipient(s)
+		if err != nil {
+			return nil, errors.New("invalid age recipient: " + err.Error())
+		}
+		return &exportRecipient{age: r}, nil
+	case strings.HasPrefix(s, "-----BEGIN PGP PUBLIC KEY BLOCK-----"):
+		keys, err := openpgp.ReadArmoredKeyRing(strings.NewReader(s))
+		if err != nil {
+			return nil, errors.New("invalid OpenPGP public key: " + err.Error())
+		}
+		if len(keys) != 1 {
+			return nil, errors.New("the OpenPGP recipient must hold exactly one public key")
+		}
+		// Caught here rather than by openpgp.Encrypt once borg is streaming.
+		if _, ok := keys[0].EncryptionKey(time.Now()); !ok {
+			return nil, errors.New("the OpenPGP recipient has no usable encryption key (sign-only, expired or revoked)")
+		}
+		return &exportRecipient{pgp: keys}, nil
+	default:
+		return nil, errors.New("recipient must be an age X25519 public key (age1…) or an armored OpenPGP public key")
+	}
+}
+
+// kind names the encryption for the task result: "age" or "openpgp".
+func (r *exportRecipient) kind() string {
+	if r.age != nil {
+		return "age"
+	}
+	return "openpgp"
+}
+
+// suffix is appended to the export's object name: ".age" or ".gpg", and "" for
+// an unencrypted export (nil recipient).
+func (r *exportRecipient) suffix() string {
+	switch {
+	case r == nil:
+		return ""
+	case r.age != nil:
+		return ".age"
+	default:
+		return ".gpg"
+	}
+}
+
+// encrypt returns a writer that encrypts to the recipient whatever is written
+// to it and passes the ciphertext on to w, a chunk at a time, so an export of
+// any size is encrypted in constant memory. Close flushes the final chunk (and
+// the authentication the formats end with); it does not close w.
+func (r *exportRecipient) encrypt(w io.Writer) (io.WriteCloser, error) {
+	if r.age != nil {
+		return age.Encrypt(w, r.age)
+	}
+	return openpgp.Encrypt(w, r.pgp, nil, &openpgp.FileHints{IsBinary: true}, nil)
+}
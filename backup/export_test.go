@@ -0,0 +1,259 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"cs-agent/exportdest"
+	"cs-agent/store"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/spf13/viper"
+)
+
+func TestExportPaths(t *testing.T) {
+	paths, err := exportPaths(taskParams{Paths: []string{"/etc/app.conf", "./var/www/"}})
+	if err != nil || len(paths) != 2 || paths[0] != "etc/app.conf" || paths[1] != "var/www" {
+		t.Fatalf("exportPaths = %v, %v", paths, err)
+	}
+	if paths, err := exportPaths(taskParams{}); err != nil || len(paths) != 0 {
+		t.Fatalf("no paths = %v, %v", paths, err)
+	}
+	for _, p := range []taskParams{
+		{Paths: []string{"../etc"}},
+		{Paths: []string{"/"}},
+		{Raw: true},
+		{Raw: true, Paths: []string{"a", "b"}},
+	} {
+		if _, err := exportPaths(p); err == nil {
+			t.Errorf("exportPaths(%+v) accepted", p)
+		}
+	}
+}
+
+func TestExportObjectName(t *testing.T) {
+	if got := exportObjectName("vol-1", "auto-2026", true, []string{"etc/my app.conf"}); got != "vol-1-auto-2026-my_app.conf" {
+		t.Errorf("raw = %q", got)
+	}
+	if got := exportObjectName("vol-1", "auto-2026", false, nil); !strings.HasPrefix(got, "vol-1-auto-2026.tar") {
+		t.Errorf("tar = %q", got)
+	}
+}
+
+func TestCleanupExports(t *testing.T) {
+	ctx := context.Background()
+	st := testStore(t)
+	for id, result := range map[string]string{
+		"lapsed":   `{"url":"https://x/a","object_key":"exports/a","size":10,"expiry":1000}`,
+		"undelete": `{"url":"https://x/b","object_key":"exports/b","size":10,"expiry":1000}`,
+		"live":     `{"url":"https://x/c","object_key":"exports/c","size":10,"expiry":3000}`,
+		// Made to destinations since switched away from.
+		"local": `{"url":"https://node/d","object_key":"/exports/d","destination":"local","expiry":1000}`,
+		"sftp":  `{"url":"sftp://host/e","object_key":"/exports/e","destination":"sftp","expiry":1000}`,
+	} {
+		if _, err := st.CreateTask(ctx, store.Task{ID: id, Name: "backup.export", Node: "test-node"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.UpdateTaskStatus(ctx, id, store.TaskCompleted, json.RawMessage(result)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var deleted []string
+	deleteFor := func(kind string) func(context.Context, string) error {
+		if kind == exportdest.KindSFTP {
+			return nil // its config is gone
+		}
+		return func(_ context.Context, key string) error {
+			if key == "exports/b" {
+				return errors.New("access denied")
+			}
+			deleted = append(deleted, kind+":"+key)
+			return nil
+		}
+	}
+	viper.Set("backups.export.delete_expired_objects", true)
+	t.Cleanup(func() { viper.Set("backups.export.delete_expired_objects", nil) })
+
+	expired, _, err := cleanupExports(ctx, st, time.Unix(2000, 0), deleteFor)
+	if err != nil || expired != 4 {
+		t.Fatalf("cleanupExports = %d, %v; want 4 expired", expired, err)
+	}
+	slices.Sort(deleted)
+	if !slices.Equal(deleted, []string{"local:/exports/d", "s3:exports/a"}) {
+		t.Fatalf("deleted = %v, want [local:/exports/d s3:exports/a]", deleted)
+	}
+	for id, objectDeleted := range map[string]bool{"lapsed": true, "undelete": false, "local": true, "sftp": false} {
+		tk, _, _ := st.GetTask(ctx, id)
+		var result map[string]any
+		_ = json.Unmarshal(tk.Result, &result)
+		if tk.Status != store.TaskExpired || result["url"] != nil || result["expired_at"] != float64(2000) ||
+			result["object_key"] == nil || result["object_deleted"] != objectDeleted {
+			t.Errorf("%s: status %q result %s", id, tk.Status, tk.Result)
+		}
+	}
+	if tk, _, _ := st.GetTask(ctx, "live"); tk.Status != store.TaskCompleted {
+		t.Fatalf("live export status = %q, want completed", tk.Status)
+	}
+}
+
+func TestExportCleanupDeletesLocalExports(t *testing.T) {
+	ctx := context.Background()
+	st := testStore(t)
+	dir := t.TempDir()
+	for key, value := range map[string]any{
+		"backups.export.destination":    exportdest.KindLocal,
+		"backups.export.local.dir":      dir,
+		"backups.export.local.base_url": "https://node1.example.com:8500",
+	} {
+		viper.Set(key, value)
+		t.Cleanup(func() { viper.Set(key, nil) })
+	}
+	dest := exportdest.NewLocal(exportdest.LocalConfigFromViper())
+	if _, err := dest.Upload(ctx, "lapsed/tok/vol-auto.tar.gz", strings.NewReader("tar bytes")); err != nil {
+		t.Fatal(err)
+	}
+	objectKey := dest.ObjectKey("lapsed/tok/vol-auto.tar.gz")
+	// The temporary file of an upload a crash cut off two days ago.
+	partial := filepath.Join(dir, "crashed", "tok", ".part-123")
+	if err := os.MkdirAll(filepath.Dir(partial), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(partial, []byte("tar"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(partial, old, old); err != nil {
+		t.Fatal(err)
+	}
+	result, _ := json.Marshal(map[string]any{"url": "https://x/a", "object_key": objectKey, "destination": "local", "expiry": 1000})
+	if _, err := st.CreateTask(ctx, store.Task{ID: "lapsed", Name: "backup.export", Node: "test-node"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.UpdateTaskStatus(ctx, "lapsed", store.TaskCompleted, result); err != nil {
+		t.Fatal(err)
+	}
+
+	// delete_expired_objects is unset: it only governs S3.
+	exportCleanup(ctx, st)
+	tk, _, _ := st.GetTask(ctx, "lapsed")
+	var got map[string]any
+	_ = json.Unmarshal(tk.Result, &got)
+	if tk.Status != store.TaskExpired || got["object_deleted"] != true {
+		t.Fatalf("lapsed export: status %q result %s", tk.Status, tk.Result)
+	}
+	for _, p := range []string{objectKey, partial, filepath.Join(dir, "lapsed"), filepath.Join(dir, "crashed")} {
+		if _, err := os.Stat(p); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s left behind: %v", p, err)
+		}
+	}
+}
+
+func TestParseRecipient(t *testing.T) {
+	if r, err := parseRecipient(" "); r != nil || err != nil || r.suffix() != "" {
+		t.Fatalf("empty recipient = %v, %v", r, err)
+	}
+	for _, bad := range []string{"age1nope", "ssh-ed25519 AAAA", "-----BEGIN PGP PUBLIC KEY BLOCK-----\n\ngarbage\n"} {
+		if _, err := parseRecipient(bad); err == nil {
+			t.Errorf("parseRecipient(%q) accepted", bad)
+		}
+	}
+}
+
+func TestParseRecipientSignOnlyKey(t *testing.T) {
+	entity, err := openpgp.NewEntity("export", "", "export@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entity.Subkeys = nil // just the sign/certify primary key
+	_, err = parseRecipient(armoredPublicKey(t, entity))
+	if err == nil || !strings.Contains(err.Error(), "no usable encryption key") {
+		t.Fatalf("sign-only key: %v", err)
+	}
+}
+
+// armoredPublicKey is entity's ASCII-armored public key block.
+func armoredPublicKey(t *testing.T, entity *openpgp.Entity) string {
+	t.Helper()
+	var pub bytes.Buffer
+	aw, err := armor.Encode(&pub, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.Serialize(aw); err != nil {
+		t.Fatal(err)
+	}
+	aw.Close()
+	return pub.String()
+}
+
+func TestExportRecipientEncrypt(t *testing.T) {
+	plaintext := bytes.Repeat([]byte("tar bytes "), 20000)
+	seal := func(t *testing.T, recipient string) (*exportRecipient, []byte) {
+		t.Helper()
+		r, err := parseRecipient(recipient)
+		if err != nil {
+			t.Fatalf("parseRecipient: %v", err)
+		}
+		var out bytes.Buffer
+		w, err := r.encrypt(&out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(plaintext); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(out.Bytes(), []byte("tar bytes")) {
+			t.Fatal("ciphertext carries the plaintext")
+		}
+		return r, out.Bytes()
+	}
+
+	t.Run("age", func(t *testing.T) {
+		id, err := age.GenerateX25519Identity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, sealed := seal(t, id.Recipient().String())
+		if r.kind() != "age" || r.suffix() != ".age" {
+			t.Fatalf("kind %q suffix %q", r.kind(), r.suffix())
+		}
+		dec, err := age.Decrypt(bytes.NewReader(sealed), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := io.ReadAll(dec); !bytes.Equal(got, plaintext) {
+			t.Fatal("age round trip mismatch")
+		}
+	})
+
+	t.Run("openpgp", func(t *testing.T) {
+		entity, err := openpgp.NewEntity("export", "", "export@example.com", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, sealed := seal(t, armoredPublicKey(t, entity))
+		if r.kind() != "openpgp" || r.suffix() != ".gpg" {
+			t.Fatalf("kind %q suffix %q", r.kind(), r.suffix())
+		}
+		md, err := openpgp.ReadMessage(bytes.NewReader(sealed), openpgp.EntityList{entity}, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := io.ReadAll(md.UnverifiedBody); !bytes.Equal(got, plaintext) {
+			t.Fatal("openpgp round trip mismatch")
+		}
+	})
+}
@@ -2,11 +2,12 @@ package backup
 
 import (
 	"context"
+	"cs-agent/config"
 	"cs-agent/store"
+	"errors"
 	"time"
 
 	"github.com/getsentry/sentry-go"
-	"github.com/spf13/viper"
 )
 
 // defaultHousekeepingInterval is the fallback cadence when changelog.prune_freq is
@@ -18,13 +19,23 @@ const defaultHousekeepingInterval = 15 * time.Minute
 // concern, not a backup concern, so a backups-disabled node must still bound
 // changelog/task growth.
 type Housekeeper struct {
-	st   *store.Store
-	expr string
+	st     *store.Store
+	expr   string
+	reload chan struct{}
 }
 
 // NewHousekeeper builds the housekeeper from the changelog.prune_freq cron.
 func NewHousekeeper(st *store.Store) *Housekeeper {
-	return &Housekeeper{st: st, expr: viper.GetString("changelog.prune_freq")}
+	return &Housekeeper{st: st, expr: config.GetString("changelog.prune_freq"), reload: make(chan struct{}, 1)}
+}
+
+// Reload asks Run to re-read changelog.prune_freq (after a config reload) and
+// reschedule from it. Non-blocking + coalescing.
+func (h *Housekeeper) Reload() {
+	select {
+	case h.reload <- struct{}{}:
+	default:
+	}
 }
 
 // Run drives housekeeping until ctx is cancelled, scheduled off the
@@ -44,6 +55,11 @@ func (h *Housekeeper) Run(ctx context.Context) {
 			return
 		case <-time.After(h.untilNext(time.Now())):
 			h.runOnce(ctx)
+		case <-h.reload:
+			if expr := config.GetString("changelog.prune_freq"); expr != h.expr {
+				h.expr = expr
+				backupLogger().Info("Housekeeping cron reloaded", "cron", expr)
+			}
 		}
 	}
 }
@@ -63,19 +79,29 @@ func (h *Housekeeper) untilNext(now time.Time) time.Duration {
 
 func (h *Housekeeper) runOnce(ctx context.Context) {
 	defer sentry.Recover()
+	_, _, _ = housekeep(ctx, h.st)
+}
+
+// housekeep is one retention pass: it prunes the changelog and reaps expired
+// terminal tasks, returning how many rows of each it removed. A failure of one
+// step is logged and does not skip the other; the errors are joined.
+func housekeep(ctx context.Context, st *store.Store) (pruned, reaped int64, err error) {
 	now := time.Now().Unix()
-	minAge := int64(viper.GetInt("changelog.prune_min_age_sec"))
-	maxAge := int64(viper.GetInt("changelog.prune_max_age_sec"))
-	if n, err := h.st.PruneChangelog(ctx, now, minAge, maxAge); err != nil {
-		backupLogger().Warn("Housekeeping: changelog prune", "error", err.Error())
-	} else if n > 0 {
+	minAge := int64(config.GetInt("changelog.prune_min_age_sec"))
+	maxAge := int64(config.GetInt("changelog.prune_max_age_sec"))
+	if n, pErr := st.PruneChangelog(ctx, now, minAge, maxAge); pErr != nil {
+		backupLogger().Warn("Housekeeping: changelog prune", "error", pErr.Error())
+		err = pErr
+	} else if pruned = n; n > 0 {
 		backupLogger().Info("Pruned changelog rows", "count", n)
 	}
-	if retention := int64(viper.GetInt("tasks.retention_sec")); retention > 0 {
-		if n, err := h.st.DeleteTerminalTasksBefore(ctx, now-retention); err != nil {
-			backupLogger().Warn("Housekeeping: task retention", "error", err.Error())
-		} else if n > 0 {
+	if retention := int64(config.GetInt("tasks.retention_sec")); retention > 0 {
+		if n, rErr := st.DeleteTerminalTasksBefore(ctx, now-retention); rErr != nil {
+			backupLogger().Warn("Housekeeping: task retention", "error", rErr.Error())
+			err = errors.Join(err, rErr)
+		} else if reaped = n; n > 0 {
 			backupLogger().Info("Reaped terminal tasks", "count", n)
 		}
 	}
+	return pruned, reaped, err
 }
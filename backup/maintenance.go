@@ -0,0 +1,131 @@
+package backup
+
+import (
+	"context"
+	"cs-agent/backup/borg"
+	"cs-agent/store"
+	"cs-agent/types"
+	"errors"
+	"strings"
+)
+
+// On-demand maintenance: the task-kind counterparts of the scheduler's prune and
+// compact sweeps (repository.prune, repository.compact) and the housekeeper's
+// retention pass (node.housekeeping), for the controller to reclaim space now
+// instead of at the next cron fire. The repository kinds act on the task's
+// volume only and hold its per-repo lock, so they queue behind a sweep or an
+// export of the same repository rather than overlap it.
+
+// PruneRepository applies the task volume's retention policy now and reports
+// borg's --stats in the result.
+func PruneRepository(ctx context.Context, st *store.Store, task store.Task, projectEvent *progress) error {
+	vol, err := maintenanceVolume(ctx, st, task, projectEvent)
+	if err != nil {
+		return err
+	}
+	if vol.Retention == (types.Volume{}).Retention {
+		// borg refuses a prune without any --keep-*; say why up front.
+		projectEvent.EventLog.Status = "failed"
+		projectEvent.PostEventUpdate("agent-prune-no-retention", "volume has no retention policy")
+		return errors.New("volume " + vol.Name + " has no retention policy")
+	}
+
+	defer borg.AcquireRepoLock(vol.Name)()
+	repo, findRepoErr := borg.FindRepository(ctx, st, &vol, &vol)
+	if findRepoErr != nil {
+		return maintenanceFailed(projectEvent, "agent-prune-repo-missing", findRepoErr)
+	}
+	defer repo.StopContainer()
+	stats, pruneErr := repo.Prune()
+	if pruneErr != nil {
+		return maintenanceFailed(projectEvent, "agent-prune-failed", pruneErr)
+	}
+	recordStats(projectEvent, vol.Name, stats)
+	return nil
+}
+
+// CompactRepository reclaims the space the task volume's prunes and deletes
+// freed, without the scheduled sweep's jitter, and reports what borg freed.
+func CompactRepository(ctx context.Context, st *store.Store, task store.Task, projectEvent *progress) error {
+	vol, err := maintenanceVolume(ctx, st, task, projectEvent)
+	if err != nil {
+		return err
+	}
+
+	defer borg.AcquireRepoLock(vol.Name)()
+	repo := borg.Repository{Name: vol.Name, SourceVolumeName: vol.Name, Store: st, Ctx: ctx}
+	defer repo.StopContainer() // no-op for the NFS backend (no container)
+	stats, compactErr := repo.Compact()
+	if compactErr != nil {
+		return maintenanceFailed(projectEvent, "agent-compact-failed", compactErr)
+	}
+	recordStats(projectEvent, vol.Name, stats)
+	return nil
+}
+
+// NodeHousekeeping runs the housekeeper's retention pass now: acked/aged
+// changelog rows are pruned and expired terminal tasks reaped. The counts are in
+// the result.
+func NodeHousekeeping(ctx context.Context, st *store.Store, _ store.Task, projectEvent *progress) error {
+	pruned, reaped, err := housekeep(ctx, st)
+	projectEvent.Set("changelog_pruned", pruned)
+	projectEvent.Set("tasks_reaped", reaped)
+	if err != nil {
+		projectEvent.noteErr(err)
+		return err
+	}
+	return nil
+}
+
+// maintenanceVolume loads the task's volume. A task without one, or naming a
+// volume this node does not have, fails: the repository kinds never sweep.
+func maintenanceVolume(ctx context.Context, st *store.Store, task store.Task, projectEvent *progress) (types.Volume, error) {
+	if task.Volume == "" {
+		projectEvent.EventLog.Status = "failed"
+		projectEvent.PostEventUpdate("agent-maintenance-no-volume", "a volume is required")
+		return types.Volume{}, errors.New(task.Name + " requires a volume")
+	}
+	v, found, err := st.GetVolume(ctx, task.Volume)
+	if err != nil {
+		return types.Volume{}, err
+	}
+	if !found {
+		projectEvent.EventLog.Status = "failed"
+		projectEvent.PostEventUpdate("agent-maintenance-unknown-volume", "volume not found")
+		return types.Volume{}, errors.New("unknown volume: " + task.Volume)
+	}
+	vol, err := types.LoadVolume(v.Config)
+	if err != nil {
+		backupLogger().Warn("Fatal error parsing volume", "volume", task.Volume, "error", err.Error())
+		return types.Volume{}, err
+	}
+	return vol, nil
+}
+
+// maintenanceFailed records a borg failure on the task and returns it as the
+// task's error.
+func maintenanceFailed(projectEvent *progress, code string, lg *borg.LogMessage) error {
+	projectEvent.EventLog.Status = "failed"
+	projectEvent.noteBorg(lg)
+	projectEvent.PostEventUpdate(code, lg.ToYaml())
+	return errors.New("(" + lg.MsgID + ") " + lg.Message)
+}
+
+// taskFailed records a failure that is not borg's (a Docker call, a missing
+// argument) on the task and returns it as the task's error.
+func taskFailed(projectEvent *progress, code, msg string) error {
+	projectEvent.EventLog.Status = "failed"
+	projectEvent.PostEventUpdate(code, msg)
+	return errors.New(msg)
+}
+
+// recordStats puts borg's stats lines in the result (under "stats") without
+// logging them; the borg layer logs the one-line completion.
+func recordStats(projectEvent *progress, repository string, stats []borg.LogMessage) {
+	lines := make([]string, 0, len(stats))
+	for _, m := range stats {
+		lines = append(lines, m.Message)
+	}
+	projectEvent.Set("repository", repository)
+	projectEvent.Set("stats", strings.Join(lines, "\n"))
+}
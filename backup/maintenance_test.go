@@ -0,0 +1,259 @@
+package backup
+
+import (
+	"context"
+	"cs-agent/store"
+	"cs-agent/types"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// TestMaintenanceTasks_RefuseBeforeBorg covers the repository kinds' checks that
+// fail a task before any borg container is built: they are scoped to one known
+// volume, and a prune needs a retention policy.
+func TestMaintenanceTasks_RefuseBeforeBorg(t *testing.T) {
+	ctx := context.Background()
+	st := testStore(t)
+	putVol(t, st, types.Volume{Name: "v1", Node: "test-node", ProjectID: 7})
+
+	for _, tc := range []struct {
+		kind, volume, want string
+	}{
+		{"repository.prune", "", "requires a volume"},
+		{"repository.compact", "", "requires a volume"},
+		{"repository.prune", "nope", "unknown volume"},
+		{"repository.compact", "nope", "unknown volume"},
+		{"repository.prune", "v1", "no retention policy"},
+		{"backup.verify", "", "requires a volume"},
+		{"backup.verify", "nope", "unknown volume"},
+		{"volume.clone", "", "requires a volume"},
+		{"volume.clone", "v1", "an archive is required"},
+		{"backup.list", "v1", "an archive is required"},
+		{"volume.restore_paths", "v1", "an archive is required"},
+	} {
+		res, err := RunTask(ctx, st, store.Task{ID: "t", Name: tc.kind, Volume: tc.volume})
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s volume=%q: err = %v, want %q", tc.kind, tc.volume, err, tc.want)
+			continue
+		}
+		if IsRetryable(err) {
+			t.Errorf("%s volume=%q: a refusal must not be retried", tc.kind, tc.volume)
+		}
+		if !strings.Contains(string(res), tc.want) {
+			t.Errorf("%s volume=%q: result %s lacks the error", tc.kind, tc.volume, res)
+		}
+	}
+}
+
+func TestCloneVolume(t *testing.T) {
+	ctx := context.Background()
+	st := testStore(t)
+	putVol(t, st, types.Volume{Name: "v1", Node: "test-node", ProjectID: 7})
+	saved := cloneSteps
+	t.Cleanup(func() { cloneSteps = saved })
+	var created, removed []string
+	var extractErr error
+	cloneSteps.create = func(name, forVol, archive, taskID string) error {
+		if forVol != "v1" || archive != "auto-1" || taskID != "c1" {
+			t.Errorf("create(%q, %q, %q, %q)", name, forVol, archive, taskID)
+		}
+		created = append(created, name)
+		return nil
+	}
+	cloneSteps.remove = func(name string) error {
+		removed = append(removed, name)
+		return nil
+	}
+	cloneSteps.extract = func(_ context.Context, _ *store.Store, vol types.Volume, clone, archive string, p *progress) error {
+		if extractErr != nil {
+			return taskFailed(p, "agent-clone-extract-failed", extractErr.Error())
+		}
+		return nil
+	}
+	task := store.Task{ID: "c1", Name: "volume.clone", Volume: "v1", Archive: "auto-1"}
+
+	res, err := RunTask(ctx, st, task)
+	if err != nil {
+		t.Fatalf("clone: %v", err)
+	}
+	var result map[string]any
+	_ = json.Unmarshal(res, &result)
+	if len(created) != 1 || !strings.HasPrefix(created[0], "v1-clone-") || result["volume"] != created[0] ||
+		result["source_volume"] != "v1" || result["archive"] != "auto-1" || len(removed) != 0 {
+		t.Fatalf("clone created %v, removed %v, result %s", created, removed, res)
+	}
+
+	// A second clone in the same second gets a volume of its own.
+	if _, err := RunTask(ctx, st, task); err != nil || created[1] == created[0] {
+		t.Fatalf("second clone: %v, volumes %v", err, created)
+	}
+
+	// A failed extract removes the clone it started.
+	extractErr = errors.New("borg extract failed")
+	if _, err := RunTask(ctx, st, task); err == nil {
+		t.Fatal("failed extract reported success")
+	}
+	if len(removed) != 1 || removed[0] != created[2] {
+		t.Fatalf("removed %v, want the failed clone %s", removed, created[2])
+	}
+}
+
+func TestCloneVolumeName(t *testing.T) {
+	now := time.Date(2026, 10, 12, 4, 30, 0, 0, time.UTC)
+	a, b := cloneVolumeName("v1", now), cloneVolumeName("v1", now)
+	if a == b || !strings.HasPrefix(a, "v1-clone-20261012043000-") {
+		t.Fatalf("clone names %q, %q", a, b)
+	}
+}
+
+// TestVerifyVerdict proves the count comparison decides the verdict: an
+// extract short of the archive's listing fails the verify with both counts in
+// the reason, a matching one passes it.
+func TestVerifyVerdict(t *testing.T) {
+	expected := verifyCounts{Files: 3, Bytes: 1200}
+	failed := backupVerifications.Value("failed")
+	p := newProgress()
+	reason := countsMismatch(expected, verifyCounts{Files: 2, Bytes: 1000})
+	if err := verifyVerdict(p, "v1", "auto-1", reason); err == nil ||
+		!strings.Contains(err.Error(), "extract wrote 2 files, 1000 bytes; the archive holds 3 files, 1200 bytes") {
+		t.Fatalf("short extract: %v", err)
+	}
+	var result map[string]any
+	_ = json.Unmarshal(p.Result(nil), &result)
+	if result["verdict"] != "failed" || result["reason"] != reason || !p.Failed() ||
+		backupVerifications.Value("failed")-failed != 1 {
+		t.Fatalf("short extract result %v", result)
+	}
+
+	passed := backupVerifications.Value("passed")
+	p = newProgress()
+	if err := verifyVerdict(p, "v1", "auto-1", countsMismatch(expected, expected)); err != nil {
+		t.Fatalf("matching extract: %v", err)
+	}
+	result = nil
+	_ = json.Unmarshal(p.Result(nil), &result)
+	if result["verdict"] != "passed" || p.Failed() || backupVerifications.Value("passed")-passed != 1 {
+		t.Fatalf("matching extract result %v", result)
+	}
+}
+
+func TestNodeHousekeeping(t *testing.T) {
+	ctx := context.Background()
+	st := testStore(t)
+	putVol(t, st, types.Volume{Name: "v1", Node: "test-node", ProjectID: 7})
+	rows, err := st.ChangelogSince(ctx, 0, "", 100)
+	if err != nil || len(rows) == 0 {
+		t.Fatalf("changelog = %d rows, %v", len(rows), err)
+	}
+	if err := st.SetChangelogAcked(ctx, rows[len(rows)-1].Seq); err != nil {
+		t.Fatal(err)
+	}
+	// Acked rows of any age are prunable.
+	viper.Set("changelog.prune_min_age_sec", -60)
+	t.Cleanup(func() { viper.Set("changelog.prune_min_age_sec", nil) })
+
+	res, err := RunTask(ctx, st, store.Task{ID: "t", Name: "node.housekeeping"})
+	if err != nil {
+		t.Fatalf("node.housekeeping: %v", err)
+	}
+	var got struct {
+		ChangelogPruned int64 `json:"changelog_pruned"`
+		TasksReaped     int64 `json:"tasks_reaped"`
+	}
+	if err := json.Unmarshal(res, &got); err != nil {
+		t.Fatalf("result %s: %v", res, err)
+	}
+	if got.ChangelogPruned != int64(len(rows)) {
+		t.Fatalf("changelog_pruned = %d, want %d (result %s)", got.ChangelogPruned, len(rows), res)
+	}
+}
+
+// TestCheckSweep covers the scheduled borg check sweep: a repository whose last
+// check is overdue is enqueued (with --verify-data when that cadence is due),
+// volumes without backups are skipped, and a second sweep the same day adds
+// nothing.
+func TestCheckSweep(t *testing.T) {
+	ctx := context.Background()
+	st := testStore(t)
+	s := newTestScheduler(t, st)
+	putVol(t, st, types.Volume{Name: "v1", Node: "test-node", Backup: true, ProjectID: 7})
+	putVol(t, st, types.Volume{Name: "v2", Node: "test-node", ProjectID: 7})
+	old := time.Now().Add(-checkCatchUp - time.Hour).Unix()
+	for _, name := range []string{"v1", "v2"} {
+		if err := st.RecordRepositoryCheck(ctx, name, store.RepositoryCheck{At: old, OK: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	viper.Set("backups.check_verify_data_days", 28)
+	t.Cleanup(func() { viper.Set("backups.check_verify_data_days", nil) })
+
+	s.checkSweep(ctx)
+	s.checkSweep(ctx)
+	tasks, _, err := st.ListTasks(ctx, store.TaskFilter{Name: "repository.check"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 || tasks[0].Volume != "v1" || !parseParams(tasks[0]).VerifyData {
+		t.Fatalf("tasks = %+v", tasks)
+	}
+}
+
+func TestCheckDue(t *testing.T) {
+	now := time.Date(2026, 10, 12, 4, 30, 0, 0, time.UTC)
+	due := 0
+	for d := 0; d < 7; d++ {
+		if checkDue("n1", "vol-1", 0, 1, now.AddDate(0, 0, d)) {
+			due++
+		}
+	}
+	if due != 1 {
+		t.Fatalf("never-checked repository due on %d days of a week, want 1", due)
+	}
+	recent := now.Add(-24 * time.Hour).Unix()
+	stale := now.Add(-checkCatchUp).Unix()
+	for d := 0; d < 7; d++ {
+		day := now.AddDate(0, 0, d)
+		if !checkDue("n1", "vol-1", stale, 1, day) {
+			t.Fatalf("stale repository not due on %s", day.Weekday())
+		}
+		if checkDue("n1", "vol-1", recent, 1, day) != checkDue("n1", "vol-1", 0, 1, day) {
+			t.Fatalf("recently checked repository off its slot on %s", day.Weekday())
+		}
+	}
+}
+
+func TestCheckDueSparseSchedule(t *testing.T) {
+	// A weekly sweep stands for the whole week: every never-checked repository
+	// is due at it, not just the seventh whose slot is its weekday.
+	sunday := time.Date(2026, 10, 11, 3, 0, 0, 0, time.UTC)
+	span := sweepSpan("0 3 * * 0", sunday)
+	if span != 7 {
+		t.Fatalf("weekly sweepSpan = %d, want 7", span)
+	}
+	for i := range 20 {
+		if name := "vol-" + strconv.Itoa(i); !checkDue("n1", name, 0, span, sunday) {
+			t.Fatalf("never-checked %s not due at a weekly sweep", name)
+		}
+	}
+	for expr, want := range map[string]int{"30 4 * * *": 1, "0 3 */2 * *": 2, "*/30 * * * *": 1, "bogus": 7} {
+		if got := sweepSpan(expr, sunday.Add(2*time.Hour)); got != want {
+			t.Errorf("sweepSpan(%q) = %d, want %d", expr, got, want)
+		}
+	}
+	// Every other day: due today or on yesterday's skipped slot, i.e. 2 of 7.
+	due := 0
+	for d := range 7 {
+		if checkDue("n1", "vol-1", 0, 2, sunday.AddDate(0, 0, d)) {
+			due++
+		}
+	}
+	if due != 2 {
+		t.Fatalf("never-checked repository due on %d days at span 2, want 2", due)
+	}
+}
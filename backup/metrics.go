@@ -0,0 +1,12 @@
+package backup
+
+import "cs-agent/metrics"
+
+var schedulerLag = metrics.NewGaugeVec("cs_agent_scheduler_lag_seconds",
+	"How far past its next_fire_at the most overdue backup schedule was at the last scheduler tick (0 when none was due).")
+
+var repositoryChecks = metrics.NewCounterVec("cs_agent_repository_checks_total",
+	"borg checks that ran to completion, by mode (repository, verify_data) and result (ok, problems).", "mode", "result")
+
+var backupVerifications = metrics.NewCounterVec("cs_agent_backup_verifications_total",
+	"Test restores (backup.verify) that reached a verdict, by result (passed, failed).", "result")
@@ -1,7 +1,9 @@
 package backup
 
 import (
+	"cs-agent/taskevent"
 	"encoding/json"
+	"io"
 	"strings"
 	"sync"
 )
@@ -9,10 +11,11 @@ import (
 // progress collects a task's step messages and terminal outcome LOCALLY, replacing
 // the old csevent HTTP push to the controller. Per the v3.0.0 contract the
 // controller learns a task's outcome from its changelog `result_json` (terminal
-// status + accumulated output), not a live per-step stream — so this just
-// accumulates: PostEventUpdate appends a line (and logs it), Set records a
-// structured success field (export url/size/…, a backup's last_backup), and
-// Result() renders the JSON the worker stores via UpdateTaskStatus.
+// status + accumulated output), so this accumulates: PostEventUpdate appends a
+// line (and logs it), Set records a structured success field (export
+// url/size/…, a backup's last_backup), and Result() renders the JSON the worker
+// stores via UpdateTaskStatus. Each line is also published, as it happens, to
+// the task's live event stream (taskevent) when it has one.
 //
 // It deliberately mirrors the old *csevent.ProjectEvent surface (PostEventUpdate,
 // CloseEvent, EventLog.Status) so the many handler/hook call sites are unchanged.
@@ -21,9 +24,15 @@ type progress struct {
 	// soft failure that doesn't return an error. Starts "running".
 	EventLog struct{ Status string }
 
+	// events is the task's live feed (nil: none, e.g. outside the worker).
+	events *taskevent.Stream
+
 	mu     sync.Mutex
 	lines  []string
 	fields map[string]any
+	// transient is set when a failure the handler saw is worth retrying (see
+	// noteBorg/noteErr); RunTask then marks the task's error retryable.
+	transient bool
 }
 
 func newProgress() *progress {
@@ -57,6 +66,31 @@ func (p *progress) Record(msg string) {
 	p.mu.Lock()
 	p.lines = append(p.lines, msg)
 	p.mu.Unlock()
+	p.events.Step(msg)
+}
+
+// uploadReader wraps an upload's source so the bytes read so far are published
+// to the task's event stream as the upload proceeds.
+func (p *progress) uploadReader(r io.Reader) io.Reader {
+	if p == nil || p.events == nil {
+		return r
+	}
+	return &uploadProgress{r: r, events: p.events}
+}
+
+type uploadProgress struct {
+	r      io.Reader
+	events *taskevent.Stream
+	n      int64
+}
+
+func (u *uploadProgress) Read(b []byte) (int, error) {
+	n, err := u.r.Read(b)
+	if n > 0 {
+		u.n += int64(n)
+		u.events.Publish(taskevent.Event{Type: taskevent.TypeUpload, Phase: "upload", Bytes: u.n})
+	}
+	return n, err
 }
 
 // CloseEvent is retained for call-site compatibility; the worker owns finalizing
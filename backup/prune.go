@@ -37,12 +37,12 @@ func prune(ctx context.Context, st *store.Store) {
 			// closure so the lock releases each iteration (and on panic).
 			func() {
 				defer borg.AcquireRepoLock(vol.Name)()
-				repo, repoErr := borg.FindRepository(st, &vol, &vol)
+				repo, repoErr := borg.FindRepository(ctx, st, &vol, &vol)
 				if repoErr != nil {
 					backupLogger().Warn("Prune Volume Error, error loading repo", "volume", vol.Name, "error", repoErr.Message)
 					return
 				}
-				if err := repo.Prune(); err != nil {
+				if _, err := repo.Prune(); err != nil {
 					backupLogger().Warn("Prune Volume Error", "volume", vol.Name)
 				}
 				repo.Container.Stop()
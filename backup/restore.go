@@ -3,6 +3,7 @@ package backup
 import (
 	"context"
 	"cs-agent/backup/borg"
+	"cs-agent/config"
 	"cs-agent/containermgr"
 	"cs-agent/store"
 	"cs-agent/types"
@@ -11,7 +12,6 @@ import (
 
 	"github.com/docker/docker/client"
 	"github.com/getsentry/sentry-go"
-	"github.com/spf13/viper"
 )
 
 func Restore(ctx context.Context, st *store.Store, task store.Task, projectEvent *progress) error {
@@ -71,7 +71,7 @@ func Restore(ctx context.Context, st *store.Store, task store.Task, projectEvent
 		return nil
 	}
 
-	repo, findRepoErr := borg.FindRepository(st, &destVol, &vol)
+	repo, findRepoErr := borg.FindRepository(ctx, st, &destVol, &vol)
 
 	if findRepoErr != nil {
 		// Can't restore from an empty repository. (A same-volume restore — source
@@ -86,9 +86,9 @@ func Restore(ctx context.Context, st *store.Store, task store.Task, projectEvent
 		}
 
 		// For SSH-backed repositories, we may need to first create the repository.
-		if findRepoErr.MsgID == "Repository.DoesNotExist" && viper.GetBool("backups.borg.ssh.enabled") {
+		if findRepoErr.MsgID == "Repository.DoesNotExist" && config.GetBool("backups.borg.ssh.enabled") {
 			// Empty SSH repos return 'InvalidRepository' rather than 'DoesNotExist'.
-			repo = &borg.Repository{Name: vol.Name, Store: st}
+			repo = &borg.Repository{Name: vol.Name, Store: st, Ctx: ctx}
 			// Build backup container
 			repoErr := repo.Setup(&destVol, &vol)
 			if repoErr != nil {
@@ -100,6 +100,7 @@ func Restore(ctx context.Context, st *store.Store, task store.Task, projectEvent
 		} else {
 			backupLogger().Warn("Error Restoring volume", "volume", task.Volume, "source_volume", params.SourceVolume, "error", findRepoErr.Message)
 			projectEvent.EventLog.Status = "failed"
+			projectEvent.noteBorg(findRepoErr)
 			projectEvent.PostEventUpdate("agent-2e2a3156b8e2ffd2", findRepoErr.ToYaml())
 			return nil
 		}
@@ -111,13 +112,16 @@ func Restore(ctx context.Context, st *store.Store, task store.Task, projectEvent
 
 	if findArchiveErr != nil {
 		backupLogger().Warn("Error Restoring volume", "volume", task.Volume, "source_volume", params.SourceVolume, "error", findArchiveErr.Message)
+		// Nothing has been touched yet, so a lock timeout here is safe to retry
+		// (a failed extract below is not: the rollback already ran).
 		projectEvent.EventLog.Status = "failed"
+		projectEvent.noteBorg(findArchiveErr)
 		projectEvent.PostEventUpdate("agent-7d32bd2230b39408", findArchiveErr.ToYaml())
 		repo.StopContainer()
 		return nil
 	}
 
-	cli, restoreDockerErr := client.NewClientWithOpts(client.WithVersion(viper.GetString("docker.version")))
+	cli, restoreDockerErr := client.NewClientWithOpts(client.WithVersion(config.GetString("docker.version")))
 	if restoreDockerErr != nil {
 		backupLogger().Warn("Failed to connect to docker", "error", restoreDockerErr.Error(), "function", "Restore")
 		projectEvent.EventLog.Status = "failed"
@@ -0,0 +1,64 @@
+package backup
+
+import (
+	"cs-agent/backup/borg"
+	"cs-agent/s3upload"
+	"errors"
+)
+
+// retryableError marks a task failure as transient: the dispatcher may re-run
+// the task later (subject to the kind's retry policy) instead of recording it
+// failed. Only RunTask produces it, from what the handler observed.
+type retryableError struct{ err error }
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// Retryable marks err as a transient failure. A nil err stays nil.
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &retryableError{err: err}
+}
+
+// IsRetryable reports whether a RunTask error was classified transient (a borg
+// lock timeout, a dropped SSH connection, an S3 5xx).
+func IsRetryable(err error) bool {
+	var re *retryableError
+	return errors.As(err, &re)
+}
+
+// noteBorg records that the task hit a transient borg failure, so RunTask
+// classifies the task's failure as retryable. A terminal msgid is a no-op.
+func (p *progress) noteBorg(lg *borg.LogMessage) {
+	if p == nil || !lg.Retryable() {
+		return
+	}
+	p.mu.Lock()
+	p.transient = true
+	p.mu.Unlock()
+}
+
+// noteErr is noteBorg for a plain Go error (the S3 upload, a docker/ssh call).
+func (p *progress) noteErr(err error) {
+	if p == nil || err == nil {
+		return
+	}
+	if !s3upload.IsRetryable(err) && !borg.TransientText(err.Error()) {
+		return
+	}
+	p.mu.Lock()
+	p.transient = true
+	p.mu.Unlock()
+}
+
+// Transient reports whether a transient failure was noted during the task.
+func (p *progress) Transient() bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.transient
+}
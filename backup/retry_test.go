@@ -0,0 +1,37 @@
+package backup
+
+import (
+	"cs-agent/backup/borg"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestProgressNotesTransientFailures(t *testing.T) {
+	p := newProgress()
+	p.noteBorg(&borg.LogMessage{MsgID: "Archive.DoesNotExist"})
+	p.noteErr(errors.New("permission denied"))
+	if p.Transient() {
+		t.Fatal("terminal failures marked transient")
+	}
+	p.noteBorg(&borg.LogMessage{MsgID: "LockTimeout"})
+	if !p.Transient() {
+		t.Fatal("lock timeout not marked transient")
+	}
+
+	p = newProgress()
+	p.noteErr(fmt.Errorf("trash: %w", errors.New("ssh: connection reset by peer")))
+	if !p.Transient() {
+		t.Fatal("ssh reset not marked transient")
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	err := Retryable(errors.New("lock timeout"))
+	if !IsRetryable(err) || !IsRetryable(fmt.Errorf("wrapped: %w", err)) {
+		t.Fatal("Retryable error not recognized")
+	}
+	if IsRetryable(errors.New("plain")) || Retryable(nil) != nil {
+		t.Fatal("plain/nil error misclassified")
+	}
+}
@@ -2,7 +2,9 @@ package backup
 
 import (
 	"context"
+	"cs-agent/backup/borg"
 	"cs-agent/store"
+	"cs-agent/taskevent"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,9 +14,17 @@ import (
 // column — the old types.Job.SourceVolumeName/FilePaths/DownloadTTL — as opaque
 // JSON in task.Params.
 type taskParams struct {
-	SourceVolume string   `json:"source_volume"`
-	FilePaths    []string `json:"file_paths"`
-	DownloadTTL  int      `json:"download_ttl"`
+	SourceVolume   string   `json:"source_volume"`
+	FilePaths      []string `json:"file_paths"`
+	DownloadTTL    int      `json:"download_ttl"`
+	VerifyData     bool     `json:"verify_data"`     // repository.check: --verify-data
+	VerifyDatabase bool     `json:"verify_database"` // backup.verify: query the extract in a scratch DB
+	Path           string   `json:"path"`            // backup.list: list under this archive path
+	Offset         int      `json:"offset"`          // backup.list: page start
+	Limit          int      `json:"limit"`           // backup.list: page size
+	Paths          []string `json:"paths"`           // backup.export: export only these archive paths
+	Raw            bool     `json:"raw"`             // backup.export: stream the one file in paths as is
+	Recipient      string   `json:"recipient"`       // backup.export: encrypt to this age / OpenPGP public key
 }
 
 func parseParams(task store.Task) taskParams {
@@ -30,9 +40,11 @@ func parseParams(task store.Task) taskParams {
 // outcome via store.UpdateTaskStatus. On any failure — a returned error OR a soft
 // failure a handler recorded via progress.EventLog.Status — the result carries the
 // accumulated failure output (per the v3.0.0 "terminal result + failure output"
-// contract; there is no live per-step stream anymore).
+// contract). The live per-step feed is the task's taskevent stream, which the
+// worker puts on ctx and the handlers publish to through p.events.
 func RunTask(ctx context.Context, st *store.Store, task store.Task) (json.RawMessage, error) {
 	p := newProgress()
+	p.events = taskevent.FromContext(ctx)
 	var err error
 	switch task.Name {
 	case "volume.backup":
@@ -46,15 +58,93 @@ func RunTask(ctx context.Context, st *store.Store, task store.Task) (json.RawMes
 		err = ExportBackup(ctx, st, task, p)
 	case "volume.trash":
 		err = Trash(ctx, st, task, p)
+	case "repository.prune":
+		err = PruneRepository(ctx, st, task, p)
+	case "repository.compact":
+		err = CompactRepository(ctx, st, task, p)
+	case "node.housekeeping":
+		err = NodeHousekeeping(ctx, st, task, p)
+	case "repository.check":
+		err = CheckRepository(ctx, st, task, p)
+	case "backup.verify":
+		err = VerifyBackup(ctx, st, task, p)
+	case "volume.clone":
+		err = CloneVolume(ctx, st, task, p)
+	case "backup.list":
+		err = ListArchive(ctx, st, task, p)
+	case "volume.restore_paths":
+		err = RestorePaths(ctx, st, task, p)
 	default:
 		err = fmt.Errorf("unknown task kind %q", task.Name)
 	}
 	if err == nil && p.Failed() {
 		err = errors.New("task reported failure")
 	}
+	switch {
+	case err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded):
+		// The worker's per-kind timeout interrupted the run.
+		err = fmt.Errorf("task timed out: %w", ctx.Err())
+	case err != nil && ctx.Err() != nil:
+		// The failure is the interrupt (operator cancel or shutdown), not the
+		// work itself; say so in the result alongside the partial output.
+		err = fmt.Errorf("task cancelled: %w", ctx.Err())
+	case err != nil && p.Transient():
+		// A lock timeout / dropped connection / S3 5xx: the dispatcher decides
+		// (per kind) whether another attempt is allowed.
+		err = Retryable(err)
+	}
 	return p.Result(err), err
 }
 
+// PrepareReplay clears what a task killed mid-run (by an agent crash) leaves
+// behind (CleanupOrphan) and on its volume's repository, so the task can be
+// re-run: any leftover backup container is force-removed — killing a borg that
+// survived the agent — and then borg's stale repository lock is broken. A
+// repository that does not exist yet has no lock to break, and nothing is
+// created for it. Called from the boot reconcile before a replayable task goes
+// back to pending; an error means "don't replay".
+func PrepareReplay(ctx context.Context, st *store.Store, task store.Task) error {
+	if err := CleanupOrphan(ctx, st, task); err != nil {
+		return err
+	}
+	if task.Volume == "" {
+		return nil
+	}
+	defer borg.AcquireRepoLock(task.Volume)()
+	if n, err := borg.RemoveBackupContainers(task.Volume); err != nil {
+		return fmt.Errorf("remove stale backup containers: %w", err)
+	} else if n > 0 {
+		backupLogger().Info("Replay: removed stale backup containers", "volume", task.Volume, "count", n)
+	}
+	repo := &borg.Repository{Name: task.Volume, SourceVolumeName: task.Volume, Store: st, Ctx: ctx}
+	if lg := repo.BreakLock(); lg != nil {
+		switch lg.MsgID {
+		case "Repository.DoesNotExist", "InvalidRepository":
+			return nil
+		}
+		return fmt.Errorf("break stale borg lock: %s", lg.Message)
+	}
+	return nil
+}
+
+// CleanupOrphan removes what a task killed mid-run (by an agent crash) left
+// outside its repository: the partial clone volume of a volume.clone, which
+// would otherwise look like a finished one. Called from the boot reconcile for
+// every task it settles, replayed or failed.
+func CleanupOrphan(_ context.Context, _ *store.Store, task store.Task) error {
+	if task.Name != "volume.clone" {
+		return nil
+	}
+	n, err := borg.RemoveCloneVolumes(task.ID)
+	if err != nil {
+		return fmt.Errorf("remove partial clone volume: %w", err)
+	}
+	if n > 0 {
+		backupLogger().Info("Boot reconcile: removed partial clone volume", "volume", task.Volume, "task", task.ID)
+	}
+	return nil
+}
+
 // resolveArchiveName expands the caller-supplied archive name into the
 // timestamped form borg records (matching the old job dispatch behavior).
 func resolveArchiveName(name string) string {
@@ -13,8 +13,10 @@ package backup
 
 import (
 	"context"
+	"cs-agent/config"
 	"cs-agent/store"
 	"cs-agent/types"
+	"fmt"
 	"os"
 	"strconv"
 	"sync"
@@ -24,7 +26,6 @@ import (
 	"github.com/getsentry/sentry-go"
 	"github.com/google/uuid"
 	"github.com/robfig/cron/v3"
-	"github.com/spf13/viper"
 )
 
 const schedulerTick = 20 * time.Second
@@ -37,18 +38,22 @@ type Scheduler struct {
 	dispatch    func() // poke the dispatcher after enqueuing work (non-blocking)
 	tick        time.Duration
 	reconcileCh chan struct{}
+	reloadCh    chan struct{}
 	maint       []*maintJob
 	maintWg     sync.WaitGroup // tracks in-flight maintenance goroutines
+	lastTick    atomic.Int64   // unix nanos of the last loop iteration
 }
 
 // maintJob is a node-wide maintenance task on a cron schedule with skip-on-misfire
 // (next-fire held in RAM; a node down over a slot simply skips it).
 type maintJob struct {
 	name    string
+	key     string // the config key expr is read from (re-read on Reload)
 	expr    string
 	next    time.Time
 	run     func(ctx context.Context)
-	running atomic.Bool // true while a run is in flight (overlap guard)
+	now     func(ctx context.Context) // RunNow's variant of run (e.g. no jitter); nil = run
+	running atomic.Bool               // true while a run is in flight (overlap guard)
 }
 
 // NewScheduler builds the scheduler. dispatch is called (non-blocking) after a
@@ -61,10 +66,16 @@ func NewScheduler(st *store.Store, dispatch func()) *Scheduler {
 		dispatch:    dispatch,
 		tick:        schedulerTick,
 		reconcileCh: make(chan struct{}, 1),
+		reloadCh:    make(chan struct{}, 1),
 	}
 	s.maint = []*maintJob{
-		{name: "prune", expr: viper.GetString("backups.prune_freq"), run: func(ctx context.Context) { prune(ctx, st) }},
-		{name: "compact", expr: viper.GetString("backups.compact_freq"), run: func(ctx context.Context) { compact(ctx, st) }},
+		{name: "prune", key: "backups.prune_freq", expr: config.GetString("backups.prune_freq"), run: func(ctx context.Context) { prune(ctx, st) }},
+		{name: "compact", key: "backups.compact_freq", expr: config.GetString("backups.compact_freq"),
+			run: func(ctx context.Context) { compact(ctx, st) }, now: func(ctx context.Context) { compactAll(ctx, st) }},
+		{name: "check", key: "backups.check_freq", expr: config.GetString("backups.check_freq"),
+			run: s.checkSweepJittered, now: s.checkSweep},
+		{name: "export_cleanup", key: "backups.export.cleanup_freq", expr: config.GetString("backups.export.cleanup_freq"),
+			run: func(ctx context.Context) { exportCleanup(ctx, st) }},
 	}
 	// NB: changelog/task-retention housekeeping is NOT a maint job here — it runs
 	// unconditionally via backup.Housekeeper (main.go), independent of
@@ -72,6 +83,26 @@ func NewScheduler(st *store.Store, dispatch func()) *Scheduler {
 	return s
 }
 
+// LastTick is when the scheduler loop last ran (zero before it starts).
+func (s *Scheduler) LastTick() time.Time {
+	if n := s.lastTick.Load(); n > 0 {
+		return time.Unix(0, n)
+	}
+	return time.Time{}
+}
+
+// TickInterval is the loop's tick period.
+func (s *Scheduler) TickInterval() time.Duration { return s.tick }
+
+// Reload asks the scheduler to re-read its maintenance crons (after a config
+// reload) on its next loop iteration. Non-blocking + coalescing.
+func (s *Scheduler) Reload() {
+	select {
+	case s.reloadCh <- struct{}{}:
+	default:
+	}
+}
+
 // ReconcileSignal asks the scheduler to reconcile volume schedules on its next
 // loop iteration. Non-blocking + coalescing: a late DOWN handler never blocks.
 func (s *Scheduler) ReconcileSignal() {
@@ -100,6 +131,7 @@ func (s *Scheduler) Run(ctx context.Context) {
 			backupLogger().Info("Maintenance scheduled", "job", m.name, "cron", m.expr)
 		}
 	}
+	s.lastTick.Store(time.Now().UnixNano())
 	s.reconcile(ctx) // boot rebuild
 
 	ticker := time.NewTicker(s.tick)
@@ -110,8 +142,12 @@ func (s *Scheduler) Run(ctx context.Context) {
 			backupLogger().Info("Backup scheduler stopping")
 			return
 		case <-s.reconcileCh:
+			s.lastTick.Store(time.Now().UnixNano())
 			s.reconcile(ctx)
+		case <-s.reloadCh:
+			s.reloadMaintenance(time.Now())
 		case <-ticker.C:
+			s.lastTick.Store(time.Now().UnixNano())
 			s.fireDue(ctx)
 			s.runMaintenance(ctx)
 			s.reconcile(ctx) // periodic backstop
@@ -129,6 +165,11 @@ func (s *Scheduler) fireDue(ctx context.Context) {
 		backupLogger().Warn("Scheduler: list due schedules", "error", err.Error())
 		return
 	}
+	var lag int64
+	for _, sc := range due {
+		lag = max(lag, now.Unix()-sc.NextFireAt)
+	}
+	schedulerLag.Set(float64(lag))
 	fired := false
 	for _, sc := range due {
 		next := nextFire(sc.CronExpr, now)
@@ -207,6 +248,56 @@ func (s *Scheduler) runMaintenance(ctx context.Context) {
 	}
 }
 
+// RunNow starts maintenance job name ("prune", "compact", "check",
+// "export_cleanup") immediately, off its cron and without the compact/check
+// jitter, under the same overlap guard as a scheduled run: started=false when a
+// run is already in flight. The cron schedule is unaffected. ctx bounds the run
+// (pass the agent's, not a request's).
+func (s *Scheduler) RunNow(ctx context.Context, name string) (started bool, err error) {
+	for _, m := range s.maint {
+		if m.name != name {
+			continue
+		}
+		if !m.running.CompareAndSwap(false, true) {
+			return false, nil
+		}
+		run := m.run
+		if m.now != nil {
+			run = m.now
+		}
+		backupLogger().Info("Maintenance run requested", "job", name)
+		s.maintWg.Add(1)
+		go func() {
+			defer s.maintWg.Done()
+			defer m.running.Store(false)
+			run(ctx)
+		}()
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown maintenance job %q", name)
+}
+
+// reloadMaintenance re-reads each maintenance job's cron and reschedules the
+// ones that changed from now (an emptied cron disables the job). Runs on the
+// loop goroutine, which owns the jobs' expr/next.
+func (s *Scheduler) reloadMaintenance(now time.Time) {
+	for _, m := range s.maint {
+		if m.key == "" {
+			continue
+		}
+		expr := config.GetString(m.key)
+		if expr == m.expr {
+			continue
+		}
+		m.expr = expr
+		m.next = time.Time{}
+		if expr != "" {
+			m.next = nextFire(expr, now)
+		}
+		backupLogger().Info("Maintenance cron reloaded", "job", m.name, "cron", expr, "next", m.next.String())
+	}
+}
+
 // reconcile brings the schedules table in line with volume desired-state. Gated by
 // the volumes-populated sentinel so an unpopulated control.db never wipes every
 // schedule. A trashed volume gets a (stable-id, idempotent) volume.trash task and
@@ -10,6 +10,8 @@ import (
 
 	"cs-agent/store"
 	"cs-agent/types"
+
+	"github.com/spf13/viper"
 )
 
 func testStore(t *testing.T) *store.Store {
@@ -173,6 +175,44 @@ func TestScheduler_MaintenanceRunsOffLoop(t *testing.T) {
 	}
 }
 
+// TestScheduler_ReloadMaintenance: a config reload reschedules a changed
+// maintenance cron, disables an emptied one and leaves an unchanged one alone.
+func TestScheduler_ReloadMaintenance(t *testing.T) {
+	viper.Set("backups.prune_freq", "0 3 * * *")
+	viper.Set("backups.compact_freq", "45 2 * * *")
+	t.Cleanup(func() {
+		viper.Set("backups.prune_freq", nil)
+		viper.Set("backups.compact_freq", nil)
+	})
+	s := newTestScheduler(t, testStore(t))
+	jobs := map[string]*maintJob{}
+	for _, m := range s.maint {
+		jobs[m.key] = m
+	}
+	prune, compact := jobs["backups.prune_freq"], jobs["backups.compact_freq"]
+	if prune == nil || compact == nil {
+		t.Fatalf("maintenance jobs = %v", jobs)
+	}
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	prune.next, compact.next = nextFire(prune.expr, now), nextFire(compact.expr, now)
+	compactNext := compact.next
+
+	viper.Set("backups.prune_freq", "30 4 * * *")
+	s.reloadMaintenance(now)
+	if prune.expr != "30 4 * * *" || !prune.next.Equal(time.Date(2026, 1, 2, 4, 30, 0, 0, time.UTC)) {
+		t.Fatalf("prune = %q next %s", prune.expr, prune.next)
+	}
+	if !compact.next.Equal(compactNext) {
+		t.Fatalf("unchanged compact rescheduled to %s", compact.next)
+	}
+
+	viper.Set("backups.compact_freq", "")
+	s.reloadMaintenance(now)
+	if !compact.next.IsZero() {
+		t.Fatalf("emptied compact cron still scheduled at %s", compact.next)
+	}
+}
+
 // TestScheduler_ReconcileDoesNotRetryFailedTrash proves the M2/defect-#1 fix: a
 // lingering trash:true volume whose teardown task already FAILED is not re-run by
 // reconcile every tick (resetFailed=false).
@@ -213,3 +253,36 @@ func TestRunTask_UnknownKind(t *testing.T) {
 		t.Fatalf("result missing error field: %s", result)
 	}
 }
+
+func TestScheduler_RunNow(t *testing.T) {
+	s := newTestScheduler(t, testStore(t))
+	release := make(chan struct{})
+	ran := make(chan string, 2)
+	for _, m := range s.maint {
+		name := m.name
+		m.run = func(context.Context) { t.Errorf("%s: scheduled run used instead of now", name) }
+		m.now = func(context.Context) { ran <- name; <-release }
+	}
+
+	started, err := s.RunNow(context.Background(), "compact")
+	if err != nil || !started {
+		t.Fatalf("RunNow(compact) = %v, %v", started, err)
+	}
+	if got := <-ran; got != "compact" {
+		t.Fatalf("ran %q", got)
+	}
+	// A second request while the first is in flight is refused, not queued.
+	if started, err := s.RunNow(context.Background(), "compact"); err != nil || started {
+		t.Fatalf("overlapping RunNow = %v, %v; want false, nil", started, err)
+	}
+	if _, err := s.RunNow(context.Background(), "vacuum"); err == nil {
+		t.Fatal("unknown job: want an error")
+	}
+
+	close(release)
+	s.maintWg.Wait()
+	if started, err := s.RunNow(context.Background(), "compact"); err != nil || !started {
+		t.Fatalf("RunNow after completion = %v, %v", started, err)
+	}
+	s.maintWg.Wait()
+}
@@ -2,19 +2,19 @@ package backup
 
 import (
 	"cs-agent/backup/borg"
+	"cs-agent/config"
 	"cs-agent/containermgr"
 	"cs-agent/types"
 	"strconv"
 	"strings"
 
 	"github.com/docker/docker/client"
-	"github.com/spf13/viper"
 )
 
 func preBackupMysql(vol *types.Volume, event *progress) bool {
 
 	// First, locate a running container
-	cli, err := client.NewClientWithOpts(client.WithVersion(viper.GetString("docker.version")))
+	cli, err := client.NewClientWithOpts(client.WithVersion(config.GetString("docker.version")))
 	if err != nil {
 		backupLogger().Warn("Skipping mysql backup due to docker error", "error", err.Error())
 		return false
@@ -131,10 +131,10 @@ func backupMysql(backupContainer *containermgr.Container, mysqlMaster *MysqlInst
 		}
 	}
 
-	mariaLongTimeout := viper.GetString("mariadb.long_queries.timeout")
-	mariaLongType := viper.GetString("mariadb.long_queries.query_type")
-	mariaWaitQueryType := viper.GetString("mariadb.lock_wait.query_type")
-	mariaWaitTimeout := viper.GetString("mariadb.lock_wait.timeout")
+	mariaLongTimeout := config.GetString("mariadb.long_queries.timeout")
+	mariaLongType := config.GetString("mariadb.long_queries.query_type")
+	mariaWaitQueryType := config.GetString("mariadb.lock_wait.query_type")
+	mariaWaitTimeout := config.GetString("mariadb.lock_wait.timeout")
 
 	backupCmd = append(backupCmd, backupBinary, "--backup", "--datadir="+mysqlMaster.DataPath, "--port=3306")
 	backupCmd = append(backupCmd, "--target-dir="+mysqlMaster.DataPath+"/backups")
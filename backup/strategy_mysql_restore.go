@@ -2,13 +2,13 @@ package backup
 
 import (
 	"cs-agent/backup/borg"
+	"cs-agent/config"
 	"cs-agent/containermgr"
 	"cs-agent/types"
 	"strconv"
 	"strings"
 
 	"github.com/docker/docker/client"
-	"github.com/spf13/viper"
 )
 
 func preRestoreMysql(vol *types.Volume, event *progress, repo *borg.Repository) (preRestoreMysqlSuccess bool) {
@@ -97,7 +97,7 @@ func rollbackRestoreMysql(event *progress, repo *borg.Repository) bool {
 }
 
 func stopAllMysqlContainers(vol *types.Volume, event *progress) bool {
-	cli, cliErr := client.NewClientWithOpts(client.WithVersion(viper.GetString("docker.version")))
+	cli, cliErr := client.NewClientWithOpts(client.WithVersion(config.GetString("docker.version")))
 	if cliErr != nil {
 		backupLogger().Warn("Failed to connect to docker", "error", cliErr.Error(), "function", "stopAllMysqlContainers")
 		event.PostEventUpdate("agent-45a73ed06ea34e25", cliErr.Error())
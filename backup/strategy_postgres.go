@@ -2,16 +2,16 @@ package backup
 
 import (
 	"cs-agent/backup/borg"
+	"cs-agent/config"
 	"cs-agent/containermgr"
 	"cs-agent/types"
 	"strconv"
 
 	"github.com/docker/docker/client"
-	"github.com/spf13/viper"
 )
 
 func preBackupPostgres(vol *types.Volume, event *progress) (preBackupPostgresSuccess bool) {
-	cli, err := client.NewClientWithOpts(client.WithVersion(viper.GetString("docker.version")))
+	cli, err := client.NewClientWithOpts(client.WithVersion(config.GetString("docker.version")))
 	if err != nil {
 		backupLogger().Warn("Docker error preBackupPostgres", "error", err.Error())
 		event.PostEventUpdate("agent-88a614c7c22772e5", "Fatal error connecting to docker for preBackupPostgres Job")
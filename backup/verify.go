@@ -0,0 +1,160 @@
+package backup
+
+import (
+	"context"
+	"cs-agent/backup/borg"
+	"cs-agent/store"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// verifyCounts is what an archive holds (its listing's regular files) or what
+// its extract wrote: regular files and their bytes.
+type verifyCounts struct {
+	Files int64 `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// VerifyBackup proves an archive restores: the task's archive (or, without one,
+// the volume's newest auto- archive) is extracted into a throwaway Docker volume
+// and the files and bytes written are compared with the regular files its
+// listing holds. With verify_database in the params, a mysql/mariadb/postgres
+// volume's extract is also started in a scratch database container and queried. The result carries
+// the verdict ("passed" or "failed"), the counts and the step timings; a failed
+// verdict fails the task. The scratch volume is removed whatever happens, and any
+// an earlier crashed run left behind is removed first.
+func VerifyBackup(ctx context.Context, st *store.Store, task store.Task, projectEvent *progress) error {
+	vol, err := maintenanceVolume(ctx, st, task, projectEvent)
+	if err != nil {
+		return err
+	}
+	params := parseParams(task)
+	started := time.Now()
+	timings := map[string]int64{}
+	defer func() {
+		timings["total_ms"] = time.Since(started).Milliseconds()
+		projectEvent.Set("timings", timings)
+	}()
+
+	// Under the repo lock: the extract never races a compact, and no other
+	// verify of this volume can be using a scratch volume removed here.
+	defer borg.AcquireRepoLock(vol.Name)()
+	if n, err := borg.RemoveScratchVolumes(vol.Name); err != nil {
+		backupLogger().Warn("Verify: error removing stale scratch volumes", "volume", vol.Name, "error", err.Error())
+	} else if n > 0 {
+		backupLogger().Info("Verify: removed stale scratch volumes", "volume", vol.Name, "count", n)
+	}
+	scratch, err := borg.CreateScratchVolume(vol.Name)
+	if err != nil {
+		projectEvent.noteErr(err)
+		return taskFailed(projectEvent, "agent-verify-scratch-failed", "create scratch volume: "+err.Error())
+	}
+	defer func() {
+		if _, err := borg.RemoveScratchVolumes(vol.Name); err != nil {
+			backupLogger().Error("Verify: failed to remove scratch volume", "volume", vol.Name, "scratch", scratch, "error", err.Error())
+			sentry.CaptureException(err)
+		}
+	}()
+
+	repo := borg.Repository{Name: vol.Name, SourceVolumeName: vol.Name, DataVolume: scratch, Store: st, Ctx: ctx}
+	defer repo.StopContainer()
+	if built, err := repo.InitBackupContainer(&vol, &vol); err != nil || !built {
+		msg := "Failed to build backup container"
+		if err != nil {
+			msg = err.Error()
+			projectEvent.noteErr(err)
+		}
+		return taskFailed(projectEvent, "agent-verify-container-failed", msg)
+	}
+
+	archiveName := task.Archive
+	if archiveName == "" {
+		latest, lg := repo.LatestArchive("auto-")
+		if lg != nil {
+			return maintenanceFailed(projectEvent, "agent-verify-list-failed", lg)
+		}
+		if latest == "" {
+			return taskFailed(projectEvent, "agent-verify-no-archive", "no auto- archive to verify")
+		}
+		archiveName = latest
+	}
+	projectEvent.Set("archive", archiveName)
+	archive, lg := repo.FindArchive(archiveName)
+	if lg != nil {
+		return maintenanceFailed(projectEvent, "agent-verify-archive-missing", lg)
+	}
+	// The listing, not borg info's stats: nfiles counts every item and
+	// original_size their chunks, neither of which is what find sees on disk.
+	files, bytes, lg := archive.FileUsage(ctx)
+	if lg != nil {
+		return maintenanceFailed(projectEvent, "agent-verify-list-failed", lg)
+	}
+	expected := verifyCounts{Files: files, Bytes: bytes}
+	projectEvent.Set("expected", expected)
+
+	step := time.Now()
+	lg = archive.Extract()
+	timings["extract_ms"] = time.Since(step).Milliseconds()
+	if lg != nil {
+		if lg.Retryable() || lg.MsgID == borg.MsgIDCancelled {
+			return maintenanceFailed(projectEvent, "agent-verify-extract-failed", lg)
+		}
+		// borg could not read the archive back: that is the verdict.
+		return verifyVerdict(projectEvent, vol.Name, archiveName, "extract failed: "+lg.Message)
+	}
+
+	step = time.Now()
+	files, bytes, lg = repo.DataUsage()
+	timings["compare_ms"] = time.Since(step).Milliseconds()
+	if lg != nil {
+		return maintenanceFailed(projectEvent, "agent-verify-count-failed", lg)
+	}
+	restored := verifyCounts{Files: files, Bytes: bytes}
+	projectEvent.Set("restored", restored)
+	if reason := countsMismatch(expected, restored); reason != "" {
+		return verifyVerdict(projectEvent, vol.Name, archiveName, reason)
+	}
+
+	if params.VerifyDatabase && databaseEngine(vol.Strategy) != "" {
+		step = time.Now()
+		check := verifyDatabase(ctx, &vol, &repo, scratch)
+		timings["database_ms"] = time.Since(step).Milliseconds()
+		projectEvent.Set("database", check)
+		if !check.OK {
+			return verifyVerdict(projectEvent, vol.Name, archiveName, "database check failed")
+		}
+	}
+
+	return verifyVerdict(projectEvent, vol.Name, archiveName, "")
+}
+
+// countsMismatch is the verdict reason when an extract wrote other than what
+// the archive holds; "" when the counts agree.
+func countsMismatch(expected, restored verifyCounts) string {
+	if restored == expected {
+		return ""
+	}
+	return "extract wrote " + strconv.FormatInt(restored.Files, 10) + " files, " + strconv.FormatInt(restored.Bytes, 10) + " bytes; the archive holds " +
+		strconv.FormatInt(expected.Files, 10) + " files, " + strconv.FormatInt(expected.Bytes, 10) + " bytes"
+}
+
+// verifyVerdict records a verify's outcome: passed when reason is "", otherwise
+// failed for reason, which fails the task.
+func verifyVerdict(projectEvent *progress, volume, archive, reason string) error {
+	if reason == "" {
+		projectEvent.Set("verdict", "passed")
+		backupVerifications.Inc("passed")
+		backupLogger().Info("Completed backup verification", "volume", volume, "archive", archive)
+		return nil
+	}
+	projectEvent.Set("verdict", "failed")
+	projectEvent.Set("reason", reason)
+	backupVerifications.Inc("failed")
+	backupLogger().Error("Backup verification failed", "volume", volume, "archive", archive, "reason", reason)
+	sentry.CaptureMessage("backup verification failed for " + volume + "::" + archive + ": " + reason)
+	projectEvent.EventLog.Status = "failed"
+	return errors.New("backup verification failed: " + reason)
+}
@@ -0,0 +1,168 @@
+package backup
+
+import (
+	"context"
+	"cs-agent/backup/borg"
+	"cs-agent/config"
+	"cs-agent/containermgr"
+	"cs-agent/types"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+)
+
+// databaseCheck is the outcome of a verify's scratch database check: the
+// engine's answer to a sanity query against the extracted data.
+type databaseCheck struct {
+	Engine string `json:"engine"`
+	OK     bool   `json:"ok"`
+	Output string `json:"output,omitempty"`
+}
+
+// databaseEngine is the engine a volume strategy's archives can be started
+// with ("" for a plain file volume).
+func databaseEngine(strategy string) string {
+	switch strategy {
+	case "mysql", "mariadb":
+		return "mysql"
+	case "postgres":
+		return "postgres"
+	}
+	return ""
+}
+
+// verifyDatabase starts the service's own image on the scratch volume, with no
+// network, and runs a sanity query until it answers or
+// backups.verify.database_timeout_sec passes. A mysql archive holds the live
+// datadir plus the prepared copy under backups/; the prepared copy is moved into
+// place first, as a restore does. The container is always removed.
+func verifyDatabase(ctx context.Context, vol *types.Volume, repo *borg.Repository, scratch string) databaseCheck {
+	check := databaseCheck{Engine: databaseEngine(vol.Strategy)}
+	cli, err := client.NewClientWithOpts(client.WithVersion(config.GetString("docker.version")))
+	if err != nil {
+		check.Output = err.Error()
+		return check
+	}
+	defer cli.Close()
+
+	serviceID := strconv.Itoa(vol.ServiceID)
+	svc, err := containermgr.FindByService(cli, serviceID, true)
+	if err != nil {
+		check.Output = "find service container: " + err.Error()
+		return check
+	}
+	svcInfo, err := cli.ContainerInspect(ctx, svc.ID)
+	if err != nil {
+		check.Output = "inspect service container: " + err.Error()
+		return check
+	}
+	mountPath := ""
+	for _, m := range svcInfo.Mounts {
+		if m.Name == vol.Name {
+			mountPath = m.Destination
+		}
+	}
+	if mountPath == "" {
+		check.Output = "the service container does not mount " + vol.Name
+		return check
+	}
+
+	var query []string
+	switch check.Engine {
+	case "mysql":
+		master, err := loadMysqlMaster(cli, serviceID, nil, true)
+		if err != nil {
+			check.Output = "load mysql settings: " + err.Error()
+			return check
+		}
+		dataDir := "/mnt/data" + strings.TrimPrefix(master.DataPath, master.MountPath)
+		prepare := []string{"mkdir", "-p /root/.staging"}
+		prepare = append(prepare, "&&", "mv", dataDir+"/backups /root/.staging/")
+		prepare = append(prepare, "&&", "rm", "-rf "+dataDir+"/*")
+		prepare = append(prepare, "&&", "mv", "/root/.staging/backups/* "+dataDir+"/")
+		exitCode, out, err := repo.Container.Exec([]string{"sh", "-c", strings.Join(prepare, " ")})
+		if err != nil || exitCode > 0 {
+			check.Output = withOutput("moving the prepared backup into place failed", out)
+			return check
+		}
+		mysqlBinary := "mysql"
+		if master.Variant == "mariadb" && master.Version.Major > 10 {
+			mysqlBinary = "mariadb"
+		}
+		query = []string{mysqlBinary, "-u" + master.Username, "-p" + master.Password, "-N", "-e", "SELECT COUNT(*) FROM information_schema.tables"}
+	case "postgres":
+		query = []string{"psql", "-U", "postgres", "-tAc", "SELECT count(*) FROM pg_database"}
+	}
+
+	db, err := startScratchDatabase(ctx, cli, vol.Name, svcInfo.Config, scratch, mountPath)
+	if err != nil {
+		check.Output = "start scratch database: " + err.Error()
+		return check
+	}
+	defer func() {
+		if rmErr := cli.ContainerRemove(context.Background(), db.ID, container.RemoveOptions{Force: true}); rmErr != nil && !client.IsErrNotFound(rmErr) {
+			backupLogger().Warn("Verify: failed to remove scratch database", "volume", vol.Name, "container", db.ID, "error", rmErr.Error())
+		}
+	}()
+
+	deadline := time.Now().Add(time.Duration(config.GetInt("backups.verify.database_timeout_sec")) * time.Second)
+	for {
+		exitCode, out, err := db.ExecContext(ctx, query)
+		check.Output = strings.TrimSpace(out)
+		if err == nil && exitCode == 0 {
+			check.OK = true
+			return check
+		}
+		if err != nil {
+			check.Output = withOutput(err.Error(), out)
+		}
+		if ctx.Err() != nil || time.Now().After(deadline) {
+			return check
+		}
+		select {
+		case <-time.After(5 * time.Second):
+		case <-ctx.Done():
+			return check
+		}
+	}
+}
+
+// startScratchDatabase runs the service's image, env and command on the scratch
+// volume, mounted where the service mounts its own. It is labelled as a backup
+// container for volName (so a replay's cleanup removes it) and carries no
+// service id, so the service's own container lookups never see it.
+func startScratchDatabase(ctx context.Context, cli *client.Client, volName string, svc *container.Config, scratch, mountPath string) (*containermgr.Container, error) {
+	if svc == nil {
+		return nil, errors.New("service container has no config")
+	}
+	resp, err := cli.ContainerCreate(ctx, &container.Config{
+		Image: svc.Image,
+		Env:   svc.Env,
+		Cmd:   svc.Cmd,
+		Labels: map[string]string{
+			"com.computestacks.role": "backup",
+			"com.computestacks.for":  volName,
+		},
+	}, &container.HostConfig{
+		NetworkMode: "none",
+		Mounts: []mount.Mount{{
+			Type:   mount.TypeVolume,
+			Source: scratch,
+			Target: mountPath,
+		}},
+		AutoRemove: true,
+	}, nil, nil, "verify-db-"+volName+"-"+strconv.FormatInt(time.Now().Unix(), 10))
+	if err != nil {
+		return nil, err
+	}
+	if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		_ = cli.ContainerRemove(context.Background(), resp.ID, container.RemoveOptions{Force: true})
+		return nil, err
+	}
+	return &containermgr.Container{ID: resp.ID}, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"cs-agent/config"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// defaultConfigFile is where the package installs agent.yml.
+const defaultConfigFile = "/etc/computestacks/agent.yml"
+
+// commands are the subcommands, keyed "<noun> <verb>", in usage order.
+var commands = []struct {
+	name, args, help string
+	run              func(args []string) int
+}{
+	{"config validate", "[file]", "check a config file (default " + defaultConfigFile + ")", configValidate},
+	{"tasks list", "[-status s] [-kind k] [-volume v] [-project p]", "list tasks, newest first", tasksList},
+	{"tasks show", "<id>", "show one task with its params, result and progress", tasksShow},
+	{"tasks cancel", "<id>", "cancel a task (a running one only via the admin socket)", tasksCancel},
+	{"volumes list", "", "list volumes with their backup cron", volumesList},
+	{"volumes show", "<name>", "show a volume with its schedule and repository", volumesShow},
+	{"schedules list", "", "list backup schedules and their next fire", schedulesList},
+	{"repos list", "", "list borg repositories, their sizes and last check", reposList},
+	{"changelog tail", "[-n 20] [-type t] [-follow]", "print the latest changelog rows", changelogTail},
+	{"tenants list", "", "list provisioned tenants", tenantsList},
+	{"maintenance run", "<prune|compact|check|export_cleanup>", "start a maintenance sweep now (via the admin socket)", maintenanceRun},
+	{"firewall reconcile", "", "run a full firewall reconcile now (via the admin socket)", firewallReconcile},
+	{"config dump", "", "print the running config, secrets redacted (via the admin socket)", configDump},
+	{"pool stats", "", "show the per-project DB handle pool (via the admin socket)", poolStats},
+}
+
+func usage() {
+	w := flag.CommandLine.Output()
+	fmt.Fprint(w, "Usage:\n  cs-agent [-version]  run the agent\n  cs-agent <command>   run an operator command (-h for its flags)\n\nCommands:\n")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", c.name, c.args, c.help)
+	}
+	_ = tw.Flush()
+	fmt.Fprint(w, "\nThe commands accept -json; those reading the store take -data-dir, those\nusing the agent's admin socket take -socket and must run as root.\n\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// runCommand runs a subcommand (cs-agent <noun> <verb> ...) instead of the agent
+// and returns the process exit code: 0 success, 1 the command failed or found
+// problems, 2 a usage error.
+func runCommand(args []string) int {
+	if len(args) >= 2 {
+		for _, c := range commands {
+			if c.name == args[0]+" "+args[1] {
+				return c.run(args[2:])
+			}
+		}
+	}
+	fmt.Fprintf(os.Stderr, "cs-agent: unknown command %q\n\n", strings.Join(args, " "))
+	usage()
+	return 2
+}
+
+// configValidate loads a config file and runs every check the agent knows
+// (config.Check plus the export settings) without starting anything. The
+// provisioner gates rollouts on its exit code; each finding is printed on its
+// own line, prefixed with the setting it concerns.
+func configValidate(args []string) int {
+	fs := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: cs-agent config validate [file]  (default %s)\n", defaultConfigFile)
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return 2
+	}
+	path := defaultConfigFile
+	if fs.NArg() == 1 {
+		path = fs.Arg(0)
+	}
+
+	if err := config.LoadFile(path); err != nil {
+		fmt.Printf("%s: 1 problem\n  - %s\n", path, err)
+		return 1
+	}
+	findings := append(config.Check(config.Current()), exportConfigProblems()...)
+	if len(findings) == 0 {
+		fmt.Printf("%s: OK\n", path)
+		return 0
+	}
+	noun := "problems"
+	if len(findings) == 1 {
+		noun = "problem"
+	}
+	fmt.Printf("%s: %d %s\n", path, len(findings), noun)
+	for _, f := range findings {
+		fmt.Printf("  - %s\n", f)
+	}
+	return 1
+}
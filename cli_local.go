@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"cs-agent/config"
+	"cs-agent/store"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Operator subcommands that act on the running agent rather than read its store:
+// they call the root-only admin socket (metadata.admin_socket), which
+// authenticates by the caller's uid, so they must run as root.
+
+// socketFlag registers -socket on a command that talks to the agent.
+func (f *opsFlags) socketFlag() {
+	f.StringVar(&f.socket, "socket", "", "agent admin socket (default: metadata.admin_socket from agent.yml)")
+}
+
+// localClient is an HTTP client for the agent's admin socket.
+type localClient struct {
+	http *http.Client
+}
+
+// local connects to the running agent's admin socket. An error means there is
+// no agent listening there (stopped, or the socket disabled).
+func (f *opsFlags) local() (*localClient, error) {
+	path := f.socket
+	if path == "" {
+		f.loadConfig()
+		path = config.GetString("metadata.admin_socket")
+	}
+	if path == "" {
+		return nil, errors.New("the admin socket is disabled (metadata.admin_socket is empty)")
+	}
+	dial := func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", path)
+	}
+	// Probe once so a stale socket file reads as "agent not running".
+	conn, err := dial(context.Background(), "", "")
+	if err != nil {
+		return nil, fmt.Errorf("agent admin socket %s: %w (is the agent running?)", path, err)
+	}
+	_ = conn.Close()
+	return &localClient{http: &http.Client{
+		Transport: &http.Transport{DialContext: dial},
+		Timeout:   30 * time.Second,
+	}}, nil
+}
+
+// call sends a bodiless request and decodes a 2xx JSON body into out (when
+// non-nil). Any other status is returned as the agent's error message.
+func (c *localClient) call(method, path string, out any) error {
+	req, err := http.NewRequest(method, "http://cs-agent"+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&e) == nil && e.Error != "" {
+			return fmt.Errorf("agent: %s", e.Error)
+		}
+		return fmt.Errorf("agent: %s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// maintenanceRun starts a scheduled maintenance sweep now instead of at its next
+// cron fire. It returns once the sweep has started; follow it in the agent log.
+func maintenanceRun(args []string) int {
+	f := newOpsFlags("maintenance run", " <prune|compact|check|export_cleanup>")
+	f.socketFlag()
+	pos, ok := f.parse(args, 1, 1)
+	if !ok {
+		return 2
+	}
+	c, err := f.local()
+	if err != nil {
+		return opsFail(err)
+	}
+	var res map[string]any
+	if err := c.call(http.MethodPost, "/v1/local/maintenance/"+pos[0], &res); err != nil {
+		return opsFail(err)
+	}
+	if f.json {
+		return printJSON(res)
+	}
+	fmt.Printf("%s started\n", pos[0])
+	return 0
+}
+
+// firewallReconcile wakes the agent's firewall reconciler for a full pass now.
+func firewallReconcile(args []string) int {
+	f := newOpsFlags("firewall reconcile", "")
+	f.socketFlag()
+	if _, ok := f.parse(args, 0, 0); !ok {
+		return 2
+	}
+	c, err := f.local()
+	if err != nil {
+		return opsFail(err)
+	}
+	if err := c.call(http.MethodPost, "/v1/local/firewall/reconcile", nil); err != nil {
+		return opsFail(err)
+	}
+	if f.json {
+		return printJSON(map[string]bool{"signalled": true})
+	}
+	fmt.Println("firewall reconcile signalled")
+	return 0
+}
+
+// configDump prints the running agent's effective config (what it loaded plus
+// defaults, after any reload), secrets redacted.
+func configDump(args []string) int {
+	f := newOpsFlags("config dump", "")
+	f.socketFlag()
+	if _, ok := f.parse(args, 0, 0); !ok {
+		return 2
+	}
+	c, err := f.local()
+	if err != nil {
+		return opsFail(err)
+	}
+	var cfg map[string]any
+	if err := c.call(http.MethodGet, "/v1/local/config", &cfg); err != nil {
+		return opsFail(err)
+	}
+	if f.json {
+		return printJSON(cfg)
+	}
+	keys := make([]string, 0, len(cfg))
+	for k := range cfg {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		b, _ := json.Marshal(cfg[k])
+		fmt.Printf("%s = %s\n", k, b)
+	}
+	return 0
+}
+
+// poolStats prints the running agent's per-project DB handle pool.
+func poolStats(args []string) int {
+	f := newOpsFlags("pool stats", "")
+	f.socketFlag()
+	if _, ok := f.parse(args, 0, 0); !ok {
+		return 2
+	}
+	c, err := f.local()
+	if err != nil {
+		return opsFail(err)
+	}
+	var st store.PoolStats
+	if err := c.call(http.MethodGet, "/v1/local/pool", &st); err != nil {
+		return opsFail(err)
+	}
+	if f.json {
+		return printJSON(st)
+	}
+	idle := "off"
+	if st.IdleTimeoutSec > 0 {
+		idle = (time.Duration(st.IdleTimeoutSec) * time.Second).String()
+	}
+	fields(os.Stdout,
+		"open", fmt.Sprintf("%d / %d", st.Open, st.Max),
+		"pinned", strconv.Itoa(st.Pinned),
+		"idle timeout", idle,
+		"deleting", strings.Join(st.Deleting, ", "),
+	)
+	return 0
+}
@@ -0,0 +1,579 @@
+package main
+
+import (
+	"context"
+	"cs-agent/config"
+	"cs-agent/store"
+	"cs-agent/types"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// Operator subcommands: on-node inspection of control.db for on-call, without
+// sqlite3 or the schema. They open the store with store.OpenExisting next to the
+// running agent — read-only, except `tasks cancel` — and print a table, or JSON
+// with -json. Commands that act on the running agent talk to its admin socket
+// (cli_local.go).
+
+// opsFlags are the flags every operator subcommand takes.
+type opsFlags struct {
+	*flag.FlagSet
+	json    bool
+	dataDir string
+	socket  string // only registered by commands that use the admin socket
+
+	configured bool
+}
+
+func newOpsFlags(name, argsUsage string) *opsFlags {
+	f := &opsFlags{FlagSet: flag.NewFlagSet(name, flag.ContinueOnError)}
+	f.BoolVar(&f.json, "json", false, "print JSON instead of a table")
+	f.StringVar(&f.dataDir, "data-dir", "", "agent data directory (default: store.data_dir from agent.yml)")
+	f.Usage = func() {
+		fmt.Fprintf(f.Output(), "Usage: cs-agent %s [flags]%s\n\nFlags:\n", name, argsUsage)
+		f.PrintDefaults()
+	}
+	return f
+}
+
+// parse parses args, allowing flags after positional arguments (`tasks show
+// <id> -json`), and checks the positional count is within [min, max].
+func (f *opsFlags) parse(args []string, min, max int) ([]string, bool) {
+	var pos []string
+	for {
+		if err := f.Parse(args); err != nil {
+			return nil, false
+		}
+		if f.NArg() == 0 {
+			break
+		}
+		pos = append(pos, f.Arg(0))
+		args = f.Args()[1:]
+	}
+	if len(pos) < min || len(pos) > max {
+		f.Usage()
+		return nil, false
+	}
+	return pos, true
+}
+
+// loadConfig reads agent.yml once, for the settings a flag was not given for.
+func (f *opsFlags) loadConfig() {
+	if !f.configured {
+		config.ConfigureApp()
+		f.configured = true
+	}
+}
+
+// open opens the agent's store. The data dir comes from agent.yml unless
+// -data-dir is given.
+func (f *opsFlags) open(readOnly bool) (*store.Store, error) {
+	dir := f.dataDir
+	if dir == "" {
+		f.loadConfig()
+		dir = config.GetString("store.data_dir")
+	}
+	return store.OpenExisting(dir, readOnly)
+}
+
+// opsFail reports err and returns the failure exit code.
+func opsFail(err error) int {
+	fmt.Fprintf(os.Stderr, "cs-agent: %v\n", err)
+	return 1
+}
+
+func printJSON(v any) int {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return opsFail(err)
+	}
+	return 0
+}
+
+// table writes a header row then rows, tab-aligned.
+func table(w io.Writer, header string, rows [][]string) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, header)
+	for _, r := range rows {
+		fmt.Fprintln(tw, strings.Join(r, "\t"))
+	}
+	_ = tw.Flush()
+}
+
+// fmtTime renders a unix timestamp in local time ("" for zero).
+func fmtTime(unix int64) string {
+	if unix == 0 {
+		return ""
+	}
+	return time.Unix(unix, 0).Local().Format("2006-01-02 15:04:05")
+}
+
+// fmtBytes renders n in binary units.
+func fmtBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatInt(n, 10) + " B"
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// indentJSON pretty-prints raw for a detail view ("" when empty).
+func indentJSON(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return string(raw)
+	}
+	b, _ := json.MarshalIndent(v, "  ", "  ")
+	return string(b)
+}
+
+// fields prints aligned "key: value" lines, skipping empty values.
+func fields(w io.Writer, kv ...string) {
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] != "" {
+			fmt.Fprintf(tw, "%s:\t%s\n", kv[i], kv[i+1])
+		}
+	}
+	_ = tw.Flush()
+}
+
+func tasksList(args []string) int {
+	f := newOpsFlags("tasks list", "")
+	var filter store.TaskFilter
+	f.StringVar(&filter.Status, "status", "", "only tasks in this status (pending, running, completed, failed, ...)")
+	f.StringVar(&filter.Name, "kind", "", "only tasks of this kind (volume.backup, backup.export, ...)")
+	f.StringVar(&filter.Volume, "volume", "", "only tasks for this volume")
+	f.StringVar(&filter.ProjectID, "project", "", "only tasks for this project id")
+	f.IntVar(&filter.Limit, "limit", 50, "page size (max 1000)")
+	f.StringVar(&filter.Cursor, "cursor", "", "continue from a previous page's cursor")
+	if _, ok := f.parse(args, 0, 0); !ok {
+		return 2
+	}
+	st, err := f.open(true)
+	if err != nil {
+		return opsFail(err)
+	}
+	defer st.Close()
+	tasks, next, err := st.ListTasks(context.Background(), filter)
+	if err != nil {
+		return opsFail(err)
+	}
+	if f.json {
+		return printJSON(map[string]any{"tasks": tasks, "next_cursor": next})
+	}
+	rows := make([][]string, 0, len(tasks))
+	for _, t := range tasks {
+		rows = append(rows, []string{t.ID, t.Name, t.Status, t.Volume, t.ProjectID, strconv.Itoa(t.Attempts), fmtTime(t.CreatedAt), fmtTime(t.UpdatedAt)})
+	}
+	table(os.Stdout, "ID\tKIND\tSTATUS\tVOLUME\tPROJECT\tATTEMPTS\tCREATED\tUPDATED", rows)
+	if next != "" {
+		fmt.Fprintf(os.Stderr, "more: cs-agent tasks list -cursor %s\n", next)
+	}
+	return 0
+}
+
+func tasksShow(args []string) int {
+	f := newOpsFlags("tasks show", " <task-id>")
+	pos, ok := f.parse(args, 1, 1)
+	if !ok {
+		return 2
+	}
+	st, err := f.open(true)
+	if err != nil {
+		return opsFail(err)
+	}
+	defer st.Close()
+	t, found, err := st.GetTask(context.Background(), pos[0])
+	if err != nil {
+		return opsFail(err)
+	}
+	if !found {
+		return opsFail(fmt.Errorf("task %s not found", pos[0]))
+	}
+	if f.json {
+		return printJSON(t)
+	}
+	var progress string
+	if p := t.Progress; p != nil {
+		progress = fmt.Sprintf("%s %s", p.Phase, fmtBytes(p.BytesDone))
+		if p.BytesTotal > 0 {
+			progress += " / " + fmtBytes(p.BytesTotal)
+		}
+		if p.ETASec > 0 {
+			progress += fmt.Sprintf(", ETA %s", time.Duration(p.ETASec)*time.Second)
+		}
+	}
+	fields(os.Stdout,
+		"id", t.ID,
+		"kind", t.Name,
+		"status", t.Status,
+		"node", t.Node,
+		"project", t.ProjectID,
+		"volume", t.Volume,
+		"archive", t.Archive,
+		"after", t.AfterID,
+		"priority", strconv.Itoa(t.Priority),
+		"attempts", strconv.Itoa(t.Attempts),
+		"replays", strconv.Itoa(t.Replays),
+		"created", fmtTime(t.CreatedAt),
+		"updated", fmtTime(t.UpdatedAt),
+		"next attempt", fmtTime(t.NextAttemptAt),
+		"deadline", fmtTime(t.Deadline),
+		"progress", progress,
+		"params", indentJSON(t.Params),
+		"result", indentJSON(t.Result),
+	)
+	return 0
+}
+
+// tasksCancel cancels a task. Through the admin socket a running task is
+// interrupted too; without the agent (no socket) only a task that has not
+// started can be cancelled, in the store directly — a running task is only
+// interruptible by the agent, which owns its context.
+func tasksCancel(args []string) int {
+	f := newOpsFlags("tasks cancel", " <task-id>")
+	f.socketFlag()
+	pos, ok := f.parse(args, 1, 1)
+	if !ok {
+		return 2
+	}
+	id := pos[0]
+	if c, err := f.local(); err == nil {
+		var res struct {
+			ID          string `json:"id"`
+			Cancelled   bool   `json:"cancelled"`
+			Interrupted bool   `json:"interrupted"`
+		}
+		if err := c.call(http.MethodDelete, "/v1/admin/tasks/"+url.PathEscape(id), &res); err != nil {
+			return opsFail(err)
+		}
+		if !res.Cancelled && !res.Interrupted {
+			return opsFail(fmt.Errorf("task %s is finished or absent; nothing to cancel", id))
+		}
+		if f.json {
+			return printJSON(res)
+		}
+		if res.Interrupted {
+			fmt.Printf("task %s interrupted; its final status follows in the changelog\n", id)
+		} else {
+			fmt.Printf("task %s cancelled\n", id)
+		}
+		return 0
+	}
+	st, err := f.open(false)
+	if err != nil {
+		return opsFail(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	cancelled, err := st.CancelPendingTask(ctx, id)
+	if err != nil {
+		return opsFail(err)
+	}
+	if !cancelled {
+		t, found, err := st.GetTask(ctx, id)
+		switch {
+		case err != nil:
+			return opsFail(err)
+		case !found:
+			return opsFail(fmt.Errorf("task %s not found", id))
+		case t.Status == store.TaskRunning:
+			return opsFail(fmt.Errorf("task %s is running; only the agent can interrupt it (DELETE /v1/admin/tasks/%s)", id, id))
+		default:
+			return opsFail(fmt.Errorf("task %s is %s; nothing to cancel", id, t.Status))
+		}
+	}
+	if f.json {
+		return printJSON(map[string]any{"id": id, "cancelled": true})
+	}
+	fmt.Printf("task %s cancelled\n", id)
+	return 0
+}
+
+func volumesList(args []string) int {
+	f := newOpsFlags("volumes list", "")
+	if _, ok := f.parse(args, 0, 0); !ok {
+		return 2
+	}
+	st, err := f.open(true)
+	if err != nil {
+		return opsFail(err)
+	}
+	defer st.Close()
+	vols, err := st.ListVolumes(context.Background())
+	if err != nil {
+		return opsFail(err)
+	}
+	if f.json {
+		return printJSON(vols)
+	}
+	rows := make([][]string, 0, len(vols))
+	for _, v := range vols {
+		cfg, _ := types.LoadVolume(v.Config) // a bad config still lists by name
+		backup := "off"
+		if cfg.Backup {
+			backup = cfg.Freq
+		}
+		rows = append(rows, []string{v.Name, v.ProjectID, v.Node, cfg.Strategy, backup, strconv.FormatBool(cfg.Trash), fmtTime(v.UpdatedAt)})
+	}
+	table(os.Stdout, "NAME\tPROJECT\tNODE\tSTRATEGY\tBACKUP\tTRASH\tUPDATED", rows)
+	return 0
+}
+
+// volumesShow prints a volume's desired state together with its backup
+// schedule and repository, the three things asked about a volume on a call.
+func volumesShow(args []string) int {
+	f := newOpsFlags("volumes show", " <volume>")
+	pos, ok := f.parse(args, 1, 1)
+	if !ok {
+		return 2
+	}
+	st, err := f.open(true)
+	if err != nil {
+		return opsFail(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	v, found, err := st.GetVolume(ctx, pos[0])
+	if err != nil {
+		return opsFail(err)
+	}
+	if !found {
+		return opsFail(fmt.Errorf("volume %s not found", pos[0]))
+	}
+	sched, hasSched, err := st.GetSchedule(ctx, v.Name)
+	if err != nil {
+		return opsFail(err)
+	}
+	repo, hasRepo, err := st.GetRepository(ctx, v.Name)
+	if err != nil {
+		return opsFail(err)
+	}
+	if f.json {
+		out := map[string]any{"volume": v}
+		if hasSched {
+			out["schedule"] = sched
+		}
+		if hasRepo {
+			out["repository"] = repo
+		}
+		return printJSON(out)
+	}
+	kv := []string{
+		"name", v.Name,
+		"project", v.ProjectID,
+		"node", v.Node,
+		"updated", fmtTime(v.UpdatedAt),
+	}
+	if hasSched {
+		kv = append(kv, "schedule", sched.CronExpr, "next backup", fmtTime(sched.NextFireAt))
+	}
+	if hasRepo {
+		kv = append(kv,
+			"repository", fmt.Sprintf("%s on disk, %s total, %d archives", fmtBytes(repo.SizeOnDisk), fmtBytes(repo.TotalSize), len(repo.Archives)),
+			"repo synced", fmtTime(repo.UpdatedAt))
+	}
+	kv = append(kv, "config", indentJSON(v.Config))
+	fields(os.Stdout, kv...)
+	return 0
+}
+
+func schedulesList(args []string) int {
+	f := newOpsFlags("schedules list", "")
+	if _, ok := f.parse(args, 0, 0); !ok {
+		return 2
+	}
+	st, err := f.open(true)
+	if err != nil {
+		return opsFail(err)
+	}
+	defer st.Close()
+	scheds, err := st.ListSchedules(context.Background())
+	if err != nil {
+		return opsFail(err)
+	}
+	if f.json {
+		return printJSON(scheds)
+	}
+	rows := make([][]string, 0, len(scheds))
+	for _, s := range scheds {
+		rows = append(rows, []string{s.VolumeName, s.CronExpr, fmtTime(s.NextFireAt), fmtTime(s.UpdatedAt)})
+	}
+	table(os.Stdout, "VOLUME\tCRON\tNEXT FIRE\tUPDATED", rows)
+	return 0
+}
+
+func reposList(args []string) int {
+	f := newOpsFlags("repos list", "")
+	if _, ok := f.parse(args, 0, 0); !ok {
+		return 2
+	}
+	st, err := f.open(true)
+	if err != nil {
+		return opsFail(err)
+	}
+	defer st.Close()
+	repos, err := st.ListRepositories(context.Background())
+	if err != nil {
+		return opsFail(err)
+	}
+	if f.json {
+		return printJSON(repos)
+	}
+	rows := make([][]string, 0, len(repos))
+	for _, r := range repos {
+		rows = append(rows, []string{r.Name, fmtBytes(r.SizeOnDisk), fmtBytes(r.TotalSize), strconv.Itoa(len(r.Archives)), fmtTime(r.UpdatedAt), fmtCheck(r.LastCheck)})
+	}
+	table(os.Stdout, "NAME\tON DISK\tTOTAL\tARCHIVES\tSYNCED\tLAST CHECK", rows)
+	return 0
+}
+
+// fmtCheck renders a repository's last borg check ("" when none has run).
+func fmtCheck(c *store.RepositoryCheck) string {
+	if c == nil {
+		return ""
+	}
+	result := "ok"
+	if !c.OK {
+		result = "PROBLEMS"
+	}
+	if c.VerifyData {
+		result += " (verify-data)"
+	}
+	return result + " " + fmtTime(c.At)
+}
+
+// changelogTail prints the last -n changelog rows; -follow keeps polling for
+// new ones until interrupted. With -json it writes one entry per line.
+func changelogTail(args []string) int {
+	f := newOpsFlags("changelog tail", "")
+	n := f.Int("n", 20, "number of rows")
+	entityType := f.String("type", "", "only this entity type (task, volume, repository, action_request, ...)")
+	follow := f.Bool("follow", false, "keep printing new rows as they are written")
+	if _, ok := f.parse(args, 0, 0); !ok {
+		return 2
+	}
+	st, err := f.open(true)
+	if err != nil {
+		return opsFail(err)
+	}
+	defer st.Close()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	enc := json.NewEncoder(os.Stdout)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if !f.json {
+		fmt.Fprintln(tw, "SEQ\tTIME\tTYPE\tENTITY\tPROJECT\tOP")
+	}
+	emit := func(entries []store.ChangelogEntry) error {
+		for _, e := range entries {
+			if f.json {
+				if err := enc.Encode(e); err != nil {
+					return err
+				}
+				continue
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", e.Seq, fmtTime(e.CreatedAt), e.EntityType, e.EntityID, e.ProjectID, e.Op)
+		}
+		return tw.Flush()
+	}
+
+	entries, err := st.ChangelogTail(ctx, *entityType, *n)
+	if err != nil {
+		return opsFail(err)
+	}
+	if err := emit(entries); err != nil {
+		return opsFail(err)
+	}
+	if !*follow {
+		return 0
+	}
+	var cursor int64
+	if len(entries) > 0 {
+		cursor = entries[len(entries)-1].Seq
+	} else if tail, err := st.ChangelogTail(ctx, "", 1); err != nil {
+		return opsFail(err)
+	} else if len(tail) > 0 {
+		cursor = tail[0].Seq
+	}
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-t.C:
+		}
+		entries, err := st.ChangelogSince(ctx, cursor, *entityType, 500)
+		if errors.Is(err, context.Canceled) {
+			return 0
+		}
+		if err != nil {
+			return opsFail(err)
+		}
+		if len(entries) > 0 {
+			cursor = entries[len(entries)-1].Seq
+		}
+		if err := emit(entries); err != nil {
+			return opsFail(err)
+		}
+	}
+}
+
+// tenantsList lists the provisioned tenants. The token hash is left out: it is
+// the credential lookup key and has no debugging value.
+func tenantsList(args []string) int {
+	f := newOpsFlags("tenants list", "")
+	if _, ok := f.parse(args, 0, 0); !ok {
+		return 2
+	}
+	st, err := f.open(true)
+	if err != nil {
+		return opsFail(err)
+	}
+	defer st.Close()
+	tenants, err := st.ListTenants(context.Background())
+	if err != nil {
+		return opsFail(err)
+	}
+	type tenantView struct {
+		ProjectID string `json:"project_id"`
+		Status    string `json:"status"`
+		CreatedAt int64  `json:"created_at"`
+		UpdatedAt int64  `json:"updated_at"`
+	}
+	views := make([]tenantView, 0, len(tenants))
+	rows := make([][]string, 0, len(tenants))
+	for _, t := range tenants {
+		views = append(views, tenantView{t.ProjectID, t.Status, t.CreatedAt, t.UpdatedAt})
+		rows = append(rows, []string{t.ProjectID, t.Status, fmtTime(t.CreatedAt), fmtTime(t.UpdatedAt)})
+	}
+	if f.json {
+		return printJSON(views)
+	}
+	table(os.Stdout, "PROJECT\tSTATUS\tCREATED\tUPDATED", rows)
+	return 0
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"cs-agent/store"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestOpsFlagsParse(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		args     []string
+		min, max int
+		pos      []string
+		json     bool
+		dataDir  string
+		ok       bool
+	}{
+		{"flags first", []string{"-json", "t1"}, 1, 1, []string{"t1"}, true, "", true},
+		{"flags after positional", []string{"t1", "-json"}, 1, 1, []string{"t1"}, true, "", true},
+		{"flags around positionals", []string{"-data-dir", "/var/lib/cs", "a", "-json", "b"}, 0, 2, []string{"a", "b"}, true, "/var/lib/cs", true},
+		{"no args", nil, 0, 0, nil, false, "", true},
+		{"too few", []string{"-json"}, 1, 1, nil, true, "", false},
+		{"too many", []string{"a", "b"}, 1, 1, nil, false, "", false},
+		{"unknown flag", []string{"t1", "-nope"}, 1, 1, nil, false, "", false},
+		{"after --", []string{"--", "-json"}, 1, 1, []string{"-json"}, false, "", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newOpsFlags("tasks show", " <task-id>")
+			f.SetOutput(io.Discard)
+			pos, ok := f.parse(tc.args, tc.min, tc.max)
+			if ok != tc.ok || (ok && !slices.Equal(pos, tc.pos)) {
+				t.Fatalf("parse(%q) = %q, %v; want %q, %v", tc.args, pos, ok, tc.pos, tc.ok)
+			}
+			if ok && (f.json != tc.json || f.dataDir != tc.dataDir) {
+				t.Fatalf("parse(%q): json %v, data-dir %q", tc.args, f.json, f.dataDir)
+			}
+		})
+	}
+}
+
+// TestTasksCancel proves the cancel goes through the agent's admin socket when
+// one answers, and falls back to cancelling a not-yet-started task in the store
+// when none does.
+func TestTasksCancel(t *testing.T) {
+	ctx := context.Background()
+	dataDir := t.TempDir()
+	st, err := store.Open(dataDir, store.Options{})
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	t.Cleanup(func() { _ = st.Close() })
+	for _, id := range []string{"pending", "running", "done"} {
+		if _, err := st.CreateTask(ctx, store.Task{ID: id, Name: "volume.backup", Node: "n"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := st.ClaimTask(ctx, "running"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.UpdateTaskStatus(ctx, "done", store.TaskCompleted, nil); err != nil {
+		t.Fatal(err)
+	}
+	// A socket path under a short temp dir: t.TempDir can outgrow sun_path.
+	sockDir, err := os.MkdirTemp("", "cs-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(sockDir) })
+	socket := filepath.Join(sockDir, "admin.sock")
+	cancel := func(id string) int {
+		t.Helper()
+		return tasksCancel([]string{id, "-socket", socket, "-data-dir", dataDir})
+	}
+	status := func(id string) string {
+		t.Helper()
+		tk, _, err := st.GetTask(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tk.Status
+	}
+
+	// No agent: a pending task is cancelled in the store, anything else refused.
+	if code := cancel("pending"); code != 0 || status("pending") != store.TaskCancelled {
+		t.Fatalf("cancel pending without the agent = %d, status %q", code, status("pending"))
+	}
+	for _, id := range []string{"running", "done", "absent"} {
+		if code := cancel(id); code != 1 {
+			t.Errorf("cancel %s without the agent = %d, want 1", id, code)
+		}
+	}
+	if status("running") != store.TaskRunning || status("done") != store.TaskCompleted {
+		t.Fatalf("refused cancels changed the store: running %q, done %q", status("running"), status("done"))
+	}
+
+	// With the agent: the socket decides, and the store is left to it.
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var asked []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /v1/admin/tasks/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		asked = append(asked, id)
+		_ = json.NewEncoder(w).Encode(map[string]any{"id": id, "cancelled": false, "interrupted": id == "running"})
+	})
+	srv := &http.Server{Handler: mux}
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	if code := cancel("running"); code != 0 {
+		t.Fatalf("cancel running through the agent = %d, want 0", code)
+	}
+	if code := cancel("done"); code != 1 {
+		t.Fatalf("cancel of a finished task through the agent = %d, want 1", code)
+	}
+	if !slices.Equal(asked, []string{"running", "done"}) || status("running") != store.TaskRunning {
+		t.Fatalf("agent asked for %v; running task status %q", asked, status("running"))
+	}
+}
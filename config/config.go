@@ -1,8 +1,9 @@
 package config
 
 import (
-	"cs-agent/log"
+	"fmt"
 
+	"github.com/hashicorp/go-hclog"
 	"github.com/spf13/viper"
 )
 
@@ -16,42 +17,107 @@ func ConfigureApp() {
 	viper.AddConfigPath(".")                  // optionally look for config in the working directory
 	err := viper.ReadInConfig()               // Find and read the config file
 	if err != nil {                           // Handle errors reading the config file
-		log.New().Warn("Error loading configuration file", "error", err)
+		hclog.New(&hclog.LoggerOptions{Name: "cs-agent"}).Warn("Error loading configuration file", "error", err)
 	}
+	setDefaults(viper.GetViper())
+	reloaded.Store(nil)
+}
+
+// LoadFile reads the config file at path (instead of searching the default
+// locations) and registers the defaults. Used by `cs-agent config validate`.
+func LoadFile(path string) error {
+	viper.SetConfigType("yaml")
+	viper.SetConfigFile(path)
+	if err := viper.ReadInConfig(); err != nil {
+		return fmt.Errorf("config: read %s: %w", path, err)
+	}
+	setDefaults(viper.GetViper())
+	reloaded.Store(nil)
+	return nil
+}
 
+// setDefaults registers every setting's default on v (the global config, or a
+// candidate being validated before a reload).
+func setDefaults(v *viper.Viper) {
 	////
 	// Defaults
-	viper.SetDefault("log.level", "INFO")
-	viper.SetDefault("sentry.dsn", "https://caf0e228c0dc4c36a4b4972cc2c0eba2@sentry.cmptstks.com/3")
+	v.SetDefault("log.level", "INFO")
+	v.SetDefault("sentry.dsn", "https://caf0e228c0dc4c36a4b4972cc2c0eba2@sentry.cmptstks.com/3")
 
 	////
 	// Specify which iptables command to use
 	// For docker environments using the older legacy iptables, switch to: iptables-legacy
-	viper.SetDefault("host.iptables-cmd", "iptables")
+	v.SetDefault("host.iptables-cmd", "iptables")
 
 	// For testing purposes only, dont set `true` in production environments.
-	viper.SetDefault("docker.privileged", false)
+	v.SetDefault("docker.privileged", false)
 
-	viper.SetDefault("docker.version", "1.44")
+	v.SetDefault("docker.version", "1.44")
 
-	viper.SetDefault("computestacks.host", "localhost:3000")
+	v.SetDefault("computestacks.host", "localhost:3000")
 
-	viper.SetDefault("queue.numworkers", 3)
+	v.SetDefault("queue.numworkers", 3)
 
 	// Changelog retention (prune janitor): delete rows at/below the controller's
 	// acked watermark once older than prune_min_age_sec, plus any row older than
 	// prune_max_age_sec regardless of ack (bounds growth before the controller
 	// integrates and starts acking).
-	viper.SetDefault("changelog.prune_freq", "*/15 * * * *")
-	viper.SetDefault("changelog.prune_min_age_sec", 604800)  // 7d
-	viper.SetDefault("changelog.prune_max_age_sec", 2592000) // 30d
+	v.SetDefault("changelog.prune_freq", "*/15 * * * *")
+	v.SetDefault("changelog.prune_min_age_sec", 604800)  // 7d
+	v.SetDefault("changelog.prune_max_age_sec", 2592000) // 30d
 	// Terminal task-row retention (all kinds). Must exceed the longest export
 	// presigned-URL TTL so a completed export whose link is still live isn't reaped.
-	viper.SetDefault("tasks.retention_sec", 604800) // 7d
+	v.SetDefault("tasks.retention_sec", 604800) // 7d
+	// Automatic retry of TRANSIENT task failures (borg lock timeout, dropped SSH
+	// connection, S3 5xx); a terminal failure is never retried. max_attempts
+	// counts the first run; backoff doubles from base_delay_sec up to
+	// max_delay_sec. Restore stays at 1: it only re-runs by explicit re-request.
+	v.SetDefault("tasks.retry.base_delay_sec", 60)
+	v.SetDefault("tasks.retry.max_delay_sec", 900) // 15m
+	v.SetDefault("tasks.retry.volume.backup.max_attempts", 3)
+	v.SetDefault("tasks.retry.backup.export.max_attempts", 3)
+	v.SetDefault("tasks.retry.backup.delete.max_attempts", 3)
+	v.SetDefault("tasks.retry.volume.trash.max_attempts", 3)
+	v.SetDefault("tasks.retry.volume.restore.max_attempts", 1)
+	v.SetDefault("tasks.retry.volume.clone.max_attempts", 3) // a failed clone is removed, so a retry starts clean
+	v.SetDefault("tasks.retry.volume.restore_paths.max_attempts", 3)
+	v.SetDefault("tasks.retry.backup.list.max_attempts", 3)
+	v.SetDefault("tasks.retry.repository.prune.max_attempts", 3)
+	v.SetDefault("tasks.retry.repository.compact.max_attempts", 3)
+	v.SetDefault("tasks.retry.repository.check.max_attempts", 3)
+	v.SetDefault("tasks.retry.backup.verify.max_attempts", 3)
+	// Boot replay: a task the agent died while running goes back to pending (after
+	// its stale backup container + borg lock are cleaned up) at most max_replays
+	// times, then fails — so a task that crashes the agent can't crash-loop it.
+	// 0 fails it on boot; only idempotent kinds default to replaying.
+	v.SetDefault("tasks.replay.volume.backup.max_replays", 2)
+	v.SetDefault("tasks.replay.backup.export.max_replays", 2)
+	v.SetDefault("tasks.replay.repository.prune.max_replays", 2)
+	v.SetDefault("tasks.replay.repository.compact.max_replays", 2)
+	v.SetDefault("tasks.replay.repository.check.max_replays", 2)
+	v.SetDefault("tasks.replay.backup.verify.max_replays", 2)
+	// Per-kind run-time cap: a run past it is interrupted and recorded timed_out,
+	// so a hung borg can't hold a worker forever. 0 = no cap. backup.export has
+	// no default here: it falls back to backups.export.timeout_sec.
+	v.SetDefault("tasks.timeout.volume.backup.max_runtime_sec", 21600)        // 6h
+	v.SetDefault("tasks.timeout.volume.restore.max_runtime_sec", 21600)       // 6h
+	v.SetDefault("tasks.timeout.volume.clone.max_runtime_sec", 21600)         // 6h
+	v.SetDefault("tasks.timeout.volume.restore_paths.max_runtime_sec", 21600) // 6h
+	v.SetDefault("tasks.timeout.backup.list.max_runtime_sec", 3600)           // 1h
+	v.SetDefault("tasks.timeout.backup.delete.max_runtime_sec", 3600)         // 1h
+	v.SetDefault("tasks.timeout.volume.trash.max_runtime_sec", 3600)          // 1h
+	v.SetDefault("tasks.timeout.repository.prune.max_runtime_sec", 3600)      // 1h
+	v.SetDefault("tasks.timeout.repository.compact.max_runtime_sec", 21600)   // 6h
+	v.SetDefault("tasks.timeout.repository.check.max_runtime_sec", 86400)     // 24h (--verify-data reads everything)
+	v.SetDefault("tasks.timeout.backup.verify.max_runtime_sec", 21600)        // 6h, as a restore
+	// How often a running task's progress snapshot (phase, bytes, ETA) is written
+	// to its row — each write is a changelog entry, so this is the throttle.
+	// 0 disables the snapshots (the live event stream is unaffected).
+	v.SetDefault("tasks.progress_interval_sec", 30)
 
 	// Embedded SQLite data plane (store/): control.db + per-project metadata DBs
 	// live under this directory.
-	viper.SetDefault("store.data_dir", "/var/lib/cs-agent")
+	v.SetDefault("store.data_dir", "/var/lib/cs-agent")
 
 	// Customer-metadata HTTP front door (httpapi/). Binds the node's
 	// own :8500 (baked into customer containers via metadata.internal:8500, so
@@ -60,90 +126,139 @@ func ConfigureApp() {
 	// Bearer the controller authenticates with (empty disables the admin scope).
 	// max_body_bytes caps a single request body (413 on exceed) — the STORED
 	// value is uncapped; this is only a transport limit.
-	viper.SetDefault("metadata.listen_addr", ":8500")
-	viper.SetDefault("metadata.admin_token_hash", "")
-	viper.SetDefault("metadata.max_body_bytes", 10485760) // 10 MiB
+	v.SetDefault("metadata.listen_addr", ":8500")
+	v.SetDefault("metadata.admin_token_hash", "")
+	v.SetDefault("metadata.max_body_bytes", 10485760) // 10 MiB
+	// Per-tenant token bucket on POST /v1/actions (a node-level abuse bound, not
+	// the real budget — that lives downstream). Reloadable on SIGHUP.
+	v.SetDefault("metadata.actions_rate_limit.burst", 20)
+	v.SetDefault("metadata.actions_rate_limit.refill_per_sec", 2.0) // ~120/min sustained
+	// Root-only unix socket serving the admin API plus node-local operator
+	// routes, authenticated by peer credentials instead of the Bearer. Empty
+	// disables it.
+	v.SetDefault("metadata.admin_socket", "/run/cs-agent/admin.sock")
+
+	// Prometheus /metrics, on its own listener so it is never reachable through
+	// the customer-facing :8500. Loopback by default; empty disables it.
+	v.SetDefault("metrics.listen_addr", "127.0.0.1:9464")
 
-	viper.SetDefault("backups.enabled", true)
-	viper.SetDefault("backups.prune_freq", "15 1 * * *")
+	v.SetDefault("backups.enabled", true)
+	v.SetDefault("backups.prune_freq", "15 1 * * *")
 	// Compaction now runs in-agent (was a host cron on the backup server). Set
 	// to "" to disable scheduling it. Offset from prune so prune (mark) runs
 	// before compact (reclaim).
-	viper.SetDefault("backups.compact_freq", "45 2 * * *")
+	v.SetDefault("backups.compact_freq", "45 2 * * *")
 	// Per-node random delay (seconds) before a compact sweep, so many nodes
 	// sharing one backup server don't all compact at the same minute.
-	viper.SetDefault("backups.compact_jitter_sec", 1800)
-	viper.SetDefault("backups.key", "changeme!")
+	v.SetDefault("backups.compact_jitter_sec", 1800)
+	// borg check sweep: "" disables it. Run daily — each repository is checked on
+	// its own weekday. --verify-data every check_verify_data_days (0 = never).
+	v.SetDefault("backups.check_freq", "")
+	v.SetDefault("backups.check_jitter_sec", 3600)
+	v.SetDefault("backups.check_verify_data_days", 0)
+	// backup.verify: how long a scratch database may take to answer its sanity
+	// query (it may run crash recovery on the extracted data first).
+	v.SetDefault("backups.verify.database_timeout_sec", 300)
+	// backup.list page size: default_limit when the task asks for none, never
+	// more than max_limit (the page is stored in the task's result_json).
+	v.SetDefault("backups.browse.default_limit", 1000)
+	v.SetDefault("backups.browse.max_limit", 5000)
+	v.SetDefault("backups.key", "changeme!")
 
-	viper.SetDefault("backups.borg.image", "ghcr.io/computestacks/cs-docker-borg:latest")
-	viper.SetDefault("backups.borg.compression", "zstd,3")
-	viper.SetDefault("backups.borg.lock_wait", "1")
+	v.SetDefault("backups.borg.image", "ghcr.io/computestacks/cs-docker-borg:latest")
+	v.SetDefault("backups.borg.compression", "zstd,3")
+	v.SetDefault("backups.borg.lock_wait", "1")
 	// Longer lock-wait for `borg create`: a scheduled backup should wait out an
 	// in-agent compact/prune (both hold borg's exclusive lock) rather than fail
 	// after 1s and miss the backup. Ops without an override fall back to lock_wait.
-	viper.SetDefault("backups.borg.lock_wait_create", "600")
+	v.SetDefault("backups.borg.lock_wait_create", "600")
 
-	viper.SetDefault("backups.borg.ssh.enabled", false)
-	viper.SetDefault("backups.borg.ssh.user", "")
-	viper.SetDefault("backups.borg.ssh.host", "")
-	viper.SetDefault("backups.borg.ssh.port", "22")
-	viper.SetDefault("backups.borg.ssh.host_path", "/tmp")
-	viper.SetDefault("backups.borg.ssh.keyfile", "/etc/computestacks/backup/.ssh/id_ed25519")
-	viper.SetDefault("backups.borg.ssh_borg_remote_path", "/usr/bin/borg")
+	v.SetDefault("backups.borg.ssh.enabled", false)
+	v.SetDefault("backups.borg.ssh.user", "")
+	v.SetDefault("backups.borg.ssh.host", "")
+	v.SetDefault("backups.borg.ssh.port", "22")
+	v.SetDefault("backups.borg.ssh.host_path", "/tmp")
+	v.SetDefault("backups.borg.ssh.keyfile", "/etc/computestacks/backup/.ssh/id_ed25519")
+	v.SetDefault("backups.borg.ssh_borg_remote_path", "/usr/bin/borg")
 
-	viper.SetDefault("backups.borg.nfs", false)
-	viper.SetDefault("backups.borg.nfs_host", "127.0.0.1")
-	viper.SetDefault("backups.borg.nfs_opts", ",async,noatime,rsize=32768,wsize=32768")
+	v.SetDefault("backups.borg.nfs", false)
+	v.SetDefault("backups.borg.nfs_host", "127.0.0.1")
+	v.SetDefault("backups.borg.nfs_opts", ",async,noatime,rsize=32768,wsize=32768")
 
-	viper.SetDefault("backups.borg.nfs_host_path", "/var/nfsshare/volume_backups")
+	v.SetDefault("backups.borg.nfs_host_path", "/var/nfsshare/volume_backups")
 	// Path to the borg binary ON the NFS/backup server. The agent runs
 	// `borg compact` locally there over SSH (heavy segment rewriting stays off
 	// the network). A non-interactive SSH session may lack /usr/local/bin on
 	// PATH, so this is configurable; bare "borg" relies on the remote PATH.
-	viper.SetDefault("backups.borg.nfs_borg_path", "borg")
-	viper.SetDefault("backups.borg.nfs_ssh.user", "root")
-	viper.SetDefault("backups.borg.nfs_ssh.port", "22")
-	viper.SetDefault("backups.borg.nfs_ssh.keyfile", "/root/.ssh/id_ed25519")
+	v.SetDefault("backups.borg.nfs_borg_path", "borg")
+	v.SetDefault("backups.borg.nfs_ssh.user", "root")
+	v.SetDefault("backups.borg.nfs_ssh.port", "22")
+	v.SetDefault("backups.borg.nfs_ssh.keyfile", "/root/.ssh/id_ed25519")
 
 	// Whether we ssh into the backup server and create the path.
-	viper.SetDefault("backups.borg.nfs_create_path", true)
+	v.SetDefault("backups.borg.nfs_create_path", true)
 
 	// When using NFS, and nfs_creat_path is enabled, we will attempt to change the ownership
 	// on the host to this value. The SSH user MUST have permissions to do so.
-	viper.SetDefault("backups.borg.nfs_ssh.fs_user", "nobody")
-	viper.SetDefault("backups.borg.nfs_ssh.fs_group", "nogroup")
+	v.SetDefault("backups.borg.nfs_ssh.fs_user", "nobody")
+	v.SetDefault("backups.borg.nfs_ssh.fs_group", "nogroup")
 
 	// Backup export ("download backup"): stream a chosen archive to S3 and hand
 	// back a presigned URL. Inert until backups.export.s3.bucket is set.
-	viper.SetDefault("backups.export.workers", 1)         // dedicated export worker count
-	viper.SetDefault("backups.export.tar_filter", "gzip") // borg --tar-filter for the exported tar; "" disables. export-tar emits the ORIGINAL (decompressed) files, so the repo's own compression does NOT carry over — without a filter the upload is full plaintext size. Suffix tracks this (gzip -> .tar.gz). "pigz"/"gzip -1" trade ratio for speed if the borg image has them.
-	viper.SetDefault("backups.export.timeout_sec", 14400) // hard cap on a single export so a hung borg/S3 can't hold the repo lock (4h)
-	viper.SetDefault("backups.export.s3.endpoint", "")    // empty = real AWS; set for S3-compatible (MinIO/Ceph)
-	viper.SetDefault("backups.export.s3.region", "us-east-1")
-	viper.SetDefault("backups.export.s3.bucket", "")
-	viper.SetDefault("backups.export.s3.prefix", "exports/")
-	viper.SetDefault("backups.export.s3.access_key", "")
-	viper.SetDefault("backups.export.s3.secret_key", "")
-	viper.SetDefault("backups.export.s3.force_path_style", false)   // true for MinIO/path-style
-	viper.SetDefault("backups.export.s3.part_size_mb", 64)          // 5MB*10000=50GB ceiling is too tight
-	viper.SetDefault("backups.export.s3.concurrency", 4)            // parts in flight; mem ≈ part_size*concurrency
-	viper.SetDefault("backups.export.s3.sse", "AES256")             // server-side encryption (exported tar is plaintext)
-	viper.SetDefault("backups.export.s3.default_ttl_sec", 43200)    // presigned URL TTL when unspecified (12h)
-	viper.SetDefault("backups.export.s3.max_ttl_sec", 86400)        // hard cap on a requested TTL (24h)
+	v.SetDefault("backups.export.workers", 1)         // dedicated export worker count
+	v.SetDefault("backups.export.tar_filter", "gzip") // borg --tar-filter for the exported tar; "" disables. export-tar emits the ORIGINAL (decompressed) files, so the repo's own compression does NOT carry over — without a filter the upload is full plaintext size. Suffix tracks this (gzip -> .tar.gz). "pigz"/"gzip -1" trade ratio for speed if the borg image has them.
+	v.SetDefault("backups.export.timeout_sec", 14400) // hard cap on a single export so a hung borg/S3 can't hold the repo lock (4h)
+	v.SetDefault("backups.export.s3.endpoint", "")    // empty = real AWS; set for S3-compatible (MinIO/Ceph)
+	v.SetDefault("backups.export.s3.region", "us-east-1")
+	v.SetDefault("backups.export.s3.bucket", "")
+	v.SetDefault("backups.export.s3.prefix", "exports/")
+	v.SetDefault("backups.export.s3.access_key", "")
+	v.SetDefault("backups.export.s3.secret_key", "")
+	v.SetDefault("backups.export.s3.force_path_style", false) // true for MinIO/path-style
+	v.SetDefault("backups.export.s3.part_size_mb", 64)        // 5MB*10000=50GB ceiling is too tight
+	v.SetDefault("backups.export.s3.concurrency", 4)          // parts in flight; mem ≈ part_size*concurrency
+	v.SetDefault("backups.export.s3.sse", "AES256")           // server-side encryption (exported tar is plaintext)
+	v.SetDefault("backups.export.s3.default_ttl_sec", 43200)  // presigned URL TTL when unspecified (12h)
+	v.SetDefault("backups.export.s3.max_ttl_sec", 86400)      // hard cap on a requested TTL (24h)
+
+	// The export_cleanup maintenance job: a completed export whose presigned URL
+	// has lapsed is marked expired, and a failed export's row is reaped after
+	// failed_retention_sec (0 leaves it to tasks.retention_sec).
+	v.SetDefault("backups.export.cleanup_freq", "*/30 * * * *")  // "" disables
+	v.SetDefault("backups.export.failed_retention_sec", 86400)   // 24h
+	v.SetDefault("backups.export.delete_expired_objects", false) // s3 only: also delete the object rather than wait for the bucket lifecycle
+
+	// Where exports go: "s3" (the s3 block; a presigned URL), "sftp" (a
+	// directory on an SFTP server; an sftp:// path the customer fetches with
+	// their own login) or "local" (a local/NFS directory; a one-time download
+	// URL served by this agent at <base_url>/v1/exports/<token>).
+	v.SetDefault("backups.export.destination", "s3")
+	v.SetDefault("backups.export.sftp.host", "")
+	v.SetDefault("backups.export.sftp.port", 22)
+	v.SetDefault("backups.export.sftp.user", "")
+	v.SetDefault("backups.export.sftp.keyfile", "")
+	v.SetDefault("backups.export.sftp.known_hosts", "/root/.ssh/known_hosts") // required: the host key is always checked
+	v.SetDefault("backups.export.sftp.dir", "")                               // absolute directory on the server
+	v.SetDefault("backups.export.sftp.default_ttl_sec", 86400)                // how long the export stays before export_cleanup retires it (24h)
+	v.SetDefault("backups.export.sftp.max_ttl_sec", 604800)                   // hard cap on a requested TTL (7d)
+	v.SetDefault("backups.export.local.dir", "")                              // absolute directory (local disk or an NFS mount)
+	v.SetDefault("backups.export.local.base_url", "")                         // how customers reach this agent, e.g. "https://node1.example.com:8500"
+	v.SetDefault("backups.export.local.default_ttl_sec", 43200)               // download token lifetime when unspecified (12h)
+	v.SetDefault("backups.export.local.max_ttl_sec", 86400)                   // hard cap on a requested TTL (24h)
 
 	// MariaDB Backup Configuration
-	viper.SetDefault("mariadb.lock_wait.query_type", "ALL")
-	viper.SetDefault("mariadb.lock_wait.timeout", "60")
-	viper.SetDefault("mariadb.long_queries.timeout", "20")
-	viper.SetDefault("mariadb.long_queries.query_type", "SELECT")
+	v.SetDefault("mariadb.lock_wait.query_type", "ALL")
+	v.SetDefault("mariadb.lock_wait.timeout", "60")
+	v.SetDefault("mariadb.long_queries.timeout", "20")
+	v.SetDefault("mariadb.long_queries.query_type", "SELECT")
 
 }
 
 // ReleaseEnvironment is a helper used to determine current release
 func ReleaseEnvironment() string {
-	if viper.GetString("backups.key") == "changeme!" {
+	if GetString("backups.key") == "changeme!" {
 		return "development"
-	} else if viper.GetString("backups.key") == "tester!" {
+	} else if GetString("backups.key") == "tester!" {
 		return "testing"
 	} else {
 		return "production"
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// redactedValue stands in for a set secret in a config dump.
+const redactedValue = "<redacted>"
+
+// secretKeys are the settings whose values a dump never shows. A key matches
+// exactly or by its last segment (so every *.secret_key is covered).
+var secretKeys = []string{
+	"backups.key",
+	"sentry.dsn",
+	"metadata.admin_token_hash",
+	"access_key",
+	"secret_key",
+	"password",
+	"token",
+}
+
+// IsSecret reports whether key's value must not be shown or logged.
+func IsSecret(key string) bool {
+	last := key[strings.LastIndex(key, ".")+1:]
+	for _, s := range secretKeys {
+		if key == s || last == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Redacted returns every setting of v, keyed by its dotted name, with the value
+// of each set secret replaced by "<redacted>" (an unset one stays empty, so a
+// dump still shows it is missing). For the admin socket's config dump.
+func Redacted(v *viper.Viper) map[string]any {
+	out := map[string]any{}
+	for _, key := range v.AllKeys() {
+		val := v.Get(key)
+		if IsSecret(key) && v.GetString(key) != "" {
+			val = redactedValue
+		}
+		out[key] = val
+	}
+	return out
+}
@@ -0,0 +1,21 @@
+package config
+
+import "testing"
+
+func TestRedacted(t *testing.T) {
+	v := defaultsViper()
+	v.Set("backups.key", "hunter2")
+	v.Set("backups.export.s3.secret_key", "s3cret")
+	dump := Redacted(v)
+	for key, want := range map[string]any{
+		"backups.key":                  redactedValue,
+		"backups.export.s3.secret_key": redactedValue,
+		"backups.export.s3.access_key": "", // unset: shown as missing
+		"backups.borg.ssh.keyfile":     "/etc/computestacks/backup/.ssh/id_ed25519",
+		"queue.numworkers":             3,
+	} {
+		if got := dump[key]; got != want {
+			t.Errorf("%s = %v, want %v", key, got, want)
+		}
+	}
+}
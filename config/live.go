@@ -0,0 +1,41 @@
+package config
+
+import (
+	"sync/atomic"
+
+	"github.com/spf13/viper"
+)
+
+// reloaded is the running config once Reload has replaced the one loaded at
+// startup; nil until then, when the global viper instance is the config. A
+// reload publishes a fresh instance here rather than re-reading into the
+// global one, whose maps every worker, the scheduler and the HTTP handlers
+// read concurrently.
+var reloaded atomic.Pointer[viper.Viper]
+
+// Current is the running config. Read settings through it (or the Get helpers
+// below), never the global viper instance, so a reload is seen.
+func Current() *viper.Viper {
+	if v := reloaded.Load(); v != nil {
+		return v
+	}
+	return viper.GetViper()
+}
+
+// GetString reads key from the running config.
+func GetString(key string) string { return Current().GetString(key) }
+
+// GetInt reads key from the running config.
+func GetInt(key string) int { return Current().GetInt(key) }
+
+// GetInt64 reads key from the running config.
+func GetInt64(key string) int64 { return Current().GetInt64(key) }
+
+// GetBool reads key from the running config.
+func GetBool(key string) bool { return Current().GetBool(key) }
+
+// GetFloat64 reads key from the running config.
+func GetFloat64(key string) float64 { return Current().GetFloat64(key) }
+
+// IsSet reports whether key is set in the running config.
+func IsSet(key string) bool { return Current().IsSet(key) }
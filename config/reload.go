@@ -0,0 +1,91 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// restartKeys are the settings only read at startup (worker pools, listeners,
+// the store, Sentry): a reload applies everything else live and reports a
+// change to one of these as needing a restart. An entry ending in "." covers
+// the whole subtree.
+var restartKeys = []string{
+	"queue.numworkers",
+	"backups.enabled",
+	"backups.export.workers",
+	"metadata.listen_addr",
+	"metadata.admin_token_hash",
+	"metadata.max_body_bytes",
+	"metadata.admin_socket",
+	"metrics.listen_addr",
+	"store.",
+	"sentry.",
+}
+
+// RestartRequired reports whether a change to key only takes effect after a
+// restart.
+func RestartRequired(key string) bool {
+	for _, k := range restartKeys {
+		if key == k || (strings.HasSuffix(k, ".") && strings.HasPrefix(key, k)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reload re-reads the config file the agent started with and validates it; only
+// a valid file replaces the running config (an invalid one leaves it untouched
+// and the error lists every problem). The new config is published whole (see
+// Current); the global viper instance is never written after startup. changed
+// lists the keys whose values differ, sorted — the caller applies the live ones
+// and reports those that RestartRequired.
+func Reload() (changed []string, err error) {
+	path := viper.ConfigFileUsed()
+	if path == "" {
+		return nil, errors.New("config: no config file was loaded at startup")
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: read %s: %w", path, err)
+	}
+	next := viper.New()
+	next.SetConfigType("yaml")
+	setDefaults(next)
+	if err := next.ReadConfig(bytes.NewReader(raw)); err != nil {
+		return nil, fmt.Errorf("config: parse %s: %w", path, err)
+	}
+	if errs := Validate(next); len(errs) > 0 {
+		return nil, fmt.Errorf("config: %s is invalid: %w", path, errors.Join(errs...))
+	}
+
+	before := snapshot(Current())
+	reloaded.Store(next)
+	after := snapshot(next)
+	for key, val := range after {
+		if prev, ok := before[key]; !ok || prev != val {
+			changed = append(changed, key)
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	slices.Sort(changed)
+	return changed, nil
+}
+
+// snapshot renders every setting of v for comparison.
+func snapshot(v *viper.Viper) map[string]string {
+	out := map[string]string{}
+	for _, key := range v.AllKeys() {
+		out[key] = fmt.Sprint(v.Get(key))
+	}
+	return out
+}
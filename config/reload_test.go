@@ -0,0 +1,81 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// loadFile points the global viper at a temp agent.yml holding body, as
+// ConfigureApp would for /etc/computestacks/agent.yml.
+func loadFile(t *testing.T, body string) string {
+	t.Helper()
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	path := filepath.Join(t.TempDir(), "agent.yml")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReload(t *testing.T) {
+	path := loadFile(t, "log:\n  level: INFO\nqueue:\n  numworkers: 3\n")
+
+	if err := os.WriteFile(path, []byte("log:\n  level: DEBUG\nqueue:\n  numworkers: 5\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(changed, []string{"log.level", "queue.numworkers"}) {
+		t.Fatalf("changed = %v", changed)
+	}
+	if GetString("log.level") != "DEBUG" {
+		t.Fatalf("log.level = %q", GetString("log.level"))
+	}
+	if !RestartRequired("queue.numworkers") || !RestartRequired("store.max_open_projects") || RestartRequired("log.level") {
+		t.Fatal("RestartRequired misclassifies keys")
+	}
+
+	// An invalid file is rejected whole: the running config is untouched.
+	if err := os.WriteFile(path, []byte("log:\n  level: WARN\nbackups:\n  prune_freq: nope\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Reload(); err == nil || !strings.Contains(err.Error(), "backups.prune_freq") {
+		t.Fatalf("err = %v, want the bad cron named", err)
+	}
+	if GetString("log.level") != "DEBUG" {
+		t.Fatalf("rejected reload applied: log.level = %q", GetString("log.level"))
+	}
+}
+
+func TestReloadLeavesGlobalAlone(t *testing.T) {
+	path := loadFile(t, "log:\n  level: INFO\n")
+	if err := os.WriteFile(path, []byte("log:\n  level: DEBUG\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 1000 {
+			_ = GetString("log.level") // readers run alongside a reload
+		}
+	}()
+	if _, err := Reload(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if GetString("log.level") != "DEBUG" || viper.GetString("log.level") != "INFO" {
+		t.Fatalf("running log.level %q, global %q; want the reload published, the global untouched",
+			GetString("log.level"), viper.GetString("log.level"))
+	}
+}
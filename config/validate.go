@@ -0,0 +1,198 @@
+package config
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/viper"
+)
+
+// metadataPort is the port the metadata front door must bind: customer
+// containers reach it as metadata.internal:8500, baked in at creation.
+const metadataPort = "8500"
+
+// cronKeys are the cron settings; empty is allowed for each (it disables the
+// job, or for changelog.prune_freq falls back to the default interval).
+var cronKeys = []string{
+	"backups.prune_freq",
+	"backups.compact_freq",
+	"backups.check_freq",
+	"changelog.prune_freq",
+	"backups.export.cleanup_freq",
+}
+
+// hostPathChars is what a backup host path may contain. The path is spliced into
+// borg repository URLs and into the mkdir/rm commands run over SSH on the backup
+// server, so anything a shell would interpret is refused.
+var hostPathChars = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+
+// Validate checks v's settings for consistency and returns every problem found
+// (nil when the config is valid). It looks at nothing outside v, so a reload
+// runs it before swapping the config in; Check adds the host checks.
+func Validate(v *viper.Viper) []error {
+	var errs []error
+	for _, key := range cronKeys {
+		if expr := v.GetString(key); expr != "" {
+			if _, err := cron.ParseStandard(expr); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid cron %q: %w", key, expr, err))
+			}
+		}
+	}
+	if level := v.GetString("log.level"); hclog.LevelFromString(level) == hclog.NoLevel {
+		errs = append(errs, fmt.Errorf("log.level: unknown level %q", level))
+	}
+	if v.GetInt("metadata.actions_rate_limit.burst") < 1 {
+		errs = append(errs, errors.New("metadata.actions_rate_limit.burst: must be at least 1"))
+	}
+	if v.GetFloat64("metadata.actions_rate_limit.refill_per_sec") <= 0 {
+		errs = append(errs, errors.New("metadata.actions_rate_limit.refill_per_sec: must be positive"))
+	}
+
+	switch dest := v.GetString("backups.export.destination"); dest {
+	case "s3", "sftp", "local":
+	default:
+		errs = append(errs, fmt.Errorf("backups.export.destination: unknown destination %q (want s3, sftp or local)", dest))
+	}
+
+	if v.GetBool("backups.borg.ssh.enabled") && v.GetBool("backups.borg.nfs") {
+		errs = append(errs, errors.New("backups.borg.nfs: cannot be combined with backups.borg.ssh.enabled; pick one backup method"))
+	}
+	if v.GetBool("backups.borg.ssh.enabled") {
+		if err := checkHostPath(v.GetString("backups.borg.ssh.host_path")); err != nil {
+			errs = append(errs, fmt.Errorf("backups.borg.ssh.host_path: %w", err))
+		}
+	}
+	if v.GetBool("backups.borg.nfs") {
+		if err := checkHostPath(v.GetString("backups.borg.nfs_host_path")); err != nil {
+			errs = append(errs, fmt.Errorf("backups.borg.nfs_host_path: %w", err))
+		}
+	}
+
+	if hash := v.GetString("metadata.admin_token_hash"); hash != "" {
+		if b, err := hex.DecodeString(hash); err != nil || len(b) != 32 || hex.EncodeToString(b) != hash {
+			errs = append(errs, errors.New("metadata.admin_token_hash: must be the lowercase hex sha256 of the admin token (64 characters)"))
+		}
+	}
+	if sock := v.GetString("metadata.admin_socket"); sock != "" && !filepath.IsAbs(sock) {
+		errs = append(errs, errors.New("metadata.admin_socket: must be an absolute path"))
+	}
+	metaPort, err := listenPort(v.GetString("metadata.listen_addr"))
+	switch {
+	case err != nil:
+		errs = append(errs, fmt.Errorf("metadata.listen_addr: %w", err))
+	case metaPort != metadataPort:
+		errs = append(errs, fmt.Errorf("metadata.listen_addr: port must be %s (customer containers use metadata.internal:%s), got %s", metadataPort, metadataPort, metaPort))
+	}
+	if addr := v.GetString("metrics.listen_addr"); addr != "" {
+		port, err := listenPort(addr)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("metrics.listen_addr: %w", err))
+		case port == metaPort:
+			errs = append(errs, fmt.Errorf("metrics.listen_addr: port %s is the metadata listener's", port))
+		}
+	}
+	return errs
+}
+
+// Check runs Validate plus the checks against this host and deployment: the SSH
+// keyfiles of the configured backup method (and of an sftp export destination,
+// with its known_hosts file) exist with owner-only permissions,
+// and backups.key has been changed from the shipped placeholder.
+func Check(v *viper.Viper) []error {
+	errs := Validate(v)
+	if v.GetBool("backups.borg.ssh.enabled") {
+		if err := checkKeyfile(v.GetString("backups.borg.ssh.keyfile")); err != nil {
+			errs = append(errs, fmt.Errorf("backups.borg.ssh.keyfile: %w", err))
+		}
+	}
+	if v.GetBool("backups.borg.nfs") {
+		// Also used without nfs_create_path: compaction runs over SSH on the server.
+		if err := checkKeyfile(v.GetString("backups.borg.nfs_ssh.keyfile")); err != nil {
+			errs = append(errs, fmt.Errorf("backups.borg.nfs_ssh.keyfile: %w", err))
+		}
+	}
+	if v.GetString("backups.export.destination") == "sftp" {
+		if err := checkKeyfile(v.GetString("backups.export.sftp.keyfile")); err != nil {
+			errs = append(errs, fmt.Errorf("backups.export.sftp.keyfile: %w", err))
+		}
+		if err := checkKnownHosts(v.GetString("backups.export.sftp.known_hosts")); err != nil {
+			errs = append(errs, fmt.Errorf("backups.export.sftp.known_hosts: %w", err))
+		}
+	}
+	if v.GetString("backups.key") == "changeme!" {
+		errs = append(errs, errors.New("backups.key: still the shipped placeholder \"changeme!\"; set a unique repository passphrase"))
+	}
+	return errs
+}
+
+// checkHostPath enforces the backup host path format: absolute, already clean
+// (no trailing slash, "." or ".."), not the root, and shell-safe.
+func checkHostPath(p string) error {
+	switch {
+	case p == "":
+		return errors.New("must be set")
+	case !filepath.IsAbs(p):
+		return fmt.Errorf("must be absolute, got %q", p)
+	case filepath.Clean(p) != p:
+		return fmt.Errorf("must be a clean path (no trailing slash, \".\" or \"..\"), got %q; use %q", p, filepath.Clean(p))
+	case p == "/":
+		return errors.New("must not be the filesystem root")
+	case !hostPathChars.MatchString(p):
+		return fmt.Errorf("may only contain letters, digits, '.', '_', '-' and '/', got %q", p)
+	}
+	return nil
+}
+
+// checkKeyfile reports an SSH private key that is missing, not a regular file,
+// or readable by group/other (ssh refuses those).
+func checkKeyfile(path string) error {
+	if path == "" {
+		return errors.New("must be set")
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", path)
+	}
+	if perm := fi.Mode().Perm(); perm&0o077 != 0 {
+		return fmt.Errorf("%s has mode %04o; a private key must not be group/world accessible (chmod 600)", path, perm)
+	}
+	return nil
+}
+
+// checkKnownHosts reports a known_hosts file that is unset or unreadable: the
+// sftp export destination never connects without checking the host key.
+func checkKnownHosts(path string) error {
+	if path == "" {
+		return errors.New("must be set")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// listenPort returns addr's port, checking addr is host:port with a port in
+// 1-65535.
+func listenPort(addr string) (string, error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid address %q: %w", addr, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid port %q in %q", port, addr)
+	}
+	return port, nil
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func defaultsViper() *viper.Viper {
+	v := viper.New()
+	setDefaults(v)
+	return v
+}
+
+// keys returns the setting each finding names (the text before the first ':').
+func keys(errs []error) []string {
+	var out []string
+	for _, err := range errs {
+		key, _, _ := strings.Cut(err.Error(), ":")
+		out = append(out, key)
+	}
+	return out
+}
+
+func TestValidate(t *testing.T) {
+	if errs := Validate(defaultsViper()); len(errs) != 0 {
+		t.Fatalf("defaults invalid: %v", errs)
+	}
+
+	v := defaultsViper()
+	v.Set("backups.prune_freq", "every tuesday")
+	v.Set("backups.export.cleanup_freq", "*/61 * * *")
+	v.Set("log.level", "LOUD")
+	v.Set("metadata.actions_rate_limit.burst", 0)
+	v.Set("backups.export.destination", "ftp")
+	v.Set("backups.borg.ssh.enabled", true)
+	v.Set("backups.borg.nfs", true)
+	v.Set("backups.borg.ssh.host_path", "/srv/backups/")
+	v.Set("backups.borg.nfs_host_path", "/srv/$(reboot)")
+	v.Set("metadata.admin_token_hash", strings.Repeat("AB", 32))
+	v.Set("metadata.listen_addr", "10.0.0.5:8080")
+	v.Set("metrics.listen_addr", "127.0.0.1:99999")
+	want := []string{
+		"backups.prune_freq",
+		"backups.export.cleanup_freq",
+		"log.level",
+		"metadata.actions_rate_limit.burst",
+		"backups.export.destination",
+		"backups.borg.nfs",
+		"backups.borg.ssh.host_path",
+		"backups.borg.nfs_host_path",
+		"metadata.admin_token_hash",
+		"metadata.listen_addr",
+		"metrics.listen_addr",
+	}
+	if got := keys(Validate(v)); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("findings for\n  %v\nwant\n  %v", got, want)
+	}
+}
+
+func TestCheckHostPath(t *testing.T) {
+	for p, ok := range map[string]bool{
+		"/var/nfsshare/volume_backups": true,
+		"/tmp":                         true,
+		"":                             false,
+		"backups":                      false,
+		"/backups/":                    false,
+		"/backups/../etc":              false,
+		"/":                            false,
+		"/my backups":                  false,
+		"/b;rm -rf":                    false,
+	} {
+		if err := checkHostPath(p); (err == nil) != ok {
+			t.Errorf("checkHostPath(%q) = %v", p, err)
+		}
+	}
+}
+
+func TestCheck_KeyfilesAndPlaceholderKey(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "id_good")
+	open := filepath.Join(dir, "id_open")
+	for path, mode := range map[string]os.FileMode{good: 0o600, open: 0o644} {
+		if err := os.WriteFile(path, []byte("key"), mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, mode); err != nil { // umask-proof
+			t.Fatal(err)
+		}
+	}
+
+	v := defaultsViper()
+	if got := keys(Check(v)); strings.Join(got, ",") != "backups.key" {
+		t.Fatalf("defaults: findings %v, want only the placeholder backups.key", got)
+	}
+
+	v.Set("backups.key", "s3cret")
+	v.Set("backups.borg.ssh.enabled", true)
+	v.Set("backups.borg.ssh.keyfile", good)
+	if errs := Check(v); len(errs) != 0 {
+		t.Fatalf("owner-only keyfile: %v", errs)
+	}
+	v.Set("backups.borg.ssh.keyfile", open)
+	if errs := Check(v); len(errs) != 1 || !strings.Contains(errs[0].Error(), "0644") {
+		t.Fatalf("group-readable keyfile: %v", errs)
+	}
+	v.Set("backups.borg.ssh.keyfile", filepath.Join(dir, "missing"))
+	if got := keys(Check(v)); strings.Join(got, ",") != "backups.borg.ssh.keyfile" {
+		t.Fatalf("missing keyfile: %v", got)
+	}
+
+	v.Set("backups.borg.ssh.enabled", false)
+	v.Set("backups.borg.nfs", true)
+	v.Set("backups.borg.nfs_ssh.keyfile", dir) // a directory
+	if got := keys(Check(v)); strings.Join(got, ",") != "backups.borg.nfs_ssh.keyfile" {
+		t.Fatalf("nfs keyfile is a directory: %v", got)
+	}
+
+	v.Set("backups.borg.nfs", false)
+	v.Set("backups.export.destination", "sftp")
+	v.Set("backups.export.sftp.keyfile", open)
+	v.Set("backups.export.sftp.known_hosts", good)
+	if got := keys(Check(v)); strings.Join(got, ",") != "backups.export.sftp.keyfile" {
+		t.Fatalf("group-readable sftp export keyfile: %v", got)
+	}
+	v.Set("backups.export.sftp.keyfile", good)
+	for _, knownHosts := range []string{"", filepath.Join(dir, "missing")} {
+		v.Set("backups.export.sftp.known_hosts", knownHosts)
+		if got := keys(Check(v)); strings.Join(got, ",") != "backups.export.sftp.known_hosts" {
+			t.Fatalf("sftp known_hosts %q: %v", knownHosts, got)
+		}
+	}
+}
@@ -3,17 +3,18 @@ package containermgr
 import (
 	"bytes"
 	"context"
+	"cs-agent/config"
 	"errors"
 	"github.com/docker/docker/api/types/container"
 	"io"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
-	"github.com/spf13/viper"
 )
 
 type Container struct {
@@ -90,9 +91,24 @@ func FindAllByService(cli *client.Client, serviceID string, allowOff bool) (cont
 	return containers, nil
 }
 
+// Ping checks the Docker daemon answers on its socket and returns the API
+// version it negotiated (for the agent's readiness check).
+func Ping(ctx context.Context) (apiVersion string, err error) {
+	cli, err := client.NewClientWithOpts(client.WithVersion(config.GetString("docker.version")))
+	if err != nil {
+		return "", err
+	}
+	defer cli.Close()
+	p, err := cli.Ping(ctx)
+	if err != nil {
+		return "", err
+	}
+	return p.APIVersion, nil
+}
+
 // Helper to exec inside a container when you don't specifically know the container ID
 func ServiceExec(serviceID string, jobCommands []string) (int, string, error) {
-	cli, err := client.NewClientWithOpts(client.WithVersion(viper.GetString("docker.version")))
+	cli, err := client.NewClientWithOpts(client.WithVersion(config.GetString("docker.version")))
 	if err != nil {
 		return 1, "", err
 	}
@@ -109,7 +125,7 @@ func (c *Container) Stop() bool {
 	if c == nil {
 		return true
 	}
-	cli, err := client.NewClientWithOpts(client.WithVersion(viper.GetString("docker.version")))
+	cli, err := client.NewClientWithOpts(client.WithVersion(config.GetString("docker.version")))
 	if err != nil {
 		containerLogger().Warn("Failed to connect to docker daemon", "error", err.Error(), "function", "ContainerStop")
 		return false
@@ -145,7 +161,7 @@ func (c *Container) Start() bool {
 	if c == nil {
 		return true
 	}
-	cli, err := client.NewClientWithOpts(client.WithVersion(viper.GetString("docker.version")))
+	cli, err := client.NewClientWithOpts(client.WithVersion(config.GetString("docker.version")))
 	if err != nil {
 		containerLogger().Warn("Failed to connect to docker daemon", "error", err.Error(), "function", "ContainerStart")
 		return false
@@ -177,10 +193,35 @@ func (c *Container) Start() bool {
 }
 
 func (c *Container) Exec(jobCommands []string) (exitCode int, response string, err error) {
-	ctx := context.Background()
-	cli, err := client.NewClientWithOpts(client.WithVersion(viper.GetString("docker.version")))
+	return c.ExecContext(context.Background(), jobCommands)
+}
+
+// interruptGrace is how long ExecContext waits after SIGTERM for an interrupted
+// exec to exit on its own before escalating to SIGKILL. borg needs the SIGTERM
+// path to release its repository lock; the grace bounds a borg that ignores it.
+const interruptGrace = 30 * time.Second
+
+// ExecContext is Exec bound to ctx: if ctx is cancelled while the command runs,
+// the exec's process group is sent SIGTERM (SIGKILL after interruptGrace) and
+// ExecContext returns ctx.Err() along with whatever output was captured so far.
+// The command runs under a TTY, so its process is a session leader and the group
+// covers the `sh -c` wrapper and its children (e.g. borg).
+func (c *Container) ExecContext(ctx context.Context, jobCommands []string) (exitCode int, response string, err error) {
+	buf := new(bytes.Buffer)
+	exitCode, err = c.ExecContextTo(ctx, jobCommands, buf)
+	return exitCode, buf.String(), err
+}
+
+// ExecContextTo is ExecContext writing the (TTY-merged) output to w as it
+// arrives instead of returning it at the end, so a caller can act on a long
+// command's progress lines while it runs.
+func (c *Container) ExecContextTo(ctx context.Context, jobCommands []string, w io.Writer) (exitCode int, err error) {
+	if err := ctx.Err(); err != nil {
+		return 1, err
+	}
+	cli, err := client.NewClientWithOpts(client.WithVersion(config.GetString("docker.version")))
 	if err != nil {
-		return 1, "", err
+		return 1, err
 	}
 	isReady := false
 
@@ -202,7 +243,7 @@ func (c *Container) Exec(jobCommands []string) (exitCode int, response string, e
 	}
 
 	if !isReady {
-		return 1, "", errors.New("container never came online")
+		return 1, errors.New("container never came online")
 	}
 
 	execConfig := container.ExecOptions{
@@ -216,7 +257,7 @@ func (c *Container) Exec(jobCommands []string) (exitCode int, response string, e
 	execResponse, err := cli.ContainerExecCreate(ctx, c.ID, execConfig)
 
 	if err != nil {
-		return 1, "", err
+		return 1, err
 	}
 
 	execStartCheck := container.ExecStartOptions{
@@ -225,21 +266,36 @@ func (c *Container) Exec(jobCommands []string) (exitCode int, response string, e
 
 	resp, err := cli.ContainerExecAttach(ctx, execResponse.ID, execStartCheck)
 	if err != nil {
-		return 1, "", err
+		return 1, err
 	}
 	defer resp.Close()
 
-	buf := new(bytes.Buffer)
-	_, _ = buf.ReadFrom(resp.Reader)
-	s := buf.String()
+	// On cancel, signal the exec rather than just dropping the connection: a TTY
+	// exec keeps running after its attach is closed, which would leave borg
+	// holding the repository lock inside the backup container.
+	watchdogDone := make(chan struct{})
+	defer close(watchdogDone)
+	go func() {
+		select {
+		case <-ctx.Done():
+			interruptExec(cli, execResponse.ID, watchdogDone)
+			resp.Close()
+		case <-watchdogDone:
+		}
+	}()
+
+	_, _ = io.Copy(w, resp.Reader)
+	if ctx.Err() != nil {
+		return 1, ctx.Err()
+	}
 
 	respStatus, err := cli.ContainerExecInspect(ctx, execResponse.ID)
 
 	if err != nil {
-		return 1, s, err
+		return 1, err
 	}
 
-	return respStatus.ExitCode, s, nil
+	return respStatus.ExitCode, nil
 }
 
 // ExecStream runs cmd in the container and streams stdout to w. Unlike Exec, it
@@ -252,7 +308,7 @@ func (c *Container) Exec(jobCommands []string) (exitCode int, response string, e
 // finished); a still-running exec is treated as a failure so the caller never
 // mistakes a truncated stream for success.
 func (c *Container) ExecStream(ctx context.Context, cmd []string, stdout io.Writer) (exitCode int, stderr string, err error) {
-	cli, err := client.NewClientWithOpts(client.WithVersion(viper.GetString("docker.version")))
+	cli, err := client.NewClientWithOpts(client.WithVersion(config.GetString("docker.version")))
 	if err != nil {
 		return 1, "", err
 	}
@@ -328,3 +384,39 @@ func (c *Container) ExecStream(ctx context.Context, cmd []string, stdout io.Writ
 	}
 	return respStatus.ExitCode, stderrBuf.String(), nil
 }
+
+// interruptExec sends SIGTERM to an exec's process group, then SIGKILL if it is
+// still running after interruptGrace (unless done closes first because the stream
+// drained). The exec's Pid is a host PID, so the agent signals it directly — no
+// dependency on tools inside the image.
+func interruptExec(cli *client.Client, execID string, done <-chan struct{}) {
+	pid, running := execPid(cli, execID)
+	if !running {
+		return
+	}
+	containerLogger().Info("Interrupting exec", "exec", execID, "pid", pid)
+	if err := syscall.Kill(-pid, syscall.SIGTERM); err != nil {
+		containerLogger().Warn("Failed to signal exec", "exec", execID, "pid", pid, "error", err.Error())
+		return
+	}
+	select {
+	case <-done:
+		return
+	case <-time.After(interruptGrace):
+	}
+	if pid, running := execPid(cli, execID); running {
+		containerLogger().Warn("Exec ignored SIGTERM; killing", "exec", execID, "pid", pid)
+		_ = syscall.Kill(-pid, syscall.SIGKILL)
+	}
+}
+
+// execPid returns an exec's host PID and whether it is still running.
+func execPid(cli *client.Client, execID string) (int, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	info, err := cli.ContainerExecInspect(ctx, execID)
+	if err != nil || !info.Running || info.Pid <= 0 {
+		return 0, false
+	}
+	return info.Pid, true
+}
@@ -0,0 +1,134 @@
+// Package exportdest is where a backup export goes and how it is handed back:
+// an S3 bucket (a presigned GET URL), an SFTP server (an sftp:// path) or a
+// local/NFS directory (a one-time download token the agent serves). The
+// backend is chosen by backups.export.destination; credentials are node-local
+// config, never from a task.
+package exportdest
+
+import (
+	"context"
+	"cs-agent/config"
+	"cs-agent/s3upload"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Destination kinds (backups.export.destination).
+const (
+	KindS3    = "s3"
+	KindSFTP  = "sftp"
+	KindLocal = "local"
+)
+
+// Destination is an export backend. s3upload.Uploader is the S3 one.
+type Destination interface {
+	// Upload streams r to key and returns the bytes written. A failed upload
+	// leaves nothing retrievable at key.
+	Upload(ctx context.Context, key string, r io.Reader) (int64, error)
+	// ObjectKey is key's full location at the destination: what an export
+	// records and DeleteObject takes.
+	ObjectKey(key string) string
+	// Reference returns the retrievable reference to an uploaded key and when it
+	// stops working. ttl is clamped to the backend's max; <=0 is its default.
+	Reference(ctx context.Context, key string, ttl time.Duration) (string, time.Time, error)
+	// DeleteObject removes the upload at objectKey (an ObjectKey). Deleting an
+	// absent one is not an error.
+	DeleteObject(ctx context.Context, objectKey string) error
+}
+
+// PartialSweeper is a Destination whose uploads go through a temporary file
+// that an agent crash mid-upload leaves behind (S3 has the bucket's
+// abort-incomplete-multipart lifecycle rule instead).
+type PartialSweeper interface {
+	// SweepPartial removes the temporary upload files last modified before
+	// before and returns how many it removed.
+	SweepPartial(ctx context.Context, before time.Time) (int, error)
+}
+
+var (
+	_ Destination    = (*s3upload.Uploader)(nil)
+	_ Destination    = (*SFTP)(nil)
+	_ Destination    = (*Local)(nil)
+	_ PartialSweeper = (*SFTP)(nil)
+	_ PartialSweeper = (*Local)(nil)
+)
+
+// Kind is the configured destination kind; unset is KindS3.
+func Kind() string {
+	if kind := config.GetString("backups.export.destination"); kind != "" {
+		return kind
+	}
+	return KindS3
+}
+
+// DeletesOnExpiry reports whether the export_cleanup job deletes an export of
+// the given kind once its reference lapses. S3 leaves that to the bucket's
+// lifecycle rule unless backups.export.delete_expired_objects says otherwise;
+// nothing else cleans up an SFTP server or a local directory, so those always
+// delete.
+func DeletesOnExpiry(kind string) bool {
+	return kind != KindS3 || config.GetBool("backups.export.delete_expired_objects")
+}
+
+// FromViper builds the configured destination, or says why export is not
+// available on this node.
+func FromViper() (Destination, error) {
+	return ForKind(Kind())
+}
+
+// ForKind builds the destination of the given kind from its config section,
+// whichever kind is configured now: the export cleanup deletes an export from
+// the kind it was made to after an operator switches destination.
+func ForKind(kind string) (Destination, error) {
+	switch kind {
+	case KindS3:
+		cfg := s3upload.ConfigFromViper()
+		if !cfg.Enabled() {
+			return nil, errors.New("backup export is not configured (no S3 bucket)")
+		}
+		if err := cfg.Validate(0); err != nil {
+			return nil, err
+		}
+		u, err := s3upload.New(cfg)
+		if err != nil {
+			return nil, errors.New("s3 init: " + err.Error())
+		}
+		return u, nil
+	case KindSFTP:
+		cfg := SFTPConfigFromViper()
+		if err := cfg.Validate(); err != nil {
+			return nil, err
+		}
+		return NewSFTP(cfg), nil
+	case KindLocal:
+		cfg := LocalConfigFromViper()
+		if err := cfg.Validate(); err != nil {
+			return nil, err
+		}
+		return NewLocal(cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown backups.export.destination %q (want s3, sftp or local)", kind)
+	}
+}
+
+// clampTTL bounds a requested reference lifetime: <=0 yields def; anything over
+// max (when max > 0) is capped to max.
+func clampTTL(req, def, max time.Duration) time.Duration {
+	if req <= 0 {
+		return def
+	}
+	if max > 0 && req > max {
+		return max
+	}
+	return req
+}
+
+// validateTTL checks a backend's default TTL is within its max.
+func validateTTL(prefix string, def, max time.Duration) error {
+	if max > 0 && def > max {
+		return fmt.Errorf("%s.default_ttl_sec (%s) must be <= max_ttl_sec (%s)", prefix, def, max)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package exportdest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestFromViper(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	if _, err := FromViper(); err == nil || !strings.Contains(err.Error(), "no S3 bucket") {
+		t.Fatalf("unset destination without a bucket: %v", err)
+	}
+	viper.Set("backups.export.destination", "ftp")
+	if _, err := FromViper(); err == nil {
+		t.Fatal("unknown destination accepted")
+	}
+	viper.Set("backups.export.destination", KindSFTP)
+	if _, err := FromViper(); err == nil {
+		t.Fatal("sftp without a host accepted")
+	}
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(knownHosts, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("backups.export.sftp.host", "backup.example.com")
+	viper.Set("backups.export.sftp.user", "exports")
+	viper.Set("backups.export.sftp.keyfile", "/etc/cs-agent/sftp_key")
+	viper.Set("backups.export.sftp.dir", "/srv/exports")
+	for _, bad := range []string{"", filepath.Join(t.TempDir(), "missing")} {
+		viper.Set("backups.export.sftp.known_hosts", bad)
+		if _, err := FromViper(); err == nil || !strings.Contains(err.Error(), "known_hosts") {
+			t.Fatalf("sftp with known_hosts %q: %v", bad, err)
+		}
+	}
+	viper.Set("backups.export.sftp.known_hosts", knownHosts)
+	if _, err := FromViper(); err != nil {
+		t.Fatalf("sftp: %v", err)
+	}
+	viper.Set("backups.export.destination", KindLocal)
+	viper.Set("backups.export.local.dir", t.TempDir())
+	viper.Set("backups.export.local.base_url", "https://node1.example.com:8500")
+	if d, err := FromViper(); err != nil {
+		t.Fatalf("local: %v", err)
+	} else if _, ok := d.(*Local); !ok {
+		t.Fatalf("local destination is a %T", d)
+	}
+}
+
+func TestSFTPReference(t *testing.T) {
+	d := NewSFTP(SFTPConfig{Host: "backup.example.com", User: "exports", Dir: "/srv/exports", DefaultTTL: time.Hour})
+	ref, expiry, err := d.Reference(context.Background(), "t1/tok/vol-auto.tar.gz", 0)
+	if err != nil || ref != "sftp://exports@backup.example.com/srv/exports/t1/tok/vol-auto.tar.gz" {
+		t.Fatalf("Reference = %q, %v", ref, err)
+	}
+	if until := time.Until(expiry); until <= 59*time.Minute || until > time.Hour {
+		t.Fatalf("expiry in %s, want the 1h default", until)
+	}
+	d = NewSFTP(SFTPConfig{Host: "backup.example.com", Port: 2222, User: "exports", Dir: "/srv/exports"})
+	if ref, _, _ := d.Reference(context.Background(), "k", 0); ref != "sftp://exports@backup.example.com:2222/srv/exports/k" {
+		t.Fatalf("Reference with a port = %q", ref)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	d := NewLocal(LocalConfig{Dir: dir, BaseURL: "https://node1.example.com:8500/", DefaultTTL: time.Hour})
+
+	n, err := d.Upload(ctx, "t1/tok/vol-auto.tar.gz", strings.NewReader("tar bytes"))
+	if err != nil || n != 9 {
+		t.Fatalf("Upload = %d, %v", n, err)
+	}
+	objectKey := d.ObjectKey("t1/tok/vol-auto.tar.gz")
+	if b, _ := os.ReadFile(objectKey); string(b) != "tar bytes" {
+		t.Fatalf("uploaded file holds %q", b)
+	}
+
+	ref, _, err := d.Reference(ctx, "t1/tok/vol-auto.tar.gz", 0)
+	token, ok := strings.CutPrefix(ref, "https://node1.example.com:8500/v1/exports/")
+	if err != nil || !ok || len(token) != 64 {
+		t.Fatalf("Reference = %q, %v", ref, err)
+	}
+	// Opening leaves the token alone; only a spent claim uses it up.
+	for range 2 {
+		f, err := d.Open(token)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		b, _ := io.ReadAll(f)
+		f.Close()
+		if string(b) != "tar bytes" {
+			t.Fatalf("opened file holds %q", b)
+		}
+	}
+	// A claimed token is held by its download: neither a second claim nor an
+	// Open gets the file until the claim is settled.
+	f, release, err := d.Claim(token)
+	if err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+	f.Close()
+	if _, _, err := d.Claim(token); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("second Claim = %v, want ErrNotExist", err)
+	}
+	if _, err := d.Open(token); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Open of a claimed token = %v, want ErrNotExist", err)
+	}
+	release(false) // broke off: the token works again
+	f, release, err = d.Claim(token)
+	if err != nil {
+		t.Fatalf("Claim after a broken-off download: %v", err)
+	}
+	f.Close()
+	release(true)
+	if _, err := d.Open(token); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Open of a spent token = %v, want ErrNotExist", err)
+	}
+	if _, _, err := d.Claim(token); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Claim of a spent token = %v, want ErrNotExist", err)
+	}
+	for _, bad := range []string{"", "../../etc/passwd", strings.Repeat("z", 64)} {
+		if _, err := d.Open(bad); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Open(%q) = %v, want ErrNotExist", bad, err)
+		}
+		if _, _, err := d.Claim(bad); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Claim(%q) = %v, want ErrNotExist", bad, err)
+		}
+	}
+
+	// A lapsed token no longer opens, and the next Reference sweeps it.
+	ref, _, _ = d.Reference(ctx, "t1/tok/vol-auto.tar.gz", time.Second)
+	token = ref[strings.LastIndex(ref, "/")+1:]
+	if err := os.WriteFile(filepath.Join(dir, tokenDir, token), []byte(`{"path":"`+objectKey+`","expiry":1}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := d.Reference(ctx, "t1/tok/vol-auto.tar.gz", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, tokenDir, token)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("lapsed token not swept: %v", err)
+	}
+
+	if err := d.DeleteObject(ctx, objectKey); err != nil {
+		t.Fatalf("DeleteObject: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "t1")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("empty export directories left behind: %v", err)
+	}
+	if err := d.DeleteObject(ctx, objectKey); err != nil {
+		t.Fatalf("DeleteObject of a deleted export: %v", err)
+	}
+	if err := d.DeleteObject(ctx, "/etc/passwd"); err == nil {
+		t.Fatal("DeleteObject outside the export directory accepted")
+	}
+}
@@ -0,0 +1,259 @@
+package exportdest
+
+import (
+	"context"
+	"crypto/rand"
+	"cs-agent/config"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// tokenDir is where Local keeps its download tokens, under the export
+// directory (export keys start with a task id, so never collide with it).
+const tokenDir = ".tokens"
+
+// claimedSuffix marks a token a download has claimed (see Local.Claim).
+const claimedSuffix = ".claimed"
+
+// LocalConfig is the resolved backups.export.local configuration.
+type LocalConfig struct {
+	Dir        string // absolute directory (local disk or an NFS mount) exports are written under
+	BaseURL    string // how customers reach this agent's HTTP server, e.g. "https://node1.example.com:8500"
+	DefaultTTL time.Duration
+	MaxTTL     time.Duration
+}
+
+// LocalConfigFromViper reads the backups.export.local.* keys.
+func LocalConfigFromViper() LocalConfig {
+	return LocalConfig{
+		Dir:        config.GetString("backups.export.local.dir"),
+		BaseURL:    config.GetString("backups.export.local.base_url"),
+		DefaultTTL: time.Duration(config.GetInt("backups.export.local.default_ttl_sec")) * time.Second,
+		MaxTTL:     time.Duration(config.GetInt("backups.export.local.max_ttl_sec")) * time.Second,
+	}
+}
+
+// Validate checks the config names an absolute directory and an http(s) base URL.
+func (c LocalConfig) Validate() error {
+	if !filepath.IsAbs(c.Dir) {
+		return errors.New("backups.export.local.dir must be an absolute path")
+	}
+	if !strings.HasPrefix(c.BaseURL, "https://") && !strings.HasPrefix(c.BaseURL, "http://") {
+		return errors.New("backups.export.local.base_url must be an http(s) URL")
+	}
+	return validateTTL("backups.export.local", c.DefaultTTL, c.MaxTTL)
+}
+
+// Local writes exports to a directory on this node. The reference is a
+// one-time download URL: a random token this agent's HTTP server serves the
+// file for (Open) until one complete download spends it (Spend) or it lapses.
+type Local struct {
+	cfg LocalConfig
+}
+
+// NewLocal builds the local-directory destination.
+func NewLocal(cfg LocalConfig) *Local {
+	return &Local{cfg: cfg}
+}
+
+// localToken is a download token's file: the export it is for and until when.
+type localToken struct {
+	Path   string `json:"path"`
+	Expiry int64  `json:"expiry"`
+}
+
+// ObjectKey is key's path under the export directory.
+func (d *Local) ObjectKey(key string) string {
+	return filepath.Join(d.cfg.Dir, filepath.FromSlash(key))
+}
+
+// Upload writes r to a temporary file in key's directory and renames it into
+// place once it is complete and synced.
+func (d *Local) Upload(ctx context.Context, key string, r io.Reader) (int64, error) {
+	dst := d.ObjectKey(key)
+	if err := os.MkdirAll(filepath.Dir(dst), 0o700); err != nil {
+		return 0, err
+	}
+	f, err := os.CreateTemp(filepath.Dir(dst), ".part-*")
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), dst)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		if ctx.Err() != nil {
+			return n, ctx.Err()
+		}
+		return n, err
+	}
+	return n, nil
+}
+
+// Reference issues a one-time download token for key's file and returns its
+// URL under BaseURL. Lapsed tokens nobody spent are swept first.
+func (d *Local) Reference(_ context.Context, key string, ttl time.Duration) (string, time.Time, error) {
+	ttl = clampTTL(ttl, d.cfg.DefaultTTL, d.cfg.MaxTTL)
+	expiry := time.Now().Add(ttl)
+	dir := filepath.Join(d.cfg.Dir, tokenDir)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", time.Time{}, err
+	}
+	d.sweepTokens(time.Now())
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	token := hex.EncodeToString(b)
+	tok, _ := json.Marshal(localToken{Path: d.ObjectKey(key), Expiry: expiry.Unix()})
+	if err := os.WriteFile(filepath.Join(dir, token), tok, 0o600); err != nil {
+		return "", time.Time{}, err
+	}
+	return strings.TrimRight(d.cfg.BaseURL, "/") + "/v1/exports/" + token, expiry, nil
+}
+
+// Open returns the export file a download token was issued for, leaving the
+// token as it is: a HEAD probe must not use it up. An unknown, claimed, spent or
+// lapsed token, or one whose export has been deleted, is os.ErrNotExist.
+func (d *Local) Open(token string) (*os.File, error) {
+	name, ok := d.tokenPath(token)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return d.openToken(name)
+}
+
+// Claim takes a download token for one download and returns its export file
+// with the func that settles the claim: spent uses the token up, otherwise (a
+// ranged request, a download that broke off) it is put back to work again.
+// The claim is a rename, so of concurrent downloads only one gets the file;
+// the rest see os.ErrNotExist, as Open does for a spent token.
+func (d *Local) Claim(token string) (*os.File, func(spent bool), error) {
+	name, ok := d.tokenPath(token)
+	if !ok {
+		return nil, nil, os.ErrNotExist
+	}
+	claimed := name + claimedSuffix
+	if os.Rename(name, claimed) != nil {
+		return nil, nil, os.ErrNotExist
+	}
+	f, err := d.openToken(claimed)
+	if err != nil {
+		_ = os.Rename(claimed, name) // as it was; a lapsed one is swept later
+		return nil, nil, err
+	}
+	return f, func(spent bool) {
+		if spent {
+			_ = os.Remove(claimed)
+		} else {
+			_ = os.Rename(claimed, name)
+		}
+	}, nil
+}
+
+// openToken opens the export file of the token file at name, if it is live.
+func (d *Local) openToken(name string) (*os.File, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	var tok localToken
+	if json.Unmarshal(b, &tok) != nil || time.Now().Unix() >= tok.Expiry || !d.inDir(tok.Path) {
+		return nil, os.ErrNotExist
+	}
+	return os.Open(tok.Path)
+}
+
+// tokenPath is the file a well-formed token lives in.
+func (d *Local) tokenPath(token string) (string, bool) {
+	if !filepath.IsAbs(d.cfg.Dir) || len(token) != 64 || strings.Trim(token, "0123456789abcdef") != "" {
+		return "", false
+	}
+	return filepath.Join(d.cfg.Dir, tokenDir, token), true
+}
+
+// DeleteObject removes the export file at objectKey, then the per-export
+// directories above it once they are empty.
+func (d *Local) DeleteObject(_ context.Context, objectKey string) error {
+	if !d.inDir(objectKey) {
+		return errors.New("export path is outside " + d.cfg.Dir)
+	}
+	if err := os.Remove(objectKey); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for dir := filepath.Dir(objectKey); d.inDir(dir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// SweepPartial removes the ".part-*" files of uploads that died before their
+// rename (Upload removes its own on an error, but not across a crash), then
+// the per-export directories they leave empty.
+func (d *Local) SweepPartial(_ context.Context, before time.Time) (int, error) {
+	var stale []string
+	err := filepath.WalkDir(d.cfg.Dir, func(p string, e fs.DirEntry, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case e.IsDir() && e.Name() == tokenDir:
+			return filepath.SkipDir
+		case e.IsDir() || !strings.HasPrefix(e.Name(), ".part-"):
+			return nil
+		}
+		if info, err := e.Info(); err == nil && info.ModTime().Before(before) {
+			stale = append(stale, p)
+		}
+		return nil
+	})
+	n := 0
+	for _, p := range stale {
+		if d.DeleteObject(context.Background(), p) == nil {
+			n++
+		}
+	}
+	return n, err
+}
+
+// sweepTokens removes the tokens that lapsed before now, claimed ones a crash
+// left included.
+func (d *Local) sweepTokens(now time.Time) {
+	dir := filepath.Join(d.cfg.Dir, tokenDir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		var tok localToken
+		if json.Unmarshal(b, &tok) != nil || now.Unix() >= tok.Expiry {
+			_ = os.Remove(filepath.Join(dir, e.Name()))
+		}
+	}
+}
+
+// inDir reports whether p is strictly below the export directory.
+func (d *Local) inDir(p string) bool {
+	rel, err := filepath.Rel(d.cfg.Dir, p)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
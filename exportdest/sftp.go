@@ -0,0 +1,234 @@
+package exportdest
+
+import (
+	"context"
+	"cs-agent/config"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTPConfig is the resolved backups.export.sftp configuration.
+type SFTPConfig struct {
+	Host       string
+	Port       int
+	User       string
+	KeyFile    string // private key the agent authenticates with
+	KnownHosts string // known_hosts file the server's host key must be in
+	Dir        string // absolute directory exports are written under
+	DefaultTTL time.Duration
+	MaxTTL     time.Duration
+}
+
+// SFTPConfigFromViper reads the backups.export.sftp.* keys.
+func SFTPConfigFromViper() SFTPConfig {
+	return SFTPConfig{
+		Host:       config.GetString("backups.export.sftp.host"),
+		Port:       config.GetInt("backups.export.sftp.port"),
+		User:       config.GetString("backups.export.sftp.user"),
+		KeyFile:    config.GetString("backups.export.sftp.keyfile"),
+		KnownHosts: config.GetString("backups.export.sftp.known_hosts"),
+		Dir:        config.GetString("backups.export.sftp.dir"),
+		DefaultTTL: time.Duration(config.GetInt("backups.export.sftp.default_ttl_sec")) * time.Second,
+		MaxTTL:     time.Duration(config.GetInt("backups.export.sftp.max_ttl_sec")) * time.Second,
+	}
+}
+
+// Validate checks the config names a server, a login, an absolute directory
+// and a readable known_hosts file.
+func (c SFTPConfig) Validate() error {
+	switch {
+	case c.Host == "":
+		return errors.New("backups.export.sftp.host is required for the sftp destination")
+	case c.User == "" || c.KeyFile == "":
+		return errors.New("backups.export.sftp.user and keyfile are required for the sftp destination")
+	case !path.IsAbs(c.Dir):
+		return errors.New("backups.export.sftp.dir must be an absolute path")
+	case c.KnownHosts == "":
+		return errors.New("backups.export.sftp.known_hosts is required for the sftp destination (the server's host key is always checked)")
+	}
+	if _, err := knownhosts.New(c.KnownHosts); err != nil {
+		return errors.New("backups.export.sftp.known_hosts: " + err.Error())
+	}
+	return validateTTL("backups.export.sftp", c.DefaultTTL, c.MaxTTL)
+}
+
+// SFTP writes exports to a directory on an SFTP server; the reference is the
+// sftp:// URL of the file, which the customer fetches with their own login.
+// There is no link to expire, so the export_cleanup job is what ends an
+// export's life there: it deletes the file once the reference lapses.
+type SFTP struct {
+	cfg SFTPConfig
+}
+
+// NewSFTP builds the SFTP destination. It does not touch the network; each
+// call opens its own connection.
+func NewSFTP(cfg SFTPConfig) *SFTP {
+	if cfg.Port == 0 {
+		cfg.Port = 22
+	}
+	return &SFTP{cfg: cfg}
+}
+
+// ObjectKey is key's remote path under the configured directory.
+func (d *SFTP) ObjectKey(key string) string {
+	return path.Join(d.cfg.Dir, key)
+}
+
+// Upload streams r to a temporary file next to key's path and renames it into
+// place once complete, so a failed upload never leaves a file at the path.
+func (d *SFTP) Upload(ctx context.Context, key string, r io.Reader) (int64, error) {
+	client, closeConn, err := d.dial(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer closeConn()
+	dst := d.ObjectKey(key)
+	if err := client.MkdirAll(path.Dir(dst)); err != nil {
+		return 0, err
+	}
+	tmp := dst + ".part"
+	f, err := client.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	n, err := f.ReadFrom(r)
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err == nil {
+		err = client.Rename(tmp, dst)
+	}
+	if err != nil {
+		_ = client.Remove(tmp)
+		if ctx.Err() != nil {
+			return n, ctx.Err()
+		}
+		return n, err
+	}
+	return n, nil
+}
+
+// Reference returns the sftp:// URL of key's file. The expiry is when the
+// export_cleanup job retires it.
+func (d *SFTP) Reference(_ context.Context, key string, ttl time.Duration) (string, time.Time, error) {
+	ttl = clampTTL(ttl, d.cfg.DefaultTTL, d.cfg.MaxTTL)
+	host := d.cfg.Host
+	if d.cfg.Port != 22 {
+		host = net.JoinHostPort(host, strconv.Itoa(d.cfg.Port))
+	}
+	u := url.URL{Scheme: "sftp", User: url.User(d.cfg.User), Host: host, Path: d.ObjectKey(key)}
+	return u.String(), time.Now().Add(ttl), nil
+}
+
+// DeleteObject removes the file at objectKey, then the per-export directories
+// above it once they are empty.
+func (d *SFTP) DeleteObject(ctx context.Context, objectKey string) error {
+	client, closeConn, err := d.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+	if err := client.Remove(objectKey); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	d.removeEmptyDirs(client, path.Dir(objectKey))
+	return nil
+}
+
+// SweepPartial removes the ".part" files of uploads that died before their
+// rename (Upload removes its own on an error, but not across an agent crash or
+// a dropped connection), then the per-export directories they leave empty.
+func (d *SFTP) SweepPartial(ctx context.Context, before time.Time) (int, error) {
+	client, closeConn, err := d.dial(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer closeConn()
+	var stale []string
+	walker := client.Walk(d.cfg.Dir)
+	for walker.Step() {
+		if walker.Err() != nil {
+			continue
+		}
+		info := walker.Stat()
+		if !info.IsDir() && strings.HasSuffix(walker.Path(), ".part") && info.ModTime().Before(before) {
+			stale = append(stale, walker.Path())
+		}
+	}
+	n := 0
+	for _, p := range stale {
+		if err := client.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		n++
+		d.removeEmptyDirs(client, path.Dir(p))
+	}
+	return n, ctx.Err()
+}
+
+// removeEmptyDirs removes dir and its parents up to (not including) the
+// export directory, stopping at the first that is not empty.
+func (d *SFTP) removeEmptyDirs(client *sftp.Client, dir string) {
+	for ; len(dir) > len(d.cfg.Dir); dir = path.Dir(dir) {
+		if client.RemoveDirectory(dir) != nil {
+			return
+		}
+	}
+}
+
+// dial opens an SFTP session and returns it with the func that closes it and
+// its connection. ctx ending closes the connection too.
+func (d *SFTP) dial(ctx context.Context) (*sftp.Client, func(), error) {
+	key, err := os.ReadFile(d.cfg.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	hostKey, err := knownhosts.New(d.cfg.KnownHosts)
+	if err != nil {
+		return nil, nil, err
+	}
+	addr := net.JoinHostPort(d.cfg.Host, strconv.Itoa(d.cfg.Port))
+	conn, err := (&net.Dialer{Timeout: 30 * time.Second}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+		User:            d.cfg.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKey,
+		Timeout:         30 * time.Second,
+	})
+	if err != nil {
+		stop()
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	sshClient := ssh.NewClient(sshConn, chans, reqs)
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		stop()
+		_ = sshClient.Close()
+		return nil, nil, err
+	}
+	return client, func() {
+		stop()
+		_ = client.Close()
+		_ = sshClient.Close()
+	}, nil
+}
@@ -20,7 +20,10 @@ import (
 // (rendered by the prior binary, persistent in the kernel) untouched so no
 // published port is closed in the gap. Once populated, an empty rule set renders
 // an empty table (fail-closed) as intended.
-func Reconcile(ctx context.Context, st *store.Store) {
+//
+// The returned error is what left the table un-rendered; skipping the render
+// behind the sentinel gate is not a failure.
+func Reconcile(ctx context.Context, st *store.Store) error {
 	defer sentry.Recover()
 
 	// Cross-project isolation stays iptables-based in DOCKER-USER and is
@@ -30,26 +33,27 @@ func Reconcile(ctx context.Context, st *store.Store) {
 	populated, err := st.IsPopulated(ctx, store.MetaFirewallPopulated)
 	if err != nil {
 		csFirewallLog().Warn("firewall: could not check populated sentinel", "error", err.Error())
-		return
+		return err
 	}
 	if !populated {
 		csFirewallLog().Info("firewall desired-state not yet populated; leaving live published-port table untouched")
-		return
+		return nil
 	}
 
 	expectedRules, err := loadExpectedRules(ctx, st)
 	if err != nil {
 		// Load/parse error: leave the kernel state untouched (do not render an
 		// empty table). The next reconcile re-renders from desired state.
-		return
+		return err
 	}
 	// A nil *NatRules here means "populated, but zero published ports" — a
 	// legitimate desired state, so render an empty cs_agent table (fail-closed).
 	if err := renderTable(expectedRules); err != nil {
 		sentry.CaptureException(err)
 		csFirewallLog().Error("Failed to render cs_agent nftables table", "error", err.Error())
-		return
+		return err
 	}
+	return nil
 }
 
 // Logger
@@ -1,7 +1,7 @@
 package firewall
 
 import (
-	"github.com/spf13/viper"
+	"cs-agent/config"
 )
 
 // iptablesCmd selects the iptables binary used by the cross-project isolation
@@ -11,7 +11,7 @@ import (
 // -- so the only remaining shell-out is isolation. The host.iptables-cmd toggle
 // stays for that path.
 func iptablesCmd() string {
-	if viper.GetString("host.iptables-cmd") == "iptables-legacy" {
+	if config.GetString("host.iptables-cmd") == "iptables-legacy" {
 		return "iptables-legacy"
 	}
 	return "iptables"
@@ -2,10 +2,22 @@ package firewall
 
 import (
 	"context"
+	"cs-agent/metrics"
 	"cs-agent/store"
+	"sync/atomic"
 	"time"
 )
 
+var (
+	reconcileTotal = metrics.NewCounterVec("cs_agent_firewall_reconcile_total",
+		"Firewall reconciles, by result (success or error).",
+		"result")
+	reconcileSeconds = metrics.NewHistogramVec("cs_agent_firewall_reconcile_duration_seconds",
+		"Firewall reconcile duration, by result.",
+		[]float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		"result")
+)
+
 // firewallBackstop re-runs the reconcile even without a signal, so a missed poke
 // (or drift) is corrected within a bounded window.
 const firewallBackstop = 60 * time.Second
@@ -14,8 +26,9 @@ const firewallBackstop = 60 * time.Second
 // firewall_rules PUT/DELETE), and on a periodic backstop — off the request
 // goroutine so the DOWN handler never blocks on an nftables render.
 type Reconciler struct {
-	st     *store.Store
-	signal chan struct{}
+	st       *store.Store
+	signal   chan struct{}
+	lastTick atomic.Int64 // unix nanos of the last reconcile's start
 }
 
 func NewReconciler(st *store.Store) *Reconciler {
@@ -33,7 +46,7 @@ func (r *Reconciler) Signal() {
 
 // Run reconciles until ctx is cancelled. Call in its own goroutine.
 func (r *Reconciler) Run(ctx context.Context) {
-	Reconcile(ctx, r.st) // boot
+	r.reconcile(ctx) // boot
 	t := time.NewTicker(firewallBackstop)
 	defer t.Stop()
 	for {
@@ -41,9 +54,32 @@ func (r *Reconciler) Run(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-r.signal:
-			Reconcile(ctx, r.st)
+			r.reconcile(ctx)
 		case <-t.C:
-			Reconcile(ctx, r.st)
+			r.reconcile(ctx)
 		}
 	}
 }
+
+// LastTick is when the last reconcile started (zero before the boot one).
+func (r *Reconciler) LastTick() time.Time {
+	if n := r.lastTick.Load(); n > 0 {
+		return time.Unix(0, n)
+	}
+	return time.Time{}
+}
+
+// TickInterval is the backstop period: it reconciles at least this often.
+func (r *Reconciler) TickInterval() time.Duration { return firewallBackstop }
+
+// reconcile runs one Reconcile and records its outcome and duration.
+func (r *Reconciler) reconcile(ctx context.Context) {
+	start := time.Now()
+	r.lastTick.Store(start.UnixNano())
+	result := "success"
+	if err := Reconcile(ctx, r.st); err != nil {
+		result = "error"
+	}
+	reconcileTotal.Inc(result)
+	reconcileSeconds.Observe(time.Since(start).Seconds(), result)
+}
@@ -129,6 +129,7 @@ func TestActions_RateLimited(t *testing.T) {
 	e.provisionTenant("proj-a", "tok-a", "active")
 	e.provisionTenant("proj-b", "tok-b", "active")
 
+	rejected := rateLimited.Value()
 	got429 := false
 	for i := 0; i < actionsBurst+5; i++ {
 		resp := e.do("POST", "/v1/actions", "tok-a", []byte(`{"action_type":"cdn_purge"}`))
@@ -140,12 +141,32 @@ func TestActions_RateLimited(t *testing.T) {
 	if !got429 {
 		t.Fatalf("expected a 429 after exhausting tenant a's burst of %d", actionsBurst)
 	}
+	if rateLimited.Value() == rejected {
+		t.Fatal("429s not counted in the rate-limited metric")
+	}
 
 	// A different tenant has its own bucket and is unaffected.
 	resp := e.do("POST", "/v1/actions", "tok-b", []byte(`{"action_type":"cdn_purge"}`))
 	mustStatus(t, resp, http.StatusAccepted)
 }
 
+// TestActions_RateLimitRetunedLive: a reload's SetActionsRateLimit applies to
+// existing buckets without a restart.
+func TestActions_RateLimitRetunedLive(t *testing.T) {
+	e := newTestEnv(t)
+	e.provisionTenant("proj-a", "tok-a", "active")
+
+	e.srv.SetActionsRateLimit(1, 0.001)
+	resp := e.do("POST", "/v1/actions", "tok-a", []byte(`{"action_type":"cdn_purge"}`))
+	mustStatus(t, resp, http.StatusAccepted)
+	resp = e.do("POST", "/v1/actions", "tok-a", []byte(`{"action_type":"cdn_purge"}`))
+	mustStatus(t, resp, http.StatusTooManyRequests)
+
+	e.srv.SetActionsRateLimit(actionsBurst, 1e6) // refills the bucket on the next request
+	resp = e.do("POST", "/v1/actions", "tok-a", []byte(`{"action_type":"cdn_purge"}`))
+	mustStatus(t, resp, http.StatusAccepted)
+}
+
 func TestActions_CustomerScopeRequired(t *testing.T) {
 	e := newTestEnv(t)
 	e.provisionTenant("proj-a", "tok-a", "active")
@@ -0,0 +1,147 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// The admin socket is a second listener for on-node tooling (the operator CLI,
+// local automation): a unix socket serving the /v1/admin API plus node-local
+// operator routes under /v1/local. It never leaves the host, and it is
+// authenticated by the connecting process's credentials (SO_PEERCRED, uid 0)
+// rather than a Bearer, so root on the node needs no token. The socket file is
+// also 0600 in a 0700 directory, so a non-root process cannot even connect.
+
+// peerUIDKey carries the peer uid read at accept into each request's context.
+type peerUIDKey struct{}
+
+// errNoPeerCred is peerUID's answer for a conn that is not a unix socket.
+var errNoPeerCred = errors.New("not a unix socket connection")
+
+// peerContext is the admin socket's ConnContext: it records the peer's uid, or
+// nothing when it cannot be read (every request on that conn is then refused).
+func (s *Server) peerContext(ctx context.Context, c net.Conn) context.Context {
+	uid, err := peerUID(c)
+	if err != nil {
+		s.log.Warn("admin socket: read peer credentials", "error", err.Error())
+		return ctx
+	}
+	return context.WithValue(ctx, peerUIDKey{}, uid)
+}
+
+// requirePeerRoot is the admin socket's requireAdmin: the request runs in admin
+// scope when the peer is root, and is refused 403 otherwise.
+func (s *Server) requirePeerRoot(h func(http.ResponseWriter, *http.Request, scope)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		uid, ok := r.Context().Value(peerUIDKey{}).(uint32)
+		if !ok || uid != s.sockUID {
+			writeError(w, http.StatusForbidden, "the admin socket is for root only")
+			return
+		}
+		h(w, r, scope{kind: scopeAdmin})
+	}
+}
+
+// sockRoutes wires the admin socket's mux.
+func (s *Server) sockRoutes() {
+	s.sockMux.HandleFunc("GET /healthz", s.handleHealthz)
+	s.sockMux.HandleFunc("GET /readyz", s.handleReadyz)
+	s.adminRoutes(s.sockMux, s.requirePeerRoot)
+
+	// --- Node-local operator routes (admin socket only) ---
+	s.sockMux.HandleFunc("POST /v1/local/maintenance/{job}", s.requirePeerRoot(s.handleLocalMaintenance))
+	s.sockMux.HandleFunc("POST /v1/local/firewall/reconcile", s.requirePeerRoot(s.handleLocalFirewallReconcile))
+	s.sockMux.HandleFunc("GET /v1/local/config", s.requirePeerRoot(s.handleLocalConfig))
+	s.sockMux.HandleFunc("GET /v1/local/pool", s.requirePeerRoot(s.handleLocalPool))
+}
+
+// ListenAdminSocket binds Config.AdminSocket; a no-op when it is empty. The
+// parent directory is created 0700 and the socket chmod'ed 0600. A socket file
+// left by a previous run is replaced; any other file at the path is an error.
+func (s *Server) ListenAdminSocket() error {
+	path := s.cfg.AdminSocket
+	if path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("admin socket: %w", err)
+	}
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode().Type() != os.ModeSocket {
+			return fmt.Errorf("admin socket: %s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("admin socket: remove stale %s: %w", path, err)
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("admin socket: %w", err)
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		_ = ln.Close()
+		return fmt.Errorf("admin socket: %w", err)
+	}
+	s.sockLn = ln
+	return nil
+}
+
+// ServeAdminSocket serves the listener ListenAdminSocket bound, in the calling
+// goroutine. It returns http.ErrServerClosed on a graceful Shutdown (which also
+// removes the socket file), and at once if the socket is disabled.
+func (s *Server) ServeAdminSocket() error {
+	if s.sockLn == nil {
+		return http.ErrServerClosed
+	}
+	s.log.Info("admin socket listening", "path", s.cfg.AdminSocket)
+	return s.sockHTTP.Serve(s.sockLn)
+}
+
+// handleLocalMaintenance starts a maintenance job now: 202 when started, 409
+// while a run is already in flight, 404 for an unknown job.
+func (s *Server) handleLocalMaintenance(w http.ResponseWriter, r *http.Request, _ scope) {
+	if s.cfg.RunMaintenance == nil {
+		writeError(w, http.StatusServiceUnavailable, "maintenance is not scheduled on this node (backups disabled)")
+		return
+	}
+	job := r.PathValue("job")
+	started, err := s.cfg.RunMaintenance(job)
+	switch {
+	case err != nil:
+		writeError(w, http.StatusNotFound, err.Error())
+	case !started:
+		writeError(w, http.StatusConflict, job+" is already running")
+	default:
+		writeJSON(w, http.StatusAccepted, map[string]any{"job": job, "started": true})
+	}
+}
+
+// handleLocalFirewallReconcile wakes the firewall reconciler for a full
+// reconcile now instead of at its next backstop tick.
+func (s *Server) handleLocalFirewallReconcile(w http.ResponseWriter, _ *http.Request, _ scope) {
+	if s.cfg.OnFirewallChanged == nil {
+		writeError(w, http.StatusServiceUnavailable, "firewall reconciler not wired")
+		return
+	}
+	s.cfg.OnFirewallChanged()
+	writeJSON(w, http.StatusAccepted, map[string]bool{"signalled": true})
+}
+
+// handleLocalConfig dumps the running config, secrets redacted.
+func (s *Server) handleLocalConfig(w http.ResponseWriter, _ *http.Request, _ scope) {
+	if s.cfg.ConfigDump == nil {
+		writeError(w, http.StatusServiceUnavailable, "config dump not wired")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.cfg.ConfigDump())
+}
+
+// handleLocalPool reports the per-project DB handle pool.
+func (s *Server) handleLocalPool(w http.ResponseWriter, _ *http.Request, _ scope) {
+	writeJSON(w, http.StatusOK, s.store.PoolStats())
+}
@@ -0,0 +1,170 @@
+//go:build linux
+
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"cs-agent/store"
+)
+
+// startSocket serves an admin socket that admits peer uid `uid` (the test runs
+// unprivileged, so "root" is whatever uid the test has) and returns a client
+// dialing it.
+func startSocket(t *testing.T, uid uint32, cfg Config) (*Server, *http.Client) {
+	t.Helper()
+	// unix socket paths are capped near 108 bytes; t.TempDir can exceed that.
+	dir, err := os.MkdirTemp("", "cs-sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	st, err := store.Open(filepath.Join(dir, "data"), store.Options{})
+	if err != nil {
+		t.Fatalf("store.Open: %v", err)
+	}
+	t.Cleanup(func() { _ = st.Close() })
+
+	cfg.ListenAddr = "127.0.0.1:0"
+	cfg.AdminSocket = filepath.Join(dir, "run", "admin.sock")
+	srv := New(cfg, st, nil)
+	srv.sockUID = uid
+	if err := srv.ListenAdminSocket(); err != nil {
+		t.Fatalf("ListenAdminSocket: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- srv.ServeAdminSocket() }()
+	t.Cleanup(func() {
+		_ = srv.Shutdown(context.Background())
+		if err := <-done; !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("ServeAdminSocket = %v", err)
+		}
+	})
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", cfg.AdminSocket)
+		},
+	}}
+	return srv, client
+}
+
+func sockDo(t *testing.T, c *http.Client, method, path string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(method, "http://cs-agent"+path, nil)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+func TestAdminSocket_Routes(t *testing.T) {
+	var reconciles atomic.Int32
+	srv, c := startSocket(t, uint32(os.Getuid()), Config{
+		OnFirewallChanged: func() { reconciles.Add(1) },
+		RunMaintenance: func(name string) (bool, error) {
+			switch name {
+			case "compact":
+				return true, nil
+			case "prune":
+				return false, nil // already running
+			}
+			return false, errors.New("unknown maintenance job")
+		},
+		ConfigDump: func() map[string]any { return map[string]any{"backups.key": "<redacted>"} },
+	})
+
+	fi, err := os.Stat(srv.cfg.AdminSocket)
+	if err != nil || fi.Mode().Perm() != 0o600 {
+		t.Fatalf("socket mode = %v, %v; want 0600", fi.Mode(), err)
+	}
+	if di, _ := os.Stat(filepath.Dir(srv.cfg.AdminSocket)); di.Mode().Perm() != 0o700 {
+		t.Fatalf("socket dir mode = %v; want 0700", di.Mode())
+	}
+
+	// The admin API needs no Bearer on the socket.
+	for _, tc := range []struct {
+		method, path string
+		want         int
+	}{
+		{"GET", "/v1/admin/tasks", http.StatusOK},
+		{"GET", "/healthz", http.StatusOK},
+		{"POST", "/v1/local/maintenance/compact", http.StatusAccepted},
+		{"POST", "/v1/local/maintenance/prune", http.StatusConflict},
+		{"POST", "/v1/local/maintenance/vacuum", http.StatusNotFound},
+		{"POST", "/v1/local/firewall/reconcile", http.StatusAccepted},
+		{"GET", "/v1/local/config", http.StatusOK},
+		{"GET", "/v1/local/pool", http.StatusOK},
+	} {
+		if resp := sockDo(t, c, tc.method, tc.path); resp.StatusCode != tc.want {
+			t.Errorf("%s %s = %d, want %d", tc.method, tc.path, resp.StatusCode, tc.want)
+		}
+	}
+	if n := reconciles.Load(); n != 1 {
+		t.Fatalf("firewall reconciles = %d, want 1", n)
+	}
+}
+
+func TestAdminSocket_NonRootPeerRefused(t *testing.T) {
+	_, c := startSocket(t, uint32(os.Getuid())+1, Config{})
+	for _, p := range []string{"/v1/admin/tasks", "/v1/local/pool"} {
+		if resp := sockDo(t, c, "GET", p); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("GET %s as non-root = %d, want 403", p, resp.StatusCode)
+		}
+	}
+	// Probes stay open, as on :8500.
+	if resp := sockDo(t, c, "GET", "/healthz"); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /healthz = %d", resp.StatusCode)
+	}
+}
+
+func TestAdminSocket_ListenPath(t *testing.T) {
+	dir, err := os.MkdirTemp("", "cs-sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	path := filepath.Join(dir, "admin.sock")
+
+	// A socket left behind by a previous run is replaced.
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = ln.Close()
+	srv := &Server{cfg: Config{AdminSocket: path}}
+	if err := srv.ListenAdminSocket(); err != nil {
+		t.Fatalf("stale socket: %v", err)
+	}
+	_ = srv.sockLn.Close()
+
+	// Any other file at the path is left alone.
+	if err := os.WriteFile(path, []byte("x"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	srv = &Server{cfg: Config{AdminSocket: path}}
+	if err := srv.ListenAdminSocket(); err == nil || !strings.Contains(err.Error(), "not a socket") {
+		t.Fatalf("regular file at path: err = %v", err)
+	}
+
+	// Disabled: nothing bound, Serve returns at once.
+	srv = &Server{}
+	if err := srv.ListenAdminSocket(); err != nil || srv.sockLn != nil {
+		t.Fatalf("disabled: %v, %v", srv.sockLn, err)
+	}
+	if err := srv.ServeAdminSocket(); !errors.Is(err, http.ErrServerClosed) {
+		t.Fatalf("ServeAdminSocket disabled = %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cs-agent/store"
+	"cs-agent/taskevent"
+)
+
+// eventsKeepalive is how often an idle task event stream gets a keepalive (so
+// proxies don't reap it) and re-checks the task row for a terminal status the
+// feed will never report (a task that finished before this agent started).
+const eventsKeepalive = 15 * time.Second
+
+// handleAdminTaskEvents streams a task's live feed (see taskevent):
+// Server-Sent Events by default, or newline-delimited JSON with
+// ?format=ndjson. The buffered history is sent first, then events as they
+// happen; the response ends after the "end" event. A reconnecting client
+// resumes with Last-Event-ID (SSE) or ?after=<seq>. A task that is not
+// running yet is waited on; one already finished gets its end event.
+func (s *Server) handleAdminTaskEvents(w http.ResponseWriter, r *http.Request, _ scope) {
+	hub := s.cfg.TaskEvents
+	if hub == nil {
+		writeError(w, http.StatusNotFound, "task events are not available")
+		return
+	}
+	id := r.PathValue("id")
+	task, found, err := s.store.GetTask(r.Context(), id)
+	if err != nil {
+		s.storeError(w, err, "get task")
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "task not found")
+		return
+	}
+	q := r.URL.Query()
+	ndjson := q.Get("format") == "ndjson"
+	if f := q.Get("format"); f != "" && f != "sse" && !ndjson {
+		writeError(w, http.StatusBadRequest, "format must be sse or ndjson")
+		return
+	}
+	resume := q.Get("after")
+	if resume == "" {
+		resume = r.Header.Get("Last-Event-ID")
+	}
+	var after int64
+	if resume != "" {
+		if after, err = strconv.ParseInt(resume, 10, 64); err != nil || after < 0 {
+			writeError(w, http.StatusBadRequest, "after must be a non-negative event seq")
+			return
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+
+	history, events, live, cancel := hub.Subscribe(id, after)
+	defer cancel()
+
+	if ndjson {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		w.Header().Set("Content-Type", "text/event-stream")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	write := func(e taskevent.Event) {
+		b, _ := json.Marshal(e)
+		if ndjson {
+			_, _ = fmt.Fprintf(w, "%s\n", b)
+		} else {
+			_, _ = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Seq, e.Type, b)
+		}
+	}
+	for _, e := range history {
+		write(e)
+		if e.Type == taskevent.TypeEnd {
+			flusher.Flush()
+			return
+		}
+	}
+	// No worker has this task in this process: if it is already terminal the
+	// feed will never say so.
+	if !live && store.TaskTerminal(task.Status) {
+		write(taskevent.Event{Type: taskevent.TypeEnd, Status: task.Status})
+		flusher.Flush()
+		return
+	}
+	flusher.Flush()
+
+	tick := time.NewTicker(eventsKeepalive)
+	defer tick.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return // ended, or this client fell behind: it resumes from its last seq
+			}
+			write(e)
+			flusher.Flush()
+			if e.Type == taskevent.TypeEnd {
+				return
+			}
+		case <-tick.C:
+			t, found, err := s.store.GetTask(r.Context(), id)
+			if err == nil && !found {
+				return // reaped
+			}
+			if err == nil && store.TaskTerminal(t.Status) {
+				write(taskevent.Event{Type: taskevent.TypeEnd, Status: t.Status})
+				flusher.Flush()
+				return
+			}
+			if ndjson {
+				_, _ = fmt.Fprint(w, "\n")
+			} else {
+				_, _ = fmt.Fprint(w, ": keepalive\n\n")
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package httpapi
+
+import (
+	"io"
+	"net/http"
+	"path/filepath"
+)
+
+// handleExportDownload serves a local-destination export for its one-time
+// download token. There is no Bearer: the token, minted per export, is the
+// credential. A GET claims it for the length of the download, so concurrent
+// GETs can't each get the file, and it is spent only by one that got the whole
+// file (a 200 with every byte written): a HEAD probe, a Range request resuming
+// a dropped download or a download that breaks off leave it working until it
+// lapses. An unknown, claimed, spent or lapsed token is a plain 404, whichever
+// it was.
+func (s *Server) handleExportDownload(w http.ResponseWriter, r *http.Request) {
+	if s.cfg.ExportDownload == nil {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	f, release, err := s.cfg.ExportDownload(r.PathValue("token"), r.Method == http.MethodGet)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	defer f.Close()
+	spent := false
+	defer func() { release(spent) }()
+	fi, err := f.Stat()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "export unreadable")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filepath.Base(f.Name())+`"`)
+	cw := &countingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+	http.ServeContent(cw, r, "", fi.ModTime(), f)
+	spent = r.Method == http.MethodGet && cw.status == http.StatusOK && cw.n == fi.Size()
+}
+
+// countingResponseWriter records the status and body bytes of a response. It
+// keeps the ResponseWriter's ReadFrom, so a large export still goes out by
+// sendfile.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (c *countingResponseWriter) WriteHeader(status int) {
+	c.status = status
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *countingResponseWriter) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingResponseWriter) ReadFrom(r io.Reader) (int64, error) {
+	n, err := io.Copy(c.ResponseWriter, r)
+	c.n += n
+	return n, err
+}
+
+func (c *countingResponseWriter) Unwrap() http.ResponseWriter { return c.ResponseWriter }
@@ -0,0 +1,71 @@
+package httpapi
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportDownload(t *testing.T) {
+	e := newTestEnv(t)
+	hs := httptest.NewServer(New(Config{}, e.st, nil).Handler())
+	t.Cleanup(hs.Close)
+	resp, err := hs.Client().Get(hs.URL + "/v1/exports/abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustStatus(t, resp, http.StatusNotFound) // not wired
+
+	path := filepath.Join(t.TempDir(), "vol-auto.tar.gz")
+	if err := os.WriteFile(path, []byte("tar bytes"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	spent, claimed := false, false
+	srv := New(Config{ExportDownload: func(token string, claim bool) (*os.File, func(bool), error) {
+		if token != "good" || spent || claimed {
+			return nil, nil, os.ErrNotExist
+		}
+		claimed = claim
+		f, err := os.Open(path)
+		return f, func(s bool) { spent, claimed = s, false }, err
+	}}, e.st, nil)
+	hs = httptest.NewServer(srv.Handler())
+	t.Cleanup(hs.Close)
+	get := func(method string, header http.Header) (*http.Response, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, hs.URL+"/v1/exports/good", nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := hs.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp, string(body)
+	}
+
+	// A HEAD probe (a download manager, a link scanner) leaves the token working.
+	if resp, _ := get(http.MethodHead, nil); resp.StatusCode != http.StatusOK || resp.ContentLength != 9 || spent {
+		t.Fatalf("HEAD = %d, length %d, spent %v", resp.StatusCode, resp.ContentLength, spent)
+	}
+	// So does a Range request, e.g. resuming a dropped download.
+	if resp, body := get(http.MethodGet, http.Header{"Range": {"bytes=4-"}}); resp.StatusCode != http.StatusPartialContent || body != "bytes" || spent || claimed {
+		t.Fatalf("ranged GET = %d %q, spent %v, still claimed %v", resp.StatusCode, body, spent, claimed)
+	}
+	resp, body := get(http.MethodGet, nil)
+	if resp.StatusCode != http.StatusOK || body != "tar bytes" ||
+		resp.Header.Get("Content-Disposition") != `attachment; filename="vol-auto.tar.gz"` {
+		t.Fatalf("download = %d %q (%s)", resp.StatusCode, body, resp.Header.Get("Content-Disposition"))
+	}
+	// The complete download spent it: the same token no longer downloads.
+	if !spent || claimed {
+		t.Fatalf("complete download: spent %v, still claimed %v", spent, claimed)
+	}
+	resp, _ = get(http.MethodGet, nil)
+	mustStatus(t, resp, http.StatusNotFound)
+}
@@ -1,11 +1,14 @@
 package httpapi
 
 import (
+	"bufio"
 	"encoding/json"
 	"net/http"
+	"strings"
 	"testing"
 
 	"cs-agent/store"
+	"cs-agent/taskevent"
 )
 
 func TestAdminTaskCreate(t *testing.T) {
@@ -62,7 +65,9 @@ func TestAdminTaskCreate_Validation(t *testing.T) {
 		{"missing id", `{"name":"volume.backup","node":"n"}`, http.StatusBadRequest},
 		{"missing name", `{"id":"x","node":"n"}`, http.StatusBadRequest},
 		{"missing node", `{"id":"x","name":"volume.backup"}`, http.StatusBadRequest},
+		{"negative deadline", `{"id":"x","name":"volume.backup","node":"n","deadline":-1}`, http.StatusBadRequest},
 		{"ok", `{"id":"okid","name":"volume.backup","node":"n"}`, http.StatusAccepted},
+		{"ok with deadline", `{"id":"dlid","name":"volume.backup","node":"n","deadline":1700000000}`, http.StatusAccepted},
 	}
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
@@ -70,6 +75,46 @@ func TestAdminTaskCreate_Validation(t *testing.T) {
 			mustStatus(t, resp, c.want)
 		})
 	}
+	if tk, _, _ := e.st.GetTask(ctxBG, "dlid"); tk.Deadline != 1700000000 {
+		t.Fatalf("deadline = %d, want 1700000000", tk.Deadline)
+	}
+}
+
+// TestAdminTaskCreate_Chain proves a POST with then creates every step at once:
+// the head pending, the later steps waiting on the one before, inheriting the
+// head's node/project/volume.
+func TestAdminTaskCreate_Chain(t *testing.T) {
+	e := newTestEnv(t)
+	body := []byte(`{"id":"h1","project_id":"proj-a","name":"volume.backup","node":"n","volume":"vol-1",
+		"then":[{"id":"s1","name":"backup.export","params":{"download_ttl":3600}},{"id":"s2","name":"backup.delete"}]}`)
+	mustStatus(t, e.do("POST", "/v1/admin/tasks", e.adminTok, body), http.StatusAccepted)
+	for _, want := range []struct{ id, status, after string }{
+		{"h1", store.TaskPending, ""},
+		{"s1", store.TaskWaiting, "h1"},
+		{"s2", store.TaskWaiting, "s1"},
+	} {
+		tk, found, err := e.st.GetTask(ctxBG, want.id)
+		if err != nil || !found {
+			t.Fatalf("GetTask(%s): found %v, %v", want.id, found, err)
+		}
+		if tk.Status != want.status || tk.AfterID != want.after || tk.Node != "n" || tk.ProjectID != "proj-a" || tk.Volume != "vol-1" {
+			t.Fatalf("%s: %+v", want.id, tk)
+		}
+	}
+	if tk, _, _ := e.st.GetTask(ctxBG, "s1"); string(tk.Params) != `{"download_ttl":3600}` {
+		t.Fatalf("s1 params = %s", tk.Params)
+	}
+
+	for _, bad := range []string{
+		`{"id":"h2","name":"volume.backup","node":"n","then":[{"name":"backup.export"}]}`,
+		`{"id":"h3","name":"volume.backup","node":"n","then":[{"id":"s1","name":"backup.export"}]}`,
+		`{"id":"h4","name":"volume.backup","node":"n","then":[{"id":"h4","name":"backup.export"}]}`,
+	} {
+		mustStatus(t, e.do("POST", "/v1/admin/tasks", e.adminTok, []byte(bad)), http.StatusBadRequest)
+	}
+	if _, found, _ := e.st.GetTask(ctxBG, "h3"); found {
+		t.Fatal("head of a rejected chain was created")
+	}
 }
 
 func TestAdminTaskCancel(t *testing.T) {
@@ -77,6 +122,10 @@ func TestAdminTaskCancel(t *testing.T) {
 	if _, err := e.st.CreateTask(ctxBG, store.Task{ID: "c1", Name: "volume.backup", Node: "n"}); err != nil {
 		t.Fatalf("seed task: %v", err)
 	}
+	hub := taskevent.NewHub()
+	e.srv.cfg.TaskEvents = hub
+	_, feed, _, cancel := hub.Subscribe("c1", 0)
+	defer cancel()
 	resp := e.do("DELETE", "/v1/admin/tasks/c1", e.adminTok, nil)
 	mustStatus(t, resp, http.StatusOK)
 	var cr taskCancelResponse
@@ -86,6 +135,129 @@ func TestAdminTaskCancel(t *testing.T) {
 	if cr.ID != "c1" || !cr.Cancelled {
 		t.Fatalf("cancel response: %+v", cr)
 	}
+	// A subscriber waiting for the task to start is told it never will.
+	ev, ok := <-feed
+	if !ok || ev.Type != taskevent.TypeEnd || ev.Status != store.TaskCancelled {
+		t.Fatalf("feed after cancel: %+v (open %v), want a cancelled end", ev, ok)
+	}
+	if _, ok := <-feed; ok {
+		t.Fatal("feed still open after the cancel")
+	}
+}
+
+// TestAdminTaskCancel_Running proves a DELETE on a running task (the pending CAS
+// misses) is routed to the OnTaskCancel hook and reported as interrupted.
+func TestAdminTaskCancel_Running(t *testing.T) {
+	e := newTestEnv(t)
+	var hooked string
+	e.srv.cfg.OnTaskCancel = func(id string) bool {
+		hooked = id
+		return true
+	}
+	if _, err := e.st.CreateTask(ctxBG, store.Task{ID: "r1", Name: "volume.backup", Node: "n"}); err != nil {
+		t.Fatalf("seed task: %v", err)
+	}
+	if _, err := e.st.ClaimTask(ctxBG, "r1"); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	resp := e.do("DELETE", "/v1/admin/tasks/r1", e.adminTok, nil)
+	mustStatus(t, resp, http.StatusOK)
+	var cr taskCancelResponse
+	if err := json.Unmarshal(readBody(t, resp), &cr); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if cr.Cancelled || !cr.Interrupted || hooked != "r1" {
+		t.Fatalf("cancel response: %+v (hook saw %q)", cr, hooked)
+	}
+}
+
+func TestAdminTaskGet(t *testing.T) {
+	e := newTestEnv(t)
+	if _, err := e.st.CreateTask(ctxBG, store.Task{ID: "g1", ProjectID: "proj-a", Name: "backup.export", Node: "n", Volume: "vol-1"}); err != nil {
+		t.Fatalf("seed task: %v", err)
+	}
+	if err := e.st.UpdateTaskStatus(ctxBG, "g1", store.TaskFailed, json.RawMessage(`{"error":"boom"}`)); err != nil {
+		t.Fatalf("fail task: %v", err)
+	}
+
+	resp := e.do("GET", "/v1/admin/tasks/g1", e.adminTok, nil)
+	mustStatus(t, resp, http.StatusOK)
+	var got store.Task
+	if err := json.Unmarshal(readBody(t, resp), &got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.ID != "g1" || got.Status != store.TaskFailed || string(got.Result) != `{"error":"boom"}` {
+		t.Fatalf("task: %+v result=%s", got, got.Result)
+	}
+
+	mustStatus(t, e.do("GET", "/v1/admin/tasks/nope", e.adminTok, nil), http.StatusNotFound)
+}
+
+func TestAdminTaskList(t *testing.T) {
+	e := newTestEnv(t)
+	for _, tk := range []store.Task{
+		{ID: "l1", ProjectID: "proj-a", Name: "volume.backup", Node: "n", Volume: "vol-1"},
+		{ID: "l2", ProjectID: "proj-a", Name: "volume.restore", Node: "n", Volume: "vol-1"},
+		{ID: "l3", ProjectID: "proj-b", Name: "volume.backup", Node: "n", Volume: "vol-2"},
+	} {
+		if _, err := e.st.CreateTask(ctxBG, tk); err != nil {
+			t.Fatalf("seed %s: %v", tk.ID, err)
+		}
+	}
+
+	list := func(query string) taskListResponse {
+		t.Helper()
+		resp := e.do("GET", "/v1/admin/tasks"+query, e.adminTok, nil)
+		mustStatus(t, resp, http.StatusOK)
+		var lr taskListResponse
+		if err := json.Unmarshal(readBody(t, resp), &lr); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return lr
+	}
+
+	if lr := list(""); len(lr.Tasks) != 3 || lr.NextCursor != "" {
+		t.Fatalf("unfiltered: %d tasks, next %q", len(lr.Tasks), lr.NextCursor)
+	}
+	if lr := list("?name=volume.backup&project_id=proj-b"); len(lr.Tasks) != 1 || lr.Tasks[0].ID != "l3" {
+		t.Fatalf("filtered: %+v", lr.Tasks)
+	}
+	if lr := list("?volume=vol-1&status=pending"); len(lr.Tasks) != 2 {
+		t.Fatalf("volume+status: %+v", lr.Tasks)
+	}
+
+	// Paging: limit=2 yields a cursor; following it returns the remainder.
+	first := list("?limit=2")
+	if len(first.Tasks) != 2 || first.NextCursor == "" {
+		t.Fatalf("page 1: %d tasks, next %q", len(first.Tasks), first.NextCursor)
+	}
+	second := list("?limit=2&cursor=" + first.NextCursor)
+	if len(second.Tasks) != 1 || second.NextCursor != "" {
+		t.Fatalf("page 2: %d tasks, next %q", len(second.Tasks), second.NextCursor)
+	}
+	seen := map[string]bool{}
+	for _, tk := range append(first.Tasks, second.Tasks...) {
+		seen[tk.ID] = true
+	}
+	if len(seen) != 3 {
+		t.Fatalf("pages overlap or skip: %v", seen)
+	}
+
+	// An empty result encodes [] not null.
+	resp := e.do("GET", "/v1/admin/tasks?status=running", e.adminTok, nil)
+	mustStatus(t, resp, http.StatusOK)
+	if body := strings.TrimSpace(string(readBody(t, resp))); body != `{"tasks":[]}` {
+		t.Fatalf("empty list body = %q", body)
+	}
+}
+
+func TestAdminTaskList_BadParams(t *testing.T) {
+	e := newTestEnv(t)
+	for _, q := range []string{"?limit=0", "?limit=x", "?created_from=-1", "?created_to=abc", "?cursor=not-a-cursor"} {
+		t.Run(q, func(t *testing.T) {
+			mustStatus(t, e.do("GET", "/v1/admin/tasks"+q, e.adminTok, nil), http.StatusBadRequest)
+		})
+	}
 }
 
 func TestAdminFirewallPutDelete(t *testing.T) {
@@ -179,6 +351,8 @@ func TestAdminGroup2Routes_RequireAdmin(t *testing.T) {
 	}{
 		{"POST", "/v1/admin/tasks", []byte(`{"id":"x","name":"volume.backup","node":"n"}`)},
 		{"DELETE", "/v1/admin/tasks/x", nil},
+		{"GET", "/v1/admin/tasks", nil},
+		{"GET", "/v1/admin/tasks/x", nil},
 		{"PUT", "/v1/admin/nodes/node-a/firewall_rules", []byte(`{"rules":[]}`)},
 		{"DELETE", "/v1/admin/nodes/node-a/firewall_rules", nil},
 		{"PUT", "/v1/admin/projects/proj-a/volumes/vol-1", []byte(`{"node":"node-a"}`)},
@@ -210,3 +384,123 @@ func TestAdminVolumeDelete_EnqueuesTeardown(t *testing.T) {
 		t.Fatal("volume desired-state row not removed after DELETE")
 	}
 }
+
+func TestAdminTaskGet_QueuePosition(t *testing.T) {
+	e := newTestEnv(t)
+	for _, body := range []string{
+		`{"id":"b1","name":"volume.backup","node":"n","priority":20}`,
+		`{"id":"r1","name":"volume.restore","node":"n"}`,
+	} {
+		mustStatus(t, e.do("POST", "/v1/admin/tasks", e.adminTok, []byte(body)), http.StatusAccepted)
+	}
+	for id, want := range map[string]int{"r1": 1, "b1": 2} {
+		resp := e.do("GET", "/v1/admin/tasks/"+id, e.adminTok, nil)
+		mustStatus(t, resp, http.StatusOK)
+		var v taskView
+		if err := json.Unmarshal(readBody(t, resp), &v); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if v.QueuePosition != want {
+			t.Errorf("%s queue_position = %d, want %d", id, v.QueuePosition, want)
+		}
+	}
+	// Once claimed the task leaves the queue and carries no position.
+	if _, err := e.st.ClaimTask(ctxBG, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	resp := e.do("GET", "/v1/admin/tasks/r1", e.adminTok, nil)
+	mustStatus(t, resp, http.StatusOK)
+	if body := string(readBody(t, resp)); strings.Contains(body, "queue_position") {
+		t.Fatalf("running task body has queue_position: %s", body)
+	}
+	mustStatus(t, e.do("POST", "/v1/admin/tasks", e.adminTok, []byte(`{"id":"x","name":"volume.backup","node":"n","priority":-1}`)), http.StatusBadRequest)
+}
+
+// TestAdminTaskEvents proves the live feed: the buffered history first, then
+// events as the worker publishes them, ending after the "end" event; NDJSON
+// resumes after a seq; a task finished before any feed existed gets its end.
+func TestAdminTaskEvents(t *testing.T) {
+	e := newTestEnv(t)
+	hub := taskevent.NewHub()
+	e.srv.cfg.TaskEvents = hub
+	for _, id := range []string{"t1", "t2"} {
+		if _, err := e.st.CreateTask(ctxBG, store.Task{ID: id, Name: "volume.backup", Node: "n"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stream := hub.Open("t1")
+	stream.Step("one")
+
+	resp := e.do("GET", "/v1/admin/tasks/t1/events", e.adminTok, nil)
+	mustStatus(t, resp, http.StatusOK)
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type = %q", ct)
+	}
+	rd := bufio.NewReader(resp.Body)
+	readEvent := func() (id, typ string, ev taskevent.Event) {
+		t.Helper()
+		for {
+			line, err := rd.ReadString('\n')
+			if err != nil {
+				t.Fatalf("read event: %v", err)
+			}
+			line = strings.TrimRight(line, "\n")
+			switch {
+			case line == "":
+				return id, typ, ev
+			case strings.HasPrefix(line, "id: "):
+				id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				typ = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev); err != nil {
+					t.Fatalf("decode data: %v", err)
+				}
+			}
+		}
+	}
+	if id, typ, ev := readEvent(); id != "1" || typ != "step" || ev.Message != "one" {
+		t.Fatalf("history event: id %s type %s %+v", id, typ, ev)
+	}
+	stream.Step("two")
+	stream.End(store.TaskCompleted)
+	if _, _, ev := readEvent(); ev.Message != "two" {
+		t.Fatalf("live event: %+v", ev)
+	}
+	if id, typ, ev := readEvent(); id != "3" || typ != "end" || ev.Status != store.TaskCompleted {
+		t.Fatalf("end event: id %s type %s %+v", id, typ, ev)
+	}
+	if _, err := rd.ReadString('\n'); err == nil {
+		t.Fatal("stream still open after the end event")
+	}
+
+	ndjsonLines := func(path string) []taskevent.Event {
+		t.Helper()
+		resp := e.do("GET", path, e.adminTok, nil)
+		mustStatus(t, resp, http.StatusOK)
+		var out []taskevent.Event
+		for _, line := range strings.Split(strings.TrimSpace(string(readBody(t, resp))), "\n") {
+			var ev taskevent.Event
+			if err := json.Unmarshal([]byte(line), &ev); err != nil {
+				t.Fatalf("decode %q: %v", line, err)
+			}
+			out = append(out, ev)
+		}
+		return out
+	}
+	if got := ndjsonLines("/v1/admin/tasks/t1/events?format=ndjson&after=2"); len(got) != 1 || got[0].Type != "end" {
+		t.Fatalf("resumed ndjson = %+v", got)
+	}
+
+	if err := e.st.UpdateTaskStatus(ctxBG, "t2", store.TaskFailed, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := ndjsonLines("/v1/admin/tasks/t2/events?format=ndjson"); len(got) != 1 || got[0].Type != "end" || got[0].Status != store.TaskFailed {
+		t.Fatalf("finished task = %+v", got)
+	}
+
+	mustStatus(t, e.do("GET", "/v1/admin/tasks/nope/events", e.adminTok, nil), http.StatusNotFound)
+	mustStatus(t, e.do("GET", "/v1/admin/tasks/t1/events?after=x", e.adminTok, nil), http.StatusBadRequest)
+	mustStatus(t, e.do("GET", "/v1/admin/tasks/t1/events?format=xml", e.adminTok, nil), http.StatusBadRequest)
+}
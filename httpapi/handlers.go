@@ -1,6 +1,7 @@
 package httpapi
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
@@ -273,6 +274,9 @@ const (
 
 	defaultChangelogLimit = 100
 	maxChangelogLimit     = 1000
+
+	defaultTaskListLimit = 100
+	maxTaskListLimit     = 1000
 )
 
 // handleActionCreate records a container's fire-and-forget action request. The
@@ -285,6 +289,7 @@ func (s *Server) handleActionCreate(w http.ResponseWriter, r *http.Request, sc s
 	// Rate-limit before reading/parsing the body so the limiter bounds CPU/alloc,
 	// not just the DB write; a 429 need not consume the body.
 	if !s.limiter.allow(sc.projectID) {
+		rateLimited.Inc()
 		writeError(w, http.StatusTooManyRequests, "too many action requests; slow down")
 		return
 	}
@@ -417,7 +422,11 @@ func (s *Server) handleAdminChangelogAck(w http.ResponseWriter, r *http.Request,
 
 // taskCreateRequest is the body of POST /v1/admin/tasks. The controller supplies
 // the task id (the jid) so a retried POST is idempotent — CreateTask dedups on it
-// and re-dispatch never happens. node identifies the owning node.
+// and re-dispatch never happens. node identifies the owning node. deadline
+// (optional, unix seconds) expires the task if it is still pending by then.
+// priority (optional; higher is claimed first) defaults by kind — see
+// store.DefaultPriority. then (optional) chains dependent steps that run in
+// order after this task — see taskStep.
 type taskCreateRequest struct {
 	ID        string          `json:"id"`
 	ProjectID string          `json:"project_id"`
@@ -427,6 +436,22 @@ type taskCreateRequest struct {
 	Archive   string          `json:"archive"`
 	AuditID   int64           `json:"audit_id"`
 	Params    json.RawMessage `json:"params"`
+	Deadline  int64           `json:"deadline"`
+	Priority  int             `json:"priority"`
+	Then      []taskStep      `json:"then"`
+}
+
+// taskStep is a dependent step of a task chain: its own task (its own id, so
+// the controller can follow it) on the head's node, project and volume. It runs
+// only once the step before it completes; a step without an archive gets the
+// previous step's — for a volume.backup, the archive it created. Any other
+// outcome cancels the rest of the chain.
+type taskStep struct {
+	ID       string          `json:"id"`
+	Name     string          `json:"name"`
+	Archive  string          `json:"archive"`
+	Params   json.RawMessage `json:"params"`
+	Priority int             `json:"priority"`
 }
 
 // taskCreateResponse is the 202 body. created=false means the id already existed
@@ -436,9 +461,10 @@ type taskCreateResponse struct {
 	Created bool   `json:"created"`
 }
 
-// handleAdminTaskCreate records a controller-submitted task (row + changelog) and
-// wakes the in-process dispatcher to claim + run it. Idempotent on the task id, so
-// a retried POST never re-dispatches.
+// handleAdminTaskCreate records a controller-submitted task (row + changelog) —
+// plus any chained steps, atomically — and wakes the in-process dispatcher to
+// claim + run it. Idempotent on the task id, so a retried POST never
+// re-dispatches.
 func (s *Server) handleAdminTaskCreate(w http.ResponseWriter, r *http.Request, _ scope) {
 	body, ok := s.readBody(w, r)
 	if !ok {
@@ -453,11 +479,25 @@ func (s *Server) handleAdminTaskCreate(w http.ResponseWriter, r *http.Request, _
 		writeError(w, http.StatusBadRequest, "id, name and node are required")
 		return
 	}
-	params := req.Params
-	if len(params) == 0 || string(params) == "null" {
-		params = nil
+	if req.Deadline < 0 {
+		writeError(w, http.StatusBadRequest, "deadline must be a unix timestamp")
+		return
+	}
+	if req.Priority < 0 {
+		writeError(w, http.StatusBadRequest, "priority must be >= 0")
+		return
+	}
+	for _, step := range req.Then {
+		if step.ID == "" || step.Name == "" {
+			writeError(w, http.StatusBadRequest, "every step in then requires id and name")
+			return
+		}
+		if step.Priority < 0 {
+			writeError(w, http.StatusBadRequest, "priority must be >= 0")
+			return
+		}
 	}
-	created, err := s.store.CreateTask(r.Context(), store.Task{
+	head := store.Task{
 		ID:        req.ID,
 		ProjectID: req.ProjectID,
 		Name:      req.Name,
@@ -465,8 +505,33 @@ func (s *Server) handleAdminTaskCreate(w http.ResponseWriter, r *http.Request, _
 		Volume:    req.Volume,
 		Archive:   req.Archive,
 		AuditID:   req.AuditID,
-		Params:    params,
-	})
+		Params:    taskParams(req.Params),
+		Deadline:  req.Deadline,
+		Priority:  req.Priority,
+	}
+	var (
+		created bool
+		err     error
+	)
+	if len(req.Then) == 0 {
+		created, err = s.store.CreateTask(r.Context(), head)
+	} else {
+		chain := []store.Task{head}
+		for _, step := range req.Then {
+			chain = append(chain, store.Task{
+				ID:        step.ID,
+				ProjectID: req.ProjectID,
+				Name:      step.Name,
+				Node:      req.Node,
+				Volume:    req.Volume,
+				Archive:   step.Archive,
+				AuditID:   req.AuditID,
+				Params:    taskParams(step.Params),
+				Priority:  step.Priority,
+			})
+		}
+		created, err = s.store.CreateTaskChain(r.Context(), chain)
+	}
 	if err != nil {
 		s.storeError(w, err, "create task")
 		return
@@ -475,15 +540,29 @@ func (s *Server) handleAdminTaskCreate(w http.ResponseWriter, r *http.Request, _
 	writeJSON(w, http.StatusAccepted, taskCreateResponse{ID: req.ID, Created: created})
 }
 
-// taskCancelResponse is the body of DELETE /v1/admin/tasks/{id}. cancelled=false
-// means the task was not pending (already dispatched/terminal, or absent).
+// taskParams normalizes an absent or JSON-null params to nil.
+func taskParams(params json.RawMessage) json.RawMessage {
+	if len(params) == 0 || string(params) == "null" {
+		return nil
+	}
+	return params
+}
+
+// taskCancelResponse is the body of DELETE /v1/admin/tasks/{id}. cancelled=true
+// means a pending task was flipped straight to cancelled. interrupted=true means
+// a running task was signalled instead: its terminal status (cancelled, or its
+// true outcome if it finished first) arrives via the changelog once the worker
+// unwinds. Both false: the task is terminal or absent.
 type taskCancelResponse struct {
-	ID        string `json:"id"`
-	Cancelled bool   `json:"cancelled"`
+	ID          string `json:"id"`
+	Cancelled   bool   `json:"cancelled"`
+	Interrupted bool   `json:"interrupted,omitempty"`
 }
 
-// handleAdminTaskCancel cancels a still-pending task (best-effort; a running task
-// is left to finish).
+// handleAdminTaskCancel cancels a task: a pending (or waiting chain step) one
+// directly (CAS), otherwise a running one via the OnTaskCancel hook, which kills
+// its in-flight borg exec. Either way the steps chained after it are cancelled by
+// the dispatcher's next drain.
 func (s *Server) handleAdminTaskCancel(w http.ResponseWriter, r *http.Request, _ scope) {
 	id := r.PathValue("id")
 	cancelled, err := s.store.CancelPendingTask(r.Context(), id)
@@ -491,7 +570,132 @@ func (s *Server) handleAdminTaskCancel(w http.ResponseWriter, r *http.Request, _
 		s.storeError(w, err, "cancel task")
 		return
 	}
-	writeJSON(w, http.StatusOK, taskCancelResponse{ID: id, Cancelled: cancelled})
+	if cancelled {
+		// End its feed: a subscriber may be waiting for it to start (or for the
+		// retry it was pending for).
+		if s.cfg.TaskEvents != nil {
+			s.cfg.TaskEvents.End(id, store.TaskCancelled)
+		}
+		s.fireHook(s.cfg.OnTaskCreated) // wake the dispatcher to settle its chain
+	}
+	interrupted := false
+	if !cancelled && s.cfg.OnTaskCancel != nil {
+		interrupted = s.cfg.OnTaskCancel(id)
+	}
+	writeJSON(w, http.StatusOK, taskCancelResponse{ID: id, Cancelled: cancelled, Interrupted: interrupted})
+}
+
+// taskView is a task as the admin read API shows it: the row plus, for a
+// pending task, its 1-based queue_position in its worker pool's claim order
+// (omitted once dispatched, or while held back by a retry backoff).
+type taskView struct {
+	store.Task
+	QueuePosition int `json:"queue_position,omitempty"`
+}
+
+// taskListResponse is the GET /v1/admin/tasks body. next_cursor is omitted on
+// the last page; otherwise pass it back as ?cursor= for the next one.
+type taskListResponse struct {
+	Tasks      []taskView `json:"tasks"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// taskViews attaches queue positions to tasks. The queue is only read when a
+// pending task is present.
+func (s *Server) taskViews(ctx context.Context, tasks []store.Task) ([]taskView, error) {
+	var pos map[string]int
+	out := make([]taskView, 0, len(tasks))
+	for _, t := range tasks {
+		v := taskView{Task: t}
+		if t.Status == store.TaskPending {
+			if pos == nil {
+				p, err := s.store.QueuePositions(ctx)
+				if err != nil {
+					return nil, err
+				}
+				pos = p
+			}
+			v.QueuePosition = pos[t.ID]
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// handleAdminTaskList is the operator's read view of the task table: newest
+// first, filtered by any of status, name, volume, project_id and a created_at
+// range (created_from/created_to, unix seconds, inclusive), cursor-paginated
+// (limit default 100, max 1000). Read-only — it lets on-call debug a node without
+// replaying the changelog.
+func (s *Server) handleAdminTaskList(w http.ResponseWriter, r *http.Request, _ scope) {
+	q := r.URL.Query()
+	f := store.TaskFilter{
+		Status:    q.Get("status"),
+		Name:      q.Get("name"),
+		Volume:    q.Get("volume"),
+		ProjectID: q.Get("project_id"),
+		Cursor:    q.Get("cursor"),
+		Limit:     defaultTaskListLimit,
+	}
+	for _, p := range []struct {
+		key string
+		dst *int64
+	}{
+		{"created_from", &f.CreatedFrom},
+		{"created_to", &f.CreatedTo},
+	} {
+		if raw := q.Get(p.key); raw != "" {
+			n, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || n < 0 {
+				writeError(w, http.StatusBadRequest, "invalid "+p.key)
+				return
+			}
+			*p.dst = n
+		}
+	}
+	if raw := q.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		if n > maxTaskListLimit {
+			n = maxTaskListLimit
+		}
+		f.Limit = n
+	}
+
+	tasks, next, err := s.store.ListTasks(r.Context(), f)
+	if err != nil {
+		s.storeError(w, err, "list tasks")
+		return
+	}
+	views, err := s.taskViews(r.Context(), tasks) // never nil: encodes [] not null
+	if err != nil {
+		s.storeError(w, err, "task queue positions")
+		return
+	}
+	writeJSON(w, http.StatusOK, taskListResponse{Tasks: views, NextCursor: next})
+}
+
+// handleAdminTaskGet returns one task's full row, including its result payload
+// (export url, failure output, …). 404 if the id is unknown or already reaped.
+func (s *Server) handleAdminTaskGet(w http.ResponseWriter, r *http.Request, _ scope) {
+	t, found, err := s.store.GetTask(r.Context(), r.PathValue("id"))
+	if err != nil {
+		s.storeError(w, err, "get task")
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "task not found")
+		return
+	}
+	views, err := s.taskViews(r.Context(), []store.Task{t})
+	if err != nil {
+		s.storeError(w, err, "task queue positions")
+		return
+	}
+	writeJSON(w, http.StatusOK, views[0])
 }
 
 // handleAdminFirewallPut stores a node's published-port NAT desired-state. The
@@ -630,9 +834,11 @@ func (s *Server) readBodyLimited(w http.ResponseWriter, r *http.Request, limit i
 // storeError maps a store error onto an HTTP status:
 //   - ErrProjectDeleting   → 404 (the project is gone);
 //   - ErrInvalidPath /
-//     ErrInvalidProjectID  → 400 (client error: empty {path...} on a PUT, or a
-//     malformed {project_id} on an admin route — surfaced via the store
-//     sentinels rather than duplicating validation in the handlers);
+//     ErrInvalidProjectID /
+//     ErrInvalidCursor     → 400 (client error: empty {path...} on a PUT, a
+//     malformed {project_id} on an admin route, or a forged task-list cursor —
+//     surfaced via the store sentinels rather than duplicating validation in
+//     the handlers);
 //   - anything else        → logged 500.
 //
 // Auth-time ErrProjectDeleting is handled in authenticate; this covers the
@@ -645,6 +851,10 @@ func (s *Server) storeError(w http.ResponseWriter, err error, op string) {
 		writeError(w, http.StatusBadRequest, "invalid path")
 	case errors.Is(err, store.ErrInvalidProjectID):
 		writeError(w, http.StatusBadRequest, "invalid project_id")
+	case errors.Is(err, store.ErrInvalidCursor):
+		writeError(w, http.StatusBadRequest, "invalid cursor")
+	case errors.Is(err, store.ErrInvalidChain):
+		writeError(w, http.StatusBadRequest, "invalid task chain: step ids must be unique and unused")
 	default:
 		s.log.Error("store operation failed", "op", op, "error", err)
 		writeError(w, http.StatusInternalServerError, "internal error")
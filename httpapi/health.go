@@ -0,0 +1,70 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// readyCheckTimeout bounds each readiness check, so one hung dependency (a
+// wedged docker socket) reports as failed instead of hanging the probe.
+const readyCheckTimeout = 5 * time.Second
+
+// ReadyCheck is one component of GET /readyz. Check returns a short detail for
+// the response (e.g. "last tick 12s ago"); a non-nil error fails the check and
+// makes the agent not ready.
+type ReadyCheck struct {
+	Name  string
+	Check func(ctx context.Context) (detail string, err error)
+}
+
+// readyCheckResult is one check's entry in the /readyz response.
+type readyCheckResult struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"` // "ok" or "fail"
+	Detail     string `json:"detail,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// handleHealthz reports the process is up and serving. It checks nothing else:
+// a liveness probe that failed on a dependency would restart a healthy agent.
+func (s *Server) handleHealthz(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReadyz runs every configured ReadyCheck concurrently and reports each
+// one: 200 "ready" when all pass, 503 "not_ready" otherwise. Unauthenticated
+// (it is what the provisioner and load-balancer probe); a detail never carries
+// tenant data.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	results := make([]readyCheckResult, len(s.cfg.ReadyChecks))
+	var wg sync.WaitGroup
+	for i, c := range s.cfg.ReadyChecks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(r.Context(), readyCheckTimeout)
+			defer cancel()
+			start := time.Now()
+			detail, err := c.Check(ctx)
+			res := readyCheckResult{Name: c.Name, Status: "ok", Detail: detail}
+			if err != nil {
+				res.Status = "fail"
+				res.Detail = err.Error()
+			}
+			res.DurationMS = time.Since(start).Milliseconds()
+			results[i] = res
+		}()
+	}
+	wg.Wait()
+
+	status, code := "ready", http.StatusOK
+	for _, res := range results {
+		if res.Status != "ok" {
+			status, code = "not_ready", http.StatusServiceUnavailable
+			break
+		}
+	}
+	writeJSON(w, code, map[string]any{"status": status, "checks": results})
+}
@@ -13,6 +13,14 @@
 //     admin hash): privileged cross-tenant writes to managed_kv and reads/writes
 //     of any project's customer_kv, plus tenant provisioning.
 //
+// Plus the unauthenticated GET /healthz and /readyz probes (health.go), which
+// carry no tenant data, and GET /v1/exports/{token} (exports.go), where the
+// one-time token of a local-destination backup export is the credential.
+//
+// A second, node-local listener on a root-only unix socket (admin_socket.go)
+// serves the same admin routes plus operator endpoints to on-node tooling,
+// authenticated by the peer's credentials instead of a Bearer.
+//
 // TENANT-ISOLATION CONTRACT (security-critical — this is now app code, not
 // Consul ACLs):
 //   - A request's scope (none/customer(projectID)/admin) is decided once, in
@@ -34,11 +42,15 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"net"
 	"net/http"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"cs-agent/store"
+	"cs-agent/taskevent"
 
 	"github.com/hashicorp/go-hclog"
 )
@@ -70,12 +82,18 @@ type Store interface {
 	// consumers (dispatcher, firewall reconciler, scheduler) read these tables; the
 	// DOWN handlers wake them via the Config.On* reconcile hooks.
 	CreateTask(ctx context.Context, t store.Task) (created bool, err error)
+	CreateTaskChain(ctx context.Context, steps []store.Task) (created bool, err error)
 	EnqueueTeardown(ctx context.Context, t store.Task, resetFailed bool) (enqueued bool, err error)
 	CancelPendingTask(ctx context.Context, id string) (cancelled bool, err error)
+	GetTask(ctx context.Context, id string) (store.Task, bool, error)
+	ListTasks(ctx context.Context, f store.TaskFilter) (tasks []store.Task, nextCursor string, err error)
+	QueuePositions(ctx context.Context) (map[string]int, error)
 	PutVolume(ctx context.Context, v store.Volume) error
 	DeleteVolume(ctx context.Context, name, projectID string) error
 	PutFirewallRules(ctx context.Context, node string, rules json.RawMessage) error
 	DeleteFirewallRules(ctx context.Context) error
+
+	PoolStats() store.PoolStats
 }
 
 // Config configures the metadata HTTP server. Populate from viper in main.go.
@@ -96,6 +114,11 @@ type Config struct {
 	// goal. <=0 falls back to defaultMaxBodyBytes.
 	MaxBodyBytes int64
 
+	// ActionsBurst / ActionsRefillPerSec size the per-tenant POST /v1/actions
+	// token bucket; <=0 falls back to actionsBurst / actionsRefillPerSec.
+	ActionsBurst        float64
+	ActionsRefillPerSec float64
+
 	// Reconcile hooks let the DOWN admin handlers wake the in-process consumers
 	// after a successful control.db write, so a controller submission is acted on
 	// promptly instead of waiting for the next backstop tick. main wires them to
@@ -104,6 +127,38 @@ type Config struct {
 	OnTaskCreated     func()
 	OnVolumesChanged  func()
 	OnFirewallChanged func()
+
+	// OnTaskCancel interrupts a RUNNING task (the dispatcher's CancelRunning) and
+	// reports whether one was found. Consulted only after the pending->cancelled
+	// CAS misses. Optional; nil leaves running tasks uncancellable.
+	OnTaskCancel func(id string) bool
+
+	// ExportDownload opens the export file a local export destination's
+	// one-time download token is for, served by GET /v1/exports/{token}. With
+	// claim (a GET) the token is taken for this download until release settles
+	// it: spent once a complete download has been served, back to work
+	// otherwise. Optional; nil answers 404.
+	ExportDownload func(token string, claim bool) (f *os.File, release func(spent bool), err error)
+
+	// TaskEvents is the dispatcher's hub of live task feeds, served by
+	// GET /v1/admin/tasks/{id}/events. Optional; nil disables that route (404).
+	TaskEvents *taskevent.Hub
+
+	// ReadyChecks are the components GET /readyz reports on (control.db, docker,
+	// the in-process loops, the populated sentinels); main wires them. With none
+	// the agent is ready as soon as it serves.
+	ReadyChecks []ReadyCheck
+
+	// AdminSocket is the path of the node-local admin listener (a unix socket
+	// only root may use; see admin_socket.go). Empty disables it.
+	AdminSocket string
+
+	// Operator hooks, served on the admin socket only; nil answers 503.
+	// RunMaintenance starts a maintenance job ("prune", "compact", "check", "export_cleanup") now, reporting
+	// started=false when a run is already in flight and an error for an unknown
+	// job. ConfigDump returns the running config with secrets redacted.
+	RunMaintenance func(name string) (started bool, err error)
+	ConfigDump     func() map[string]any
 }
 
 // fireHook invokes an optional reconcile hook if set.
@@ -151,7 +206,20 @@ type Server struct {
 	log     hclog.Logger
 	mux     *http.ServeMux
 	http    *http.Server
+	ln      net.Listener // bound by Listen; nil until then
 	limiter *rateLimiter
+
+	// The admin socket: its own mux and server (the admin routes under peer
+	// auth plus the operator routes), bound by ListenAdminSocket. sockUID is the
+	// peer uid allowed in — root; tests substitute their own.
+	sockMux  *http.ServeMux
+	sockHTTP *http.Server
+	sockLn   net.Listener
+	sockUID  uint32
+	// done is closed when Shutdown starts, ending long-lived event streams
+	// (which Shutdown would otherwise wait out).
+	done      chan struct{}
+	closeDone sync.Once
 }
 
 // New builds the server and wires its routes. It does not bind a socket; call
@@ -160,6 +228,12 @@ func New(cfg Config, st Store, logger hclog.Logger) *Server {
 	if cfg.MaxBodyBytes <= 0 {
 		cfg.MaxBodyBytes = defaultMaxBodyBytes
 	}
+	if cfg.ActionsBurst <= 0 {
+		cfg.ActionsBurst = actionsBurst
+	}
+	if cfg.ActionsRefillPerSec <= 0 {
+		cfg.ActionsRefillPerSec = actionsRefillPerSec
+	}
 	if logger == nil {
 		logger = hclog.NewNullLogger()
 	}
@@ -168,12 +242,15 @@ func New(cfg Config, st Store, logger hclog.Logger) *Server {
 		store:   st,
 		log:     logger,
 		mux:     http.NewServeMux(),
-		limiter: newRateLimiter(actionsBurst, actionsRefillPerSec),
+		limiter: newRateLimiter(cfg.ActionsBurst, cfg.ActionsRefillPerSec),
+		done:    make(chan struct{}),
+		sockMux: http.NewServeMux(),
 	}
 	s.routes()
+	s.sockRoutes()
 	s.http = &http.Server{
 		Addr:    cfg.ListenAddr,
-		Handler: s.mux,
+		Handler: instrument(s.mux),
 		// Slowloris defense on a customer-reachable listener: bound how long a
 		// client may dribble request headers and how long an idle keep-alive
 		// connection lingers. MaxBytesReader caps body SIZE, not TIME, so these
@@ -183,27 +260,64 @@ func New(cfg Config, st Store, logger hclog.Logger) *Server {
 		ReadHeaderTimeout: 10 * time.Second,
 		IdleTimeout:       120 * time.Second,
 	}
+	s.sockHTTP = &http.Server{
+		Handler:           s.sockMux,
+		ConnContext:       s.peerContext,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
 	return s
 }
 
-// Handler exposes the wired mux (for httptest in the same process and tests).
-func (s *Server) Handler() http.Handler { return s.mux }
+// Handler exposes the wired (instrumented) mux (for httptest in the same process
+// and tests).
+func (s *Server) Handler() http.Handler { return s.http.Handler }
 
-// Start binds ListenAddr and serves in the calling goroutine. main.go runs it in
-// its own goroutine. It returns http.ErrServerClosed on a graceful Shutdown.
+// SetActionsRateLimit retunes the per-tenant POST /v1/actions limit live (a
+// config reload). Non-positive values are ignored.
+func (s *Server) SetActionsRateLimit(burst, refillPerSec float64) {
+	if burst <= 0 || refillPerSec <= 0 {
+		return
+	}
+	s.limiter.setRate(burst, refillPerSec)
+}
+
+// Listen binds ListenAddr without serving yet. main binds before it reports the
+// agent ready to systemd, so READY=1 means the front door holds its port.
+func (s *Server) Listen() error {
+	ln, err := net.Listen("tcp", s.cfg.ListenAddr)
+	if err != nil {
+		return err
+	}
+	s.ln = ln
+	return nil
+}
+
+// Start serves in the calling goroutine, on the listener Listen bound (binding
+// ListenAddr itself if Listen was not called). main.go runs it in its own
+// goroutine. It returns http.ErrServerClosed on a graceful Shutdown.
 func (s *Server) Start() error {
-	s.log.Info("metadata HTTP server listening", "addr", s.cfg.ListenAddr)
-	return s.http.ListenAndServe()
+	if s.ln == nil {
+		if err := s.Listen(); err != nil {
+			return err
+		}
+	}
+	s.log.Info("metadata HTTP server listening", "addr", s.ln.Addr().String())
+	return s.http.Serve(s.ln)
 }
 
-// Shutdown gracefully drains the server.
+// Shutdown gracefully drains the server and the admin socket.
 func (s *Server) Shutdown(ctx context.Context) error {
-	return s.http.Shutdown(ctx)
+	s.closeDone.Do(func() { close(s.done) })
+	return errors.Join(s.http.Shutdown(ctx), s.sockHTTP.Shutdown(ctx))
 }
 
 // routes registers every handler using Go 1.22 method+path patterns. {path...}
 // is a trailing wildcard captured with r.PathValue("path").
 func (s *Server) routes() {
+	// --- Probes (unauthenticated) ---
+	s.mux.HandleFunc("GET /healthz", s.handleHealthz)
+	s.mux.HandleFunc("GET /readyz", s.handleReadyz)
+
 	// --- Customer (tenant Bearer; project derived from the token) ---
 	s.mux.HandleFunc("GET /v1/db/{path...}", s.requireCustomer(s.handleCustomerDBGet))
 	s.mux.HandleFunc("PUT /v1/db/{path...}", s.requireCustomer(s.handleCustomerDBPut))
@@ -216,29 +330,41 @@ func (s *Server) routes() {
 	// --- Legacy monarx shim; identity is the Bearer, not {token} ---
 	s.mux.HandleFunc("GET /v1/kv/projects/{token}/metadata", s.requireCustomer(s.handleShimMetadata))
 
-	// --- Admin (per-node admin Bearer; project_id explicit in the path) ---
-	s.mux.HandleFunc("PUT /v1/admin/projects/{project_id}/managed/{path...}", s.requireAdmin(s.handleAdminManagedPut))
-	s.mux.HandleFunc("DELETE /v1/admin/projects/{project_id}/managed/{path...}", s.requireAdmin(s.handleAdminManagedDelete))
-	s.mux.HandleFunc("GET /v1/admin/projects/{project_id}/db/{path...}", s.requireAdmin(s.handleAdminDBGet))
-	s.mux.HandleFunc("PUT /v1/admin/projects/{project_id}/db/{path...}", s.requireAdmin(s.handleAdminDBPut))
-	s.mux.HandleFunc("DELETE /v1/admin/projects/{project_id}/db/{path...}", s.requireAdmin(s.handleAdminDBDelete))
+	// --- Local-destination export downloads; the {token} is the credential ---
+	s.mux.HandleFunc("GET /v1/exports/{token}", s.handleExportDownload)
+
+	s.adminRoutes(s.mux, s.requireAdmin)
+}
+
+// adminRoutes registers the /v1/admin API on mux behind auth: requireAdmin (the
+// admin Bearer) on the front door, requirePeerRoot on the admin socket.
+func (s *Server) adminRoutes(mux *http.ServeMux, auth func(func(http.ResponseWriter, *http.Request, scope)) http.HandlerFunc) {
+	// --- Admin (project_id explicit in the path) ---
+	mux.HandleFunc("PUT /v1/admin/projects/{project_id}/managed/{path...}", auth(s.handleAdminManagedPut))
+	mux.HandleFunc("DELETE /v1/admin/projects/{project_id}/managed/{path...}", auth(s.handleAdminManagedDelete))
+	mux.HandleFunc("GET /v1/admin/projects/{project_id}/db/{path...}", auth(s.handleAdminDBGet))
+	mux.HandleFunc("PUT /v1/admin/projects/{project_id}/db/{path...}", auth(s.handleAdminDBPut))
+	mux.HandleFunc("DELETE /v1/admin/projects/{project_id}/db/{path...}", auth(s.handleAdminDBDelete))
 
-	s.mux.HandleFunc("PUT /v1/admin/tenants/{project_id}", s.requireAdmin(s.handleAdminTenantPut))
-	s.mux.HandleFunc("DELETE /v1/admin/tenants/{project_id}", s.requireAdmin(s.handleAdminTenantDelete))
+	mux.HandleFunc("PUT /v1/admin/tenants/{project_id}", auth(s.handleAdminTenantPut))
+	mux.HandleFunc("DELETE /v1/admin/tenants/{project_id}", auth(s.handleAdminTenantDelete))
 
-	// --- Controller pull channel for the changelog (per-node admin Bearer) ---
-	s.mux.HandleFunc("GET /v1/admin/changelog", s.requireAdmin(s.handleAdminChangelogList))
-	s.mux.HandleFunc("POST /v1/admin/changelog/ack", s.requireAdmin(s.handleAdminChangelogAck))
+	// --- Controller pull channel for the changelog ---
+	mux.HandleFunc("GET /v1/admin/changelog", auth(s.handleAdminChangelogList))
+	mux.HandleFunc("POST /v1/admin/changelog/ack", auth(s.handleAdminChangelogAck))
 
-	// --- Controller DOWN desired-state + task dispatch (per-node admin Bearer) ---
+	// --- Controller DOWN desired-state + task dispatch ---
 	// Each persists to control.db + the changelog and wakes the matching in-process
 	// consumer (dispatcher / firewall reconciler / scheduler) via a reconcile hook.
-	s.mux.HandleFunc("POST /v1/admin/tasks", s.requireAdmin(s.handleAdminTaskCreate))
-	s.mux.HandleFunc("DELETE /v1/admin/tasks/{id}", s.requireAdmin(s.handleAdminTaskCancel))
-	s.mux.HandleFunc("PUT /v1/admin/nodes/{host}/firewall_rules", s.requireAdmin(s.handleAdminFirewallPut))
-	s.mux.HandleFunc("DELETE /v1/admin/nodes/{host}/firewall_rules", s.requireAdmin(s.handleAdminFirewallDelete))
-	s.mux.HandleFunc("PUT /v1/admin/projects/{project_id}/volumes/{name}", s.requireAdmin(s.handleAdminVolumePut))
-	s.mux.HandleFunc("DELETE /v1/admin/projects/{project_id}/volumes/{name}", s.requireAdmin(s.handleAdminVolumeDelete))
+	mux.HandleFunc("POST /v1/admin/tasks", auth(s.handleAdminTaskCreate))
+	mux.HandleFunc("DELETE /v1/admin/tasks/{id}", auth(s.handleAdminTaskCancel))
+	mux.HandleFunc("GET /v1/admin/tasks", auth(s.handleAdminTaskList))
+	mux.HandleFunc("GET /v1/admin/tasks/{id}", auth(s.handleAdminTaskGet))
+	mux.HandleFunc("GET /v1/admin/tasks/{id}/events", auth(s.handleAdminTaskEvents))
+	mux.HandleFunc("PUT /v1/admin/nodes/{host}/firewall_rules", auth(s.handleAdminFirewallPut))
+	mux.HandleFunc("DELETE /v1/admin/nodes/{host}/firewall_rules", auth(s.handleAdminFirewallDelete))
+	mux.HandleFunc("PUT /v1/admin/projects/{project_id}/volumes/{name}", auth(s.handleAdminVolumePut))
+	mux.HandleFunc("DELETE /v1/admin/projects/{project_id}/volumes/{name}", auth(s.handleAdminVolumeDelete))
 }
 
 // authenticate decides the request scope from the Authorization header ALONE.
@@ -6,11 +6,13 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -322,6 +324,35 @@ func TestCustomerDB_CrossTenantIsolation(t *testing.T) {
 	}
 }
 
+// TestMetrics_RequestsByRoute: requests are counted and timed under the route
+// pattern they matched (never the raw path, which carries tenant data).
+func TestMetrics_RequestsByRoute(t *testing.T) {
+	e := newTestEnv(t)
+	e.provisionTenant("proj-a", "tok-a", "active")
+	const route = "GET /v1/db/{path...}"
+	found := httpRequests.Value(route, "200")
+	missing := httpRequests.Value(route, "404")
+	unmatched := httpRequests.Value("unmatched", "404")
+
+	mustStatus(t, e.do("PUT", "/v1/db/k", "tok-a", []byte("v")), http.StatusOK)
+	mustStatus(t, e.do("GET", "/v1/db/k", "tok-a", nil), http.StatusOK)
+	mustStatus(t, e.do("GET", "/v1/db/nope", "tok-a", nil), http.StatusNotFound)
+	mustStatus(t, e.do("GET", "/no/such/route", "tok-a", nil), http.StatusNotFound)
+
+	if got := httpRequests.Value(route, "200") - found; got != 1 {
+		t.Fatalf("200s on %s counted %v, want 1", route, got)
+	}
+	if got := httpRequests.Value(route, "404") - missing; got != 1 {
+		t.Fatalf("404s on %s counted %v, want 1", route, got)
+	}
+	if got := httpRequests.Value("unmatched", "404") - unmatched; got != 1 {
+		t.Fatalf("unmatched 404s counted %v, want 1", got)
+	}
+	if httpDuration.Count(route, "200") == 0 {
+		t.Fatal("request latency not observed")
+	}
+}
+
 // TestCustomerManaged_ReadOnly: a customer can GET /v1/managed but there is NO
 // customer-facing managed WRITE route (PUT/DELETE on /v1/managed 405/404).
 func TestCustomerManaged_ReadOnly(t *testing.T) {
@@ -582,6 +613,78 @@ func TestUnknownTenant401(t *testing.T) {
 	mustStatus(t, resp, http.StatusNotFound)
 }
 
+// TestProbes: /healthz is unconditional; /readyz reports every check and is
+// 503 while any fails. Neither needs a token.
+func TestProbes(t *testing.T) {
+	e := newTestEnv(t)
+	mustStatus(t, e.do("GET", "/healthz", "", nil), http.StatusOK)
+	mustStatus(t, e.do("GET", "/readyz", "", nil), http.StatusOK) // no checks configured
+
+	var ready atomic.Bool
+	e.srv.cfg.ReadyChecks = []ReadyCheck{
+		{Name: "db", Check: func(context.Context) (string, error) { return "answers", nil }},
+		{Name: "loop", Check: func(context.Context) (string, error) {
+			if !ready.Load() {
+				return "", errors.New("has not ticked yet")
+			}
+			return "last tick 1s ago", nil
+		}},
+	}
+	type report struct {
+		Status string             `json:"status"`
+		Checks []readyCheckResult `json:"checks"`
+	}
+	var got report
+	resp := e.do("GET", "/readyz", "", nil)
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503 with a failing check", resp.StatusCode)
+	}
+	if err := json.Unmarshal(readBody(t, resp), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != "not_ready" || len(got.Checks) != 2 ||
+		got.Checks[0] != (readyCheckResult{Name: "db", Status: "ok", Detail: "answers", DurationMS: got.Checks[0].DurationMS}) ||
+		got.Checks[1].Name != "loop" || got.Checks[1].Status != "fail" || got.Checks[1].Detail != "has not ticked yet" {
+		t.Fatalf("report = %+v", got)
+	}
+
+	ready.Store(true)
+	resp = e.do("GET", "/readyz", "", nil)
+	mustStatus(t, resp, http.StatusOK)
+}
+
+// TestListenThenStart: Listen holds the port before Start serves on it (what
+// lets main report READY only once the front door is bound).
+func TestListenThenStart(t *testing.T) {
+	st, err := store.Open(t.TempDir(), store.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = st.Close() })
+	srv := New(Config{ListenAddr: "127.0.0.1:0"}, st, nil)
+	if err := srv.Listen(); err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.Start() }()
+
+	resp, err := http.Get("http://" + srv.ln.Addr().String() + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(ctxBG, 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		t.Fatalf("Start returned %v, want ErrServerClosed", err)
+	}
+}
+
 // TestUnit_BearerToken exercises the header parser directly.
 func TestUnit_BearerToken(t *testing.T) {
 	cases := []struct {
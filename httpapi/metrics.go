@@ -0,0 +1,69 @@
+package httpapi
+
+import (
+	"cs-agent/metrics"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	httpRequests = metrics.NewCounterVec("cs_agent_http_requests_total",
+		"Metadata API requests, by route pattern and status code.",
+		"route", "status")
+	httpDuration = metrics.NewHistogramVec("cs_agent_http_request_duration_seconds",
+		"Metadata API request latency, by route pattern and status code.",
+		[]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		"route", "status")
+	rateLimited = metrics.NewCounterVec("cs_agent_http_rate_limited_total",
+		"Requests rejected with 429 by the per-tenant rate limiter.")
+)
+
+// instrument records every request's count and latency. The route label is the
+// mux pattern the request matched (bounded, unlike the raw path, which carries
+// tokens and project ids); a request no route matched is "unmatched".
+func instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		route := r.Pattern // set by the mux on this request once it routes it
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(sw.status)
+		httpRequests.Inc(route, status)
+		httpDuration.Observe(time.Since(start).Seconds(), route, status)
+	})
+}
+
+// statusWriter captures the response status. It passes Flush through so the
+// task event stream still streams.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.wroteHeader = true
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package httpapi
+
+import (
+	"errors"
+	"net"
+)
+
+// peerUID is Linux-only (SO_PEERCRED); elsewhere every admin socket request is
+// refused.
+func peerUID(net.Conn) (uint32, error) {
+	return 0, errors.New("peer credentials are not supported on this platform")
+}
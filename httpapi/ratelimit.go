@@ -5,7 +5,9 @@ import (
 	"time"
 )
 
-// Per-tenant rate-limit defaults for POST /v1/actions. Deliberately generous —
+// Per-tenant rate-limit defaults for POST /v1/actions (Config.ActionsBurst /
+// ActionsRefillPerSec override them; SetActionsRateLimit retunes them live).
+// Deliberately generous —
 // the real coalescing/budget lives downstream (controller + CloudPress); this is
 // only a node-level abuse bound so one tenant cannot flood the shared control.db
 // write path and starve the tenant-auth read path for every other tenant.
@@ -61,6 +63,18 @@ func (rl *rateLimiter) allow(key string) bool {
 	return true
 }
 
+// setRate retunes the limiter. Existing buckets keep their tokens (capped to the
+// new burst), so a change neither grants a fresh burst nor empties anyone.
+func (rl *rateLimiter) setRate(burst, refillPerSec float64) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.burst = burst
+	rl.refill = refillPerSec
+	for _, b := range rl.buckets {
+		b.tokens = min(b.tokens, burst)
+	}
+}
+
 // forget drops a key's bucket (e.g. when a tenant is de-provisioned) so the map
 // tracks the live tenant set rather than every project id ever seen.
 func (rl *rateLimiter) forget(key string) {
@@ -2,10 +2,18 @@ package job
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
+	"cs-agent/backup"
 	"cs-agent/store"
+	"cs-agent/taskevent"
+
+	"github.com/spf13/viper"
 )
 
 func testStore(t *testing.T) *store.Store {
@@ -41,7 +49,13 @@ func TestDispatcher_DrainClaimsOnce(t *testing.T) {
 		}
 	}()
 
+	if !d.LastTick().IsZero() {
+		t.Fatal("LastTick set before the first drain")
+	}
 	d.drain(ctx)
+	if time.Since(d.LastTick()) > time.Minute {
+		t.Fatalf("LastTick = %s after a drain", d.LastTick())
+	}
 	select {
 	case task := <-got:
 		if task.ID != "b1" {
@@ -106,3 +120,573 @@ func TestDispatcher_BootReconcileFailsRunning(t *testing.T) {
 		t.Fatalf("restore status = %q, want failed", tk.Status)
 	}
 }
+
+// TestDispatcher_CancelRunningInterruptsTask proves a running task's context is
+// cancelled by CancelRunning and the worker records "cancelled" (not "failed")
+// with the runner's partial output.
+func TestDispatcher_CancelRunningInterruptsTask(t *testing.T) {
+	d := newTestDispatcher(t)
+	ctx := context.Background()
+	if _, err := d.st.CreateTask(ctx, store.Task{ID: "r1", Name: "volume.restore", Node: "test-node"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.st.ClaimTask(ctx, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	d.runner = func(ctx context.Context, _ *store.Store, _ store.Task) (json.RawMessage, error) {
+		close(started)
+		<-ctx.Done()
+		return json.RawMessage(`{"output":"partial"}`), ctx.Err()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		d.runTask(ctx, store.Task{ID: "r1", Name: "volume.restore", Node: "test-node"})
+		close(done)
+	}()
+	<-started
+	if !d.CancelRunning("r1") {
+		t.Fatal("CancelRunning = false for an in-flight task")
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("runTask did not return after cancel")
+	}
+
+	tk, _, _ := d.st.GetTask(ctx, "r1")
+	if tk.Status != store.TaskCancelled {
+		t.Fatalf("status = %q, want cancelled", tk.Status)
+	}
+	if string(tk.Result) != `{"output":"partial"}` {
+		t.Fatalf("result = %s, want the partial output", tk.Result)
+	}
+	if d.CancelRunning("r1") {
+		t.Fatal("CancelRunning = true for a terminal task")
+	}
+}
+
+// TestDispatcher_CancelRunningQueuedTask proves a task cancelled after its claim
+// but before a worker picks it up is skipped (never run) and recorded cancelled.
+func TestDispatcher_CancelRunningQueuedTask(t *testing.T) {
+	d := newTestDispatcher(t)
+	ctx := context.Background()
+	if _, err := d.st.CreateTask(ctx, store.Task{ID: "q1", Name: "volume.backup", Node: "test-node"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.st.ClaimTask(ctx, "q1"); err != nil {
+		t.Fatal(err)
+	}
+	if !d.CancelRunning("q1") {
+		t.Fatal("CancelRunning = false for a claimed task")
+	}
+	d.runner = func(context.Context, *store.Store, store.Task) (json.RawMessage, error) {
+		t.Fatal("runner invoked for a task cancelled while queued")
+		return nil, nil
+	}
+	d.runTask(ctx, store.Task{ID: "q1", Name: "volume.backup", Node: "test-node"})
+
+	if tk, _, _ := d.st.GetTask(ctx, "q1"); tk.Status != store.TaskCancelled {
+		t.Fatalf("status = %q, want cancelled", tk.Status)
+	}
+}
+
+// TestDispatcher_StaleCancelSparesRetry proves a cancel flag left for a claim
+// that finished before any worker saw the flag (CancelRunning read the task as
+// running just as it ended) doesn't skip the next attempt of the task.
+func TestDispatcher_StaleCancelSparesRetry(t *testing.T) {
+	d := newTestDispatcher(t)
+	ctx := context.Background()
+	if _, err := d.st.CreateTask(ctx, store.Task{ID: "st1", Name: "volume.backup", Node: "test-node"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.st.ClaimTask(ctx, "st1"); err != nil {
+		t.Fatal(err)
+	}
+	if !d.CancelRunning("st1") {
+		t.Fatal("CancelRunning = false for a claimed task")
+	}
+	// That attempt ends without a worker seeing the flag, and the task is retried.
+	if _, err := d.st.RetryTask(ctx, "st1", 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.st.ClaimTask(ctx, "st1"); err != nil {
+		t.Fatal(err)
+	}
+	ran := false
+	d.runner = func(context.Context, *store.Store, store.Task) (json.RawMessage, error) {
+		ran = true
+		return nil, nil
+	}
+	tk, _, _ := d.st.GetTask(ctx, "st1")
+	d.runTask(ctx, tk)
+	if tk, _, _ := d.st.GetTask(ctx, "st1"); !ran || tk.Status != store.TaskCompleted {
+		t.Fatalf("retry ran %v, status %q; want it run and completed", ran, tk.Status)
+	}
+}
+
+// TestDispatcher_CancelAfterSuccessKeepsCompleted proves a cancel that lands as
+// the runner succeeds does not rewrite a real success into "cancelled".
+func TestDispatcher_CancelAfterSuccessKeepsCompleted(t *testing.T) {
+	d := newTestDispatcher(t)
+	ctx := context.Background()
+	if _, err := d.st.CreateTask(ctx, store.Task{ID: "s1", Name: "volume.backup", Node: "test-node"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.st.ClaimTask(ctx, "s1"); err != nil {
+		t.Fatal(err)
+	}
+	d.runner = func(context.Context, *store.Store, store.Task) (json.RawMessage, error) {
+		d.CancelRunning("s1")
+		return nil, nil
+	}
+	d.runTask(ctx, store.Task{ID: "s1", Name: "volume.backup", Node: "test-node"})
+
+	if tk, _, _ := d.st.GetTask(ctx, "s1"); tk.Status != store.TaskCompleted {
+		t.Fatalf("status = %q, want completed", tk.Status)
+	}
+}
+
+// TestDispatcher_RetryTransientFailure proves a transient (retryable) failure is
+// put back to pending with a backoff while the kind's attempts last, and is
+// recorded failed once they are spent; a terminal failure is never retried.
+func TestDispatcher_RetryTransientFailure(t *testing.T) {
+	viper.Set("tasks.retry.volume.backup.max_attempts", 2)
+	viper.Set("tasks.retry.base_delay_sec", 60)
+	t.Cleanup(func() {
+		viper.Set("tasks.retry.volume.backup.max_attempts", nil)
+		viper.Set("tasks.retry.base_delay_sec", nil)
+	})
+	d := newTestDispatcher(t)
+	ctx := context.Background()
+	d.runner = func(context.Context, *store.Store, store.Task) (json.RawMessage, error) {
+		return json.RawMessage(`{"error":"lock timeout"}`), backup.Retryable(errors.New("lock timeout"))
+	}
+	for _, id := range []string{"b1", "b2"} {
+		if _, err := d.st.CreateTask(ctx, store.Task{ID: id, Name: "volume.backup", Node: "test-node"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Attempt 1 of 2: back to pending, held for the backoff.
+	if _, err := d.st.ClaimTask(ctx, "b1"); err != nil {
+		t.Fatal(err)
+	}
+	tk, _, _ := d.st.GetTask(ctx, "b1")
+	d.runTask(ctx, tk)
+	tk, _, _ = d.st.GetTask(ctx, "b1")
+	if tk.Status != store.TaskPending || tk.Attempts != 1 {
+		t.Fatalf("after attempt 1: status %q attempts %d, want pending/1", tk.Status, tk.Attempts)
+	}
+	if wait := tk.NextAttemptAt - time.Now().Unix(); wait < 55 || wait > 60 {
+		t.Fatalf("next_attempt_at is %ds out, want ~60s", wait)
+	}
+
+	// Attempt 2 of 2: retries exhausted, recorded failed.
+	if _, err := d.st.ClaimTask(ctx, "b1"); err != nil {
+		t.Fatal(err)
+	}
+	tk, _, _ = d.st.GetTask(ctx, "b1")
+	d.runTask(ctx, tk)
+	if tk, _, _ = d.st.GetTask(ctx, "b1"); tk.Status != store.TaskFailed || tk.Attempts != 2 {
+		t.Fatalf("after attempt 2: status %q attempts %d, want failed/2", tk.Status, tk.Attempts)
+	}
+
+	// A terminal failure fails on the first attempt.
+	d.runner = func(context.Context, *store.Store, store.Task) (json.RawMessage, error) {
+		return nil, errors.New("repository does not exist")
+	}
+	if _, err := d.st.ClaimTask(ctx, "b2"); err != nil {
+		t.Fatal(err)
+	}
+	tk, _, _ = d.st.GetTask(ctx, "b2")
+	d.runTask(ctx, tk)
+	if tk, _, _ = d.st.GetTask(ctx, "b2"); tk.Status != store.TaskFailed {
+		t.Fatalf("terminal failure: status %q, want failed", tk.Status)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := retryPolicy{maxAttempts: 5, baseDelay: time.Minute, maxDelay: 5 * time.Minute}
+	for attempt, want := range map[int]time.Duration{
+		1: time.Minute, 2: 2 * time.Minute, 3: 4 * time.Minute, 4: 5 * time.Minute, 10: 5 * time.Minute,
+	} {
+		if got := p.backoff(attempt); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", attempt, got, want)
+		}
+	}
+}
+
+// TestDispatcher_BootReconcileReplaysSafeKinds proves a replay-safe kind left
+// running by a crash is cleaned up and put back to pending (counting the replay),
+// while a kind without a replay policy, a task at its replay cap, and a task
+// whose cleanup failed are all failed.
+func TestDispatcher_BootReconcileReplaysSafeKinds(t *testing.T) {
+	viper.Set("tasks.replay.volume.backup.max_replays", 1)
+	t.Cleanup(func() { viper.Set("tasks.replay.volume.backup.max_replays", nil) })
+	d := newTestDispatcher(t)
+	ctx := context.Background()
+
+	var prepared []string
+	d.prepareReplay = func(_ context.Context, _ *store.Store, task store.Task) error {
+		prepared = append(prepared, task.ID)
+		if task.Volume == "broken" {
+			return errors.New("docker unavailable")
+		}
+		return nil
+	}
+	var cleaned []string
+	d.cleanupOrphan = func(_ context.Context, _ *store.Store, task store.Task) error {
+		cleaned = append(cleaned, task.ID)
+		return nil
+	}
+	for _, tk := range []store.Task{
+		{ID: "backup", Name: "volume.backup", Volume: "v1"},
+		{ID: "capped", Name: "volume.backup", Volume: "v2"},
+		{ID: "cleanup", Name: "volume.backup", Volume: "broken"},
+		{ID: "restore", Name: "volume.restore", Volume: "v3"},
+		{ID: "clone", Name: "volume.clone", Volume: "v4"},
+	} {
+		tk.Node = "test-node"
+		if _, err := d.st.CreateTask(ctx, tk); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.st.ClaimTask(ctx, tk.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// "capped" already used its one replay.
+	if _, err := d.st.ReplayTask(ctx, "capped"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.st.ClaimTask(ctx, "capped"); err != nil {
+		t.Fatal(err)
+	}
+
+	d.bootReconcile(ctx)
+
+	want := map[string]string{
+		"backup":  store.TaskPending,
+		"capped":  store.TaskFailed,
+		"cleanup": store.TaskFailed,
+		"restore": store.TaskFailed,
+		"clone":   store.TaskFailed,
+	}
+	for id, status := range want {
+		if tk, _, _ := d.st.GetTask(ctx, id); tk.Status != status {
+			t.Errorf("%s: status %q, want %q", id, tk.Status, status)
+		}
+	}
+	if tk, _, _ := d.st.GetTask(ctx, "backup"); tk.Replays != 1 {
+		t.Errorf("backup: replays %d, want 1", tk.Replays)
+	}
+	if tk, _, _ := d.st.GetTask(ctx, "capped"); !strings.Contains(string(tk.Result), "replay limit") {
+		t.Errorf("capped: result %s, want replay-limit reason", tk.Result)
+	}
+	if !slices.Equal(prepared, []string{"backup", "cleanup"}) {
+		t.Errorf("prepared %v, want [backup cleanup] (only replay candidates)", prepared)
+	}
+	slices.Sort(cleaned)
+	if !slices.Equal(cleaned, []string{"capped", "cleanup", "clone", "restore"}) {
+		t.Errorf("cleaned up %v, want every failed orphan (a clone's partial volume goes)", cleaned)
+	}
+}
+
+// TestDispatcher_DrainExpiresPastDeadline proves a pending task whose deadline
+// has passed is recorded expired and never dispatched.
+func TestDispatcher_DrainExpiresPastDeadline(t *testing.T) {
+	d := newTestDispatcher(t)
+	ctx := context.Background()
+	if _, err := d.st.CreateTask(ctx, store.Task{ID: "late", Name: "backup.export", Node: "test-node", Deadline: time.Now().Unix() - 1}); err != nil {
+		t.Fatal(err)
+	}
+	_, feed, _, cancel := d.Events().Subscribe("late", 0)
+	defer cancel()
+	expired := tasksTotal.Value("backup.export", store.TaskExpired)
+	d.drain(ctx) // no exportQ consumer: a dispatch attempt would revert to pending
+	if ev := lastEvent(t, feed); ev.Type != taskevent.TypeEnd || ev.Status != store.TaskExpired {
+		t.Fatalf("feed of the expired task ended with %+v, want an expired end", ev)
+	}
+	tk, _, _ := d.st.GetTask(ctx, "late")
+	if tk.Status != store.TaskExpired || tk.Attempts != 0 {
+		t.Fatalf("status %q attempts %d, want expired/0 (never claimed)", tk.Status, tk.Attempts)
+	}
+	if got := tasksTotal.Value("backup.export", store.TaskExpired) - expired; got != 1 {
+		t.Fatalf("expired tasks counted %v times, want 1", got)
+	}
+	// Told apart from an export whose download lapsed (which adds expired_at).
+	var result map[string]any
+	if err := json.Unmarshal(tk.Result, &result); err != nil || result["error"] == nil || result["expired_at"] != nil {
+		t.Fatalf("expired task result %s", tk.Result)
+	}
+	if got := queueDepth.Value(store.QueueExport); got != 0 {
+		t.Fatalf("export queue depth = %v after expiring its only task, want 0", got)
+	}
+}
+
+// TestDispatcher_TimeoutRecordsTimedOut proves a run past its kind's timeout has
+// its context cancelled and is recorded timed_out (not failed or cancelled).
+func TestDispatcher_TimeoutRecordsTimedOut(t *testing.T) {
+	viper.Set("tasks.timeout.volume.restore.max_runtime_sec", 1)
+	t.Cleanup(func() { viper.Set("tasks.timeout.volume.restore.max_runtime_sec", nil) })
+	d := newTestDispatcher(t)
+	ctx := context.Background()
+	if _, err := d.st.CreateTask(ctx, store.Task{ID: "slow", Name: "volume.restore", Node: "test-node"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.st.ClaimTask(ctx, "slow"); err != nil {
+		t.Fatal(err)
+	}
+	d.runner = func(ctx context.Context, _ *store.Store, _ store.Task) (json.RawMessage, error) {
+		select {
+		case <-ctx.Done():
+			return json.RawMessage(`{"error":"task timed out"}`), ctx.Err()
+		case <-time.After(10 * time.Second):
+			return nil, nil
+		}
+	}
+	tk, _, _ := d.st.GetTask(ctx, "slow")
+	timedOut := taskDuration.Count("volume.restore", store.TaskTimedOut)
+	d.runTask(ctx, tk)
+	if tk, _, _ = d.st.GetTask(ctx, "slow"); tk.Status != store.TaskTimedOut {
+		t.Fatalf("status %q, want timed_out", tk.Status)
+	}
+	if got := taskDuration.Count("volume.restore", store.TaskTimedOut) - timedOut; got != 1 {
+		t.Fatalf("timed-out attempts timed %d times, want 1", got)
+	}
+}
+
+func TestTaskTimeoutFor_ExportFallback(t *testing.T) {
+	viper.Set("backups.export.timeout_sec", 120)
+	t.Cleanup(func() {
+		viper.Set("backups.export.timeout_sec", nil)
+		viper.Set("tasks.timeout.backup.export.max_runtime_sec", nil)
+	})
+	if got := taskTimeoutFor("backup.export"); got != 2*time.Minute {
+		t.Fatalf("export timeout = %s, want 2m (backups.export.timeout_sec)", got)
+	}
+	viper.Set("tasks.timeout.backup.export.max_runtime_sec", 60)
+	if got := taskTimeoutFor("backup.export"); got != time.Minute {
+		t.Fatalf("export timeout = %s, want 1m (per-kind override)", got)
+	}
+	if got := taskTimeoutFor("unknown.kind"); got != 0 {
+		t.Fatalf("unknown kind timeout = %s, want 0 (no cap)", got)
+	}
+}
+
+// TestDispatcher_DrainPriorityOrder proves drain claims in the store's claim
+// order: a restore ahead of a cron wave, and the wave round-robined by project.
+func TestDispatcher_DrainPriorityOrder(t *testing.T) {
+	d := newTestDispatcher(t)
+	ctx := context.Background()
+	for _, tk := range []store.Task{
+		{ID: "p1-a", ProjectID: "p1", Priority: store.PriorityScheduled},
+		{ID: "p1-b", ProjectID: "p1", Priority: store.PriorityScheduled},
+		{ID: "p2-a", ProjectID: "p2", Priority: store.PriorityScheduled},
+		{ID: "restore", ProjectID: "p3", Name: "volume.restore"},
+	} {
+		if tk.Name == "" {
+			tk.Name = "volume.backup"
+		}
+		tk.Node = "test-node"
+		if _, err := d.st.CreateTask(ctx, tk); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := make(chan string, 4)
+	go func() {
+		for task := range d.backupQ {
+			got <- task.ID
+		}
+	}()
+	d.drain(ctx)
+	var order []string
+	for range 4 {
+		select {
+		case id := <-got:
+			order = append(order, id)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("dispatched %v, want 4 tasks", order)
+		}
+	}
+	if want := []string{"restore", "p1-a", "p2-a", "p1-b"}; !slices.Equal(order, want) {
+		t.Fatalf("dispatch order = %v, want %v", order, want)
+	}
+}
+
+// TestDispatcher_ChainHandsOffArchive proves a chained export runs after its
+// backup completes, against the archive the backup created: the worker's
+// terminal write wakes the dispatcher, whose drain releases and dispatches the
+// step in the same pass.
+func TestDispatcher_ChainHandsOffArchive(t *testing.T) {
+	d := newTestDispatcher(t)
+	ctx := context.Background()
+	if _, err := d.st.CreateTaskChain(ctx, []store.Task{
+		{ID: "c1", Name: "volume.backup", Node: "test-node", Volume: "vol"},
+		{ID: "c2", Name: "backup.export", Node: "test-node", Volume: "vol"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	d.runner = func(context.Context, *store.Store, store.Task) (json.RawMessage, error) {
+		return json.RawMessage(`{"archive":"manual-m-2026-10-17T12:00:00"}`), nil
+	}
+	if _, err := d.st.ClaimTask(ctx, "c1"); err != nil {
+		t.Fatal(err)
+	}
+	tk, _, _ := d.st.GetTask(ctx, "c1")
+	d.runTask(ctx, tk)
+	select {
+	case <-d.signal:
+	default:
+		t.Fatal("terminal write did not wake the dispatcher")
+	}
+
+	got := make(chan store.Task, 1)
+	go func() {
+		for task := range d.exportQ {
+			got <- task
+		}
+	}()
+	// The export hand-off is non-blocking: a drain that runs before the consumer
+	// is receiving reverts the claim, so re-drain until it lands.
+	deadline := time.After(2 * time.Second)
+	for {
+		d.drain(ctx)
+		select {
+		case task := <-got:
+			if task.ID != "c2" || task.Archive != "manual-m-2026-10-17T12:00:00" {
+				t.Fatalf("dispatched %s with archive %q", task.ID, task.Archive)
+			}
+			return
+		case <-deadline:
+			t.Fatal("chained export was not dispatched")
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+// TestDispatcher_ChainFailureShortCircuits proves a failed head cancels the
+// rest of its chain instead of running it.
+func TestDispatcher_ChainFailureShortCircuits(t *testing.T) {
+	d := newTestDispatcher(t)
+	ctx := context.Background()
+	if _, err := d.st.CreateTaskChain(ctx, []store.Task{
+		{ID: "c1", Name: "volume.backup", Node: "test-node", Volume: "vol"},
+		{ID: "c2", Name: "backup.export", Node: "test-node", Volume: "vol"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	d.runner = func(context.Context, *store.Store, store.Task) (json.RawMessage, error) {
+		return nil, errors.New("repository does not exist")
+	}
+	if _, err := d.st.ClaimTask(ctx, "c1"); err != nil {
+		t.Fatal(err)
+	}
+	_, feed, _, cancel := d.Events().Subscribe("c2", 0)
+	defer cancel()
+	tk, _, _ := d.st.GetTask(ctx, "c1")
+	d.runTask(ctx, tk)
+	d.drain(ctx)
+	if tk, _, _ := d.st.GetTask(ctx, "c2"); tk.Status != store.TaskCancelled {
+		t.Fatalf("c2 status = %q, want cancelled", tk.Status)
+	}
+	if ev := lastEvent(t, feed); ev.Type != taskevent.TypeEnd || ev.Status != store.TaskCancelled {
+		t.Fatalf("feed of the aborted step ended with %+v, want a cancelled end", ev)
+	}
+}
+
+// lastEvent reads feed until the hub closes it and returns the final event.
+func lastEvent(t *testing.T, feed <-chan taskevent.Event) taskevent.Event {
+	t.Helper()
+	var last taskevent.Event
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev, ok := <-feed:
+			if !ok {
+				return last
+			}
+			last = ev
+		case <-timeout:
+			t.Fatal("task feed never closed")
+		}
+	}
+}
+
+// TestDispatcher_TaskEventsFeed proves the worker hands the task its live feed
+// on the context and ends it with the recorded terminal status.
+func TestDispatcher_TaskEventsFeed(t *testing.T) {
+	d := newTestDispatcher(t)
+	ctx := context.Background()
+	if _, err := d.st.CreateTask(ctx, store.Task{ID: "e1", Name: "volume.backup", Node: "test-node"}); err != nil {
+		t.Fatal(err)
+	}
+	d.runner = func(ctx context.Context, _ *store.Store, _ store.Task) (json.RawMessage, error) {
+		taskevent.FromContext(ctx).Step("creating archive")
+		return nil, nil
+	}
+	if _, err := d.st.ClaimTask(ctx, "e1"); err != nil {
+		t.Fatal(err)
+	}
+	tk, _, _ := d.st.GetTask(ctx, "e1")
+	d.runTask(ctx, tk)
+
+	history, _, _, cancel := d.Events().Subscribe("e1", 0)
+	defer cancel()
+	if len(history) != 2 || history[0].Message != "creating archive" ||
+		history[1].Type != taskevent.TypeEnd || history[1].Status != store.TaskCompleted {
+		t.Fatalf("feed = %+v", history)
+	}
+}
+
+func TestProgressSnapshot(t *testing.T) {
+	prev := taskevent.Event{Seq: 1, Time: 10_000, Phase: "extract", Bytes: 100, Total: 1100}
+	cur := taskevent.Event{Seq: 2, Time: 20_000, Phase: "extract", Bytes: 600, Total: 1100}
+	got := progressSnapshot(cur, prev, true)
+	want := store.TaskProgress{Phase: "extract", BytesDone: 600, BytesTotal: 1100, ETASec: 10, UpdatedAt: 20}
+	if got != want {
+		t.Fatalf("snapshot = %+v, want %+v", got, want)
+	}
+	// No total (a create, an upload), or no earlier sample: no ETA.
+	if got := progressSnapshot(taskevent.Event{Phase: "create", Bytes: 5}, prev, true); got.ETASec != 0 {
+		t.Fatalf("ETA without a total: %+v", got)
+	}
+	if got := progressSnapshot(cur, taskevent.Event{}, false); got.ETASec != 0 {
+		t.Fatalf("ETA from a single sample: %+v", got)
+	}
+}
+
+// TestDispatcher_PersistsProgress proves a running task's latest progress
+// record lands on its row on the configured interval.
+func TestDispatcher_PersistsProgress(t *testing.T) {
+	viper.Set("tasks.progress_interval_sec", 1)
+	t.Cleanup(func() { viper.Set("tasks.progress_interval_sec", nil) })
+	d := newTestDispatcher(t)
+	ctx := context.Background()
+	if _, err := d.st.CreateTask(ctx, store.Task{ID: "p1", Name: "backup.export", Node: "test-node"}); err != nil {
+		t.Fatal(err)
+	}
+	var seen *store.TaskProgress
+	d.runner = func(ctx context.Context, st *store.Store, _ store.Task) (json.RawMessage, error) {
+		taskevent.FromContext(ctx).Publish(taskevent.Event{Type: taskevent.TypeUpload, Phase: "upload", Bytes: 4096})
+		deadline := time.Now().Add(3 * time.Second)
+		for time.Now().Before(deadline) {
+			if tk, _, _ := st.GetTask(ctx, "p1"); tk.Progress != nil {
+				seen = tk.Progress
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		return nil, nil
+	}
+	if _, err := d.st.ClaimTask(ctx, "p1"); err != nil {
+		t.Fatal(err)
+	}
+	tk, _, _ := d.st.GetTask(ctx, "p1")
+	d.runTask(ctx, tk)
+	if seen == nil || seen.Phase != "upload" || seen.BytesDone != 4096 {
+		t.Fatalf("progress while running = %+v", seen)
+	}
+}
@@ -2,14 +2,18 @@ package job
 
 import (
 	"context"
+	"cs-agent/backup"
+	"cs-agent/config"
 	"cs-agent/log"
 	"cs-agent/store"
+	"cs-agent/taskevent"
 	"encoding/json"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
-	"github.com/spf13/viper"
 )
 
 // backstopInterval is how often the dispatcher re-drains ListPendingTasks even
@@ -29,18 +33,36 @@ type Dispatcher struct {
 	signal        chan struct{}
 	backupWorkers int
 	exportWorkers int
+	// mu guards inflight/precancelled: the per-task cancel registry that lets
+	// CancelRunning reach a task a worker is executing (or has been handed).
+	mu           sync.Mutex
+	inflight     map[string]*inflightTask
+	precancelled map[string]int // task id -> the attempt (claim) it was flagged for
+	// events carries each running task's live feed (see taskevent).
+	events *taskevent.Hub
+	// lastTick is when the dispatch loop last started a drain (unix nanos);
+	// handingOff is set while a drain blocks handing a task to a busy backup
+	// pool. Both feed the readiness check (LastTick).
+	lastTick   atomic.Int64
+	handingOff atomic.Bool
 	// runner executes a task; nil means backup.RunTask (the production path).
 	// Overridable in tests to exercise the worker's terminal guard directly.
 	runner func(context.Context, *store.Store, store.Task) (json.RawMessage, error)
+	// prepareReplay cleans up after a crashed task before the boot reconcile
+	// replays it; nil means backup.PrepareReplay. Overridable in tests.
+	prepareReplay func(context.Context, *store.Store, store.Task) error
+	// cleanupOrphan removes what a crashed task that the boot reconcile fails
+	// left behind; nil means backup.CleanupOrphan. Overridable in tests.
+	cleanupOrphan func(context.Context, *store.Store, store.Task) error
 }
 
 // NewDispatcher builds the dispatcher (unbuffered worker queues sized by config).
 func NewDispatcher(st *store.Store) *Dispatcher {
-	backupWorkers := viper.GetInt("queue.numworkers") + 1
+	backupWorkers := config.GetInt("queue.numworkers") + 1
 	if backupWorkers < 1 {
 		backupWorkers = 1
 	}
-	exportWorkers := viper.GetInt("backups.export.workers")
+	exportWorkers := config.GetInt("backups.export.workers")
 	if exportWorkers < 1 {
 		exportWorkers = 1
 	}
@@ -51,9 +73,116 @@ func NewDispatcher(st *store.Store) *Dispatcher {
 		signal:        make(chan struct{}, 1),
 		backupWorkers: backupWorkers,
 		exportWorkers: exportWorkers,
+		inflight:      map[string]*inflightTask{},
+		precancelled:  map[string]int{},
+		events:        taskevent.NewHub(),
 	}
 }
 
+// Events is the hub of the running tasks' live feeds, for the admin API's
+// GET /v1/admin/tasks/{id}/events.
+func (d *Dispatcher) Events() *taskevent.Hub {
+	return d.events
+}
+
+// LastTick is when the dispatch loop last drained (zero before the first). A
+// drain blocked handing a task to a busy backup pool is waiting on the workers,
+// not stuck, so it reads as ticking now.
+func (d *Dispatcher) LastTick() time.Time {
+	if d.handingOff.Load() {
+		return time.Now()
+	}
+	if n := d.lastTick.Load(); n > 0 {
+		return time.Unix(0, n)
+	}
+	return time.Time{}
+}
+
+// TickInterval is the loop's backstop period: it drains at least this often.
+func (d *Dispatcher) TickInterval() time.Duration { return backstopInterval }
+
+// inflightTask is a running task's cancel handle. cancelled records that an
+// operator (not shutdown) cancelled it, so the worker records "cancelled" rather
+// than "failed".
+type inflightTask struct {
+	cancel    context.CancelFunc
+	cancelled bool
+}
+
+// CancelRunning interrupts a running task: its per-task context is cancelled,
+// which kills the in-flight borg exec (see borg.Repository.Ctx); the worker then
+// records a "cancelled" terminal status with the partial output, and the
+// handler's defers release the repo lock and stop the backup container. A task
+// claimed but not yet picked up by a worker is flagged so the worker skips it;
+// the flag is for that claim only, so one set just as the task finished can't
+// skip a later attempt (a retry) of it. Returns false if the task is not running on this node. Non-blocking apart from
+// one control.db read, made without d.mu so a busy database stalls only this
+// call and not the workers; it is safe to call from the HTTP handler.
+func (d *Dispatcher) CancelRunning(id string) bool {
+	if d.cancelInflight(id, 0) {
+		return true
+	}
+	tk, found, err := d.st.GetTask(context.Background(), id)
+	if err != nil {
+		jobEvent().Warn("cancel: get task", "task", id, "error", err.Error())
+		return false
+	}
+	if !found || tk.Status != store.TaskRunning {
+		return false
+	}
+	// A worker may have picked it up during the read.
+	return d.cancelInflight(id, max(tk.Attempts, 1))
+}
+
+// cancelInflight cancels id if a worker is running it; otherwise, given the
+// attempt (> 0) the task was claimed for, it flags that claim for track to
+// skip. It reports whether either happened.
+func (d *Dispatcher) cancelInflight(id string, attempt int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if t, ok := d.inflight[id]; ok {
+		t.cancelled = true
+		t.cancel()
+		return true
+	}
+	if attempt > 0 {
+		d.precancelled[id] = attempt
+	}
+	return attempt > 0
+}
+
+// track registers a task's cancel func as it starts on a worker. skip reports
+// that CancelRunning already flagged this claim of it while it sat in the queue;
+// a flag left for an earlier claim is dropped.
+func (d *Dispatcher) track(task store.Task, cancel context.CancelFunc) (t *inflightTask, skip bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t = &inflightTask{cancel: cancel}
+	if attempt, ok := d.precancelled[task.ID]; ok {
+		delete(d.precancelled, task.ID)
+		if attempt == max(task.Attempts, 1) {
+			t.cancelled = true
+			skip = true
+		}
+	}
+	d.inflight[task.ID] = t
+	return t, skip
+}
+
+func (d *Dispatcher) untrack(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.inflight, id)
+	delete(d.precancelled, id)
+}
+
+// wasCancelled reports whether CancelRunning fired for t.
+func (d *Dispatcher) wasCancelled(t *inflightTask) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return t.cancelled
+}
+
 // Signal wakes the dispatcher to drain pending tasks. Non-blocking + coalescing:
 // callers (the task-create HTTP handler, the scheduler) never block, and bursts
 // collapse into a single drain.
@@ -81,6 +210,7 @@ func (d *Dispatcher) Start(ctx context.Context, wg *sync.WaitGroup) {
 
 func (d *Dispatcher) startWorkers(ctx context.Context, wg *sync.WaitGroup, name string, count int, q <-chan store.Task) {
 	wg.Add(count)
+	poolWorkers.Set(float64(count), name)
 	for i := 1; i <= count; i++ {
 		jobEvent().Info("Starting worker process", "queue", name, "worker-process", i)
 		go d.worker(ctx, wg, name, q)
@@ -107,31 +237,88 @@ func (d *Dispatcher) loop(ctx context.Context, wg *sync.WaitGroup) {
 	}
 }
 
-// drain claims and dispatches every pending task for this node. It is the ONLY
-// claimer/dispatcher. Exports are dispatched first (non-blocking) so a full backup
-// queue can't head-of-line-block an export; backups then send blocking (the
-// workers are the throughput limiter).
+// drain claims and dispatches every pending task for this node, in the store's
+// claim order (priority, then per-project round-robin). It is the ONLY
+// claimer/dispatcher. A task past its deadline is expired rather than dispatched.
+// Exports are dispatched first (non-blocking) so a full backup queue can't
+// head-of-line-block an export; backups then send blocking (the workers are the
+// throughput limiter). While blocked on a busy backup pool the drain's snapshot
+// goes stale, so a wake signal arriving mid-drain ends it and re-arms: the next
+// drain re-reads the queue and a just-submitted restore overtakes the rest of a
+// cron wave instead of waiting behind it. Task chains are settled first, so a
+// step released by its predecessor's completion is dispatched in the same drain.
 func (d *Dispatcher) drain(ctx context.Context) {
-	pending, err := d.st.ListPendingTasks(ctx)
+	d.lastTick.Store(time.Now().UnixNano())
+	if _, aborted, err := d.st.SettleChains(ctx); err != nil {
+		jobEvent().Warn("dispatch: settle task chains", "error", err.Error())
+	} else {
+		for _, id := range aborted {
+			d.events.End(id, store.TaskCancelled)
+		}
+	}
+	listed, err := d.st.ListPendingTasks(ctx)
 	if err != nil {
 		jobEvent().Warn("dispatch: list pending tasks", "error", err.Error())
 		return
 	}
+	now := time.Now().Unix()
+	pending := listed[:0]
+	for _, task := range listed {
+		if task.Deadline > 0 && now >= task.Deadline {
+			d.expire(ctx, task)
+			continue
+		}
+		pending = append(pending, task)
+	}
+	depth := map[string]int{store.QueueBackup: 0, store.QueueExport: 0}
+	for _, task := range pending {
+		depth[store.TaskQueue(task.Name)]++
+	}
+	for queue, n := range depth {
+		queueDepth.Set(float64(n), queue)
+	}
 	for _, task := range pending {
 		if ctx.Err() != nil {
 			return
 		}
-		if task.Name == "backup.export" {
+		if store.TaskQueue(task.Name) == store.QueueExport {
 			d.dispatchExport(ctx, task)
 		}
 	}
+	dispatched := false
 	for _, task := range pending {
 		if ctx.Err() != nil {
 			return
 		}
-		if task.Name != "backup.export" {
-			d.dispatchBackup(ctx, task)
+		if store.TaskQueue(task.Name) != store.QueueBackup {
+			continue
+		}
+		if dispatched { // always make progress before yielding to a re-drain
+			select {
+			case <-d.signal:
+				d.Signal() // new work arrived: re-drain from a fresh read
+				return
+			default:
+			}
 		}
+		d.dispatchBackup(ctx, task)
+		dispatched = true
+	}
+}
+
+// expire records a pending task whose deadline passed as expired.
+func (d *Dispatcher) expire(ctx context.Context, task store.Task) {
+	reason, _ := json.Marshal(map[string]string{"error": "deadline passed before the task was dispatched"})
+	expired, err := d.st.ExpirePendingTask(ctx, task.ID, reason)
+	if err != nil {
+		jobEvent().Warn("dispatch: expire task", "task", task.ID, "error", err.Error())
+		return
+	}
+	if expired {
+		jobEvent().Warn("task expired before dispatch", "task", task.ID, "kind", task.Name, "deadline", task.Deadline)
+		observeTask(task, store.TaskExpired, time.Time{})
+		d.events.End(task.ID, store.TaskExpired) // a subscriber waiting for it to start, or a retry
+		d.Signal()                               // abort its chain steps on the next drain
 	}
 }
 
@@ -147,6 +334,9 @@ func (d *Dispatcher) dispatchBackup(ctx context.Context, task store.Task) {
 	if !claimed {
 		return // already claimed/terminal
 	}
+	task.Attempts++ // mirror the claim so the worker sees this attempt's number
+	d.handingOff.Store(true)
+	defer d.handingOff.Store(false)
 	select {
 	case d.backupQ <- task:
 	case <-ctx.Done():
@@ -156,7 +346,7 @@ func (d *Dispatcher) dispatchBackup(ctx context.Context, task store.Task) {
 // dispatchExport claims then NON-BLOCKING sends to the export pool; if the pool is
 // full the claim is reverted (running->pending) so a later wake retries it. A
 // crash between claim and revert leaves the task running, which the boot reconcile
-// then fails — an export is never blindly re-run.
+// then replays or fails per the kind's replay policy.
 func (d *Dispatcher) dispatchExport(ctx context.Context, task store.Task) {
 	claimed, err := d.st.ClaimTask(ctx, task.ID)
 	if err != nil {
@@ -166,6 +356,7 @@ func (d *Dispatcher) dispatchExport(ctx context.Context, task store.Task) {
 	if !claimed {
 		return
 	}
+	task.Attempts++
 	select {
 	case d.exportQ <- task:
 	default:
@@ -175,25 +366,68 @@ func (d *Dispatcher) dispatchExport(ctx context.Context, task store.Task) {
 	}
 }
 
-// bootReconcile fails every task left "running" by a crashed process. On boot no
-// task is truly in flight, so a "running" row is orphaned work. NONE are
-// auto-replayed — destructive kinds (restore/delete/trash) must never re-run
-// unbidden, and export must never blindly re-upload; the scheduler re-fires
-// backups on their next slot; the controller re-requests the rest.
+// bootReconcile settles every task left "running" by a crashed process. On boot
+// no task is truly in flight, so a "running" row is orphaned work. A replay-safe
+// kind (tasks.replay.<kind>.max_replays > 0: by default volume.backup and
+// backup.export, which are idempotent) goes back to pending — after its leftover
+// backup container and stale borg lock are cleaned up — until its replay cap is
+// reached. Everything else is failed, after what it left behind (a partial
+// clone volume) is removed: destructive kinds (restore/delete/trash) must never
+// re-run unbidden; the controller re-requests them.
 func (d *Dispatcher) bootReconcile(ctx context.Context) {
 	running, err := d.st.ListRunningTasks(ctx)
 	if err != nil {
 		jobEvent().Warn("boot reconcile: list running tasks", "error", err.Error())
 		return
 	}
-	result, _ := json.Marshal(map[string]string{"error": "agent restarted while task was running; not auto-replayed"})
 	for _, task := range running {
+		reason := "agent restarted while task was running; not auto-replayed"
+		if limit := replayLimitFor(task.Name); limit > 0 {
+			if task.Replays < limit {
+				if d.replay(ctx, task) {
+					continue
+				}
+				reason = "agent restarted while task was running; replay cleanup failed"
+			} else {
+				reason = fmt.Sprintf("agent restarted while task was running; replay limit (%d) reached", limit)
+			}
+		}
+		cleanup := d.cleanupOrphan
+		if cleanup == nil {
+			cleanup = backup.CleanupOrphan
+		}
+		if err := cleanup(ctx, d.st, task); err != nil {
+			jobEvent().Warn("boot reconcile: orphan cleanup", "task", task.ID, "kind", task.Name, "error", err.Error())
+		}
+		result, _ := json.Marshal(map[string]string{"error": reason})
 		if err := d.st.UpdateTaskStatus(ctx, task.ID, store.TaskFailed, result); err != nil {
 			jobEvent().Warn("boot reconcile: mark failed", "task", task.ID, "error", err.Error())
 			continue
 		}
-		jobEvent().Warn("boot reconcile: failed orphaned running task", "task", task.ID, "kind", task.Name)
+		jobEvent().Warn("boot reconcile: failed orphaned running task", "task", task.ID, "kind", task.Name, "reason", reason)
+	}
+}
+
+// replay cleans up after a crashed task and puts it back to pending. Returns
+// false (the caller fails the task) if the cleanup or the requeue failed.
+func (d *Dispatcher) replay(ctx context.Context, task store.Task) bool {
+	prepare := d.prepareReplay
+	if prepare == nil {
+		prepare = backup.PrepareReplay
+	}
+	if err := prepare(ctx, d.st, task); err != nil {
+		jobEvent().Warn("boot reconcile: replay cleanup", "task", task.ID, "kind", task.Name, "error", err.Error())
+		return false
+	}
+	replayed, err := d.st.ReplayTask(ctx, task.ID)
+	if err != nil {
+		jobEvent().Warn("boot reconcile: replay task", "task", task.ID, "error", err.Error())
+		return false
+	}
+	if replayed {
+		jobEvent().Warn("boot reconcile: replaying orphaned running task", "task", task.ID, "kind", task.Name, "replay", task.Replays+1)
 	}
+	return true
 }
 
 func jobEvent() hclog.Logger {
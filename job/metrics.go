@@ -0,0 +1,35 @@
+package job
+
+import (
+	"cs-agent/metrics"
+	"cs-agent/store"
+	"time"
+)
+
+var (
+	tasksTotal = metrics.NewCounterVec("cs_agent_tasks_total",
+		"Task outcomes recorded by the dispatcher, by kind and status (retried = a transient failure sent back to pending; expired = a deadline passed before dispatch, never an export's lapsed download).",
+		"kind", "status")
+	taskDuration = metrics.NewHistogramVec("cs_agent_task_duration_seconds",
+		"Wall time of a task attempt on a worker, by kind and outcome.",
+		[]float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600, 7200, 14400},
+		"kind", "status")
+	queueDepth = metrics.NewGaugeVec("cs_agent_dispatcher_queue_depth",
+		"Pending tasks due for dispatch at the last drain, by worker pool.",
+		"queue")
+	busyWorkers = metrics.NewGaugeVec("cs_agent_dispatcher_busy_workers",
+		"Workers currently running a task, by worker pool.",
+		"queue")
+	poolWorkers = metrics.NewGaugeVec("cs_agent_dispatcher_workers",
+		"Configured workers, by worker pool.",
+		"queue")
+)
+
+// observeTask records an attempt's outcome; started is zero for a task that
+// never ran (expired), which is counted but not timed.
+func observeTask(task store.Task, status string, started time.Time) {
+	tasksTotal.Inc(task.Name, status)
+	if !started.IsZero() {
+		taskDuration.Observe(time.Since(started).Seconds(), task.Name, status)
+	}
+}
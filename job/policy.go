@@ -0,0 +1,73 @@
+package job
+
+import (
+	"cs-agent/config"
+	"time"
+)
+
+// progressInterval is how often a running task's progress snapshot is
+// persisted (tasks.progress_interval_sec); 0 disables it.
+func progressInterval() time.Duration {
+	return time.Duration(config.GetInt("tasks.progress_interval_sec")) * time.Second
+}
+
+// retryPolicy bounds the automatic retry of a task kind's transient failures
+// (backup.IsRetryable). A terminal failure is never retried, whatever the policy.
+type retryPolicy struct {
+	maxAttempts int           // total runs including the first; 1 = never retry
+	baseDelay   time.Duration // backoff before the second attempt; doubles after
+	maxDelay    time.Duration // backoff ceiling
+}
+
+// retryPolicyFor reads the kind's policy: tasks.retry.<kind>.max_attempts plus
+// the shared tasks.retry.base_delay_sec / max_delay_sec. An unset or unknown
+// kind gets one attempt (no retry).
+func retryPolicyFor(kind string) retryPolicy {
+	p := retryPolicy{
+		maxAttempts: config.GetInt("tasks.retry." + kind + ".max_attempts"),
+		baseDelay:   time.Duration(config.GetInt("tasks.retry.base_delay_sec")) * time.Second,
+		maxDelay:    time.Duration(config.GetInt("tasks.retry.max_delay_sec")) * time.Second,
+	}
+	if p.maxAttempts < 1 {
+		p.maxAttempts = 1
+	}
+	if p.baseDelay <= 0 {
+		p.baseDelay = time.Second
+	}
+	if p.maxDelay < p.baseDelay {
+		p.maxDelay = p.baseDelay
+	}
+	return p
+}
+
+// backoff is the delay after the given (1-based) failed attempt: baseDelay,
+// then doubling, capped at maxDelay.
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	d := p.baseDelay
+	for i := 1; i < attempt && d < p.maxDelay; i++ {
+		d *= 2
+	}
+	if d > p.maxDelay {
+		d = p.maxDelay
+	}
+	return d
+}
+
+// replayLimitFor is how many times a task of this kind may be put back to
+// pending after the agent died while running it (tasks.replay.<kind>.max_replays).
+// 0 — the default for any kind not configured — means it is failed instead:
+// only idempotent kinds (a backup, an export) are safe to re-run unbidden.
+func replayLimitFor(kind string) int {
+	return max(config.GetInt("tasks.replay."+kind+".max_replays"), 0)
+}
+
+// taskTimeoutFor is the kind's run-time cap (tasks.timeout.<kind>.max_runtime_sec);
+// 0 means no cap. backup.export falls back to its older, dedicated
+// backups.export.timeout_sec when no per-kind value is configured.
+func taskTimeoutFor(kind string) time.Duration {
+	key := "tasks.timeout." + kind + ".max_runtime_sec"
+	if kind == "backup.export" && !config.IsSet(key) {
+		key = "backups.export.timeout_sec"
+	}
+	return time.Duration(max(config.GetInt(key), 0)) * time.Second
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"cs-agent/backup"
 	"cs-agent/store"
+	"cs-agent/taskevent"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -22,7 +24,9 @@ func (d *Dispatcher) worker(ctx context.Context, wg *sync.WaitGroup, name string
 			jobEvent().Info("[" + name + "] Shutting down")
 			return
 		case task := <-queue:
+			busyWorkers.Add(1, name)
 			d.runTask(ctx, task)
+			busyWorkers.Add(-1, name)
 			if ctx.Err() != nil {
 				jobEvent().Info("[" + name + "] Shutdown")
 				return
@@ -37,6 +41,7 @@ func (d *Dispatcher) worker(ctx context.Context, wg *sync.WaitGroup, name string
 // task failed — the csevent CloseEvent + finalizeStuckExport that used to own this
 // are gone, so the worker owns it now.
 func (d *Dispatcher) runTask(ctx context.Context, task store.Task) {
+	started := time.Now()
 	completed := false
 	defer func() {
 		if r := recover(); r != nil {
@@ -45,38 +50,81 @@ func (d *Dispatcher) runTask(ctx context.Context, task store.Task) {
 			hub.Flush(2 * time.Second)
 			jobEvent().Error("task panicked", "task", task.ID, "kind", task.Name, "panic", fmt.Sprintf("%v", r))
 			d.markFailed(task.ID, "task panicked")
+			observeTask(task, store.TaskFailed, started)
 			return
 		}
 		if !completed {
 			// Handler returned without us recording a terminal status (should not
 			// happen) — fail closed rather than leave the task running forever.
 			d.markFailed(task.ID, "task exited without a terminal result")
+			observeTask(task, store.TaskFailed, started)
 		}
 	}()
 
-	jobEvent().Info("Processing task", "task", task.ID, "kind", task.Name)
-	run := d.runner
-	if run == nil {
-		run = backup.RunTask
+	// Each task runs under its own child context so CancelRunning can interrupt
+	// it without touching its siblings, bounded by the kind's timeout. Untrack runs
+	// before the guard above, and after the terminal write below, so a cancel never
+	// sees a stale "running".
+	taskCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	timeout := taskTimeoutFor(task.Name)
+	if timeout > 0 {
+		taskCtx, cancel = context.WithTimeout(taskCtx, timeout)
+		defer cancel()
+	}
+	tracked, skip := d.track(task, cancel)
+	defer d.untrack(task.ID)
+	events := d.events.Open(task.ID)
+	taskCtx = taskevent.NewContext(taskCtx, events)
+
+	var (
+		result json.RawMessage
+		err    error
+	)
+	if skip {
+		err = errors.New("task cancelled before it started")
+		result, _ = json.Marshal(map[string]string{"error": err.Error()})
+	} else {
+		jobEvent().Info("Processing task", "task", task.ID, "kind", task.Name)
+		run := d.runner
+		if run == nil {
+			run = backup.RunTask
+		}
+		stopProgress := d.reportProgress(taskCtx, task.ID, events)
+		result, err = run(taskCtx, d.st, task)
+		stopProgress()
 	}
-	result, err := run(ctx, d.st, task)
 	status := store.TaskCompleted
-	if err != nil {
+	switch {
+	case err != nil && d.wasCancelled(tracked):
+		// A task that finished before the cancel landed keeps its true outcome;
+		// only an interrupted (errored) one is recorded as cancelled.
+		status = store.TaskCancelled
+		jobEvent().Info("task cancelled", "task", task.ID, "kind", task.Name)
+	case err != nil && errors.Is(taskCtx.Err(), context.DeadlineExceeded):
+		status = store.TaskTimedOut
+		jobEvent().Warn("task timed out", "task", task.ID, "kind", task.Name, "timeout", timeout.String())
+	case err != nil:
 		status = store.TaskFailed
 		jobEvent().Warn("task failed", "task", task.ID, "kind", task.Name, "error", err.Error())
 	}
 	// Record the terminal status on a fresh context, not the worker ctx: a task
 	// that finished right as shutdown cancelled ctx must still be recorded with its
 	// true outcome (not failed by the guard) — important for the never-replayed
-	// kinds (restore/delete/export/trash) where a false failure needs a manual
+	// kinds (restore/delete/trash) where a false failure needs a manual
 	// re-request.
 	writeCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
+	if status == store.TaskFailed && ctx.Err() == nil && backup.IsRetryable(err) && d.retryTask(writeCtx, task, result) {
+		completed = true
+		observeTask(task, "retried", started)
+		return
+	}
 	uErr := d.st.UpdateTaskStatus(writeCtx, task.ID, status, result)
 	if uErr != nil {
 		// One retry: a transient control.db write failure must not turn a real
-		// success into a false failure on the never-replay kinds (restore/export/
-		// delete/trash), where the guard's fallback would need a manual re-request.
+		// success into a false failure on the never-replay kinds (restore/delete/
+		// trash), where the guard's fallback would need a manual re-request.
 		jobEvent().Warn("record task status failed; retrying once", "task", task.ID, "error", uErr.Error())
 		uErr = d.st.UpdateTaskStatus(writeCtx, task.ID, status, result)
 	}
@@ -85,6 +133,103 @@ func (d *Dispatcher) runTask(ctx context.Context, task store.Task) {
 		return // leave completed=false so the guard marks it failed
 	}
 	completed = true
+	observeTask(task, status, started)
+	events.End(status)
+	d.Signal() // release (or abort) any chain step waiting on this task
+}
+
+// retryTask schedules another attempt of a transiently-failed task if its kind's
+// policy allows one: the task goes back to pending, held for the backoff, and the
+// dispatcher is woken once it is due. Returns false when the attempts are spent
+// (or the retry could not be recorded), leaving the caller to record the failure.
+func (d *Dispatcher) retryTask(ctx context.Context, task store.Task, result json.RawMessage) bool {
+	policy := retryPolicyFor(task.Name)
+	attempt := max(task.Attempts, 1)
+	if attempt >= policy.maxAttempts {
+		if policy.maxAttempts > 1 {
+			jobEvent().Warn("task retries exhausted", "task", task.ID, "kind", task.Name, "attempts", attempt)
+		}
+		return false
+	}
+	delay := policy.backoff(attempt)
+	retried, err := d.st.RetryTask(ctx, task.ID, time.Now().Add(delay).Unix(), result)
+	if err != nil {
+		jobEvent().Warn("record task retry failed", "task", task.ID, "error", err.Error())
+		return false
+	}
+	if !retried {
+		// No longer running: something else already moved it; nothing to record,
+		// but its feed must still end with whatever status that was.
+		if cur, ok, err := d.st.GetTask(ctx, task.ID); err == nil && ok && store.TaskTerminal(cur.Status) {
+			d.events.End(task.ID, cur.Status)
+		}
+		return true
+	}
+	jobEvent().Warn("task failed transiently; retry scheduled", "task", task.ID, "kind", task.Name, "attempt", attempt, "max_attempts", policy.maxAttempts, "delay", delay.String())
+	d.events.Open(task.ID).Step(fmt.Sprintf("attempt %d failed transiently; retrying in %s", attempt, delay))
+	time.AfterFunc(delay, d.Signal)
+	return true
+}
+
+// reportProgress persists the task's progress snapshot every
+// tasks.progress_interval_sec while it runs, sampled from the latest progress
+// record on its event stream (borg progress, S3 upload bytes) — sampling on an
+// interval is what keeps progress from flooding the changelog. The returned
+// stop ends the reporter and waits for it.
+func (d *Dispatcher) reportProgress(ctx context.Context, id string, events *taskevent.Stream) (stop func()) {
+	interval := progressInterval()
+	if interval <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		var (
+			prev     taskevent.Event
+			havePrev bool
+		)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-tick.C:
+			}
+			cur, ok := events.LatestProgress()
+			if !ok || (havePrev && cur.Seq == prev.Seq) {
+				continue // nothing new since the last snapshot
+			}
+			if _, err := d.st.UpdateTaskProgress(ctx, id, progressSnapshot(cur, prev, havePrev)); err != nil && ctx.Err() == nil {
+				jobEvent().Warn("record task progress failed", "task", id, "error", err.Error())
+			}
+			prev, havePrev = cur, true
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
+
+// progressSnapshot converts a progress record into the persisted snapshot. The
+// ETA is projected from the rate since the previous sample of the same phase,
+// and only when the phase knows its total.
+func progressSnapshot(cur, prev taskevent.Event, havePrev bool) store.TaskProgress {
+	p := store.TaskProgress{
+		Phase:      cur.Phase,
+		BytesDone:  cur.Bytes,
+		BytesTotal: cur.Total,
+		UpdatedAt:  cur.Time / 1000,
+	}
+	if havePrev && prev.Phase == cur.Phase && cur.Total > cur.Bytes && cur.Bytes > prev.Bytes && cur.Time > prev.Time {
+		rate := float64(cur.Bytes-prev.Bytes) / (float64(cur.Time-prev.Time) / 1000) // bytes/s
+		p.ETASec = int64(float64(cur.Total-cur.Bytes) / rate)
+	}
+	return p
 }
 
 // markFailed records a failed terminal status on a background context (the
@@ -93,5 +238,8 @@ func (d *Dispatcher) markFailed(id, reason string) {
 	result, _ := json.Marshal(map[string]string{"error": reason})
 	if err := d.st.UpdateTaskStatus(context.Background(), id, store.TaskFailed, result); err != nil {
 		jobEvent().Warn("failed to mark task failed", "task", id, "error", err.Error())
+		return
 	}
+	d.events.End(id, store.TaskFailed)
+	d.Signal()
 }
@@ -4,11 +4,14 @@ import (
 	"context"
 	"cs-agent/backup"
 	"cs-agent/config"
+	"cs-agent/containermgr"
+	"cs-agent/exportdest"
 	"cs-agent/firewall"
 	"cs-agent/httpapi"
 	"cs-agent/job"
 	"cs-agent/log"
-	"cs-agent/s3upload"
+	"cs-agent/metrics"
+	"cs-agent/sdnotify"
 	"cs-agent/store"
 	"errors"
 	"flag"
@@ -16,12 +19,13 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/getsentry/sentry-go"
-	"github.com/spf13/viper"
+	"github.com/hashicorp/go-hclog"
 )
 
 // Build-info, injected at release time via GoReleaser ldflags
@@ -35,11 +39,15 @@ var (
 
 func main() {
 	showVersion := flag.Bool("version", false, "print version information and exit")
+	flag.Usage = usage
 	flag.Parse()
 	if *showVersion {
 		fmt.Printf("cs-agent %s (commit %s, built %s)\n", version, commit, date)
 		return
 	}
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(flag.Args()))
+	}
 
 	config.ConfigureApp()
 	configureSentry(version)
@@ -48,7 +56,7 @@ func main() {
 
 	// Open the embedded data plane. control.db is the sole source of truth for
 	// coordination state now (no Consul); opening it also runs migrations.
-	st, err := store.Open(viper.GetString("store.data_dir"), store.Options{})
+	st, err := store.Open(config.GetString("store.data_dir"), store.Options{})
 	if err != nil {
 		log.New().Error("Failed to open control store", "error", err.Error())
 		sentry.CaptureException(err)
@@ -64,24 +72,48 @@ func main() {
 	// control.db retention runs unconditionally (independent of backups.enabled).
 	housekeeper := backup.NewHousekeeper(st)
 	var scheduler *backup.Scheduler
-	if viper.GetBool("backups.enabled") {
+	if config.GetBool("backups.enabled") {
 		scheduler = backup.NewScheduler(st, dispatcher.Signal)
 	}
 
-	// Customer-metadata + admin HTTP front door. Reconcile hooks wake the
-	// in-process consumers after a controller DOWN write (all non-blocking).
+	// Maintenance runs on the scheduler, so "run now" is only offered with it.
+	var runMaintenance func(string) (bool, error)
+	if scheduler != nil {
+		runMaintenance = func(name string) (bool, error) { return scheduler.RunNow(ctx, name) }
+	}
+
+	// Customer-metadata + admin HTTP front door, plus the root-only admin socket.
+	// Reconcile hooks wake the in-process consumers after a controller DOWN write
+	// (all non-blocking).
+	httpLog := log.New() // kept so a reload can retune its level
 	srv := httpapi.New(httpapi.Config{
-		ListenAddr:        viper.GetString("metadata.listen_addr"),
-		AdminTokenHash:    viper.GetString("metadata.admin_token_hash"),
-		MaxBodyBytes:      viper.GetInt64("metadata.max_body_bytes"),
-		OnTaskCreated:     dispatcher.Signal,
-		OnFirewallChanged: fwReconciler.Signal,
+		ListenAddr:          config.GetString("metadata.listen_addr"),
+		AdminTokenHash:      config.GetString("metadata.admin_token_hash"),
+		MaxBodyBytes:        config.GetInt64("metadata.max_body_bytes"),
+		AdminSocket:         config.GetString("metadata.admin_socket"),
+		ActionsBurst:        config.GetFloat64("metadata.actions_rate_limit.burst"),
+		ActionsRefillPerSec: config.GetFloat64("metadata.actions_rate_limit.refill_per_sec"),
+		OnTaskCreated:       dispatcher.Signal,
+		OnTaskCancel:        dispatcher.CancelRunning,
+		TaskEvents:          dispatcher.Events(),
+		OnFirewallChanged:   fwReconciler.Signal,
 		OnVolumesChanged: func() {
 			if scheduler != nil {
 				scheduler.ReconcileSignal()
 			}
 		},
-	}, st, log.New())
+		ExportDownload: func(token string, claim bool) (*os.File, func(bool), error) {
+			dest := exportdest.NewLocal(exportdest.LocalConfigFromViper())
+			if claim {
+				return dest.Claim(token)
+			}
+			f, err := dest.Open(token)
+			return f, func(bool) {}, err
+		},
+		ReadyChecks:    readyChecks(st, dispatcher, fwReconciler, scheduler),
+		RunMaintenance: runMaintenance,
+		ConfigDump:     func() map[string]any { return config.Redacted(config.Current()) },
+	}, st, httpLog)
 
 	// Start order: components (dispatcher runs its boot crash-reconcile before
 	// accepting work) → then the HTTP front door LAST, so the DOWN surface only
@@ -96,34 +128,90 @@ func main() {
 		go func() { defer wg.Done(); scheduler.Run(ctx) }()
 	}
 
+	// Bind before telling systemd we're ready, so READY=1 means the front door
+	// holds its port. A failed bind leaves the agent un-ready; under Type=notify
+	// systemd's start timeout then restarts it.
+	if err := srv.Listen(); err != nil {
+		log.New().Error("metadata HTTP server could not bind", "addr", config.GetString("metadata.listen_addr"), "error", err.Error())
+		sentry.CaptureException(err)
+	} else {
+		go func() {
+			if err := srv.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.New().Error("metadata HTTP server stopped", "error", err.Error())
+				sentry.CaptureException(err)
+			}
+		}()
+		notifySystemd(sdnotify.Ready)
+	}
+	// The admin socket is operator tooling, not part of readiness: a failed bind
+	// is logged and the agent runs without it.
+	if err := srv.ListenAdminSocket(); err != nil {
+		log.New().Error("admin socket could not bind", "error", err.Error())
+		sentry.CaptureException(err)
+	} else {
+		go func() {
+			if err := srv.ServeAdminSocket(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.New().Error("admin socket stopped", "error", err.Error())
+				sentry.CaptureException(err)
+			}
+		}()
+	}
+	metricsSrv := startMetricsServer(config.GetString("metrics.listen_addr"), st)
+
+	// Liveness supervisor: pet systemd's watchdog only while every in-process
+	// loop is making progress, so a wedged loop gets the agent restarted.
+	loops := map[string]ticker{"dispatcher": dispatcher, "firewall reconciler": fwReconciler}
+	if scheduler != nil {
+		loops["scheduler"] = scheduler
+	}
+	wg.Add(1)
 	go func() {
-		if err := srv.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			log.New().Error("metadata HTTP server stopped", "error", err.Error())
-			sentry.CaptureException(err)
-		}
+		defer wg.Done()
+		sdnotify.RunWatchdog(ctx, func() error {
+			for name, t := range loops {
+				if _, err := tickHealth(t); err != nil {
+					return fmt.Errorf("%s: %w", name, err)
+				}
+			}
+			return nil
+		}, func(err error) {
+			log.New().Warn("Watchdog not petted: loop stalled", "error", err.Error())
+		})
 	}()
 
 	log.New().Info("Agent Configuration", "environment", config.ReleaseEnvironment())
-	log.New().Info("Agent Configuration", "backupWorkers", viper.GetInt("queue.numworkers")+1)
+	log.New().Info("Agent Configuration", "backupWorkers", config.GetInt("queue.numworkers")+1)
 	log.New().Info("Agent Configuration", "backingFS", currentBackupMethod())
 
-	// Ordered shutdown. On SIGTERM/SIGINT:
+	// Ordered shutdown. On SIGTERM/SIGINT (after telling systemd STOPPING=1):
 	//  1. Shut the HTTP DOWN surface first (drain in-flight customer requests;
 	//     they use the store) so a late DOWN write can't signal a reconciler we're
 	//     about to stop. In-process signals are non-blocking, so this order is safe.
 	//  2. Cancel ctx → dispatcher/workers/scheduler/firewall observe it and drain.
 	//  3. Bounded wait for the workers/loops to finish an in-flight job.
 	//  4. Close the store (nothing uses it after the workers stop).
+	// SIGHUP reloads agent.yml in place (see reloadConfig) instead of stopping.
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
-	<-sig
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	for s := range sig {
+		if s != syscall.SIGHUP {
+			break
+		}
+		reloadConfig(srv, httpLog, housekeeper, scheduler)
+	}
 	log.New().Info("Shutdown signal received, draining")
+	notifySystemd(sdnotify.Stopping)
 
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownCancel()
 	if err := srv.Shutdown(shutdownCtx); err != nil {
 		log.New().Warn("metadata server shutdown", "error", err)
 	}
+	if metricsSrv != nil {
+		if err := metricsSrv.Shutdown(shutdownCtx); err != nil {
+			log.New().Warn("metrics server shutdown", "error", err)
+		}
+	}
 
 	cancel()
 	waitWorkers(&wg, 25*time.Second)
@@ -152,33 +240,195 @@ func waitWorkers(wg *sync.WaitGroup, timeout time.Duration) {
 	}
 }
 
+// reloadConfig re-reads and validates agent.yml (SIGHUP). An invalid file is
+// rejected whole and the running config kept. A valid one is applied: the log
+// level, the maintenance and housekeeping crons, S3 export settings (read per
+// job) and the actions rate limit take effect live; changed settings that are
+// only read at startup are logged as needing a restart.
+func reloadConfig(srv *httpapi.Server, httpLog hclog.Logger, housekeeper *backup.Housekeeper, scheduler *backup.Scheduler) {
+	notifySystemd(sdnotify.Reloading)
+	defer notifySystemd(sdnotify.Ready)
+
+	changed, err := config.Reload()
+	if err != nil {
+		log.New().Error("Config reload rejected; keeping the running config", "error", err.Error())
+		return
+	}
+	if len(changed) == 0 {
+		log.New().Info("Config reloaded; nothing changed")
+		return
+	}
+	httpLog.SetLevel(hclog.LevelFromString(config.GetString("log.level")))
+	srv.SetActionsRateLimit(config.GetFloat64("metadata.actions_rate_limit.burst"), config.GetFloat64("metadata.actions_rate_limit.refill_per_sec"))
+	housekeeper.Reload()
+	if scheduler != nil {
+		scheduler.Reload()
+	}
+	validateExportConfig()
+
+	var restart []string
+	for _, key := range changed {
+		if config.RestartRequired(key) {
+			restart = append(restart, key)
+		}
+	}
+	// Key names only: values may be secrets.
+	log.New().Info("Config reloaded", "changed", strings.Join(changed, ","))
+	if len(restart) > 0 {
+		log.New().Warn("Config changes need a restart to take effect", "keys", strings.Join(restart, ","))
+	}
+}
+
+// ticker is an in-process loop that records when it last ran.
+type ticker interface {
+	LastTick() time.Time
+	TickInterval() time.Duration
+}
+
+// tickHealth reports whether a loop is making progress: it has ticked within
+// three of its intervals (one slow pass is not an outage). Shared by /readyz and
+// the watchdog supervisor.
+func tickHealth(t ticker) (detail string, err error) {
+	last := t.LastTick()
+	if last.IsZero() {
+		return "", errors.New("has not ticked yet")
+	}
+	age := time.Since(last).Round(time.Second)
+	if age > 3*t.TickInterval() {
+		return "", fmt.Errorf("last tick %s ago (interval %s)", age, t.TickInterval())
+	}
+	return fmt.Sprintf("last tick %s ago", age), nil
+}
+
+// notifySystemd sends a service state to systemd (a no-op outside a
+// Type=notify unit).
+func notifySystemd(state string) {
+	if _, err := sdnotify.Notify(state); err != nil {
+		log.New().Warn("sd_notify failed", "state", state, "error", err.Error())
+	}
+}
+
+// readyChecks builds the components GET /readyz reports on; the scheduler is
+// only checked when backups are enabled.
+func readyChecks(st *store.Store, dispatcher *job.Dispatcher, fw *firewall.Reconciler, scheduler *backup.Scheduler) []httpapi.ReadyCheck {
+	tickCheck := func(name string, t ticker) httpapi.ReadyCheck {
+		return httpapi.ReadyCheck{Name: name, Check: func(context.Context) (string, error) {
+			return tickHealth(t)
+		}}
+	}
+	sentinelCheck := func(name, domain string) httpapi.ReadyCheck {
+		return httpapi.ReadyCheck{Name: name, Check: func(ctx context.Context) (string, error) {
+			populated, err := st.IsPopulated(ctx, domain)
+			if err != nil {
+				return "", err
+			}
+			if !populated {
+				return "", errors.New("not yet populated by the controller")
+			}
+			return "populated", nil
+		}}
+	}
+	checks := []httpapi.ReadyCheck{
+		{Name: "control_db", Check: func(ctx context.Context) (string, error) {
+			return "", st.Ping(ctx)
+		}},
+		{Name: "docker", Check: func(ctx context.Context) (string, error) {
+			v, err := containermgr.Ping(ctx)
+			if err != nil {
+				return "", err
+			}
+			return "api " + v, nil
+		}},
+		tickCheck("dispatcher", dispatcher),
+		tickCheck("firewall_reconciler", fw),
+	}
+	if scheduler != nil {
+		checks = append(checks, tickCheck("scheduler", scheduler))
+	}
+	return append(checks,
+		sentinelCheck("firewall_populated", store.MetaFirewallPopulated),
+		sentinelCheck("volumes_populated", store.MetaVolumesPopulated),
+	)
+}
+
+// startMetricsServer registers the scrape-time store gauges and serves
+// GET /metrics on addr in its own goroutine. Returns nil when addr is empty
+// (metrics disabled).
+func startMetricsServer(addr string, st *store.Store) *http.Server {
+	if addr == "" {
+		return nil
+	}
+	metrics.NewGaugeFunc("cs_agent_store_pool_open_handles",
+		"Per-project DB handles the store's LRU pool holds open.", nil,
+		func() []metrics.Sample {
+			return []metrics.Sample{{Value: float64(st.OpenProjectDBs())}}
+		})
+	metrics.NewGaugeFunc("cs_agent_repository_size_on_disk_bytes",
+		"A borg repository's size on disk as last reported, by volume.", []string{"volume"},
+		func() []metrics.Sample {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			sizes, err := st.RepositorySizes(ctx)
+			if err != nil {
+				log.New().Warn("metrics: repository sizes", "error", err.Error())
+				return nil
+			}
+			samples := make([]metrics.Sample, 0, len(sizes))
+			for name, size := range sizes {
+				samples = append(samples, metrics.Sample{LabelValues: []string{name}, Value: float64(size)})
+			}
+			return samples
+		})
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Handler())
+	ms := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		log.New().Info("metrics HTTP server listening", "addr", addr)
+		if err := ms.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.New().Error("metrics HTTP server stopped", "error", err.Error())
+			sentry.CaptureException(err)
+		}
+	}()
+	return ms
+}
+
 func currentBackupMethod() string {
-	if viper.GetBool("backups.borg.ssh.enabled") {
+	if config.GetBool("backups.borg.ssh.enabled") {
 		return "ssh"
-	} else if viper.GetBool("backups.borg.nfs") {
+	} else if config.GetBool("backups.borg.nfs") {
 		return "nfs"
 	} else {
 		return "local"
 	}
 }
 
-// validateExportConfig loudly surfaces export misconfiguration at startup. It
-// does not abort the agent (export jobs fail individually if misconfigured), but
-// the no-compactor combination is dangerous enough to flag prominently: with
-// export enabled and the host compact cron retired, an empty compact_freq means
-// nothing ever compacts repos and they grow unbounded.
+// validateExportConfig loudly surfaces export misconfiguration at startup (and
+// on reload). It does not abort the agent (export jobs fail individually if
+// misconfigured); `cs-agent config validate` reports the same problems.
 func validateExportConfig() {
-	if viper.GetString("backups.export.s3.bucket") == "" {
-		return // export disabled (no bucket)
+	for _, err := range exportConfigProblems() {
+		log.New().Error("Invalid backups.export configuration", "error", err.Error())
+		sentry.CaptureException(err)
 	}
-	if viper.GetString("backups.compact_freq") == "" {
-		log.New().Error("backups.export is enabled but backups.compact_freq is empty: with the host compact cron retired, nothing compacts repositories and they will grow unbounded. Set backups.compact_freq.")
-		sentry.CaptureMessage("backup export enabled with compaction disabled (no compactor)")
+}
+
+// exportConfigProblems checks the export settings of the global config. The
+// no-compactor combination is dangerous enough to flag: with export enabled and
+// the host compact cron retired, an empty compact_freq means nothing ever
+// compacts repos and they grow unbounded.
+func exportConfigProblems() []error {
+	if exportdest.Kind() == exportdest.KindS3 && config.GetString("backups.export.s3.bucket") == "" {
+		return nil // export disabled (no bucket)
 	}
-	if err := s3upload.ConfigFromViper().Validate(0); err != nil {
-		log.New().Error("Invalid backups.export.s3 configuration", "error", err.Error())
-		sentry.CaptureException(err)
+	var problems []error
+	if config.GetString("backups.compact_freq") == "" {
+		problems = append(problems, errors.New("backups.compact_freq: empty while backups.export is enabled; with the host compact cron retired nothing compacts repositories and they grow unbounded"))
+	}
+	if _, err := exportdest.FromViper(); err != nil {
+		problems = append(problems, err)
 	}
+	return problems
 }
 
 /*
@@ -194,7 +444,7 @@ func configureSentry(v string) {
 	env := config.ReleaseEnvironment()
 	hostname, _ := os.Hostname()
 	err := sentry.Init(sentry.ClientOptions{
-		Dsn:              viper.GetString("sentry.dsn"),
+		Dsn:              config.GetString("sentry.dsn"),
 		Environment:      env,
 		Debug:            env != "production",
 		ServerName:       hostname,
@@ -0,0 +1,389 @@
+// Package metrics is the agent's Prometheus exposition: counters, gauges and
+// histograms (optionally labelled) rendered in the Prometheus text format by
+// Handler. The instrumented packages declare their metrics as package-level vars
+// (NewCounterVec & co register them with the default registry); state that is
+// cheaper to read than to track — open pool handles, repository sizes — is
+// sampled at scrape time through NewGaugeFunc. main serves Handler on its own
+// listener (metrics.listen_addr), off the customer-reachable :8500 mux.
+//
+// It is deliberately small rather than a dependency on the Prometheus client:
+// no exemplars, summaries or protobuf, just what the agent exports.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Sample is one series of a NewGaugeFunc gauge: its label values (in the
+// gauge's label order) and value.
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+// Registry holds metric families in registration order. The zero value is not
+// usable; build with NewRegistry.
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+	names    map[string]bool
+}
+
+type family interface {
+	write(w *bufio.Writer)
+}
+
+// NewRegistry builds an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{names: map[string]bool{}}
+}
+
+// Default is the registry the package-level constructors and Handler use.
+var Default = NewRegistry()
+
+// register adds f under name; a duplicate name is a programming error.
+func (r *Registry) register(name string, f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.names[name] = true
+	r.families = append(r.families, f)
+}
+
+// WriteTo renders every family in the Prometheus text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := slices.Clone(r.families)
+	r.mu.Unlock()
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		f.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler serves the registry's exposition.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = r.WriteTo(w)
+	})
+}
+
+// Handler serves the default registry.
+func Handler() http.Handler { return Default.Handler() }
+
+// NewCounterVec registers a counter with the default registry.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+// NewGaugeVec registers a gauge with the default registry.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return Default.NewGaugeVec(name, help, labels...)
+}
+
+// NewHistogramVec registers a histogram with the default registry.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labels...)
+}
+
+// NewGaugeFunc registers a scrape-time gauge with the default registry.
+func NewGaugeFunc(name, help string, labels []string, collect func() []Sample) {
+	Default.NewGaugeFunc(name, help, labels, collect)
+}
+
+// meta is a family's name, help and label names.
+type meta struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (m meta) header(w *bufio.Writer, typ string) {
+	help := strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(m.help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, help, m.name, typ)
+}
+
+// labelPairs renders {a="x",b="y"} for values (plus any extra pre-rendered
+// pair, e.g. a histogram's le); "" when there are none.
+func (m meta) labelPairs(values []string, extra string) string {
+	if len(m.labels) == 0 && extra == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, l := range m.labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(values[i]))
+		b.WriteByte('"')
+	}
+	if extra != "" {
+		if len(m.labels) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(extra)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func (m meta) check(values []string) {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", m.name, len(m.labels), len(values)))
+	}
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// seriesKey joins label values into a map key.
+func seriesKey(values []string) string { return strings.Join(values, "\xff") }
+
+// valueVec is the shared series map of counters and gauges.
+type valueVec struct {
+	meta
+	typ    string
+	mu     sync.Mutex
+	series map[string]*valueSeries
+}
+
+type valueSeries struct {
+	values []string
+	v      float64
+}
+
+func newValueVec(name, help, typ string, labels []string) *valueVec {
+	v := &valueVec{meta: meta{name: name, help: help, labels: labels}, typ: typ, series: map[string]*valueSeries{}}
+	if len(labels) == 0 {
+		v.series[""] = &valueSeries{} // an unlabelled metric reads 0 before its first update
+	}
+	return v
+}
+
+func (v *valueVec) update(values []string, fn func(float64) float64) {
+	v.check(values)
+	key := seriesKey(values)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = &valueSeries{values: slices.Clone(values)}
+		v.series[key] = s
+	}
+	s.v = fn(s.v)
+}
+
+func (v *valueVec) value(values []string) float64 {
+	v.check(values)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok := v.series[seriesKey(values)]; ok {
+		return s.v
+	}
+	return 0
+}
+
+func (v *valueVec) write(w *bufio.Writer) {
+	v.header(w, v.typ)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, key := range sortedKeys(v.series) {
+		s := v.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", v.name, v.labelPairs(s.values, ""), formatFloat(s.v))
+	}
+}
+
+// CounterVec is a monotonically increasing counter per label set.
+type CounterVec struct{ vec *valueVec }
+
+// NewCounterVec registers a counter with r.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec: newValueVec(name, help, "counter", labels)}
+	r.register(name, c.vec)
+	return c
+}
+
+// Inc adds one to the series of labelValues.
+func (c *CounterVec) Inc(labelValues ...string) { c.Add(1, labelValues...) }
+
+// Add adds delta (which must not be negative) to the series of labelValues.
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("metrics: counter " + c.vec.name + " cannot decrease")
+	}
+	c.vec.update(labelValues, func(v float64) float64 { return v + delta })
+}
+
+// Value returns the series of labelValues (0 if it has never been updated).
+func (c *CounterVec) Value(labelValues ...string) float64 { return c.vec.value(labelValues) }
+
+// GaugeVec is a value that goes up and down, per label set.
+type GaugeVec struct{ vec *valueVec }
+
+// NewGaugeVec registers a gauge with r.
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{vec: newValueVec(name, help, "gauge", labels)}
+	r.register(name, g.vec)
+	return g
+}
+
+// Set sets the series of labelValues to v.
+func (g *GaugeVec) Set(v float64, labelValues ...string) {
+	g.vec.update(labelValues, func(float64) float64 { return v })
+}
+
+// Add adds delta (possibly negative) to the series of labelValues.
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.vec.update(labelValues, func(v float64) float64 { return v + delta })
+}
+
+// Value returns the series of labelValues (0 if it has never been set).
+func (g *GaugeVec) Value(labelValues ...string) float64 { return g.vec.value(labelValues) }
+
+// HistogramVec counts observations into cumulative buckets per label set.
+type HistogramVec struct {
+	meta
+	buckets []float64 // upper bounds, ascending; +Inf is implicit
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec registers a histogram with r. buckets are the upper bounds,
+// in ascending order.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if !slices.IsSorted(buckets) {
+		panic("metrics: histogram " + name + " buckets are not sorted")
+	}
+	h := &HistogramVec{meta: meta{name: name, help: help, labels: labels}, buckets: buckets, series: map[string]*histogramSeries{}}
+	r.register(name, h)
+	return h
+}
+
+// Observe records v in the series of labelValues.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.check(labelValues)
+	key := seriesKey(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{values: slices.Clone(labelValues), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i, _ := slices.BinarySearch(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+}
+
+// Count returns how many observations the series of labelValues has.
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	h.check(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.series[seriesKey(labelValues)]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.header(w, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cum uint64
+		for i, le := range h.buckets {
+			cum += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(s.values, `le="`+formatFloat(le)+`"`), cum)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(s.values, `le="+Inf"`), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(s.values, ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(s.values, ""), s.count)
+	}
+}
+
+// gaugeFunc is a gauge whose series are collected at scrape time.
+type gaugeFunc struct {
+	meta
+	collect func() []Sample
+}
+
+// NewGaugeFunc registers a gauge with r whose series collect returns on every
+// scrape. collect runs on the scrape goroutine, so it should be cheap.
+func (r *Registry) NewGaugeFunc(name, help string, labels []string, collect func() []Sample) {
+	r.register(name, &gaugeFunc{meta: meta{name: name, help: help, labels: labels}, collect: collect})
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	g.header(w, "gauge")
+	samples := g.collect()
+	slices.SortFunc(samples, func(a, b Sample) int {
+		return strings.Compare(seriesKey(a.LabelValues), seriesKey(b.LabelValues))
+	})
+	for _, s := range samples {
+		if len(s.LabelValues) != len(g.labels) {
+			continue // a malformed sample is dropped rather than failing the scrape
+		}
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelPairs(s.LabelValues, ""), formatFloat(s.Value))
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
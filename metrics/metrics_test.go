@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func render(t *testing.T, r *Registry) string {
+	t.Helper()
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestRegistry_CounterAndGauge(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("jobs_total", "Jobs run.", "kind", "status")
+	c.Inc("volume.backup", "completed")
+	c.Add(2, "volume.backup", "completed")
+	c.Inc("backup.export", "failed")
+	g := r.NewGaugeVec("depth", "Queue depth.")
+	g.Set(4)
+	g.Add(-1)
+
+	want := `# HELP jobs_total Jobs run.
+# TYPE jobs_total counter
+jobs_total{kind="backup.export",status="failed"} 1
+jobs_total{kind="volume.backup",status="completed"} 3
+# HELP depth Queue depth.
+# TYPE depth gauge
+depth 3
+`
+	if got := render(t, r); got != want {
+		t.Fatalf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+	if c.Value("volume.backup", "completed") != 3 || c.Value("volume.backup", "failed") != 0 || g.Value() != 3 {
+		t.Fatal("Value does not match the exposition")
+	}
+}
+
+func TestRegistry_Histogram(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+	h.Observe(0.05, "GET /x")
+	h.Observe(0.5, "GET /x")
+	h.Observe(3, "GET /x")
+
+	want := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="GET /x",le="0.1"} 1
+latency_seconds_bucket{route="GET /x",le="1"} 2
+latency_seconds_bucket{route="GET /x",le="+Inf"} 3
+latency_seconds_sum{route="GET /x"} 3.55
+latency_seconds_count{route="GET /x"} 3
+`
+	if got := render(t, r); got != want {
+		t.Fatalf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+	if h.Count("GET /x") != 3 {
+		t.Fatalf("count = %d", h.Count("GET /x"))
+	}
+}
+
+func TestRegistry_GaugeFuncAndEscaping(t *testing.T) {
+	r := NewRegistry()
+	r.NewGaugeFunc("size_bytes", "Size,\nper volume.", []string{"volume"}, func() []Sample {
+		return []Sample{
+			{LabelValues: []string{`b"\`}, Value: 2},
+			{LabelValues: []string{"a"}, Value: 1},
+			{Value: 9}, // wrong arity: dropped
+		}
+	})
+	want := `# HELP size_bytes Size,\nper volume.
+# TYPE size_bytes gauge
+size_bytes{volume="a"} 1
+size_bytes{volume="b\"\\"} 2
+`
+	if got := render(t, r); got != want {
+		t.Fatalf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("up_total", "Up.").Inc()
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("content type = %q", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "up_total 1\n") {
+		t.Fatalf("body = %q", rec.Body.String())
+	}
+}
+
+func TestRegistry_Misuse(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("dup_total", "Dup.", "kind")
+	for name, fn := range map[string]func(){
+		"duplicate name":   func() { r.NewGaugeVec("dup_total", "Again.") },
+		"label arity":      func() { c.Inc() },
+		"counter decrease": func() { c.Add(-1, "x") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s did not panic", name)
+				}
+			}()
+			fn()
+		}()
+	}
+}
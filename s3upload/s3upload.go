@@ -10,6 +10,7 @@ package s3upload
 
 import (
 	"context"
+	"cs-agent/config"
 	"errors"
 	"fmt"
 	"io"
@@ -21,7 +22,6 @@ import (
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
-	"github.com/spf13/viper"
 )
 
 // s3MaxParts is S3's hard cap on multipart parts; part size must leave room for
@@ -48,18 +48,18 @@ type Config struct {
 // ConfigFromViper reads the backups.export.s3.* keys.
 func ConfigFromViper() Config {
 	return Config{
-		Endpoint:       viper.GetString("backups.export.s3.endpoint"),
-		Region:         viper.GetString("backups.export.s3.region"),
-		Bucket:         viper.GetString("backups.export.s3.bucket"),
-		Prefix:         viper.GetString("backups.export.s3.prefix"),
-		AccessKey:      viper.GetString("backups.export.s3.access_key"),
-		SecretKey:      viper.GetString("backups.export.s3.secret_key"),
-		ForcePathStyle: viper.GetBool("backups.export.s3.force_path_style"),
-		PartSizeMB:     viper.GetInt("backups.export.s3.part_size_mb"),
-		Concurrency:    viper.GetInt("backups.export.s3.concurrency"),
-		SSE:            viper.GetString("backups.export.s3.sse"),
-		DefaultTTL:     time.Duration(viper.GetInt("backups.export.s3.default_ttl_sec")) * time.Second,
-		MaxTTL:         time.Duration(viper.GetInt("backups.export.s3.max_ttl_sec")) * time.Second,
+		Endpoint:       config.GetString("backups.export.s3.endpoint"),
+		Region:         config.GetString("backups.export.s3.region"),
+		Bucket:         config.GetString("backups.export.s3.bucket"),
+		Prefix:         config.GetString("backups.export.s3.prefix"),
+		AccessKey:      config.GetString("backups.export.s3.access_key"),
+		SecretKey:      config.GetString("backups.export.s3.secret_key"),
+		ForcePathStyle: config.GetBool("backups.export.s3.force_path_style"),
+		PartSizeMB:     config.GetInt("backups.export.s3.part_size_mb"),
+		Concurrency:    config.GetInt("backups.export.s3.concurrency"),
+		SSE:            config.GetString("backups.export.s3.sse"),
+		DefaultTTL:     time.Duration(config.GetInt("backups.export.s3.default_ttl_sec")) * time.Second,
+		MaxTTL:         time.Duration(config.GetInt("backups.export.s3.max_ttl_sec")) * time.Second,
 	}
 }
 
@@ -150,8 +150,9 @@ func New(cfg Config) (*Uploader, error) {
 	return &Uploader{cfg: cfg, client: client, uploader: uploader}, nil
 }
 
-// objectKey joins the configured prefix with key.
-func (u *Uploader) objectKey(key string) string {
+// ObjectKey joins the configured prefix with key: the full object key an export
+// records and DeleteObject takes.
+func (u *Uploader) ObjectKey(key string) string {
 	return u.cfg.Prefix + key
 }
 
@@ -162,7 +163,7 @@ func (u *Uploader) Upload(ctx context.Context, key string, r io.Reader) (int64,
 	cr := &countingReader{r: r}
 	in := &s3.PutObjectInput{
 		Bucket: aws.String(u.cfg.Bucket),
-		Key:    aws.String(u.objectKey(key)),
+		Key:    aws.String(u.ObjectKey(key)),
 		Body:   cr,
 	}
 	if u.cfg.SSE != "" {
@@ -179,7 +180,7 @@ func (u *Uploader) PresignGet(ctx context.Context, key string, ttl time.Duration
 	ps := s3.NewPresignClient(u.client)
 	req, err := ps.PresignGetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(u.cfg.Bucket),
-		Key:    aws.String(u.objectKey(key)),
+		Key:    aws.String(u.ObjectKey(key)),
 	}, s3.WithPresignExpires(ttl))
 	if err != nil {
 		return "", time.Time{}, err
@@ -187,6 +188,35 @@ func (u *Uploader) PresignGet(ctx context.Context, key string, ttl time.Duration
 	return req.URL, time.Now().Add(ttl), nil
 }
 
+// Reference is PresignGet under the name export destinations share: S3's
+// retrievable reference to an export is its presigned GET URL.
+func (u *Uploader) Reference(ctx context.Context, key string, ttl time.Duration) (string, time.Time, error) {
+	return u.PresignGet(ctx, key, ttl)
+}
+
+// DeleteObject removes the object at objectKey, which is the FULL key (prefix
+// included) as an export records it, not a key relative to the prefix: the
+// prefix may have changed since the object was written. Deleting an absent
+// object is not an error.
+func (u *Uploader) DeleteObject(ctx context.Context, objectKey string) error {
+	_, err := u.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(u.cfg.Bucket),
+		Key:    aws.String(objectKey),
+	})
+	return err
+}
+
+// IsRetryable reports whether err carries an S3 server-side (5xx) response —
+// an endpoint outage or SlowDown that a later attempt can be expected to clear.
+// 4xx responses (auth, missing bucket, bad request) are terminal.
+func IsRetryable(err error) bool {
+	var re interface{ HTTPStatusCode() int }
+	if errors.As(err, &re) {
+		return re.HTTPStatusCode() >= 500
+	}
+	return false
+}
+
 // countingReader counts bytes read so the caller can record the uploaded size.
 type countingReader struct {
 	r io.Reader
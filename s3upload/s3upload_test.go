@@ -2,6 +2,8 @@ package s3upload
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"strings"
 	"testing"
 	"time"
@@ -96,3 +98,26 @@ func TestPresignGet(t *testing.T) {
 		t.Error("expected a non-zero expiry time")
 	}
 }
+
+type statusErr int
+
+func (e statusErr) Error() string       { return "http status" }
+func (e statusErr) HTTPStatusCode() int { return int(e) }
+
+func TestIsRetryable(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{fmt.Errorf("upload: %w", statusErr(503)), true},
+		{statusErr(500), true},
+		{statusErr(403), false},
+		{errors.New("plain"), false},
+		{nil, false},
+	}
+	for _, tc := range cases {
+		if got := IsRetryable(tc.err); got != tc.want {
+			t.Errorf("IsRetryable(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Package sdnotify speaks systemd's service notification protocol natively: a
+// datagram of newline-separated KEY=VALUE assignments sent to the unix socket
+// in $NOTIFY_SOCKET. The unit runs Type=notify with WatchdogSec (see
+// packaging/cs-agent.service): main sends READY=1 once the agent is serving,
+// RELOADING=1 (then READY=1) around a SIGHUP config reload, STOPPING=1 when it
+// begins its ordered shutdown, and Watchdog pets systemd's
+// watchdog only while the in-process loops are making progress, so a wedged
+// loop gets the agent restarted. Outside systemd (no $NOTIFY_SOCKET) every call
+// is a no-op.
+package sdnotify
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// States the agent sends.
+const (
+	Ready     = "READY=1"
+	Reloading = "RELOADING=1"
+	Stopping  = "STOPPING=1"
+	Watchdog  = "WATCHDOG=1"
+)
+
+// Notify sends state to systemd. sent=false (with a nil error) when the process
+// was not started with a notification socket.
+func Notify(state string) (sent bool, err error) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return false, nil
+	}
+	if path[0] == '@' {
+		path = "\x00" + path[1:] // abstract namespace
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WatchdogInterval is systemd's watchdog timeout for this process ($WATCHDOG_USEC),
+// or 0 when the watchdog is not enabled for it.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0 // meant for another process
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// RunWatchdog pets the watchdog at half its interval until ctx is done, but only
+// while healthy returns nil; a non-nil error is passed to onStall and the pet
+// skipped, so systemd restarts the agent once the stall outlasts WatchdogSec.
+// It returns at once when the watchdog is not enabled.
+func RunWatchdog(ctx context.Context, healthy func() error, onStall func(error)) {
+	interval := WatchdogInterval()
+	if interval <= 0 {
+		return
+	}
+	t := time.NewTicker(interval / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := healthy(); err != nil {
+				onStall(err)
+				continue
+			}
+			_, _ = Notify(Watchdog)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package sdnotify
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// listen binds a notification socket and points $NOTIFY_SOCKET at it.
+func listen(t *testing.T) *net.UnixConn {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+func read(t *testing.T, conn *net.UnixConn, within time.Duration) (string, bool) {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(within))
+	buf := make([]byte, 256)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return "", false
+	}
+	return string(buf[:n]), true
+}
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := Notify(Ready); sent || err != nil {
+		t.Fatalf("without a socket: sent %v err %v", sent, err)
+	}
+	conn := listen(t)
+	if sent, err := Notify(Ready); !sent || err != nil {
+		t.Fatalf("sent %v err %v", sent, err)
+	}
+	if got, ok := read(t, conn, time.Second); !ok || got != "READY=1" {
+		t.Fatalf("received %q", got)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	if WatchdogInterval() != 0 {
+		t.Fatal("interval without WATCHDOG_USEC")
+	}
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", "")
+	if got := WatchdogInterval(); got != 30*time.Second {
+		t.Fatalf("interval = %s", got)
+	}
+	t.Setenv("WATCHDOG_PID", "1")
+	if WatchdogInterval() != 0 {
+		t.Fatal("interval for another pid's watchdog")
+	}
+}
+
+func TestRunWatchdog_PetsOnlyWhileHealthy(t *testing.T) {
+	conn := listen(t)
+	t.Setenv("WATCHDOG_USEC", strconv.Itoa(int((40 * time.Millisecond).Microseconds())))
+	t.Setenv("WATCHDOG_PID", "")
+
+	healthy := make(chan error, 1)
+	healthy <- nil
+	stalls := make(chan error, 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		RunWatchdog(ctx, func() error {
+			select {
+			case err := <-healthy:
+				return err
+			default:
+				return errors.New("dispatcher stalled")
+			}
+		}, func(err error) { stalls <- err })
+	}()
+	defer func() { cancel(); <-done }()
+
+	if got, ok := read(t, conn, time.Second); !ok || got != "WATCHDOG=1" {
+		t.Fatalf("first pet: %q", got)
+	}
+	select {
+	case err := <-stalls:
+		if err.Error() != "dispatcher stalled" {
+			t.Fatalf("stall = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stall not reported")
+	}
+	if got, ok := read(t, conn, 60*time.Millisecond); ok {
+		t.Fatalf("petted while stalled: %q", got)
+	}
+}
@@ -116,13 +116,21 @@ func SSHCommandBool(command string, sci ServerConnInfo) (bool, error) {
 }
 
 func SSHCommandString(command string, sci ServerConnInfo) (string, error) {
+	stdout, _, err := SSHCommandOutput(command, sci)
+	return stdout, err
+}
+
+// SSHCommandOutput is SSHCommandString that also returns the remote stderr on
+// success, for commands that report there (borg logs, its stats included, go to
+// stderr).
+func SSHCommandOutput(command string, sci ServerConnInfo) (stdout, stderr string, err error) {
 	session, conn, err := generateSession(sci)
 	if err != nil {
 		if conn != nil {
 			_ = conn.Close()
 		}
 
-		return "", err
+		return "", "", err
 	}
 
 	var stdoutBuf, stderrBuf bytes.Buffer
@@ -138,9 +146,9 @@ func SSHCommandString(command string, sci ServerConnInfo) (string, error) {
 		// Surface remote stderr (and the non-zero exit) so failures in commands
 		// like `borg compact` / `chown` are diagnosable rather than opaque.
 		if stderr := strings.TrimSpace(stderrBuf.String()); stderr != "" {
-			return "", fmt.Errorf("%w: %s", err, stderr)
+			return "", "", fmt.Errorf("%w: %s", err, stderr)
 		}
-		return "", err
+		return "", "", err
 	}
-	return strings.TrimSuffix(stdoutBuf.String(), "\n"), nil
+	return strings.TrimSuffix(stdoutBuf.String(), "\n"), stderrBuf.String(), nil
 }
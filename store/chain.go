@@ -0,0 +1,218 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// A task chain is a task plus dependent steps that run after it, in order —
+// e.g. a volume.backup followed by a backup.export of the archive it created.
+// The steps are ordinary task rows, created up front so the controller can
+// follow each by id: the head is pending, every later step is waiting with
+// after_id naming the step before it. SettleChains moves a waiting step on once
+// the step it waits on is terminal: released to pending (inheriting the
+// archive) on completion, otherwise cancelled — a failure anywhere
+// short-circuits the rest of the chain.
+
+// CreateTaskChain inserts a chain (steps[0] is the head) and, in the SAME
+// transaction, appends each step's changelog row. Like CreateTask it is
+// idempotent on the head's id: if the head already exists nothing is inserted
+// and created=false. A later step whose id repeats another step's or is already
+// taken fails the whole chain with ErrInvalidChain. Callers set id, name and
+// node on every step; status, after_id and timestamps are assigned here.
+func (s *Store) CreateTaskChain(ctx context.Context, steps []Task) (created bool, err error) {
+	if len(steps) == 0 {
+		return false, errors.New("store: CreateTaskChain requires at least one step")
+	}
+	seen := make(map[string]bool, len(steps))
+	for _, t := range steps {
+		if t.ID == "" || t.Name == "" || t.Node == "" {
+			return false, errors.New("store: CreateTaskChain requires id, name, node on every step")
+		}
+		if seen[t.ID] {
+			return false, fmt.Errorf("%w: duplicate step id %q", ErrInvalidChain, t.ID)
+		}
+		seen[t.ID] = true
+	}
+
+	now := time.Now().Unix()
+	err = s.withControlTx(ctx, func(tx *sql.Tx) error {
+		for i, t := range steps {
+			t.Status = TaskPending
+			if i > 0 {
+				t.Status = TaskWaiting
+				t.AfterID = steps[i-1].ID
+			}
+			if t.Priority == 0 {
+				t.Priority = DefaultPriority(t.Name)
+			}
+			t.CreatedAt = now
+			t.UpdatedAt = now
+			snapshot, err := json.Marshal(t)
+			if err != nil {
+				return fmt.Errorf("store: marshal task %q: %w", t.ID, err)
+			}
+			ok, err := insertTaskTx(ctx, tx, t, snapshot)
+			if err != nil {
+				return err
+			}
+			switch {
+			case !ok && i == 0:
+				return nil // duplicate head: the chain already exists
+			case !ok:
+				return fmt.Errorf("%w: step id %q already exists", ErrInvalidChain, t.ID)
+			}
+		}
+		created = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return created, nil
+}
+
+// SettleChains moves on every waiting chain step whose predecessor is terminal,
+// in one transaction (each transition changelogged): after a completed
+// predecessor the step goes pending, taking the predecessor's archive if it
+// named none (see chainArchive); after any other outcome — or if the
+// predecessor row is gone — the step is cancelled with the reason in its result,
+// and so, transitively, is every step after it. Returns how many steps were
+// released and the ids of those cancelled. The dispatcher calls it at the top
+// of each drain.
+func (s *Store) SettleChains(ctx context.Context) (released int, aborted []string, err error) {
+	now := time.Now().Unix()
+	err = s.withControlTx(ctx, func(tx *sql.Tx) error {
+		released, aborted = 0, nil
+		for {
+			n, ids, changed, err := settleChainsPassTx(ctx, tx, now)
+			if err != nil {
+				return err
+			}
+			released += n
+			aborted = append(aborted, ids...)
+			if !changed {
+				return nil
+			}
+		}
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return released, aborted, nil
+}
+
+// settleChainsPassTx settles each waiting step against its predecessor's
+// current row. An abort can unblock the step after it, so SettleChains repeats
+// passes until one changes nothing.
+func settleChainsPassTx(ctx context.Context, tx *sql.Tx, now int64) (released int, aborted []string, changed bool, err error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT `+taskColumns+` FROM tasks WHERE status = ? ORDER BY created_at, id`, TaskWaiting)
+	if err != nil {
+		return 0, nil, false, fmt.Errorf("store: list waiting tasks: %w", err)
+	}
+	waiting, err := collectTasks(rows)
+	if err != nil {
+		return 0, nil, false, err
+	}
+	for _, t := range waiting {
+		var prev Task
+		gone := t.AfterID == ""
+		if !gone {
+			prev, err = getTaskTx(ctx, tx, t.AfterID)
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				gone = true
+			case err != nil:
+				return 0, nil, false, fmt.Errorf("store: get task %q: %w", t.AfterID, err)
+			}
+		}
+		switch {
+		case gone:
+			reason := fmt.Sprintf("chain aborted: task %q no longer exists", t.AfterID)
+			if err := abortChainStepTx(ctx, tx, t, reason, now); err != nil {
+				return 0, nil, false, err
+			}
+			aborted = append(aborted, t.ID)
+		case prev.Status == TaskCompleted:
+			archive := t.Archive
+			if archive == "" {
+				archive = chainArchive(prev)
+			}
+			if archive == "" && prev.Name == "volume.backup" {
+				// Released without one, the step would run against whatever
+				// archive is newest — not the backup it was chained to.
+				if err := abortChainStepTx(ctx, tx, t, "chain aborted: previous backup reported no archive", now); err != nil {
+					return 0, nil, false, err
+				}
+				aborted = append(aborted, t.ID)
+				break
+			}
+			if err := moveChainStepTx(ctx, tx, t.ID, TaskPending, `, archive = ?`, now, nullable(archive)); err != nil {
+				return 0, nil, false, err
+			}
+			released++
+		case prev.Status == TaskPending || prev.Status == TaskRunning || prev.Status == TaskWaiting:
+			continue
+		default:
+			reason := fmt.Sprintf("chain aborted: task %q %s", prev.ID, prev.Status)
+			if err := abortChainStepTx(ctx, tx, t, reason, now); err != nil {
+				return 0, nil, false, err
+			}
+			aborted = append(aborted, t.ID)
+		}
+		changed = true
+	}
+	return released, aborted, changed, nil
+}
+
+// chainArchive is the archive a completed step hands to the step after it: the
+// name its result reports (a volume.backup records the archive borg actually
+// created — its own Archive column is only the unexpanded prefix), otherwise
+// the archive it ran against. "" for a backup that reported none, which
+// aborts the step.
+func chainArchive(prev Task) string {
+	var res struct {
+		Archive string `json:"archive"`
+	}
+	if len(prev.Result) > 0 && json.Unmarshal(prev.Result, &res) == nil && res.Archive != "" {
+		return res.Archive
+	}
+	if prev.Name == "volume.backup" {
+		return ""
+	}
+	return prev.Archive
+}
+
+func abortChainStepTx(ctx context.Context, tx *sql.Tx, t Task, reason string, now int64) error {
+	result, err := json.Marshal(map[string]string{"error": reason})
+	if err != nil {
+		return fmt.Errorf("store: marshal task %q: %w", t.ID, err)
+	}
+	return moveChainStepTx(ctx, tx, t.ID, TaskCancelled, `, result_json = ?`, now, string(result))
+}
+
+// moveChainStepTx flips a waiting step to status (extraSet as in casTaskStatus)
+// and appends the resulting snapshot.
+func moveChainStepTx(ctx context.Context, tx *sql.Tx, id, status, extraSet string, now int64, extraArgs ...any) error {
+	args := append([]any{status, now}, extraArgs...)
+	args = append(args, id, TaskWaiting)
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE tasks SET status = ?, updated_at = ?`+extraSet+` WHERE id = ? AND status = ?`,
+		args...); err != nil {
+		return fmt.Errorf("store: settle chain step %q: %w", id, err)
+	}
+	t, err := getTaskTx(ctx, tx, id)
+	if err != nil {
+		return fmt.Errorf("store: reload task %q: %w", id, err)
+	}
+	snapshot, err := json.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("store: marshal task %q: %w", id, err)
+	}
+	return appendChangelogTx(ctx, tx, "task", t.ID, t.ProjectID, "upsert", snapshot, now)
+}
@@ -0,0 +1,192 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func chainSteps() []Task {
+	return []Task{
+		{ID: "c1", ProjectID: "p1", Name: "volume.backup", Node: "n", Volume: "vol"},
+		{ID: "c2", ProjectID: "p1", Name: "backup.export", Node: "n", Volume: "vol"},
+		{ID: "c3", ProjectID: "p1", Name: "backup.delete", Node: "n", Volume: "vol"},
+	}
+}
+
+func mustTask(t *testing.T, s *Store, id string) Task {
+	t.Helper()
+	tk, found, err := s.GetTask(ctx, id)
+	if err != nil || !found {
+		t.Fatalf("GetTask(%s) = found %v, %v", id, found, err)
+	}
+	return tk
+}
+
+func TestCreateTaskChain(t *testing.T) {
+	s := open(t, Options{})
+	created, err := s.CreateTaskChain(ctx, chainSteps())
+	if err != nil || !created {
+		t.Fatalf("CreateTaskChain = %v, %v; want true", created, err)
+	}
+	for _, want := range []struct{ id, status, after string }{
+		{"c1", TaskPending, ""},
+		{"c2", TaskWaiting, "c1"},
+		{"c3", TaskWaiting, "c2"},
+	} {
+		if tk := mustTask(t, s, want.id); tk.Status != want.status || tk.AfterID != want.after {
+			t.Fatalf("%s: status %q after %q; want %q after %q", want.id, tk.Status, tk.AfterID, want.status, want.after)
+		}
+	}
+	if n := len(mustSince(t, s, 0, "task", 100)); n != 3 {
+		t.Fatalf("changelog rows = %d, want 3", n)
+	}
+
+	// A retried POST (same head id) is a no-op.
+	if created, err := s.CreateTaskChain(ctx, chainSteps()); err != nil || created {
+		t.Fatalf("duplicate CreateTaskChain = %v, %v; want false", created, err)
+	}
+	if n := len(mustSince(t, s, 0, "task", 100)); n != 3 {
+		t.Fatalf("changelog rows after duplicate = %d, want 3", n)
+	}
+
+	// A step reusing an existing id rolls back the whole chain.
+	_, err = s.CreateTaskChain(ctx, []Task{
+		{ID: "d1", Name: "volume.backup", Node: "n"},
+		{ID: "c2", Name: "backup.export", Node: "n"},
+	})
+	if !errors.Is(err, ErrInvalidChain) {
+		t.Fatalf("taken step id: err = %v, want ErrInvalidChain", err)
+	}
+	if _, found, _ := s.GetTask(ctx, "d1"); found {
+		t.Fatal("head of a rejected chain was inserted")
+	}
+	_, err = s.CreateTaskChain(ctx, []Task{
+		{ID: "e1", Name: "volume.backup", Node: "n"},
+		{ID: "e1", Name: "backup.export", Node: "n"},
+	})
+	if !errors.Is(err, ErrInvalidChain) {
+		t.Fatalf("repeated step id: err = %v, want ErrInvalidChain", err)
+	}
+}
+
+// TestSettleChains_ReleasesWithArchive proves a step waits for its predecessor
+// and, once it completes, is released with the archive the backup created.
+func TestSettleChains_ReleasesWithArchive(t *testing.T) {
+	s := open(t, Options{})
+	if _, err := s.CreateTaskChain(ctx, chainSteps()); err != nil {
+		t.Fatal(err)
+	}
+	if n, _, err := s.SettleChains(ctx); err != nil || n != 0 {
+		t.Fatalf("SettleChains with head pending = %d, %v; want 0", n, err)
+	}
+	if _, err := s.ClaimTask(ctx, "c1"); err != nil {
+		t.Fatal(err)
+	}
+	if n, _, _ := s.SettleChains(ctx); n != 0 {
+		t.Fatalf("SettleChains with head running released %d", n)
+	}
+	if err := s.UpdateTaskStatus(ctx, "c1", TaskCompleted, json.RawMessage(`{"archive":"manual-m-2026-10-17T12:00:00","last_backup":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if n, _, err := s.SettleChains(ctx); err != nil || n != 1 {
+		t.Fatalf("SettleChains = %d, %v; want 1", n, err)
+	}
+	if tk := mustTask(t, s, "c2"); tk.Status != TaskPending || tk.Archive != "manual-m-2026-10-17T12:00:00" {
+		t.Fatalf("c2 after release: status %q archive %q", tk.Status, tk.Archive)
+	}
+	if tk := mustTask(t, s, "c3"); tk.Status != TaskWaiting {
+		t.Fatalf("c3 status = %q, want waiting", tk.Status)
+	}
+
+	// The export carries the archive on to the next step.
+	if _, err := s.ClaimTask(ctx, "c2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateTaskStatus(ctx, "c2", TaskCompleted, json.RawMessage(`{"url":"https://example/x"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if n, _, _ := s.SettleChains(ctx); n != 1 {
+		t.Fatalf("second SettleChains released %d, want 1", n)
+	}
+	if tk := mustTask(t, s, "c3"); tk.Status != TaskPending || tk.Archive != "manual-m-2026-10-17T12:00:00" {
+		t.Fatalf("c3 after release: status %q archive %q", tk.Status, tk.Archive)
+	}
+}
+
+// TestSettleChains_BackupWithoutArchive proves a step after a backup that
+// completed without reporting its archive is aborted rather than released to
+// run against whichever archive is newest.
+func TestSettleChains_BackupWithoutArchive(t *testing.T) {
+	s := open(t, Options{})
+	if _, err := s.CreateTaskChain(ctx, chainSteps()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ClaimTask(ctx, "c1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateTaskStatus(ctx, "c1", TaskCompleted, json.RawMessage(`{"last_backup":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if n, aborted, err := s.SettleChains(ctx); err != nil || n != 0 || !slices.Equal(aborted, []string{"c2", "c3"}) {
+		t.Fatalf("SettleChains = %d, %v, %v; want 0 released, [c2 c3] aborted", n, aborted, err)
+	}
+	tk := mustTask(t, s, "c2")
+	var res map[string]string
+	_ = json.Unmarshal(tk.Result, &res)
+	if tk.Status != TaskCancelled || res["error"] != "chain aborted: previous backup reported no archive" {
+		t.Fatalf("c2: status %q result %s", tk.Status, tk.Result)
+	}
+}
+
+// TestSettleChains_FailureShortCircuits proves a failed step cancels every
+// step after it, each with the reason, and that cancelling a waiting step
+// cancels the steps behind it too.
+func TestSettleChains_FailureShortCircuits(t *testing.T) {
+	s := open(t, Options{})
+	if _, err := s.CreateTaskChain(ctx, chainSteps()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ClaimTask(ctx, "c1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateTaskStatus(ctx, "c1", TaskFailed, json.RawMessage(`{"error":"boom"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if n, aborted, err := s.SettleChains(ctx); err != nil || n != 0 || !slices.Equal(aborted, []string{"c2", "c3"}) {
+		t.Fatalf("SettleChains = %d, %v, %v; want 0 released, [c2 c3] aborted", n, aborted, err)
+	}
+	for id, reason := range map[string]string{
+		"c2": `chain aborted: task "c1" failed`,
+		"c3": `chain aborted: task "c2" cancelled`,
+	} {
+		tk := mustTask(t, s, id)
+		var res map[string]string
+		_ = json.Unmarshal(tk.Result, &res)
+		if tk.Status != TaskCancelled || res["error"] != reason {
+			t.Fatalf("%s: status %q result %s; want cancelled %q", id, tk.Status, tk.Result, reason)
+		}
+	}
+
+	// Cancelling a waiting step directly.
+	steps := chainSteps()
+	for i := range steps {
+		steps[i].ID = "x" + steps[i].ID
+	}
+	if _, err := s.CreateTaskChain(ctx, steps); err != nil {
+		t.Fatal(err)
+	}
+	if cancelled, err := s.CancelPendingTask(ctx, "xc2"); err != nil || !cancelled {
+		t.Fatalf("CancelPendingTask(waiting) = %v, %v; want true", cancelled, err)
+	}
+	if _, _, err := s.SettleChains(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if tk := mustTask(t, s, "xc3"); tk.Status != TaskCancelled {
+		t.Fatalf("xc3 status = %q, want cancelled", tk.Status)
+	}
+	if tk := mustTask(t, s, "xc1"); tk.Status != TaskPending {
+		t.Fatalf("xc1 status = %q, want pending", tk.Status)
+	}
+}
@@ -129,3 +129,23 @@ func (s *Store) ChangelogSince(ctx context.Context, since int64, entityType stri
 	}
 	return out, nil
 }
+
+// ChangelogTail returns the last n changelog rows (only entityType's, when
+// non-empty), oldest first — the operator CLI's `changelog tail`. A non-positive
+// n is clamped to defaultChangelogPull.
+func (s *Store) ChangelogTail(ctx context.Context, entityType string, n int) ([]ChangelogEntry, error) {
+	if n <= 0 {
+		n = defaultChangelogPull
+	}
+	var since sql.NullInt64
+	if err := s.control.QueryRowContext(ctx, `
+		SELECT min(seq) - 1 FROM (
+			SELECT seq FROM changelog WHERE ? = '' OR entity_type = ? ORDER BY seq DESC LIMIT ?)`,
+		entityType, entityType, n).Scan(&since); err != nil {
+		return nil, fmt.Errorf("store: changelog tail: %w", err)
+	}
+	if !since.Valid {
+		return nil, nil // empty
+	}
+	return s.ChangelogSince(ctx, since.Int64, entityType, n)
+}
@@ -235,6 +235,38 @@ func TestChangelogSince_PaginationAndFilter(t *testing.T) {
 	}
 }
 
+func TestChangelogTail(t *testing.T) {
+	s := open(t, Options{})
+	if tail, err := s.ChangelogTail(ctx, "", 10); err != nil || len(tail) != 0 {
+		t.Fatalf("empty tail = %v, %v", tail, err)
+	}
+	for i := 1; i <= 4; i++ {
+		if _, err := s.CreateActionRequest(ctx, fmt.Sprintf("a-%d", i), "proj-1", "cdn_purge", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.withControlTx(ctx, func(tx *sql.Tx) error {
+		return appendChangelogTx(ctx, tx, "other", "o-1", "proj-1", "upsert", []byte(`{}`), 1)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tail, err := s.ChangelogTail(ctx, "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tail) != 2 || tail[0].EntityID != "a-4" || tail[1].EntityID != "o-1" {
+		t.Fatalf("tail = %+v", tail)
+	}
+	tail, err = s.ChangelogTail(ctx, "action_request", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tail) != 3 || tail[0].EntityID != "a-2" || tail[2].EntityID != "a-4" {
+		t.Fatalf("filtered tail = %+v", tail)
+	}
+}
+
 func mustSince(t *testing.T, s *Store, since int64, entityType string, limit int) []ChangelogEntry {
 	t.Helper()
 	got, err := s.ChangelogSince(ctx, since, entityType, limit)
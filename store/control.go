@@ -158,6 +158,106 @@ var controlMigrations = []migration{
 			return err
 		},
 	},
+	{
+		version: 5,
+		up: func(tx *sql.Tx) error {
+			// Admin task read API (GET /v1/admin/tasks). The list is newest-first
+			// keyset-paginated on (created_at, id); each filter column gets an
+			// index leading with it so a filtered page is a range scan rather than
+			// a full-table walk on a node with a long task history.
+			_, err := tx.Exec(`
+				CREATE INDEX idx_tasks_created         ON tasks(created_at, id);
+				CREATE INDEX idx_tasks_status_created  ON tasks(status, created_at, id);
+				CREATE INDEX idx_tasks_name_created    ON tasks(name, created_at, id);
+				CREATE INDEX idx_tasks_volume_created  ON tasks(volume, created_at, id);
+				CREATE INDEX idx_tasks_project_created ON tasks(project_id, created_at, id);
+			`)
+			return err
+		},
+	},
+	{
+		version: 6,
+		up: func(tx *sql.Tx) error {
+			// Automatic retry of transient task failures. attempts counts runs
+			// (bumped on claim); next_attempt_at holds a retried task back in
+			// pending until its backoff elapses (NULL = due now). Both ride in the
+			// task changelog snapshot so the controller sees every attempt.
+			_, err := tx.Exec(`
+				ALTER TABLE tasks ADD COLUMN attempts        INTEGER NOT NULL DEFAULT 0;
+				ALTER TABLE tasks ADD COLUMN next_attempt_at INTEGER;
+			`)
+			return err
+		},
+	},
+	{
+		version: 7,
+		up: func(tx *sql.Tx) error {
+			// Boot replay of replay-safe kinds: replays counts how many times a
+			// task was put back to pending after the agent died while it ran, so
+			// the per-kind cap stops a task that crashes the agent from looping.
+			_, err := tx.Exec(`ALTER TABLE tasks ADD COLUMN replays INTEGER NOT NULL DEFAULT 0;`)
+			return err
+		},
+	},
+	{
+		version: 8,
+		up: func(tx *sql.Tx) error {
+			// Optional controller-supplied dispatch deadline (unix seconds; NULL =
+			// none): a task still pending past it is expired, never dispatched.
+			_, err := tx.Exec(`ALTER TABLE tasks ADD COLUMN deadline INTEGER;`)
+			return err
+		},
+	},
+	{
+		version: 9,
+		up: func(tx *sql.Tx) error {
+			// Task priorities (store/queue.go): the pending drain orders by
+			// priority first. Rows from before this migration are treated as
+			// manual work.
+			_, err := tx.Exec(`
+				ALTER TABLE tasks ADD COLUMN priority INTEGER NOT NULL DEFAULT 30;
+				CREATE INDEX idx_tasks_status_priority ON tasks(status, priority DESC, created_at, id);
+			`)
+			return err
+		},
+	},
+	{
+		version: 10,
+		up: func(tx *sql.Tx) error {
+			// Task chains (store/chain.go): after_id is the step a waiting task
+			// runs after; NULL for a task that is not a later chain step.
+			_, err := tx.Exec(`
+				ALTER TABLE tasks ADD COLUMN after_id TEXT;
+				CREATE INDEX idx_tasks_after_id ON tasks(after_id) WHERE after_id IS NOT NULL;
+			`)
+			return err
+		},
+	},
+	{
+		version: 11,
+		up: func(tx *sql.Tx) error {
+			// A running task's last progress snapshot (JSON TaskProgress; NULL
+			// until the first one), refreshed by the worker on an interval.
+			_, err := tx.Exec(`ALTER TABLE tasks ADD COLUMN progress_json TEXT;`)
+			return err
+		},
+	},
+	{
+		version: 12,
+		up: func(tx *sql.Tx) error {
+			// The latest borg check of each repository (NULL until one has
+			// run), and when a --verify-data check last ran, which paces that
+			// slower mode.
+			_, err := tx.Exec(`
+				ALTER TABLE repositories ADD COLUMN check_at          INTEGER;
+				ALTER TABLE repositories ADD COLUMN check_verify_data INTEGER;
+				ALTER TABLE repositories ADD COLUMN check_ok          INTEGER;
+				ALTER TABLE repositories ADD COLUMN check_findings    TEXT;
+				ALTER TABLE repositories ADD COLUMN verified_at       INTEGER;
+			`)
+			return err
+		},
+	},
 }
 
 // ErrTenantExists is returned when UpsertTenant would collide on token_hash with
@@ -241,6 +341,28 @@ func (s *Store) TenantByProjectID(ctx context.Context, projectID string) (t Tena
 	}
 }
 
+// ListTenants returns every tenant row, ordered by project id.
+func (s *Store) ListTenants(ctx context.Context) ([]Tenant, error) {
+	rows, err := s.control.QueryContext(ctx,
+		`SELECT project_id, token_hash, status, created_at, updated_at FROM tenants ORDER BY project_id`)
+	if err != nil {
+		return nil, fmt.Errorf("store: list tenants: %w", err)
+	}
+	defer rows.Close()
+	var out []Tenant
+	for rows.Next() {
+		var t Tenant
+		if err := rows.Scan(&t.ProjectID, &t.TokenHash, &t.Status, &t.CreatedAt, &t.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("store: scan tenant row: %w", err)
+		}
+		out = append(out, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: iterate tenants: %w", err)
+	}
+	return out, nil
+}
+
 // DeleteTenant removes the tenant mapping for projectID. Deleting an absent
 // project is a no-op (no error). This only removes the auth mapping; the
 // project's per-project DB is removed separately via DeleteProjectDB.
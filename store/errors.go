@@ -16,6 +16,19 @@ var ErrInvalidProjectID = errors.New("store: invalid project_id")
 // CLIENT error → HTTP 400.
 var ErrInvalidPath = errors.New("store: invalid path")
 
+// ErrInvalidCursor is returned (wrapped) by ListTasks given a cursor it did not
+// issue. A CLIENT error → HTTP 400.
+var ErrInvalidCursor = errors.New("store: invalid cursor")
+
+// ErrInvalidChain is returned (wrapped) by CreateTaskChain when a later step's
+// id repeats another step's or is already taken by an existing task. A CLIENT
+// error → HTTP 400.
+var ErrInvalidChain = errors.New("store: invalid task chain")
+
+// ErrProjectDBsUnavailable is returned by the per-project methods of a store
+// opened with OpenExisting: tooling reads control.db only.
+var ErrProjectDBsUnavailable = errors.New("store: per-project DBs are not available to tooling")
+
 // isUniqueViolation reports whether err is a SQLite UNIQUE-constraint failure
 // (extended result code SQLITE_CONSTRAINT_UNIQUE). Used to map a token_hash
 // collision onto ErrTenantExists rather than a generic DB error. PRIMARYKEY
@@ -0,0 +1,54 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// exportTask is the task kind whose completed result advertises a download.
+const exportTask = "backup.export"
+
+// ListLapsedExports returns the completed backup.export tasks whose presigned
+// URL has lapsed — result_json's "expiry" (unix seconds) is at or before now —
+// oldest first. They are ExpireExport's candidates.
+func (s *Store) ListLapsedExports(ctx context.Context, now int64) ([]Task, error) {
+	rows, err := s.control.QueryContext(ctx,
+		`SELECT `+taskColumns+` FROM tasks
+		  WHERE name = ? AND status = ? AND json_extract(result_json, '$.expiry') <= ?
+		  ORDER BY created_at, id`,
+		exportTask, TaskCompleted, now)
+	if err != nil {
+		return nil, fmt.Errorf("store: list lapsed exports: %w", err)
+	}
+	return collectTasks(rows)
+}
+
+// ExpireExport flips a completed export to expired, replacing result_json with
+// result (the download it no longer offers), and appends the snapshot so the
+// controller stops showing the dead link. Only a completed task expires;
+// expired=false otherwise. The status is the one a missed deadline records
+// (ExpirePendingTask); result's "expired_at" is what tells the two apart.
+func (s *Store) ExpireExport(ctx context.Context, id string, result json.RawMessage) (expired bool, err error) {
+	return s.casTaskStatus(ctx, id, TaskCompleted, TaskExpired,
+		`, result_json = COALESCE(?, result_json)`, nullableJSON(result))
+}
+
+// DeleteFailedExportsBefore reaps failed (and timed-out) backup.export tasks
+// last updated before `before`, returning the number deleted. Like
+// DeleteTerminalTasksBefore it is local housekeeping, never changelogged; it
+// lets a failed export's row go well before tasks.retention_sec.
+func (s *Store) DeleteFailedExportsBefore(ctx context.Context, before int64) (int64, error) {
+	res, err := s.control.ExecContext(ctx, `
+		DELETE FROM tasks
+		WHERE name = ? AND status IN (?, ?) AND updated_at < ?
+	`, exportTask, TaskFailed, TaskTimedOut, before)
+	if err != nil {
+		return 0, fmt.Errorf("store: reap failed exports: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("store: reap failed exports rows affected: %w", err)
+	}
+	return n, nil
+}
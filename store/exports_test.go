@@ -0,0 +1,103 @@
+package store
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestExpireExports(t *testing.T) {
+	s := open(t, Options{})
+	for _, tk := range []Task{
+		{ID: "lapsed", Name: "backup.export", Node: "n"},
+		{ID: "live", Name: "backup.export", Node: "n"},
+		{ID: "running", Name: "backup.export", Node: "n"},
+		{ID: "backup", Name: "volume.backup", Node: "n"},
+	} {
+		if _, err := s.CreateTask(ctx, tk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id, result := range map[string]string{
+		"lapsed": `{"url":"https://x/a","object_key":"exports/a","expiry":1000}`,
+		"live":   `{"url":"https://x/b","object_key":"exports/b","expiry":3000}`,
+		"backup": `{"expiry":1000}`,
+	} {
+		if err := s.UpdateTaskStatus(ctx, id, TaskCompleted, json.RawMessage(result)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.ClaimTask(ctx, "running"); err != nil {
+		t.Fatal(err)
+	}
+
+	lapsed, err := s.ListLapsedExports(ctx, 2000)
+	if err != nil {
+		t.Fatalf("ListLapsedExports: %v", err)
+	}
+	if len(lapsed) != 1 || lapsed[0].ID != "lapsed" {
+		t.Fatalf("lapsed = %+v, want only the lapsed export", lapsed)
+	}
+
+	before := len(mustSince(t, s, 0, "task", 100))
+	expired, err := s.ExpireExport(ctx, "lapsed", json.RawMessage(`{"object_key":"exports/a","expiry":1000,"expired_at":2000}`))
+	if err != nil || !expired {
+		t.Fatalf("ExpireExport = %v, %v; want true", expired, err)
+	}
+	tk, _, _ := s.GetTask(ctx, "lapsed")
+	if tk.Status != TaskExpired || strings.Contains(string(tk.Result), "url") {
+		t.Fatalf("after expire: status %q result %s", tk.Status, tk.Result)
+	}
+	if after := mustSince(t, s, 0, "task", 100); len(after) != before+1 || after[len(after)-1].EntityID != "lapsed" {
+		t.Fatalf("expire appended %d changelog rows, want 1", len(after)-before)
+	}
+	if lapsed, _ := s.ListLapsedExports(ctx, 2000); len(lapsed) != 0 {
+		t.Fatalf("expired export still listed: %+v", lapsed)
+	}
+	// Only a completed export expires.
+	if expired, err := s.ExpireExport(ctx, "running", nil); err != nil || expired {
+		t.Fatalf("ExpireExport(running) = %v, %v; want false", expired, err)
+	}
+}
+
+func TestDeleteFailedExportsBefore(t *testing.T) {
+	s := open(t, Options{})
+	for _, tk := range []Task{
+		{ID: "failed", Name: "backup.export", Node: "n"},
+		{ID: "timed_out", Name: "backup.export", Node: "n"},
+		{ID: "fresh", Name: "backup.export", Node: "n"},
+		{ID: "done", Name: "backup.export", Node: "n"},
+		{ID: "backup", Name: "volume.backup", Node: "n"},
+	} {
+		if _, err := s.CreateTask(ctx, tk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id, status := range map[string]string{
+		"failed":    TaskFailed,
+		"timed_out": TaskTimedOut,
+		"fresh":     TaskFailed,
+		"done":      TaskCompleted,
+		"backup":    TaskFailed,
+	} {
+		if err := s.UpdateTaskStatus(ctx, id, status, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.control.ExecContext(ctx,
+		`UPDATE tasks SET updated_at = 1000 WHERE id <> 'fresh'`); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := s.DeleteFailedExportsBefore(ctx, 2000)
+	if err != nil {
+		t.Fatalf("DeleteFailedExportsBefore: %v", err)
+	}
+	if deleted != 2 {
+		t.Fatalf("deleted = %d, want 2 (failed+timed_out)", deleted)
+	}
+	for _, id := range []string{"fresh", "done", "backup"} {
+		if _, found, _ := s.GetTask(ctx, id); !found {
+			t.Fatalf("task %q was reaped", id)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+package store
+
+import "cs-agent/metrics"
+
+var (
+	poolEvictions = metrics.NewCounterVec("cs_agent_store_pool_evictions_total",
+		"Per-project DB handles closed by the LRU pool, by reason (cap = over max open, idle = idle sweep).",
+		"reason")
+	poolAcquireSeconds = metrics.NewHistogramVec("cs_agent_store_pool_acquire_seconds",
+		"Time to lease a per-project DB handle, including opening and migrating it on a miss.",
+		[]float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1})
+)
@@ -95,6 +95,27 @@ func runMigrations(db *sql.DB, dbName string, migrations []migration) error {
 	return nil
 }
 
+// checkSchemaCurrent refuses a DB whose schema is not exactly the latest of
+// migrations, without migrating it: newer is the usual schemaVersionError, older
+// means the agent has not yet migrated it (tooling must not do that under it).
+func checkSchemaCurrent(db *sql.DB, dbName string, migrations []migration) error {
+	_, maxApplied, err := appliedVersions(db)
+	if err != nil {
+		return fmt.Errorf("%s: read schema_migrations: %w", dbName, err)
+	}
+	supported := 0
+	if n := len(migrations); n > 0 {
+		supported = migrations[n-1].version
+	}
+	switch {
+	case maxApplied > supported:
+		return &schemaVersionError{dbName: dbName, onDisk: maxApplied, supported: supported}
+	case maxApplied < supported:
+		return fmt.Errorf("%s is schema v%d; this binary expects v%d — start (or restart) the agent so it migrates the DB first", dbName, maxApplied, supported)
+	}
+	return nil
+}
+
 // appliedVersions returns the set of applied versions and the max of them (0 if
 // none applied).
 func appliedVersions(db *sql.DB) (map[int]bool, int, error) {
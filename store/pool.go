@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
 	"time"
 )
@@ -64,7 +65,8 @@ type connPool struct {
 	deleting map[string]bool // projects currently being deleted (tombstone)
 
 	// migrate is injected so the pool stays decoupled from the per-project
-	// schema; Store wires it to runMigrations(db, name, projectMigrations).
+	// schema; Store wires it to runMigrations(db, name, projectMigrations). Nil
+	// for a tooling store (OpenExisting), which never opens per-project DBs.
 	migrate func(db *sql.DB, name string) error
 
 	stopSweep chan struct{}
@@ -101,6 +103,8 @@ func (p *connPool) dbPath(projectID string) string {
 // the close is deferred to release(). Returns ErrProjectDeleting if a delete is
 // in flight for this project.
 func (p *connPool) acquire(projectID string) (*sql.DB, func(), error) {
+	start := time.Now()
+	defer func() { poolAcquireSeconds.Observe(time.Since(start).Seconds()) }()
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -163,6 +167,9 @@ func (p *connPool) closeConn(c *projectConn) {
 // DB) closes the just-opened handle and propagates the clear error. Caller holds
 // mu.
 func (p *connPool) openAndMigrate(projectID string) (*sql.DB, error) {
+	if p.migrate == nil {
+		return nil, ErrProjectDBsUnavailable
+	}
 	if err := os.MkdirAll(p.dir, 0o750); err != nil {
 		return nil, fmt.Errorf("create projects dir: %w", err)
 	}
@@ -190,6 +197,7 @@ func (p *connPool) evictToCap() {
 		if c.refs == 0 {
 			p.unpool(c)
 			p.closeConn(c)
+			poolEvictions.Inc("cap")
 		}
 		// Pinned conns are left in place (soft cap); just move on.
 		e = prev
@@ -285,6 +293,7 @@ func (p *connPool) sweepIdle() {
 		if c.refs == 0 { // never sweep a pinned handle
 			p.unpool(c)
 			p.closeConn(c)
+			poolEvictions.Inc("idle")
 		}
 		e = prev
 	}
@@ -319,9 +328,27 @@ func (p *connPool) close() error {
 	return firstErr
 }
 
-// openCount returns the number of currently-pooled handles (test/observability).
+// openCount returns the number of currently-pooled handles (test/observability;
+// exported as Store.OpenProjectDBs).
 func (p *connPool) openCount() int {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	return len(p.conns)
 }
+
+// stats snapshots the pool under mu.
+func (p *connPool) stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st := PoolStats{Open: len(p.conns), Max: p.maxOpen, IdleTimeoutSec: int64(p.idleTimeout / time.Second), Deleting: []string{}}
+	for _, c := range p.conns {
+		if c.refs > 0 {
+			st.Pinned++
+		}
+	}
+	for id := range p.deleting {
+		st.Deleting = append(st.Deleting, id)
+	}
+	slices.Sort(st.Deleting)
+	return st
+}
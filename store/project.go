@@ -216,3 +216,11 @@ func nullable(s string) any {
 	}
 	return s
 }
+
+// nullableInt maps the zero value to SQL NULL, like nullable.
+func nullableInt(n int64) any {
+	if n == 0 {
+		return nil
+	}
+	return n
+}
@@ -0,0 +1,98 @@
+package store
+
+import "context"
+
+// Task priorities: a higher value is claimed first. A task submitted without a
+// priority gets one from its kind (DefaultPriority); the scheduler's own backups
+// are PriorityScheduled, so a customer's manual work overtakes a cron wave.
+const (
+	PriorityMaintenance = 10 // teardown and other housekeeping
+	PriorityScheduled   = 20 // scheduler-fired backups
+	PriorityManual      = 30 // controller-submitted backup/export/delete
+	PriorityRestore     = 40 // a manual restore, clone or browse: a customer is waiting on it
+)
+
+// DefaultPriority is the priority of a controller-submitted task of this kind
+// that did not carry one.
+func DefaultPriority(name string) int {
+	switch name {
+	case "volume.restore", "volume.clone", "volume.restore_paths", "backup.list":
+		return PriorityRestore
+	case "volume.trash", "repository.prune", "repository.compact", "repository.check", "backup.verify", "node.housekeeping":
+		return PriorityMaintenance
+	default:
+		return PriorityManual
+	}
+}
+
+// Worker pools a task kind dispatches to (see TaskQueue).
+const (
+	QueueBackup = "backup"
+	QueueExport = "export"
+)
+
+// TaskQueue names the worker pool a task kind runs on: exports have their own
+// pool so a long stream never holds a backup worker; everything else shares the
+// backup pool. Claim order and queue positions are per pool.
+func TaskQueue(name string) string {
+	if name == "backup.export" {
+		return QueueExport
+	}
+	return QueueBackup
+}
+
+// fairOrder puts pending tasks — sorted priority DESC, created_at, id — into
+// claim order: priority levels stay strictly ordered, and within a level the
+// projects take turns (round-robin, each project's own tasks oldest first), so
+// one project's 40 same-cron backups can't occupy every worker ahead of another
+// project's single task. Projects are visited in order of their oldest task, so
+// the order is stable for a given set of tasks. Tasks without a project share
+// one turn.
+func fairOrder(tasks []Task) []Task {
+	out := make([]Task, 0, len(tasks))
+	for start := 0; start < len(tasks); {
+		end := start
+		for end < len(tasks) && tasks[end].Priority == tasks[start].Priority {
+			end++
+		}
+		var (
+			projects []string
+			byProj   = map[string][]Task{}
+		)
+		for _, t := range tasks[start:end] {
+			if _, seen := byProj[t.ProjectID]; !seen {
+				projects = append(projects, t.ProjectID)
+			}
+			byProj[t.ProjectID] = append(byProj[t.ProjectID], t)
+		}
+		for left := end - start; left > 0; {
+			for _, p := range projects {
+				if q := byProj[p]; len(q) > 0 {
+					out = append(out, q[0])
+					byProj[p] = q[1:]
+					left--
+				}
+			}
+		}
+		start = end
+	}
+	return out
+}
+
+// QueuePositions returns each due pending task's 1-based position in its pool's
+// claim order (see TaskQueue) — what the dispatcher will claim next. A task held
+// back by a retry backoff is not queued yet and has no position.
+func (s *Store) QueuePositions(ctx context.Context) (map[string]int, error) {
+	pending, err := s.ListPendingTasks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pos := make(map[string]int, len(pending))
+	next := map[string]int{}
+	for _, t := range pending {
+		q := TaskQueue(t.Name)
+		next[q]++
+		pos[t.ID] = next[q]
+	}
+	return pos, nil
+}
@@ -0,0 +1,70 @@
+package store
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFairOrder(t *testing.T) {
+	// Input is priority DESC, created_at, id — as ListPendingTasks reads it.
+	in := []Task{
+		{ID: "r1", ProjectID: "p2", Priority: PriorityRestore},
+		{ID: "a1", ProjectID: "p1", Priority: PriorityScheduled},
+		{ID: "a2", ProjectID: "p1", Priority: PriorityScheduled},
+		{ID: "a3", ProjectID: "p1", Priority: PriorityScheduled},
+		{ID: "b1", ProjectID: "p2", Priority: PriorityScheduled},
+		{ID: "n1", Priority: PriorityScheduled},
+		{ID: "b2", ProjectID: "p2", Priority: PriorityScheduled},
+		{ID: "m1", ProjectID: "p1", Priority: PriorityMaintenance},
+	}
+	want := []string{"r1", "a1", "b1", "n1", "a2", "b2", "a3", "m1"}
+	if got := taskIDs(fairOrder(in)); !slices.Equal(got, want) {
+		t.Fatalf("fairOrder = %v, want %v", got, want)
+	}
+}
+
+// TestListPendingTasks_PriorityAndFairness proves the claim order end to end:
+// kinds get their default priority on insert (a scheduled backup below a manual
+// one, a restore above both), and a project's cron wave is interleaved with
+// another project's work instead of queueing ahead of it.
+func TestListPendingTasks_PriorityAndFairness(t *testing.T) {
+	s := open(t, Options{})
+	for _, id := range []string{"p1-a", "p1-b", "p1-c"} {
+		if _, err := s.FireDueBackup(ctx, Task{ID: id, ProjectID: "p1", Name: "volume.backup", Node: "n", Volume: id}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.FireDueBackup(ctx, Task{ID: "p2-a", ProjectID: "p2", Name: "volume.backup", Node: "n", Volume: "p2-a"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	seedTasks(t, s, []Task{
+		{ID: "manual", ProjectID: "p1", Name: "volume.backup", Node: "n", CreatedAt: 5},
+		{ID: "restore", ProjectID: "p2", Name: "volume.restore", Node: "n", CreatedAt: 6},
+		{ID: "export", ProjectID: "p1", Name: "backup.export", Node: "n", CreatedAt: 7},
+	})
+	// Pin the scheduled wave's created_at so the order doesn't hinge on the clock.
+	if _, err := s.control.ExecContext(ctx, `UPDATE tasks SET created_at = 1 WHERE priority = ?`, PriorityScheduled); err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := s.ListPendingTasks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"restore", "manual", "export", "p1-a", "p2-a", "p1-b", "p1-c"}
+	if got := taskIDs(pending); !slices.Equal(got, want) {
+		t.Fatalf("claim order = %v, want %v", got, want)
+	}
+
+	pos, err := s.QueuePositions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Positions are per pool: the export is first in its own queue.
+	wantPos := map[string]int{"restore": 1, "manual": 2, "p1-a": 3, "p2-a": 4, "p1-b": 5, "p1-c": 6, "export": 1}
+	for id, p := range wantPos {
+		if pos[id] != p {
+			t.Errorf("position(%s) = %d, want %d", id, pos[id], p)
+		}
+	}
+}
@@ -11,26 +11,85 @@ import (
 
 // Repository is a borg repository's observed state, reported UP by the agent
 // (replacing the Consul borg/repository/<name> key): its on-disk/total size and
-// archive-name list. name matches the volume name. The changelog entity_type is
-// "repository". This is standing observed-state, not a unit of work — hence its
-// own table rather than folding into tasks.
+// archive-name list, plus the outcome of its latest integrity check. name
+// matches the volume name. The changelog entity_type is "repository". This is
+// standing observed-state, not a unit of work — hence its own table rather than
+// folding into tasks.
 type Repository struct {
 	Name       string   `json:"name"`
 	SizeOnDisk int64    `json:"size_on_disk"`
 	TotalSize  int64    `json:"total_size"`
 	Archives   []string `json:"archives"`
 	UpdatedAt  int64    `json:"updated_at"`
+
+	// LastCheck is the latest borg check; nil until one has run. VerifiedAt is
+	// when a --verify-data check last ran (whatever it found; 0 = never).
+	LastCheck  *RepositoryCheck `json:"last_check,omitempty"`
+	VerifiedAt int64            `json:"verified_at,omitempty"`
+}
+
+// RepositoryCheck is the outcome of a borg check that ran to completion. OK is
+// false when borg found problems; Findings carries its messages then.
+type RepositoryCheck struct {
+	At         int64  `json:"at"`
+	VerifyData bool   `json:"verify_data"`
+	OK         bool   `json:"ok"`
+	Findings   string `json:"findings,omitempty"`
+}
+
+// repositoryColumns is the SELECT list scanRepository reads.
+const repositoryColumns = `name, size_on_disk, total_size, archives, updated_at,
+	check_at, check_verify_data, check_ok, check_findings, verified_at`
+
+func scanRepository(row interface{ Scan(...any) error }) (Repository, error) {
+	var (
+		r          Repository
+		sizeOnDisk sql.NullInt64
+		totalSize  sql.NullInt64
+		archives   sql.NullString
+		checkAt    sql.NullInt64
+		verifyData sql.NullBool
+		checkOK    sql.NullBool
+		findings   sql.NullString
+		verifiedAt sql.NullInt64
+	)
+	if err := row.Scan(&r.Name, &sizeOnDisk, &totalSize, &archives, &r.UpdatedAt,
+		&checkAt, &verifyData, &checkOK, &findings, &verifiedAt); err != nil {
+		return Repository{}, err
+	}
+	r.SizeOnDisk = sizeOnDisk.Int64 // nullable columns: absent -> 0
+	r.TotalSize = totalSize.Int64
+	if archives.Valid && archives.String != "" {
+		if err := json.Unmarshal([]byte(archives.String), &r.Archives); err != nil {
+			return Repository{}, fmt.Errorf("unmarshal archives: %w", err)
+		}
+	}
+	if checkAt.Valid {
+		r.LastCheck = &RepositoryCheck{At: checkAt.Int64, VerifyData: verifyData.Bool, OK: checkOK.Bool, Findings: findings.String}
+	}
+	r.VerifiedAt = verifiedAt.Int64
+	return r, nil
+}
+
+// getRepositoryTx reads a repository's full row inside tx, for the changelog
+// snapshot of a write that only touched some of its columns.
+func getRepositoryTx(ctx context.Context, tx *sql.Tx, name string) (Repository, error) {
+	r, err := scanRepository(tx.QueryRowContext(ctx, `SELECT `+repositoryColumns+` FROM repositories WHERE name = ?`, name))
+	if err != nil {
+		return Repository{}, fmt.Errorf("store: reread repository %q: %w", name, err)
+	}
+	return r, nil
 }
 
 // UpsertRepository writes a repository's observed state and appends its
 // changelog row (entity_type "repository", op "upsert") in one transaction. This
-// is the store-backed successor to borg.Repository.SyncConsul.
+// is the store-backed successor to borg.Repository.SyncConsul. The latest check
+// is left as it is (and is in the snapshot).
 func (s *Store) UpsertRepository(ctx context.Context, r Repository) error {
 	if r.Name == "" {
 		return errors.New("store: UpsertRepository requires name")
 	}
 	now := time.Now().Unix()
-	r.UpdatedAt = now
 	if r.Archives == nil {
 		r.Archives = []string{}
 	}
@@ -38,10 +97,6 @@ func (s *Store) UpsertRepository(ctx context.Context, r Repository) error {
 	if err != nil {
 		return fmt.Errorf("store: marshal repository archives %q: %w", r.Name, err)
 	}
-	snapshot, err := json.Marshal(r)
-	if err != nil {
-		return fmt.Errorf("store: marshal repository %q: %w", r.Name, err)
-	}
 
 	return s.withControlTx(ctx, func(tx *sql.Tx) error {
 		if _, err := tx.ExecContext(ctx, `
@@ -52,42 +107,124 @@ func (s *Store) UpsertRepository(ctx context.Context, r Repository) error {
 				total_size   = excluded.total_size,
 				archives     = excluded.archives,
 				updated_at   = excluded.updated_at
-		`, r.Name, r.SizeOnDisk, r.TotalSize, nullableJSON(archives), r.UpdatedAt); err != nil {
+		`, r.Name, r.SizeOnDisk, r.TotalSize, nullableJSON(archives), now); err != nil {
 			return fmt.Errorf("store: upsert repository %q: %w", r.Name, err)
 		}
-		return appendChangelogTx(ctx, tx, "repository", r.Name, "", "upsert", snapshot, now)
+		return appendRepositoryTx(ctx, tx, r.Name, now)
 	})
 }
 
+// RecordRepositoryCheck stores the outcome of a borg check as the repository's
+// latest and appends the repository's changelog row, so a failed check reaches
+// the controller. A repository the agent has not synced yet gets a row holding
+// just the check.
+func (s *Store) RecordRepositoryCheck(ctx context.Context, name string, c RepositoryCheck) error {
+	if name == "" || c.At == 0 {
+		return errors.New("store: RecordRepositoryCheck requires name and at")
+	}
+	var verifiedAt any
+	if c.VerifyData {
+		verifiedAt = c.At
+	}
+	now := time.Now().Unix()
+	return s.withControlTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO repositories (name, updated_at, check_at, check_verify_data, check_ok, check_findings, verified_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(name) DO UPDATE SET
+				updated_at        = excluded.updated_at,
+				check_at          = excluded.check_at,
+				check_verify_data = excluded.check_verify_data,
+				check_ok          = excluded.check_ok,
+				check_findings    = excluded.check_findings,
+				verified_at       = COALESCE(excluded.verified_at, repositories.verified_at)
+		`, name, now, c.At, c.VerifyData, c.OK, nullable(c.Findings), verifiedAt); err != nil {
+			return fmt.Errorf("store: record repository check %q: %w", name, err)
+		}
+		return appendRepositoryTx(ctx, tx, name, now)
+	})
+}
+
+// appendRepositoryTx appends a repository's full row to the changelog (op
+// "upsert").
+func appendRepositoryTx(ctx context.Context, tx *sql.Tx, name string, now int64) error {
+	full, err := getRepositoryTx(ctx, tx, name)
+	if err != nil {
+		return err
+	}
+	if full.Archives == nil {
+		full.Archives = []string{}
+	}
+	snapshot, err := json.Marshal(full)
+	if err != nil {
+		return fmt.Errorf("store: marshal repository %q: %w", name, err)
+	}
+	return appendChangelogTx(ctx, tx, "repository", name, "", "upsert", snapshot, now)
+}
+
 // GetRepository returns a repository's observed state by name. found=false on a
 // miss.
 func (s *Store) GetRepository(ctx context.Context, name string) (Repository, bool, error) {
-	var (
-		r          Repository
-		sizeOnDisk sql.NullInt64
-		totalSize  sql.NullInt64
-		archives   sql.NullString
-	)
-	err := s.control.QueryRowContext(ctx,
-		`SELECT name, size_on_disk, total_size, archives, updated_at FROM repositories WHERE name = ?`, name).
-		Scan(&r.Name, &sizeOnDisk, &totalSize, &archives, &r.UpdatedAt)
+	r, err := scanRepository(s.control.QueryRowContext(ctx,
+		`SELECT `+repositoryColumns+` FROM repositories WHERE name = ?`, name))
 	switch {
 	case errors.Is(err, sql.ErrNoRows):
 		return Repository{}, false, nil
 	case err != nil:
 		return Repository{}, false, fmt.Errorf("store: get repository %q: %w", name, err)
 	default:
-		r.SizeOnDisk = sizeOnDisk.Int64 // nullable columns: absent -> 0
-		r.TotalSize = totalSize.Int64
-		if archives.Valid && archives.String != "" {
-			if err := json.Unmarshal([]byte(archives.String), &r.Archives); err != nil {
-				return Repository{}, false, fmt.Errorf("store: unmarshal repository archives %q: %w", name, err)
-			}
-		}
 		return r, true, nil
 	}
 }
 
+// ListRepositories returns every repository's observed state, ordered by name.
+func (s *Store) ListRepositories(ctx context.Context) ([]Repository, error) {
+	rows, err := s.control.QueryContext(ctx,
+		`SELECT `+repositoryColumns+` FROM repositories ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("store: list repositories: %w", err)
+	}
+	defer rows.Close()
+	var out []Repository
+	for rows.Next() {
+		r, err := scanRepository(rows)
+		if err != nil {
+			return nil, fmt.Errorf("store: scan repository row: %w", err)
+		}
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: iterate repositories: %w", err)
+	}
+	return out, nil
+}
+
+// RepositorySizes returns every repository's size_on_disk, keyed by name (the
+// volume name). It skips the archive lists, so it is cheap enough to run on a
+// metrics scrape.
+func (s *Store) RepositorySizes(ctx context.Context) (map[string]int64, error) {
+	rows, err := s.control.QueryContext(ctx, `SELECT name, size_on_disk FROM repositories`)
+	if err != nil {
+		return nil, fmt.Errorf("store: list repository sizes: %w", err)
+	}
+	defer rows.Close()
+	sizes := map[string]int64{}
+	for rows.Next() {
+		var (
+			name string
+			size sql.NullInt64
+		)
+		if err := rows.Scan(&name, &size); err != nil {
+			return nil, fmt.Errorf("store: scan repository size: %w", err)
+		}
+		sizes[name] = size.Int64
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: list repository sizes: %w", err)
+	}
+	return sizes, nil
+}
+
 // DeleteRepository removes a repository's observed-state row and appends a delete
 // changelog row (entity_type "repository"), so the controller's projection drops
 // the dead repo after a teardown. Deleting an absent repository is a no-op.
@@ -1,6 +1,47 @@
 package store
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
+
+func TestRepositorySizes(t *testing.T) {
+	s := open(t, Options{})
+	for name, size := range map[string]int64{"vol-1": 100, "vol-2": 2048} {
+		if err := s.UpsertRepository(ctx, Repository{Name: name, SizeOnDisk: size}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sizes, err := s.RepositorySizes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sizes) != 2 || sizes["vol-1"] != 100 || sizes["vol-2"] != 2048 {
+		t.Fatalf("sizes = %v", sizes)
+	}
+}
+
+func TestListRepositories(t *testing.T) {
+	s := open(t, Options{})
+	if repos, err := s.ListRepositories(ctx); err != nil || len(repos) != 0 {
+		t.Fatalf("empty: %v, %v", repos, err)
+	}
+	for _, r := range []Repository{
+		{Name: "vol-b", SizeOnDisk: 2048, Archives: []string{"a1", "a2"}},
+		{Name: "vol-a", SizeOnDisk: 100},
+	} {
+		if err := s.UpsertRepository(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repos, err := s.ListRepositories(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repos) != 2 || repos[0].Name != "vol-a" || repos[1].Name != "vol-b" || len(repos[1].Archives) != 2 {
+		t.Fatalf("repos = %+v", repos)
+	}
+}
 
 // TestDeleteRepository covers m7: Trash must be able to drop the repository
 // projection row so the controller's backups list doesn't keep a dead repo.
@@ -28,3 +69,43 @@ func TestDeleteRepository(t *testing.T) {
 		t.Fatalf("delete absent: %v", err)
 	}
 }
+
+func TestRecordRepositoryCheck(t *testing.T) {
+	s := open(t, Options{})
+	// A check before the first sync creates the row with just the check.
+	if err := s.RecordRepositoryCheck(ctx, "vol-1", RepositoryCheck{At: 100, VerifyData: true, OK: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpsertRepository(ctx, Repository{Name: "vol-1", SizeOnDisk: 10, Archives: []string{"a1"}}); err != nil {
+		t.Fatal(err)
+	}
+	r, _, err := s.GetRepository(ctx, "vol-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.LastCheck == nil || r.LastCheck.At != 100 || r.VerifiedAt != 100 || r.SizeOnDisk != 10 {
+		t.Fatalf("after sync: %+v (check %+v)", r, r.LastCheck)
+	}
+
+	// A --repository-only check replaces the latest but keeps verified_at.
+	if err := s.RecordRepositoryCheck(ctx, "vol-1", RepositoryCheck{At: 200, Findings: "segment 3 corrupt"}); err != nil {
+		t.Fatal(err)
+	}
+	r, _, _ = s.GetRepository(ctx, "vol-1")
+	if r.LastCheck == nil || r.LastCheck.OK || r.LastCheck.VerifyData || r.LastCheck.Findings != "segment 3 corrupt" || r.VerifiedAt != 100 {
+		t.Fatalf("after failed check: %+v (check %+v)", r, r.LastCheck)
+	}
+	if len(r.Archives) != 1 {
+		t.Fatalf("check dropped archives: %+v", r.Archives)
+	}
+
+	// The changelog snapshot carries the full row, check included.
+	var et, snapshot string
+	if err := s.control.QueryRowContext(ctx,
+		`SELECT entity_type, payload FROM changelog ORDER BY seq DESC LIMIT 1`).Scan(&et, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if et != "repository" || !strings.Contains(snapshot, `"findings":"segment 3 corrupt"`) || !strings.Contains(snapshot, `"archives":["a1"]`) {
+		t.Fatalf("changelog = %s %s", et, snapshot)
+	}
+}
@@ -43,7 +43,8 @@ func (s *Store) PruneChangelog(ctx context.Context, now, minAgeSec, maxAgeSec in
 	return n, nil
 }
 
-// DeleteTerminalTasksBefore reaps terminal tasks (completed/failed/cancelled) last
+// DeleteTerminalTasksBefore reaps terminal tasks (completed/failed/cancelled/
+// timed_out/expired) last
 // updated before `before`, returning the number deleted. This bounds the tasks
 // table under continuous scheduled backups — every kind is reaped, not just
 // exports (only exports carried an expiry). `before` must be older than the
@@ -54,8 +55,8 @@ func (s *Store) PruneChangelog(ctx context.Context, now, minAgeSec, maxAgeSec in
 func (s *Store) DeleteTerminalTasksBefore(ctx context.Context, before int64) (int64, error) {
 	res, err := s.control.ExecContext(ctx, `
 		DELETE FROM tasks
-		WHERE status IN (?, ?, ?) AND updated_at < ?
-	`, TaskCompleted, TaskFailed, TaskCancelled, before)
+		WHERE status IN (?, ?, ?, ?, ?) AND updated_at < ?
+	`, TaskCompleted, TaskFailed, TaskCancelled, TaskTimedOut, TaskExpired, before)
 	if err != nil {
 		return 0, fmt.Errorf("store: prune terminal tasks: %w", err)
 	}
@@ -104,7 +104,7 @@ func TestSetChangelogAcked_Monotonic(t *testing.T) {
 
 func TestDeleteTerminalTasksBefore(t *testing.T) {
 	s := open(t, Options{})
-	for _, id := range []string{"pending", "done", "failed"} {
+	for _, id := range []string{"pending", "done", "failed", "timed_out"} {
 		if _, err := s.CreateTask(ctx, Task{ID: id, Name: "volume.backup", Node: "n"}); err != nil {
 			t.Fatal(err)
 		}
@@ -115,17 +115,20 @@ func TestDeleteTerminalTasksBefore(t *testing.T) {
 	if err := s.UpdateTaskStatus(ctx, "failed", TaskFailed, nil); err != nil {
 		t.Fatal(err)
 	}
-	// Age the two terminal tasks into the past.
+	if err := s.UpdateTaskStatus(ctx, "timed_out", TaskTimedOut, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Age the terminal tasks into the past.
 	if _, err := s.control.ExecContext(ctx,
-		`UPDATE tasks SET updated_at = 1000 WHERE id IN ('done','failed')`); err != nil {
+		`UPDATE tasks SET updated_at = 1000 WHERE id IN ('done','failed','timed_out')`); err != nil {
 		t.Fatal(err)
 	}
 	deleted, err := s.DeleteTerminalTasksBefore(ctx, 2000)
 	if err != nil {
 		t.Fatalf("DeleteTerminalTasksBefore: %v", err)
 	}
-	if deleted != 2 {
-		t.Fatalf("deleted = %d, want 2 (done+failed)", deleted)
+	if deleted != 3 {
+		t.Fatalf("deleted = %d, want 3 (done+failed+timed_out)", deleted)
 	}
 	// The pending task survives (never a reap candidate).
 	if got := countTable(t, s, "tasks"); got != 1 {
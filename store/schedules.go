@@ -128,6 +128,9 @@ func (s *Store) FireDueBackup(ctx context.Context, task Task, nextFireAt int64)
 	if task.Status == "" {
 		task.Status = TaskPending
 	}
+	if task.Priority == 0 {
+		task.Priority = PriorityScheduled
+	}
 	task.CreatedAt = now
 	task.UpdatedAt = now
 	snapshot, err := json.Marshal(task)
@@ -43,7 +43,30 @@ func openSQLite(path string, txlockImmediate bool) (*sql.DB, error) {
 	if txlockImmediate {
 		dsn += "&_txlock=immediate"
 	}
+	return openDSN(path, dsn)
+}
+
+// openSQLiteExisting opens an existing control.db for tooling next to the
+// running agent: mode=rw/ro never creates the file, and journal_mode is left as
+// the agent set it (WAL) rather than re-applied. readOnly adds query_only, so
+// even a stray write is refused by SQLite itself.
+func openSQLiteExisting(path string, readOnly bool) (*sql.DB, error) {
+	mode := "rw"
+	if readOnly {
+		mode = "ro"
+	}
+	dsn := fmt.Sprintf(
+		"file:%s?mode=%s&_pragma=synchronous(FULL)&_pragma=busy_timeout(%d)&_pragma=foreign_keys(ON)&_txlock=immediate",
+		path, mode, busyTimeout.Milliseconds(),
+	)
+	if readOnly {
+		dsn += "&_pragma=query_only(1)"
+	}
+	return openDSN(path, dsn)
+}
 
+// openDSN opens dsn with the shared pool settings and forces a connection.
+func openDSN(path, dsn string) (*sql.DB, error) {
 	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("open sqlite %s: %w", path, err)
@@ -16,6 +16,7 @@
 package store
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -92,6 +93,32 @@ func Open(dataDir string, opts Options) (*Store, error) {
 	}, nil
 }
 
+// OpenExisting opens dataDir's control.db for on-node tooling (the operator
+// CLI) while the agent may be running. Unlike Open it never creates or migrates
+// anything — the agent owns the schema — and it refuses a control.db whose
+// schema is not exactly this binary's. readOnly makes the handle query-only.
+// Only control.db is opened: per-project methods return
+// ErrProjectDBsUnavailable.
+func OpenExisting(dataDir string, readOnly bool) (*Store, error) {
+	path := filepath.Join(dataDir, "control.db")
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("store: %w", err)
+	}
+	controlSQL, err := openSQLiteExisting(path, readOnly)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSchemaCurrent(controlSQL, "control.db", controlMigrations); err != nil {
+		_ = controlSQL.Close()
+		return nil, err
+	}
+	return &Store{
+		dataDir: dataDir,
+		control: controlSQL,
+		pool:    newConnPool(filepath.Join(dataDir, "projects"), DefaultMaxOpenProjectDBs, 0, nil),
+	}, nil
+}
+
 // Close closes the per-project pool (and its idle sweeper) and control.db.
 // Idempotent: a second call is a safe no-op. The Store must not be used after
 // the first Close.
@@ -106,6 +133,35 @@ func (s *Store) Close() error {
 	return firstErr
 }
 
+// Ping checks control.db answers a query (it reads the schema, so it touches
+// the file rather than just the connection).
+func (s *Store) Ping(ctx context.Context) error {
+	var n int
+	if err := s.control.QueryRowContext(ctx, `SELECT count(*) FROM sqlite_master`).Scan(&n); err != nil {
+		return fmt.Errorf("store: ping control.db: %w", err)
+	}
+	return nil
+}
+
+// OpenProjectDBs returns how many per-project handles the LRU pool holds open.
+func (s *Store) OpenProjectDBs() int {
+	return s.pool.openCount()
+}
+
+// PoolStats is a snapshot of the per-project handle pool, for operators.
+type PoolStats struct {
+	Open           int      `json:"open"`             // handles held open
+	Pinned         int      `json:"pinned"`           // of those, with a query in flight
+	Max            int      `json:"max"`              // LRU cap (soft: pinned handles may exceed it)
+	IdleTimeoutSec int64    `json:"idle_timeout_sec"` // 0 = no idle close
+	Deleting       []string `json:"deleting"`         // projects whose DB is being deleted
+}
+
+// PoolStats snapshots the per-project handle pool.
+func (s *Store) PoolStats() PoolStats {
+	return s.pool.stats()
+}
+
 // validateProjectID rejects ids that would escape the projects dir or otherwise
 // be unsafe as a filename. project_id is a controller-provisioned stable id, but
 // since it becomes a path component we still guard it (defense in depth — a
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"sync"
 	"testing"
 	"time"
@@ -178,6 +179,69 @@ func TestSchemaVersionGuard_PerProjectDB(t *testing.T) {
 
 // --- Tenants ----------------------------------------------------------------
 
+func TestPing(t *testing.T) {
+	s := open(t, Options{})
+	if err := s.Ping(ctx); err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+	_ = s.Close()
+	if err := s.Ping(ctx); err == nil {
+		t.Fatal("ping of a closed store succeeded")
+	}
+}
+
+// TestOpenExisting covers the tooling open: no create, no migrate, query-only
+// when asked, and no per-project DBs.
+func TestOpenExisting(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := OpenExisting(dir, true); err == nil {
+		t.Fatal("OpenExisting created a missing control.db")
+	}
+	s, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpsertTenant(ctx, "proj-1", hash("bearer-1"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateTask(ctx, Task{ID: "t-1", Name: "volume.backup", Node: "n1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ro, err := OpenExisting(dir, true)
+	if err != nil {
+		t.Fatalf("OpenExisting(ro): %v", err)
+	}
+	defer ro.Close()
+	if tenants, err := ro.ListTenants(ctx); err != nil || len(tenants) != 1 || tenants[0].ProjectID != "proj-1" {
+		t.Fatalf("ListTenants = %+v, %v", tenants, err)
+	}
+	if _, err := ro.CancelPendingTask(ctx, "t-1"); err == nil {
+		t.Fatal("write through a read-only store succeeded")
+	}
+	if _, _, err := ro.CustomerGet(ctx, "proj-1", "a"); !errors.Is(err, ErrProjectDBsUnavailable) {
+		t.Fatalf("CustomerGet = %v, want ErrProjectDBsUnavailable", err)
+	}
+
+	rw, err := OpenExisting(dir, false)
+	if err != nil {
+		t.Fatalf("OpenExisting(rw): %v", err)
+	}
+	defer rw.Close()
+	if cancelled, err := rw.CancelPendingTask(ctx, "t-1"); err != nil || !cancelled {
+		t.Fatalf("CancelPendingTask = %v, %v", cancelled, err)
+	}
+
+	// A control.db the agent has not migrated yet is refused, not migrated.
+	if _, err := s.control.Exec(`DELETE FROM schema_migrations WHERE version = (SELECT max(version) FROM schema_migrations)`); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Close()
+	if _, err := OpenExisting(dir, true); err == nil {
+		t.Fatal("OpenExisting accepted an unmigrated control.db")
+	}
+}
+
 func TestTenants_UpsertLookupDelete(t *testing.T) {
 	s := open(t, Options{})
 
@@ -424,6 +488,7 @@ func TestDoubleClose(t *testing.T) {
 func TestLRU_CapAndEviction(t *testing.T) {
 	const cap = 3
 	s := open(t, Options{MaxOpenProjectDBs: cap})
+	evicted := poolEvictions.Value("cap")
 
 	// Open cap+ projects, writing to each so the file is real. Each call leases
 	// then releases, so nothing is pinned across iterations → the soft cap holds
@@ -441,6 +506,12 @@ func TestLRU_CapAndEviction(t *testing.T) {
 	if got := s.pool.openCount(); got != cap {
 		t.Fatalf("final open count = %d, want %d", got, cap)
 	}
+	if got := poolEvictions.Value("cap") - evicted; got != n-cap {
+		t.Fatalf("cap evictions counted %v, want %d", got, n-cap)
+	}
+	if s.OpenProjectDBs() != cap {
+		t.Fatalf("OpenProjectDBs = %d, want %d", s.OpenProjectDBs(), cap)
+	}
 
 	// p00 was evicted long ago; prove its data survived eviction (the close
 	// flushed cleanly) by reopening and reading it.
@@ -483,6 +554,30 @@ func TestLRU_EvictedUnpinnedHandleClosed(t *testing.T) {
 	}
 }
 
+// TestPoolStats checks the snapshot counts open and pinned handles and reports
+// the pool's configuration.
+func TestPoolStats(t *testing.T) {
+	s := open(t, Options{MaxOpenProjectDBs: 4, ProjectIdleTimeout: time.Hour})
+	for _, id := range []string{"p1", "p2"} {
+		if err := s.CreateProjectDB(ctx, id); err != nil {
+			t.Fatalf("create %s: %v", id, err)
+		}
+	}
+	_, release, err := s.pool.acquire("p1")
+	if err != nil {
+		t.Fatalf("acquire p1: %v", err)
+	}
+	got := s.PoolStats()
+	release()
+	want := PoolStats{Open: 2, Pinned: 1, Max: 4, IdleTimeoutSec: 3600, Deleting: []string{}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("PoolStats = %+v, want %+v", got, want)
+	}
+	if got := s.PoolStats(); got.Pinned != 0 {
+		t.Fatalf("pinned after release = %d", got.Pinned)
+	}
+}
+
 // TestLRU_PinnedHandleNotClosed_C1 is the C1 use-after-evict regression. It pins
 // p1's handle (acquire WITHOUT releasing), forces an eviction at cap=1 by
 // opening p2, then proves the still-leased p1 handle is NOT closed and a query on
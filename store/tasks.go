@@ -3,9 +3,12 @@ package store
 import (
 	"context"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -15,6 +18,13 @@ import (
 // (entity_type "task"). name is one of volume.backup, volume.restore,
 // backup.delete, backup.export, volume.trash. Result carries the terminal payload
 // (export url/size/expiry/error; a backup's last_backup; failure output).
+// Attempts counts runs (a transient failure may be retried, see RetryTask);
+// NextAttemptAt holds a retried task in pending until then (0 = due now).
+// Replays counts boot replays after a crash (see ReplayTask). Deadline, if set,
+// is when a still-pending task expires instead of being dispatched. Priority
+// orders the pending queue (see store/queue.go). AfterID, on a later step of a
+// task chain, is the task it waits on (see store/chain.go). Progress is the
+// last snapshot of a run's progress (see UpdateTaskProgress).
 type Task struct {
 	ID        string          `json:"id"`
 	ProjectID string          `json:"project_id,omitempty"`
@@ -28,6 +38,28 @@ type Task struct {
 	Result    json.RawMessage `json:"result,omitempty"`
 	CreatedAt int64           `json:"created_at"`
 	UpdatedAt int64           `json:"updated_at"`
+
+	Attempts      int    `json:"attempts"`
+	NextAttemptAt int64  `json:"next_attempt_at,omitempty"`
+	Replays       int    `json:"replays,omitempty"`
+	Deadline      int64  `json:"deadline,omitempty"`
+	Priority      int    `json:"priority"`
+	AfterID       string `json:"after_id,omitempty"`
+
+	Progress *TaskProgress `json:"progress,omitempty"`
+}
+
+// TaskProgress is a snapshot of how far a running task has got: the phase
+// (borg's operation, e.g. "create" or "extract", or "upload" for an export's
+// S3 stream), bytes processed so far and — when the phase knows its total —
+// bytes_total and an ETA in seconds at the recent rate. UpdatedAt is when the
+// snapshot was taken (unix seconds).
+type TaskProgress struct {
+	Phase      string `json:"phase,omitempty"`
+	BytesDone  int64  `json:"bytes_done"`
+	BytesTotal int64  `json:"bytes_total,omitempty"`
+	ETASec     int64  `json:"eta_sec,omitempty"`
+	UpdatedAt  int64  `json:"updated_at"`
 }
 
 // Task status values.
@@ -37,9 +69,28 @@ const (
 	TaskCompleted = "completed"
 	TaskFailed    = "failed"
 	TaskCancelled = "cancelled"
+	// TaskTimedOut: the run exceeded its kind's timeout and was interrupted.
+	TaskTimedOut = "timed_out"
+	// TaskExpired: the task's deadline passed before it was dispatched (its
+	// result holds only the error), or a completed export's download link
+	// lapsed (ExpireExport; its result keeps the export's and adds
+	// "expired_at").
+	TaskExpired = "expired"
+	// TaskWaiting: a later chain step, not dispatchable until the task it runs
+	// after completes (see SettleChains).
+	TaskWaiting = "waiting"
 )
 
-const taskColumns = `id, project_id, name, node, volume, archive, audit_id, params, status, result_json, created_at, updated_at`
+// TaskTerminal reports whether status is final: the task will not run again.
+func TaskTerminal(status string) bool {
+	switch status {
+	case TaskCompleted, TaskFailed, TaskCancelled, TaskTimedOut, TaskExpired:
+		return true
+	}
+	return false
+}
+
+const taskColumns = `id, project_id, name, node, volume, archive, audit_id, params, status, result_json, created_at, updated_at, attempts, next_attempt_at, replays, deadline, priority, after_id, progress_json`
 
 // scanTask reads a task row from either *sql.Row or *sql.Rows.
 func scanTask(row interface{ Scan(...any) error }) (Task, error) {
@@ -51,20 +102,33 @@ func scanTask(row interface{ Scan(...any) error }) (Task, error) {
 		auditID sql.NullInt64
 		params  sql.NullString
 		result  sql.NullString
+		nextAt  sql.NullInt64
+		dl      sql.NullInt64
+		afterID sql.NullString
+		prog    sql.NullString
 	)
-	if err := row.Scan(&t.ID, &projID, &t.Name, &t.Node, &volume, &archive, &auditID, &params, &t.Status, &result, &t.CreatedAt, &t.UpdatedAt); err != nil {
+	if err := row.Scan(&t.ID, &projID, &t.Name, &t.Node, &volume, &archive, &auditID, &params, &t.Status, &result, &t.CreatedAt, &t.UpdatedAt, &t.Attempts, &nextAt, &t.Replays, &dl, &t.Priority, &afterID, &prog); err != nil {
 		return Task{}, err
 	}
 	t.ProjectID = projID.String
 	t.Volume = volume.String
 	t.Archive = archive.String
 	t.AuditID = auditID.Int64
+	t.NextAttemptAt = nextAt.Int64
+	t.Deadline = dl.Int64
+	t.AfterID = afterID.String
 	if params.Valid {
 		t.Params = json.RawMessage(params.String)
 	}
 	if result.Valid {
 		t.Result = json.RawMessage(result.String)
 	}
+	if prog.Valid {
+		var p TaskProgress
+		if err := json.Unmarshal([]byte(prog.String), &p); err == nil {
+			t.Progress = &p
+		}
+	}
 	return t, nil
 }
 
@@ -78,14 +142,14 @@ func getTaskTx(ctx context.Context, tx *sql.Tx, id string) (Task, error) {
 // row (entity_type "task", op "upsert"). It is idempotent on the task id
 // (ON CONFLICT(id) DO NOTHING): a duplicate id inserts no row and appends no
 // changelog entry, returning created=false. Callers must pre-set t.Status,
-// t.CreatedAt, t.UpdatedAt and pass snapshot = json.Marshal(t). Shared by
+// t.CreatedAt, t.UpdatedAt, t.Priority and pass snapshot = json.Marshal(t). Shared by
 // CreateTask (controller/DOWN) and FireDueBackup (scheduler).
 func insertTaskTx(ctx context.Context, tx *sql.Tx, t Task, snapshot []byte) (bool, error) {
 	res, err := tx.ExecContext(ctx, `
-		INSERT INTO tasks (id, project_id, name, node, volume, archive, audit_id, params, status, result_json, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO tasks (id, project_id, name, node, volume, archive, audit_id, params, status, result_json, created_at, updated_at, deadline, priority, after_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(id) DO NOTHING
-	`, t.ID, nullable(t.ProjectID), t.Name, t.Node, nullable(t.Volume), nullable(t.Archive), t.AuditID, nullableJSON(t.Params), t.Status, nullableJSON(t.Result), t.CreatedAt, t.UpdatedAt)
+	`, t.ID, nullable(t.ProjectID), t.Name, t.Node, nullable(t.Volume), nullable(t.Archive), t.AuditID, nullableJSON(t.Params), t.Status, nullableJSON(t.Result), t.CreatedAt, t.UpdatedAt, nullableInt(t.Deadline), t.Priority, nullable(t.AfterID))
 	if err != nil {
 		return false, fmt.Errorf("store: insert task %q: %w", t.ID, err)
 	}
@@ -115,6 +179,9 @@ func (s *Store) CreateTask(ctx context.Context, t Task) (created bool, err error
 	if t.Status == "" {
 		t.Status = TaskPending
 	}
+	if t.Priority == 0 {
+		t.Priority = DefaultPriority(t.Name)
+	}
 	t.CreatedAt = now
 	t.UpdatedAt = now
 	snapshot, err := json.Marshal(t)
@@ -172,9 +239,12 @@ func (s *Store) UpdateTaskStatus(ctx context.Context, id, status string, result
 // snapshot, only while it is still pending. Returns claimed=false if the task was
 // not pending (already claimed/terminal/cancelled/absent). The dispatcher is the
 // only caller; this CAS is what guarantees a task dispatches at most once even if
-// the in-process wake signal and the backstop drain race on the same row.
+// the in-process wake signal and the backstop drain race on the same row. A claim
+// starts a new attempt: attempts is bumped and any retry backoff cleared, along
+// with the previous attempt's progress snapshot.
 func (s *Store) ClaimTask(ctx context.Context, id string) (claimed bool, err error) {
-	claimed, err = s.casTaskStatus(ctx, id, TaskPending, TaskRunning)
+	claimed, err = s.casTaskStatus(ctx, id, TaskPending, TaskRunning,
+		`, attempts = attempts + 1, next_attempt_at = NULL, progress_json = NULL`)
 	return claimed, err
 }
 
@@ -183,25 +253,71 @@ func (s *Store) ClaimTask(ctx context.Context, id string) (claimed bool, err err
 // worker (pool full): the row goes back to pending so a later wake retries it. The
 // CAS on running means it can never stomp a task a worker has already moved
 // terminal. A crash between claim and this revert leaves the task running, which
-// the boot reconcile then fails (an export is never blindly re-run).
+// the boot reconcile then replays or fails per the kind's replay policy.
 func (s *Store) UnclaimTask(ctx context.Context, id string) (unclaimed bool, err error) {
-	unclaimed, err = s.casTaskStatus(ctx, id, TaskRunning, TaskPending)
+	// The claim never ran, so it does not count as an attempt.
+	unclaimed, err = s.casTaskStatus(ctx, id, TaskRunning, TaskPending,
+		`, attempts = MAX(attempts - 1, 0)`)
 	return unclaimed, err
 }
 
+// RetryTask puts a running task whose attempt failed transiently back to
+// pending, held until nextAttemptAt (unix seconds), and — if result is
+// non-nil — records the failed attempt's output in result_json. The snapshot is
+// changelogged like any transition, so the controller sees each attempt. Returns
+// retried=false when the task was no longer running (e.g. a concurrent cancel).
+func (s *Store) RetryTask(ctx context.Context, id string, nextAttemptAt int64, result json.RawMessage) (retried bool, err error) {
+	if id == "" {
+		return false, errors.New("store: RetryTask requires id")
+	}
+	retried, err = s.casTaskStatus(ctx, id, TaskRunning, TaskPending,
+		`, next_attempt_at = ?, result_json = COALESCE(?, result_json)`, nextAttemptAt, nullableJSON(result))
+	return retried, err
+}
+
+// ReplayTask puts a task orphaned "running" by an agent crash back to pending
+// and bumps its replay counter (changelogged like any transition). It is the
+// boot reconcile's path for replay-safe kinds; the counter is persisted BEFORE
+// the re-run so a task that crashes the agent every time hits its cap instead of
+// looping. Returns replayed=false when the task was no longer running.
+func (s *Store) ReplayTask(ctx context.Context, id string) (replayed bool, err error) {
+	if id == "" {
+		return false, errors.New("store: ReplayTask requires id")
+	}
+	replayed, err = s.casTaskStatus(ctx, id, TaskRunning, TaskPending,
+		`, replays = replays + 1, next_attempt_at = NULL`)
+	return replayed, err
+}
+
+// UpdateTaskProgress records a progress snapshot on a running task (changelogged
+// like any update, so the controller's pull sees it; the worker throttles how
+// often it calls this). Returns updated=false, without writing, once the task
+// is no longer running — a late snapshot never lands on a terminal row.
+func (s *Store) UpdateTaskProgress(ctx context.Context, id string, p TaskProgress) (updated bool, err error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return false, fmt.Errorf("store: marshal task %q progress: %w", id, err)
+	}
+	return s.casTaskStatus(ctx, id, TaskRunning, TaskRunning, `, progress_json = ?`, string(b))
+}
+
 // casTaskStatus flips a task from -> to only while it is currently in `from`,
-// appending the resulting snapshot in the same tx. Returns false (no changelog
-// append) when the row was not in `from` (or absent).
-func (s *Store) casTaskStatus(ctx context.Context, id, from, to string) (bool, error) {
+// appending the resulting snapshot in the same tx. extraSet is appended to the
+// UPDATE's SET list (a literal ", col = ..." fragment; its placeholders are bound
+// from extraArgs). Returns false (no changelog append) when the row was not in
+// `from` (or absent).
+func (s *Store) casTaskStatus(ctx context.Context, id, from, to, extraSet string, extraArgs ...any) (bool, error) {
 	if id == "" {
 		return false, errors.New("store: casTaskStatus requires id")
 	}
 	now := time.Now().Unix()
 	var changed bool
 	err := s.withControlTx(ctx, func(tx *sql.Tx) error {
+		args := append([]any{to, now}, extraArgs...)
+		args = append(args, id, from)
 		res, err := tx.ExecContext(ctx,
-			`UPDATE tasks SET status = ?, updated_at = ? WHERE id = ? AND status = ?`,
-			to, now, id, from)
+			`UPDATE tasks SET status = ?, updated_at = ?`+extraSet+` WHERE id = ? AND status = ?`,
+			args...)
 		if err != nil {
 			return fmt.Errorf("store: cas task %q %s->%s: %w", id, from, to, err)
 		}
@@ -231,10 +347,25 @@ func (s *Store) casTaskStatus(ctx context.Context, id, from, to string) (bool, e
 
 // CancelPendingTask flips a task pending -> cancelled (and appends the snapshot)
 // only while it is still pending; a task already dispatched/terminal is left
-// untouched. Returns cancelled=false if the task was not pending (or absent).
+// untouched. A waiting chain step has not been dispatched either and cancels the
+// same way (the steps after it are aborted by SettleChains). Returns
+// cancelled=false if the task was not pending/waiting (or absent).
 func (s *Store) CancelPendingTask(ctx context.Context, id string) (cancelled bool, err error) {
-	cancelled, err = s.casTaskStatus(ctx, id, TaskPending, TaskCancelled)
-	return cancelled, err
+	cancelled, err = s.casTaskStatus(ctx, id, TaskPending, TaskCancelled, "")
+	if err != nil || cancelled {
+		return cancelled, err
+	}
+	return s.casTaskStatus(ctx, id, TaskWaiting, TaskCancelled, "")
+}
+
+// ExpirePendingTask flips a pending task whose deadline passed to expired,
+// recording reason (if non-nil) in result_json. Like CancelPendingTask it only
+// acts while the task is still pending; the dispatcher is the caller, deciding
+// from the task's Deadline before it would claim it.
+func (s *Store) ExpirePendingTask(ctx context.Context, id string, reason json.RawMessage) (expired bool, err error) {
+	expired, err = s.casTaskStatus(ctx, id, TaskPending, TaskExpired,
+		`, result_json = COALESCE(?, result_json)`, nullableJSON(reason))
+	return expired, err
 }
 
 // EnqueueTeardown idempotently enqueues a volume.trash teardown task keyed by the
@@ -245,7 +376,7 @@ func (s *Store) CancelPendingTask(ctx context.Context, id string) (cancelled boo
 //   - pending/running -> no-op (a teardown is already in flight)
 //   - completed       -> no-op (already torn down)
 //   - failed          -> if resetFailed, reset to pending + re-dispatch (enqueued=true);
-//                        otherwise no-op.
+//                        otherwise no-op. A timed_out row is treated as failed.
 //
 // resetFailed=true is for the controller-driven DELETE path (an explicit re-request
 // retries a transiently-failed teardown). resetFailed=false is for the scheduler
@@ -261,6 +392,9 @@ func (s *Store) EnqueueTeardown(ctx context.Context, t Task, resetFailed bool) (
 		switch {
 		case errors.Is(gErr, sql.ErrNoRows):
 			t.Status = TaskPending
+			if t.Priority == 0 {
+				t.Priority = DefaultPriority(t.Name)
+			}
 			t.CreatedAt = now
 			t.UpdatedAt = now
 			snapshot, mErr := json.Marshal(t)
@@ -272,13 +406,14 @@ func (s *Store) EnqueueTeardown(ctx context.Context, t Task, resetFailed bool) (
 		case gErr != nil:
 			return fmt.Errorf("store: get task %q: %w", t.ID, gErr)
 		}
-		// A row exists: only a failed row with resetFailed is re-activated.
-		if !(resetFailed && existing.Status == TaskFailed) {
+		// A row exists: only a failed (or timed-out) row with resetFailed is
+		// re-activated.
+		if !resetFailed || (existing.Status != TaskFailed && existing.Status != TaskTimedOut) {
 			return nil
 		}
 		res, uErr := tx.ExecContext(ctx,
-			`UPDATE tasks SET status = ?, result_json = NULL, updated_at = ? WHERE id = ? AND status = ?`,
-			TaskPending, now, t.ID, TaskFailed)
+			`UPDATE tasks SET status = ?, result_json = NULL, attempts = 0, next_attempt_at = NULL, replays = 0, updated_at = ? WHERE id = ? AND status = ?`,
+			TaskPending, now, t.ID, existing.Status)
 		if uErr != nil {
 			return fmt.Errorf("store: reset teardown %q: %w", t.ID, uErr)
 		}
@@ -322,17 +457,32 @@ func (s *Store) GetTask(ctx context.Context, id string) (Task, bool, error) {
 	}
 }
 
-// ListPendingTasks returns this node's pending tasks in creation order. It is
-// the dispatcher's boot/backstop drain source. There is no node filter: this
+// ListPendingTasks returns this node's pending tasks that are due — not held
+// back by a retry backoff (next_attempt_at in the future) — in claim order:
+// highest priority first, projects round-robin within a priority (fairOrder). It
+// is the dispatcher's boot/backstop drain source. There is no node filter: this
 // node's control.db holds only this node's tasks (the controller writes each
 // node's desired state to that node's endpoint), so the DB IS the node scope.
 func (s *Store) ListPendingTasks(ctx context.Context) ([]Task, error) {
-	return s.listTasksByStatus(ctx, TaskPending)
+	rows, err := s.control.QueryContext(ctx,
+		`SELECT `+taskColumns+` FROM tasks
+		  WHERE status = ? AND (next_attempt_at IS NULL OR next_attempt_at <= ?)
+		  ORDER BY priority DESC, created_at, id`,
+		TaskPending, time.Now().Unix())
+	if err != nil {
+		return nil, fmt.Errorf("store: list pending tasks: %w", err)
+	}
+	tasks, err := collectTasks(rows)
+	if err != nil {
+		return nil, err
+	}
+	return fairOrder(tasks), nil
 }
 
 // ListRunningTasks returns this node's running tasks in creation order. It is the
 // boot crash-reconcile source: a task left running across a restart is dead work
-// and must be failed (never auto-replayed for destructive kinds).
+// and must be failed or, for a replay-safe kind, replayed (never a destructive
+// kind).
 func (s *Store) ListRunningTasks(ctx context.Context) ([]Task, error) {
 	return s.listTasksByStatus(ctx, TaskRunning)
 }
@@ -344,6 +494,11 @@ func (s *Store) listTasksByStatus(ctx context.Context, status string) ([]Task, e
 	if err != nil {
 		return nil, fmt.Errorf("store: list %s tasks: %w", status, err)
 	}
+	return collectTasks(rows)
+}
+
+// collectTasks scans and closes rows.
+func collectTasks(rows *sql.Rows) ([]Task, error) {
 	defer rows.Close()
 
 	var out []Task
@@ -359,3 +514,127 @@ func (s *Store) listTasksByStatus(ctx context.Context, status string) ([]Task, e
 	}
 	return out, nil
 }
+
+// Default and maximum page sizes for ListTasks. A non-positive Limit gets the
+// default; anything above the max is clamped (the HTTP handler validates too).
+const (
+	defaultTaskPage = 100
+	maxTaskPage     = 1000
+)
+
+// TaskFilter narrows ListTasks. Empty/zero fields do not filter. CreatedFrom and
+// CreatedTo bound created_at inclusively (unix seconds). Cursor is the NextCursor
+// of a previous page; empty starts from the newest task.
+type TaskFilter struct {
+	Status      string
+	Name        string
+	Volume      string
+	ProjectID   string
+	CreatedFrom int64
+	CreatedTo   int64
+	Cursor      string
+	Limit       int
+}
+
+// ListTasks returns one page of tasks matching f, newest first (created_at DESC,
+// id DESC), plus the cursor for the next page ("" when this page is the last).
+// Pagination is keyset on (created_at, id), so a task inserted or reaped between
+// pages never shifts or duplicates rows. It is the admin read API's source —
+// on-call debugging, not a dispatch path. A cursor not issued by ListTasks
+// returns ErrInvalidCursor.
+func (s *Store) ListTasks(ctx context.Context, f TaskFilter) (tasks []Task, nextCursor string, err error) {
+	limit := f.Limit
+	if limit <= 0 {
+		limit = defaultTaskPage
+	}
+	if limit > maxTaskPage {
+		limit = maxTaskPage
+	}
+
+	var (
+		where []string
+		args  []any
+	)
+	for _, eq := range []struct{ col, val string }{
+		{"status", f.Status},
+		{"name", f.Name},
+		{"volume", f.Volume},
+		{"project_id", f.ProjectID},
+	} {
+		if eq.val != "" {
+			where = append(where, eq.col+" = ?")
+			args = append(args, eq.val)
+		}
+	}
+	if f.CreatedFrom > 0 {
+		where = append(where, "created_at >= ?")
+		args = append(args, f.CreatedFrom)
+	}
+	if f.CreatedTo > 0 {
+		where = append(where, "created_at <= ?")
+		args = append(args, f.CreatedTo)
+	}
+	if f.Cursor != "" {
+		createdAt, id, err := decodeTaskCursor(f.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		where = append(where, "(created_at < ? OR (created_at = ? AND id < ?))")
+		args = append(args, createdAt, createdAt, id)
+	}
+
+	query := `SELECT ` + taskColumns + ` FROM tasks`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	// Fetch one extra row to learn whether another page exists without a COUNT.
+	query += ` ORDER BY created_at DESC, id DESC LIMIT ?`
+	args = append(args, limit+1)
+
+	rows, err := s.control.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", fmt.Errorf("store: list tasks: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return nil, "", fmt.Errorf("store: scan task row: %w", err)
+		}
+		tasks = append(tasks, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("store: iterate tasks: %w", err)
+	}
+	if len(tasks) > limit {
+		tasks = tasks[:limit]
+		last := tasks[len(tasks)-1]
+		nextCursor = encodeTaskCursor(last.CreatedAt, last.ID)
+	}
+	return tasks, nextCursor, nil
+}
+
+// encodeTaskCursor renders a ListTasks keyset position as an opaque, URL-safe
+// token. Task ids are controller-chosen (may contain ':' or '/'), hence base64.
+func encodeTaskCursor(createdAt int64, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(createdAt, 10) + ":" + id))
+}
+
+// decodeTaskCursor is the inverse of encodeTaskCursor. created_at never contains
+// ':', so the first one separates it from the (arbitrary) id.
+func decodeTaskCursor(cursor string) (int64, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", ErrInvalidCursor
+	}
+	ts, id, ok := strings.Cut(string(raw), ":")
+	if !ok || id == "" {
+		return 0, "", ErrInvalidCursor
+	}
+	createdAt, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return 0, "", ErrInvalidCursor
+	}
+	return createdAt, id, nil
+}
@@ -0,0 +1,302 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestControlMigrations_V5_TaskIndexes(t *testing.T) {
+	s := open(t, Options{})
+	for _, idx := range []string{
+		"idx_tasks_created", "idx_tasks_status_created", "idx_tasks_name_created",
+		"idx_tasks_volume_created", "idx_tasks_project_created",
+	} {
+		var n int
+		if err := s.control.QueryRowContext(ctx,
+			`SELECT count(*) FROM sqlite_master WHERE type = 'index' AND name = ?`, idx,
+		).Scan(&n); err != nil || n != 1 {
+			t.Fatalf("index %s: n=%d err=%v", idx, n, err)
+		}
+	}
+}
+
+// seedTasks inserts tasks with explicit created_at values so ordering is
+// deterministic (CreateTask stamps wall-clock seconds).
+func seedTasks(t *testing.T, s *Store, tasks []Task) {
+	t.Helper()
+	for _, tk := range tasks {
+		if _, err := s.CreateTask(ctx, tk); err != nil {
+			t.Fatalf("CreateTask(%s): %v", tk.ID, err)
+		}
+		if _, err := s.control.ExecContext(ctx,
+			`UPDATE tasks SET created_at = ? WHERE id = ?`, tk.CreatedAt, tk.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func taskIDs(tasks []Task) []string {
+	out := make([]string, 0, len(tasks))
+	for _, tk := range tasks {
+		out = append(out, tk.ID)
+	}
+	return out
+}
+
+func TestListTasks_FiltersAndOrder(t *testing.T) {
+	s := open(t, Options{})
+	seedTasks(t, s, []Task{
+		{ID: "a", ProjectID: "p1", Name: "volume.backup", Node: "n", Volume: "v1", CreatedAt: 100},
+		{ID: "b", ProjectID: "p1", Name: "volume.restore", Node: "n", Volume: "v1", CreatedAt: 200},
+		{ID: "c", ProjectID: "p2", Name: "volume.backup", Node: "n", Volume: "v2", CreatedAt: 300},
+		{ID: "d", ProjectID: "p2", Name: "backup.export", Node: "n", Volume: "v2", CreatedAt: 300},
+	})
+	if err := s.UpdateTaskStatus(ctx, "a", TaskFailed, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		f    TaskFilter
+		want []string
+	}{
+		{"all newest first, id breaks ties", TaskFilter{}, []string{"d", "c", "b", "a"}},
+		{"status", TaskFilter{Status: TaskFailed}, []string{"a"}},
+		{"name", TaskFilter{Name: "volume.backup"}, []string{"c", "a"}},
+		{"volume", TaskFilter{Volume: "v1"}, []string{"b", "a"}},
+		{"project", TaskFilter{ProjectID: "p2"}, []string{"d", "c"}},
+		{"created range", TaskFilter{CreatedFrom: 150, CreatedTo: 250}, []string{"b"}},
+		{"combined", TaskFilter{ProjectID: "p2", Name: "volume.backup"}, []string{"c"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, next, err := s.ListTasks(ctx, tc.f)
+			if err != nil {
+				t.Fatalf("ListTasks: %v", err)
+			}
+			if next != "" {
+				t.Fatalf("next cursor = %q, want empty (single page)", next)
+			}
+			if ids := taskIDs(got); !slices.Equal(ids, tc.want) {
+				t.Fatalf("ids = %v, want %v", ids, tc.want)
+			}
+		})
+	}
+}
+
+// TestListTasks_CursorPagination walks a result set page by page and proves the
+// keyset cursor neither skips nor repeats rows, including across a created_at
+// tie and a task inserted mid-walk (newer rows never shift older pages).
+func TestListTasks_CursorPagination(t *testing.T) {
+	s := open(t, Options{})
+	seedTasks(t, s, []Task{
+		{ID: "t1", Name: "volume.backup", Node: "n", CreatedAt: 10},
+		{ID: "t2", Name: "volume.backup", Node: "n", CreatedAt: 20},
+		{ID: "t3", Name: "volume.backup", Node: "n", CreatedAt: 20},
+		{ID: "t4", Name: "volume.backup", Node: "n", CreatedAt: 30},
+		{ID: "t5", Name: "volume.backup", Node: "n", CreatedAt: 40},
+	})
+
+	var (
+		all    []string
+		cursor string
+		pages  int
+	)
+	for {
+		page, next, err := s.ListTasks(ctx, TaskFilter{Limit: 2, Cursor: cursor})
+		if err != nil {
+			t.Fatalf("ListTasks page %d: %v", pages, err)
+		}
+		all = append(all, taskIDs(page)...)
+		pages++
+		if pages == 1 {
+			seedTasks(t, s, []Task{{ID: "t6", Name: "volume.backup", Node: "n", CreatedAt: 50}})
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if want := []string{"t5", "t4", "t3", "t2", "t1"}; !slices.Equal(all, want) {
+		t.Fatalf("walk = %v, want %v", all, want)
+	}
+	if pages != 3 {
+		t.Fatalf("pages = %d, want 3", pages)
+	}
+}
+
+func TestListTasks_InvalidCursor(t *testing.T) {
+	s := open(t, Options{})
+	for _, c := range []string{"!!!", encodeBase64("no-colon"), encodeBase64("abc:id"), encodeBase64("10:")} {
+		if _, _, err := s.ListTasks(ctx, TaskFilter{Cursor: c}); !errors.Is(err, ErrInvalidCursor) {
+			t.Fatalf("cursor %q: err = %v, want ErrInvalidCursor", c, err)
+		}
+	}
+}
+
+func encodeBase64(s string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+// TestRetryTask_AttemptsAndBackoff walks a task through a transient failure: the
+// claim counts the attempt, RetryTask holds it in pending until next_attempt_at
+// (invisible to the dispatcher's drain until due), and every transition is
+// changelogged so the controller sees the attempt history.
+func TestRetryTask_AttemptsAndBackoff(t *testing.T) {
+	s := open(t, Options{})
+	if _, err := s.CreateTask(ctx, Task{ID: "r1", Name: "volume.backup", Node: "n"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ClaimTask(ctx, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour).Unix()
+	retried, err := s.RetryTask(ctx, "r1", later, json.RawMessage(`{"error":"lock timeout"}`))
+	if err != nil || !retried {
+		t.Fatalf("RetryTask = %v, %v; want true, nil", retried, err)
+	}
+	tk, _, _ := s.GetTask(ctx, "r1")
+	if tk.Status != TaskPending || tk.Attempts != 1 || tk.NextAttemptAt != later || string(tk.Result) != `{"error":"lock timeout"}` {
+		t.Fatalf("after retry: %+v", tk)
+	}
+	if pending, _ := s.ListPendingTasks(ctx); len(pending) != 0 {
+		t.Fatalf("pending = %v, want none (backoff not elapsed)", taskIDs(pending))
+	}
+
+	// Backoff elapsed: the task is due again, and the next claim is attempt 2.
+	if _, err := s.control.ExecContext(ctx, `UPDATE tasks SET next_attempt_at = ? WHERE id = 'r1'`, time.Now().Unix()-1); err != nil {
+		t.Fatal(err)
+	}
+	if pending, _ := s.ListPendingTasks(ctx); len(pending) != 1 {
+		t.Fatalf("pending = %d, want 1 (due)", len(pending))
+	}
+	if _, err := s.ClaimTask(ctx, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	tk, _, _ = s.GetTask(ctx, "r1")
+	if tk.Status != TaskRunning || tk.Attempts != 2 || tk.NextAttemptAt != 0 {
+		t.Fatalf("after second claim: %+v", tk)
+	}
+
+	// create, claim, retry, claim.
+	if got := countTable(t, s, "changelog"); got != 4 {
+		t.Fatalf("changelog rows = %d, want 4", got)
+	}
+
+	// Only a running task can be retried.
+	if retried, err := s.RetryTask(ctx, "absent", later, nil); err != nil || retried {
+		t.Fatalf("RetryTask(absent) = %v, %v", retried, err)
+	}
+}
+
+func TestUnclaimTask_DoesNotCountAttempt(t *testing.T) {
+	s := open(t, Options{})
+	if _, err := s.CreateTask(ctx, Task{ID: "e1", Name: "backup.export", Node: "n"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ClaimTask(ctx, "e1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.UnclaimTask(ctx, "e1"); err != nil {
+		t.Fatal(err)
+	}
+	if tk, _, _ := s.GetTask(ctx, "e1"); tk.Attempts != 0 {
+		t.Fatalf("attempts = %d, want 0 (the claim never ran)", tk.Attempts)
+	}
+}
+
+func TestReplayTask(t *testing.T) {
+	s := open(t, Options{})
+	if _, err := s.CreateTask(ctx, Task{ID: "b1", Name: "volume.backup", Node: "n"}); err != nil {
+		t.Fatal(err)
+	}
+	if replayed, err := s.ReplayTask(ctx, "b1"); err != nil || replayed {
+		t.Fatalf("ReplayTask(pending) = %v, %v; want false (only running replays)", replayed, err)
+	}
+	if _, err := s.ClaimTask(ctx, "b1"); err != nil {
+		t.Fatal(err)
+	}
+	if replayed, err := s.ReplayTask(ctx, "b1"); err != nil || !replayed {
+		t.Fatalf("ReplayTask = %v, %v; want true", replayed, err)
+	}
+	tk, _, _ := s.GetTask(ctx, "b1")
+	if tk.Status != TaskPending || tk.Replays != 1 || tk.Attempts != 1 {
+		t.Fatalf("after replay: %+v", tk)
+	}
+	// create, claim, replay.
+	if got := countTable(t, s, "changelog"); got != 3 {
+		t.Fatalf("changelog rows = %d, want 3", got)
+	}
+}
+
+func TestExpirePendingTask(t *testing.T) {
+	s := open(t, Options{})
+	for _, tk := range []Task{
+		{ID: "d1", Name: "volume.backup", Node: "n", Deadline: 1700000000},
+		{ID: "d2", Name: "volume.backup", Node: "n", Deadline: 1700000000},
+	} {
+		if _, err := s.CreateTask(ctx, tk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tk, _, _ := s.GetTask(ctx, "d1"); tk.Deadline != 1700000000 {
+		t.Fatalf("deadline = %d, want 1700000000", tk.Deadline)
+	}
+	expired, err := s.ExpirePendingTask(ctx, "d1", json.RawMessage(`{"error":"deadline passed"}`))
+	if err != nil || !expired {
+		t.Fatalf("ExpirePendingTask = %v, %v; want true", expired, err)
+	}
+	if tk, _, _ := s.GetTask(ctx, "d1"); tk.Status != TaskExpired || string(tk.Result) != `{"error":"deadline passed"}` {
+		t.Fatalf("after expire: %+v", tk)
+	}
+	// Only a pending task expires.
+	if _, err := s.ClaimTask(ctx, "d2"); err != nil {
+		t.Fatal(err)
+	}
+	if expired, err := s.ExpirePendingTask(ctx, "d2", nil); err != nil || expired {
+		t.Fatalf("ExpirePendingTask(running) = %v, %v; want false", expired, err)
+	}
+}
+
+func TestUpdateTaskProgress(t *testing.T) {
+	s := open(t, Options{})
+	if _, err := s.CreateTask(ctx, Task{ID: "p1", Name: "volume.backup", Node: "n"}); err != nil {
+		t.Fatal(err)
+	}
+	snap := TaskProgress{Phase: "create", BytesDone: 10, BytesTotal: 100, ETASec: 9, UpdatedAt: 1700000000}
+	// Only a running task takes a snapshot.
+	if updated, err := s.UpdateTaskProgress(ctx, "p1", snap); err != nil || updated {
+		t.Fatalf("UpdateTaskProgress(pending) = %v, %v; want false", updated, err)
+	}
+	if _, err := s.ClaimTask(ctx, "p1"); err != nil {
+		t.Fatal(err)
+	}
+	before := len(mustSince(t, s, 0, "task", 100))
+	if updated, err := s.UpdateTaskProgress(ctx, "p1", snap); err != nil || !updated {
+		t.Fatalf("UpdateTaskProgress(running) = %v, %v; want true", updated, err)
+	}
+	tk, _, _ := s.GetTask(ctx, "p1")
+	if tk.Status != TaskRunning || tk.Progress == nil || *tk.Progress != snap {
+		t.Fatalf("after snapshot: status %q progress %+v", tk.Status, tk.Progress)
+	}
+	entries := mustSince(t, s, 0, "task", 100)
+	if len(entries) != before+1 || !strings.Contains(string(entries[len(entries)-1].Payload), `"progress":{"phase":"create"`) {
+		t.Fatalf("snapshot not changelogged: %d -> %d entries", before, len(entries))
+	}
+
+	// A new attempt starts without the previous attempt's progress.
+	if _, err := s.RetryTask(ctx, "p1", 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ClaimTask(ctx, "p1"); err != nil {
+		t.Fatal(err)
+	}
+	if tk, _, _ := s.GetTask(ctx, "p1"); tk.Progress != nil {
+		t.Fatalf("progress after re-claim = %+v, want none", tk.Progress)
+	}
+}
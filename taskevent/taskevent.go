@@ -0,0 +1,274 @@
+// Package taskevent is the live feed of a running task: its step lines, borg
+// progress records and S3 upload bytes, as they happen. The worker opens a
+// task's Stream and hands it to the handlers on the task context (NewContext /
+// FromContext); the admin API subscribes to it (GET /v1/admin/tasks/{id}/events).
+// It is in-memory only — the terminal outcome still lands in result_json — and a
+// late subscriber is first replayed the stream's buffered history.
+package taskevent
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	TypeStep     = "step"     // a handler step line (what ends up in result_json "output")
+	TypeProgress = "progress" // a borg --progress record
+	TypeUpload   = "upload"   // bytes uploaded to S3 so far
+	TypeEnd      = "end"      // the task's terminal status; always the last event
+)
+
+// Event is one record of a task's feed. Seq increases by one per published
+// event (a subscriber resumes after the last Seq it saw); Time is unix millis.
+// Which of the other fields are set depends on Type; Phase names the operation
+// a progress or upload record measures (borg's msgid, "create", "upload").
+type Event struct {
+	Seq     int64   `json:"seq"`
+	Time    int64   `json:"time"`
+	Type    string  `json:"type"`
+	Phase   string  `json:"phase,omitempty"`
+	Message string  `json:"message,omitempty"`
+	Bytes   int64   `json:"bytes,omitempty"`
+	Total   int64   `json:"total,omitempty"`
+	Files   int64   `json:"files,omitempty"`
+	Percent float64 `json:"percent,omitempty"`
+	Status  string  `json:"status,omitempty"`
+}
+
+const (
+	// maxHistory bounds a stream's buffered history. Progress and upload
+	// records are coalesced (only the latest is kept between two steps), so
+	// this is mostly step lines.
+	maxHistory = 1000
+	// subBuffer is a subscriber's channel depth. A subscriber that falls this
+	// far behind is dropped (its channel closed); it can resume with the last
+	// Seq it received.
+	subBuffer = 256
+	// progressInterval rate-limits progress/upload records per stream; borg
+	// emits them several times a second.
+	progressInterval = time.Second
+	// linger keeps an ended stream's history so a subscriber arriving just
+	// after the task finished still gets it.
+	linger = 5 * time.Minute
+)
+
+// Hub holds the streams of this node's tasks, keyed by task id. The zero value
+// is not usable; build with NewHub.
+type Hub struct {
+	mu      sync.Mutex
+	streams map[string]*Stream
+}
+
+// NewHub builds an empty hub.
+func NewHub() *Hub {
+	return &Hub{streams: map[string]*Stream{}}
+}
+
+// Stream is one task's feed. A nil *Stream is valid and discards everything,
+// so handlers publish unconditionally.
+type Stream struct {
+	hub *Hub
+	id  string
+
+	mu       sync.Mutex
+	opened   bool // a worker is running (or has run) the task
+	ended    bool
+	endedAt  time.Time
+	seq      int64
+	history  []Event
+	subs     map[chan Event]struct{}
+	lastProg map[string]time.Time
+	latest   *Event // the last progress/upload record published
+}
+
+// stream returns id's stream, creating it if absent. h.mu must be held.
+func (h *Hub) stream(id string) *Stream {
+	s, ok := h.streams[id]
+	if !ok {
+		s = &Stream{hub: h, id: id, subs: map[chan Event]struct{}{}, lastProg: map[string]time.Time{}}
+		h.streams[id] = s
+	}
+	return s
+}
+
+// Open returns the stream a worker publishes a task's run to. A subscriber that
+// arrived while the task was still pending is already attached. Re-opening an
+// ended stream (the task runs again, e.g. a retry) continues its history, but
+// not its LatestProgress: the new run's progress starts from nothing.
+func (h *Hub) Open(id string) *Stream {
+	h.mu.Lock()
+	s := h.stream(id)
+	h.mu.Unlock()
+	s.mu.Lock()
+	s.opened = true
+	s.ended = false
+	s.latest = nil
+	clear(s.lastProg)
+	s.mu.Unlock()
+	return s
+}
+
+// End publishes id's terminal status, if it has a stream (see Stream.End).
+func (h *Hub) End(id, status string) {
+	h.mu.Lock()
+	s := h.streams[id]
+	h.mu.Unlock()
+	s.End(status)
+}
+
+// Subscribe attaches to id's feed: history is the buffered events after Seq
+// `after` (0 = all), and events delivers the rest until the stream ends or the
+// subscriber falls behind (the channel is then closed). live reports whether a
+// worker has the task; a subscriber to a task that is not running yet is
+// attached anyway and starts receiving when it does. cancel detaches.
+func (h *Hub) Subscribe(id string, after int64) (history []Event, events <-chan Event, live bool, cancel func()) {
+	h.mu.Lock()
+	s := h.stream(id)
+	h.mu.Unlock()
+
+	ch := make(chan Event, subBuffer)
+	s.mu.Lock()
+	for _, e := range s.history {
+		if e.Seq > after {
+			history = append(history, e)
+		}
+	}
+	live = s.opened
+	if s.ended {
+		close(ch)
+	} else {
+		s.subs[ch] = struct{}{}
+	}
+	s.mu.Unlock()
+	return history, ch, live, func() { s.unsubscribe(ch) }
+}
+
+func (s *Stream) unsubscribe(ch chan Event) {
+	s.mu.Lock()
+	if _, ok := s.subs[ch]; ok {
+		delete(s.subs, ch)
+		close(ch)
+	}
+	idle := !s.opened && len(s.subs) == 0
+	s.mu.Unlock()
+	if idle {
+		s.hub.remove(s)
+	}
+}
+
+// remove drops s from the hub unless it has since been replaced or picked up
+// again by a subscriber or a worker.
+func (h *Hub) remove(s *Stream) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.streams[s.id] != s {
+		return
+	}
+	s.mu.Lock()
+	keep := len(s.subs) > 0 || (s.opened && !s.ended) || (s.ended && time.Since(s.endedAt) < linger)
+	s.mu.Unlock()
+	if !keep {
+		delete(h.streams, s.id)
+	}
+}
+
+// Step publishes a step line.
+func (s *Stream) Step(msg string) {
+	s.Publish(Event{Type: TypeStep, Message: msg})
+}
+
+// Publish stamps e with the next Seq and the current time, appends it to the
+// history and fans it out. Progress and upload records arriving faster than
+// progressInterval are dropped, and only the latest one between two other
+// events is kept in the history.
+func (s *Stream) Publish(e Event) {
+	if s == nil {
+		return
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	coalesce := e.Type == TypeProgress || e.Type == TypeUpload
+	if coalesce {
+		if now.Sub(s.lastProg[e.Type]) < progressInterval {
+			return
+		}
+		s.lastProg[e.Type] = now
+	}
+	s.seq++
+	e.Seq = s.seq
+	e.Time = now.UnixMilli()
+	if n := len(s.history); coalesce && n > 0 && s.history[n-1].Type == e.Type {
+		s.history[n-1] = e
+	} else {
+		if n >= maxHistory {
+			s.history = append(s.history[:0], s.history[n-maxHistory+1:]...)
+		}
+		s.history = append(s.history, e)
+	}
+	if coalesce {
+		s.latest = &e
+	}
+	for ch := range s.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(s.subs, ch) // too far behind: let it resume from its last Seq
+			close(ch)
+		}
+	}
+}
+
+// LatestProgress returns the last progress or upload record published, if any
+// (what a periodic progress snapshot samples).
+func (s *Stream) LatestProgress() (Event, bool) {
+	if s == nil {
+		return Event{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.latest == nil {
+		return Event{}, false
+	}
+	return *s.latest, true
+}
+
+// End publishes the terminal status and closes every subscriber. The history
+// is kept for a while after (linger) for late subscribers.
+func (s *Stream) End(status string) {
+	if s == nil {
+		return
+	}
+	s.Publish(Event{Type: TypeEnd, Status: status})
+	s.mu.Lock()
+	s.ended = true
+	s.endedAt = time.Now()
+	for ch := range s.subs {
+		delete(s.subs, ch)
+		close(ch)
+	}
+	s.mu.Unlock()
+	time.AfterFunc(linger, func() { s.hub.remove(s) })
+}
+
+type ctxKey struct{}
+
+// NewContext returns ctx carrying s for the task's handlers.
+func NewContext(ctx context.Context, s *Stream) context.Context {
+	return context.WithValue(ctx, ctxKey{}, s)
+}
+
+// FromContext returns the stream ctx carries, or nil (a valid, discarding
+// stream) if it carries none.
+func FromContext(ctx context.Context) *Stream {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(ctxKey{}).(*Stream)
+	return s
+}
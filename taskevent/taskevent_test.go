@@ -0,0 +1,151 @@
+package taskevent
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func types(events []Event) []string {
+	out := make([]string, 0, len(events))
+	for _, e := range events {
+		out = append(out, e.Type)
+	}
+	return out
+}
+
+func TestStream_HistoryAndResume(t *testing.T) {
+	h := NewHub()
+	s := h.Open("t1")
+	s.Step("one")
+	s.Publish(Event{Type: TypeProgress, Bytes: 10})
+	s.Publish(Event{Type: TypeProgress, Bytes: 20}) // within progressInterval: dropped
+	s.Step("two")
+
+	history, _, live, cancel := h.Subscribe("t1", 0)
+	defer cancel()
+	if !live || len(history) != 3 || history[1].Bytes != 10 || history[2].Message != "two" {
+		t.Fatalf("history = %+v (live %v)", history, live)
+	}
+	for i, e := range history {
+		if e.Seq != int64(i+1) {
+			t.Fatalf("event %d seq = %d", i, e.Seq)
+		}
+	}
+	if resumed, _, _, c := h.Subscribe("t1", 2); len(resumed) != 1 || resumed[0].Seq != 3 {
+		t.Fatalf("resume after 2 = %+v", resumed)
+	} else {
+		c()
+	}
+}
+
+func TestStream_CoalescesProgressInHistory(t *testing.T) {
+	h := NewHub()
+	s := h.Open("t1")
+	s.Step("start")
+	s.Publish(Event{Type: TypeUpload, Bytes: 1})
+	s.lastProg[TypeUpload] = s.lastProg[TypeUpload].Add(-progressInterval) // let the next one through
+	s.Publish(Event{Type: TypeUpload, Bytes: 2})
+
+	history, _, _, cancel := h.Subscribe("t1", 0)
+	defer cancel()
+	if len(history) != 2 || history[1].Bytes != 2 {
+		t.Fatalf("history = %+v; want the step and only the latest upload", history)
+	}
+}
+
+func TestHub_ReopenClearsLatestProgress(t *testing.T) {
+	h := NewHub()
+	s := h.Open("t1")
+	s.Publish(Event{Type: TypeUpload, Phase: "upload", Bytes: 500})
+	if _, ok := s.LatestProgress(); !ok {
+		t.Fatal("no LatestProgress after an upload record")
+	}
+	h.End("t1", "failed")
+
+	// The retry re-opens the stream: nothing of the last attempt's progress.
+	s = h.Open("t1")
+	if e, ok := s.LatestProgress(); ok {
+		t.Fatalf("re-opened stream's LatestProgress = %+v, want none", e)
+	}
+	s.Publish(Event{Type: TypeUpload, Phase: "upload", Bytes: 7}) // not rate-limited by the last attempt
+	if e, ok := s.LatestProgress(); !ok || e.Bytes != 7 {
+		t.Fatalf("LatestProgress = %+v, %v; want the retry's own record", e, ok)
+	}
+}
+
+func TestStream_LiveAndEnd(t *testing.T) {
+	h := NewHub()
+	// A subscriber may attach before the worker opens the stream.
+	history, events, live, cancel := h.Subscribe("t1", 0)
+	defer cancel()
+	if len(history) != 0 || live {
+		t.Fatalf("before open: history %v live %v", history, live)
+	}
+	s := h.Open("t1")
+	s.Step("working")
+	s.End("completed")
+	var got []Event
+	for e := range events {
+		got = append(got, e)
+	}
+	if len(got) != 2 || got[0].Message != "working" || got[1].Type != TypeEnd || got[1].Status != "completed" {
+		t.Fatalf("live events = %+v", got)
+	}
+
+	// A late subscriber gets the whole history and a closed channel.
+	history, events, _, cancel2 := h.Subscribe("t1", 0)
+	defer cancel2()
+	if want := []string{TypeStep, TypeEnd}; !slices.Equal(types(history), want) {
+		t.Fatalf("late history = %+v", history)
+	}
+	if _, ok := <-events; ok {
+		t.Fatal("late subscriber's channel is open after the end")
+	}
+	s.Step("after end") // ignored
+	if h2, _, _, c := h.Subscribe("t1", 0); len(h2) != 2 {
+		t.Fatalf("published after end: %+v", h2)
+	} else {
+		c()
+	}
+}
+
+func TestStream_SlowSubscriberDropped(t *testing.T) {
+	h := NewHub()
+	s := h.Open("t1")
+	_, events, _, cancel := h.Subscribe("t1", 0)
+	defer cancel()
+	for range subBuffer + 1 {
+		s.Step("line")
+	}
+	n := 0
+	for range events {
+		n++
+	}
+	if n != subBuffer {
+		t.Fatalf("received %d events before the drop, want %d", n, subBuffer)
+	}
+}
+
+func TestHub_IdleSubscriberStreamRemoved(t *testing.T) {
+	h := NewHub()
+	_, _, _, cancel := h.Subscribe("pending", 0)
+	cancel()
+	if _, ok := h.streams["pending"]; ok {
+		t.Fatal("stream of a never-opened task kept after its last subscriber left")
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	if FromContext(context.Background()) != nil {
+		t.Fatal("FromContext without a stream is non-nil")
+	}
+	var s *Stream
+	s.Step("discarded") // a nil stream is safe
+	s.End("completed")
+	h := NewHub()
+	st := h.Open("t1")
+	if FromContext(NewContext(context.Background(), st)) != st {
+		t.Fatal("FromContext did not return the stream")
+	}
+}